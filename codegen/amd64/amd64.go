@@ -0,0 +1,472 @@
+// Package amd64 compiles lowered programs to x86-64 assembly for the GNU
+// assembler, which as and ld turn into a static Linux executable:
+//
+//	as -o prog.o prog.s && ld -o prog prog.o
+//
+// INTEGER and other ordinal values are 64-bit, as in the interpreter's
+// default mode. Functions follow the System V calling convention, keeping
+// every variable in a slot of their stack frame. The executable needs no C
+// library: it writes with system calls, and stops with exit status 1 and a
+// message on standard error at a division by zero, an integer overflow or
+// a stack overflow, as the interpreter does.
+package amd64
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/interpreter"
+	"github.com/kieron-dev/lsbasi/ir"
+)
+
+// argRegs hold the first arguments of a call
+var argRegs = []string{"%rdi", "%rsi", "%rdx", "%rcx", "%r8", "%r9"}
+
+var arithmetic = map[ir.Op]string{
+	ir.Add: "add",
+	ir.Sub: "sub",
+	ir.Mul: "imul",
+}
+
+var conditions = map[ir.Op]string{
+	ir.Eq: "e",
+	ir.Ne: "ne",
+	ir.Lt: "l",
+	ir.Le: "le",
+	ir.Gt: "g",
+	ir.Ge: "ge",
+}
+
+// runtimeErrors are the errors the executable stops with, reported with
+// the messages the interpreter gives
+var runtimeErrors = []struct{ name, msg string }{
+	{"division_by_zero", "division by zero"},
+	{"integer_overflow", "integer overflow"},
+	{"stack_overflow", fmt.Sprintf("stack overflow: more than %d nested calls", interpreter.DefaultMaxDepth)},
+}
+
+type generator struct {
+	out     *bufio.Writer
+	prog    *ir.Program
+	funcs   map[*ir.Func]string
+	globals map[*ir.Global]string
+	tables  map[string]string
+	strings []string
+
+	fn    *ir.Func
+	index int
+	slots map[*ir.Var]string
+}
+
+// Compile writes the assembly for a program. The functions must not be in
+// SSA form.
+func Compile(p *ir.Program, w io.Writer) error {
+	g := &generator{
+		out:     bufio.NewWriter(w),
+		prog:    p,
+		funcs:   map[*ir.Func]string{},
+		globals: map[*ir.Global]string{},
+		tables:  map[string]string{},
+	}
+
+	for n, f := range p.Funcs {
+		if f.SSA {
+			return fmt.Errorf("cannot compile %s: functions in SSA form are not supported", f.Name)
+		}
+		g.funcs[f] = symbol("f", n, f.Name)
+	}
+	for n, glob := range p.Globals {
+		g.globals[glob] = symbol("g", n, glob.Name)
+	}
+
+	g.line(".text")
+	g.line(".globl _start")
+	g.label("_start")
+	g.emit("call %s", g.funcs[p.Main])
+	g.emit("mov $60, %%eax")
+	g.emit("xor %%edi, %%edi")
+	g.emit("syscall")
+
+	for n, f := range p.Funcs {
+		g.function(n, f)
+	}
+
+	g.runtime()
+	g.data()
+
+	return g.out.Flush()
+}
+
+// symbol names a function or global, made unique by its index
+func symbol(prefix string, index int, name string) string {
+	var sb strings.Builder
+	for _, c := range []byte(name) {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+
+	return fmt.Sprintf("%s%d_%s", prefix, index, sb.String())
+}
+
+func (g *generator) line(format string, args ...interface{}) {
+	fmt.Fprintf(g.out, format+"\n", args...)
+}
+
+func (g *generator) label(name string) {
+	g.line("%s:", name)
+}
+
+func (g *generator) emit(format string, args ...interface{}) {
+	g.line("\t"+format, args...)
+}
+
+func (g *generator) blockLabel(b *ir.Block) string {
+	return fmt.Sprintf(".L%d_%d", g.index, b.ID)
+}
+
+// function generates a function. Its frame holds a slot for each local,
+// and the slots of the first six parameters, which are stored there on
+// entry. The other parameters are in the caller's frame.
+func (g *generator) function(index int, f *ir.Func) {
+	g.fn, g.index = f, index
+	g.slots = map[*ir.Var]string{}
+
+	stored := 0
+	for n, p := range f.Params {
+		if n < len(argRegs) {
+			stored++
+			g.slots[p] = fmt.Sprintf("%d(%%rbp)", -8*stored)
+		} else {
+			g.slots[p] = fmt.Sprintf("%d(%%rbp)", 16+8*(n-len(argRegs)))
+		}
+	}
+	for n, v := range f.Locals {
+		g.slots[v] = fmt.Sprintf("%d(%%rbp)", -8*(stored+n+1))
+	}
+
+	// keep the stack aligned to 16 bytes for calls
+	size := 8 * (stored + len(f.Locals))
+	size += size % 16
+
+	g.line("")
+	g.line("# %s", f.Name)
+	g.label(g.funcs[f])
+	g.emit("push %%rbp")
+	g.emit("mov %%rsp, %%rbp")
+	if size > 0 {
+		g.emit("sub $%d, %%rsp", size)
+	}
+	for n := 0; n < stored; n++ {
+		g.emit("mov %s, %s", argRegs[n], g.slots[f.Params[n]])
+	}
+	if f != g.prog.Main {
+		g.emit("mov rt_depth(%%rip), %%rax")
+		g.emit("inc %%rax")
+		g.emit("cmp $%d, %%rax", interpreter.DefaultMaxDepth)
+		g.emit("jg rt_stack_overflow")
+		g.emit("mov %%rax, rt_depth(%%rip)")
+	}
+
+	for n, b := range f.Blocks {
+		var next *ir.Block
+		if n+1 < len(f.Blocks) {
+			next = f.Blocks[n+1]
+		}
+
+		g.label(g.blockLabel(b))
+		for _, in := range b.Instrs {
+			g.emit("# %s", in)
+			g.instr(in, next)
+		}
+	}
+}
+
+// load puts a constant or the value of a variable in a register
+func (g *generator) load(v ir.Value, reg string) {
+	if c, ok := v.(ir.Const); ok {
+		g.emit("mov $%d, %s", int(c), reg)
+		return
+	}
+
+	g.emit("mov %s, %s", g.slots[v.(*ir.Var)], reg)
+}
+
+// store saves %rax in the slot of a variable
+func (g *generator) store(v *ir.Var) {
+	g.emit("mov %%rax, %s", g.slots[v])
+}
+
+// instr generates an instruction. A jump to the next block is left out.
+func (g *generator) instr(in *ir.Instr, next *ir.Block) {
+	switch in.Op {
+	case ir.Copy:
+		g.load(in.Args[0], "%rax")
+
+	case ir.Neg:
+		g.load(in.Args[0], "%rax")
+		g.emit("neg %%rax")
+		g.emit("jo rt_integer_overflow")
+
+	case ir.Add, ir.Sub, ir.Mul:
+		g.load(in.Args[0], "%rax")
+		g.load(in.Args[1], "%rcx")
+		g.emit("%s %%rcx, %%rax", arithmetic[in.Op])
+		g.emit("jo rt_integer_overflow")
+
+	case ir.Div, ir.Mod:
+		g.divide(in)
+
+	case ir.Eq, ir.Ne, ir.Lt, ir.Le, ir.Gt, ir.Ge:
+		g.load(in.Args[0], "%rax")
+		g.load(in.Args[1], "%rcx")
+		g.emit("cmp %%rcx, %%rax")
+		g.emit("set%s %%al", conditions[in.Op])
+		g.emit("movzbl %%al, %%eax")
+
+	case ir.Load:
+		g.emit("mov %s(%%rip), %%rax", g.globals[in.Global])
+
+	case ir.Store:
+		g.load(in.Args[0], "%rax")
+		g.emit("mov %%rax, %s(%%rip)", g.globals[in.Global])
+
+	case ir.Call:
+		g.call(in)
+
+	case ir.Write:
+		if in.Names == nil {
+			g.load(in.Args[0], "%rdi")
+			g.emit("call rt_write_int")
+			break
+		}
+		g.load(in.Args[0], "%rax")
+		g.emit("shl $4, %%rax")
+		g.emit("lea %s(%%rip), %%rcx", g.table(in.Names))
+		g.emit("mov (%%rcx,%%rax), %%rdi")
+		g.emit("mov 8(%%rcx,%%rax), %%rsi")
+		g.emit("call rt_write")
+
+	case ir.WriteLn:
+		g.emit("lea rt_newline(%%rip), %%rdi")
+		g.emit("mov $1, %%esi")
+		g.emit("call rt_write")
+
+	case ir.Jump:
+		if in.Targets[0] != next {
+			g.emit("jmp %s", g.blockLabel(in.Targets[0]))
+		}
+
+	case ir.Branch:
+		g.load(in.Args[0], "%rax")
+		g.emit("test %%rax, %%rax")
+		yes, no := in.Targets[0], in.Targets[1]
+		if yes == next {
+			g.emit("jz %s", g.blockLabel(no))
+			break
+		}
+		g.emit("jnz %s", g.blockLabel(yes))
+		if no != next {
+			g.emit("jmp %s", g.blockLabel(no))
+		}
+
+	case ir.Return:
+		if len(in.Args) > 0 {
+			g.load(in.Args[0], "%rax")
+		}
+		if g.fn != g.prog.Main {
+			g.emit("decq rt_depth(%%rip)")
+		}
+		g.emit("leave")
+		g.emit("ret")
+	}
+
+	if in.Dst != nil {
+		g.store(in.Dst)
+	}
+}
+
+// divide generates DIV or MOD, which truncate towards zero as idiv does.
+// Dividing the most negative number by -1 overflows for DIV and gives 0
+// for MOD, but would trap in idiv.
+func (g *generator) divide(in *ir.Instr) {
+	g.load(in.Args[0], "%rax")
+	g.load(in.Args[1], "%rcx")
+	g.emit("test %%rcx, %%rcx")
+	g.emit("jz rt_division_by_zero")
+	g.emit("cmp $-1, %%rcx")
+	g.emit("jne 1f")
+	if in.Op == ir.Div {
+		g.emit("neg %%rax")
+		g.emit("jo rt_integer_overflow")
+	} else {
+		g.emit("xor %%eax, %%eax")
+	}
+	g.emit("jmp 2f")
+	g.line("1:")
+	g.emit("cqo")
+	g.emit("idiv %%rcx")
+	if in.Op == ir.Mod {
+		g.emit("mov %%rdx, %%rax")
+	}
+	g.line("2:")
+}
+
+// call passes the first six arguments in registers and pushes the rest,
+// last first, keeping the stack aligned
+func (g *generator) call(in *ir.Instr) {
+	pushed := 0
+	if len(in.Args) > len(argRegs) {
+		pushed = len(in.Args) - len(argRegs)
+		if pushed%2 == 1 {
+			g.emit("sub $8, %%rsp")
+			pushed++
+		}
+		for n := len(in.Args) - 1; n >= len(argRegs); n-- {
+			g.load(in.Args[n], "%rax")
+			g.emit("push %%rax")
+		}
+	}
+
+	for n, arg := range in.Args {
+		if n < len(argRegs) {
+			g.load(arg, argRegs[n])
+		}
+	}
+
+	g.emit("call %s", g.funcs[in.Callee])
+	if pushed > 0 {
+		g.emit("add $%d, %%rsp", 8*pushed)
+	}
+}
+
+// table gives the label of a table of the addresses and lengths of names,
+// which Write indexes by ordinal
+func (g *generator) table(names []string) string {
+	key := strings.Join(names, "\x00")
+	if label, ok := g.tables[key]; ok {
+		return label
+	}
+
+	label := fmt.Sprintf(".Lnames%d", len(g.tables))
+	g.tables[key] = label
+	g.strings = append(g.strings, key)
+
+	return label
+}
+
+// runtime generates the routines the functions call
+func (g *generator) runtime() {
+	g.line(`
+# write(1, %%rdi, %%rsi)
+rt_write:
+	mov %%rsi, %%rdx
+	mov %%rdi, %%rsi
+	mov $1, %%edi
+	mov $1, %%eax
+	syscall
+	ret
+
+# write the decimal digits of %%rdi, working back from the end of a buffer
+rt_write_int:
+	sub $40, %%rsp
+	mov %%rdi, %%rax
+	lea 32(%%rsp), %%rsi
+	mov $10, %%rcx
+	test %%rax, %%rax
+	jns 1f
+	neg %%rax
+1:
+	xor %%edx, %%edx
+	div %%rcx
+	add $'0', %%dl
+	dec %%rsi
+	mov %%dl, (%%rsi)
+	test %%rax, %%rax
+	jnz 1b
+	test %%rdi, %%rdi
+	jns 2f
+	dec %%rsi
+	movb $'-', (%%rsi)
+2:
+	lea 32(%%rsp), %%rdx
+	sub %%rsi, %%rdx
+	mov $1, %%edi
+	mov $1, %%eax
+	syscall
+	add $40, %%rsp
+	ret`)
+
+	for _, e := range runtimeErrors {
+		g.line("")
+		g.label("rt_" + e.name)
+		g.emit("lea rt_%s_msg(%%rip), %%rsi", e.name)
+		g.emit("mov $rt_%s_len, %%edx", e.name)
+		g.emit("jmp rt_fail")
+	}
+
+	g.line(`
+# write(2, %%rsi, %%rdx) and exit(1)
+rt_fail:
+	mov $2, %%edi
+	mov $1, %%eax
+	syscall
+	mov $60, %%eax
+	mov $1, %%edi
+	syscall`)
+}
+
+// data generates the globals and the strings written
+func (g *generator) data() {
+	g.line("")
+	g.line(".bss")
+	g.line(".balign 8")
+	g.label("rt_depth")
+	g.emit(".quad 0")
+	for _, glob := range g.prog.Globals {
+		g.line("# %s", glob.Name)
+		g.label(g.globals[glob])
+		g.emit(".quad 0")
+	}
+
+	g.line("")
+	g.line(".section .rodata")
+	g.label("rt_newline")
+	g.emit(".ascii \"\\n\"")
+	for _, e := range runtimeErrors {
+		g.label("rt_" + e.name + "_msg")
+		g.emit(".ascii \"%s\"", escape("runtime error: "+e.msg+"\n"))
+		g.emit(".set rt_%s_len, . - rt_%s_msg", e.name, e.name)
+	}
+
+	for n, key := range g.strings {
+		names := strings.Split(key, "\x00")
+		for m, name := range names {
+			g.label(fmt.Sprintf(".Lname%d_%d", n, m))
+			g.emit(".ascii \"%s\"", escape(name))
+		}
+		g.line(".balign 8")
+		g.label(g.tables[key])
+		for m, name := range names {
+			g.emit(".quad .Lname%d_%d, %d", n, m, len(name))
+		}
+	}
+}
+
+// escape quotes a string for .ascii
+func escape(s string) string {
+	var sb strings.Builder
+	for _, c := range []byte(s) {
+		if c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&sb, "\\%03o", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String()
+}
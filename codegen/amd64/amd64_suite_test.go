@@ -0,0 +1,13 @@
+package amd64_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestAmd64(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Amd64 Suite")
+}
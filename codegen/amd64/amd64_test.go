@@ -0,0 +1,231 @@
+package amd64_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/codegen/amd64"
+	"github.com/kieron-dev/lsbasi/interpreter"
+	"github.com/kieron-dev/lsbasi/ir"
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func lower(source string) *ir.Program {
+	node, err := parser.NewParser(lexer.NewTokeniser(strings.NewReader(source))).Program()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	prog, err := ir.Lower(node)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	return prog
+}
+
+// interpret runs a program with the interpreter, giving its output
+func interpret(source string) (string, error) {
+	var out bytes.Buffer
+	interp := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(source))),
+		interpreter.WithOutput(&out))
+	err := interp.Interpret()
+
+	return out.String(), err
+}
+
+var _ = Describe("Compile", func() {
+	It("writes assembly for each function, commented with its IR", func() {
+		var out bytes.Buffer
+		Expect(amd64.Compile(lower(`FUNCTION Twice(n: INTEGER): INTEGER;
+BEGIN Twice := n * 2 END;
+BEGIN a := Twice(4) END.`), &out)).To(Succeed())
+
+		Expect(out.String()).To(ContainSubstring(`
+# Twice
+f1_Twice:
+	push %rbp
+	mov %rsp, %rbp
+	sub $16, %rsp
+	mov %rdi, -8(%rbp)
+`))
+		Expect(out.String()).To(ContainSubstring(`
+	# %1 = call Twice(4)
+	mov $4, %rdi
+	call f1_Twice
+	mov %rax, -8(%rbp)
+	# store a, %1
+	mov -8(%rbp), %rax
+	mov %rax, g0_a(%rip)
+`))
+	})
+
+	It("rejects functions in SSA form", func() {
+		prog := lower(`BEGIN a := 1 END.`)
+		prog.ToSSA()
+
+		Expect(amd64.Compile(prog, ioutil.Discard)).To(MatchError("cannot compile main: functions in SSA form are not supported"))
+	})
+
+	Context("with as and ld", func() {
+		var dir string
+
+		BeforeEach(func() {
+			if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+				Skip("executables only run on linux/amd64")
+			}
+			for _, tool := range []string{"as", "ld"} {
+				if _, err := exec.LookPath(tool); err != nil {
+					Skip(tool + " is not installed")
+				}
+			}
+
+			var err error
+			dir, err = ioutil.TempDir("", "amd64")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		// run compiles, assembles and links a program, then runs it
+		run := func(source string) (stdout, stderr string, status int) {
+			asm := filepath.Join(dir, "prog.s")
+			obj := filepath.Join(dir, "prog.o")
+			exe := filepath.Join(dir, "prog")
+
+			f, err := os.Create(asm)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(amd64.Compile(lower(source), f)).To(Succeed())
+			Expect(f.Close()).To(Succeed())
+
+			for _, args := range [][]string{{"as", "-o", obj, asm}, {"ld", "-o", exe, obj}} {
+				output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+			}
+
+			var out, errOut bytes.Buffer
+			cmd := exec.Command(exe)
+			cmd.Stdout, cmd.Stderr = &out, &errOut
+			if err := cmd.Run(); err != nil {
+				exitErr, ok := err.(*exec.ExitError)
+				Expect(ok).To(BeTrue(), err.Error())
+				status = exitErr.ExitCode()
+			}
+
+			return out.String(), errOut.String(), status
+		}
+
+		DescribeTable("running programs as the interpreter does", func(source string) {
+			expected, err := interpret(source)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(expected).NotTo(BeEmpty())
+
+			stdout, stderr, status := run(source)
+			Expect(stderr).To(BeEmpty())
+			Expect(status).To(BeZero())
+			Expect(stdout).To(Equal(expected))
+		},
+			Entry("arithmetic", `BEGIN
+  a := 7; b := -a * 3 + 100 DIV 7;
+  WriteLn(a, b, -17 DIV 5, -17 MOD 5, 17 MOD -5);
+  WriteLn(9223372036854775807, -9223372036854775807 - 1);
+  WriteLn(Sqr(-7) + Abs(-3) + Succ(1) + Pred(1), 0)
+END.`),
+			Entry("booleans and enumerations", `TYPE Colour = (Red, Green, Blue);
+VAR c: Colour; done: BOOLEAN;
+BEGIN
+  done := 3 < 4;
+  WriteLn(done, 4 <= 3, Odd(-3));
+  FOR c := Red TO Blue DO Write(c, Ord(c));
+  WriteLn;
+  c := Pred(Blue);
+  WriteLn(c)
+END.`),
+			Entry("CASE and FOR", `VAR n, small, odds, other: INTEGER;
+BEGIN
+  small := 0; odds := 0; other := 0;
+  FOR n := 20 DOWNTO 1 DO
+    CASE n OF
+      1..5: small := small + 1;
+      7, 9, 11: odds := odds + n
+    ELSE
+      other := other + 1
+    END;
+  WriteLn(small, odds, other);
+  FOR i := 1 TO 4 DO
+    FOR j := i TO 4 DO
+      Write(i * j);
+  WriteLn;
+  FOR k := 10 TO 1 DO WriteLn(k)
+END.`),
+			Entry("procedures and recursion", `VAR total: INTEGER;
+PROCEDURE Add(n: INTEGER);
+BEGIN total := total + n END;
+FUNCTION Fact(n: INTEGER): INTEGER;
+BEGIN
+  CASE n OF 0: Fact := 1 ELSE Fact := n * Fact(n - 1) END
+END;
+FUNCTION Gcd(a, b: INTEGER): INTEGER;
+BEGIN
+  CASE Ord(b = 0) OF 1: Gcd := a ELSE Gcd := Gcd(b, a MOD b) END
+END;
+BEGIN
+  total := 0;
+  FOR i := 1 TO 10 DO Add(i);
+  WriteLn(total, Gcd(1071, 462));
+  FOR i := 15 TO 20 DO WriteLn(Fact(i))
+END.`),
+			Entry("arguments passed on the stack", `FUNCTION Seven(a, b, c, d, e, f, g: INTEGER): INTEGER;
+BEGIN Seven := a - b + c - d + e - f + g * 100 END;
+FUNCTION Eight(a, b, c, d, e, f, g, h: INTEGER): INTEGER;
+BEGIN Eight := a + b * 2 + c * 3 + d * 4 + e * 5 + f * 6 + g * 7 + h * 8 END;
+BEGIN
+  WriteLn(Seven(1, 2, 3, 4, 5, 6, 7));
+  WriteLn(Eight(1, 2, 3, 4, 5, 6, 7, Seven(1, 1, 1, 1, 1, 1, 1)))
+END.`),
+			Entry("nested procedures", `FUNCTION Outer(n: INTEGER): INTEGER;
+  FUNCTION Inner(m: INTEGER): INTEGER;
+  BEGIN Inner := m * m END;
+BEGIN Outer := Inner(n) + 1 END;
+BEGIN WriteLn(Outer(6)) END.`),
+		)
+
+		DescribeTable("stopping at runtime errors as the interpreter does", func(source, msg string) {
+			expected, err := interpret(source)
+			Expect(err).To(MatchError(ContainSubstring(msg)))
+
+			stdout, stderr, status := run(source)
+			Expect(stdout).To(Equal(expected))
+			Expect(stderr).To(Equal("runtime error: " + msg + "\n"))
+			Expect(status).To(Equal(1))
+		},
+			Entry("division by zero", `BEGIN WriteLn(1); x := 0; WriteLn(1 DIV x) END.`,
+				"division by zero"),
+			Entry("MOD by zero", `BEGIN x := 0; WriteLn(1 MOD x) END.`,
+				"division by zero"),
+			Entry("overflowing multiplication", `BEGIN x := 3037000500; WriteLn(Sqr(x)) END.`,
+				"integer overflow"),
+			Entry("overflowing division", `BEGIN x := -9223372036854775807 - 1; WriteLn(x DIV -1) END.`,
+				"integer overflow"),
+			Entry("runaway recursion", `PROCEDURE P(n: INTEGER); BEGIN P(n + 1) END; BEGIN P(1) END.`,
+				"stack overflow: more than 10000 nested calls"),
+		)
+
+		It("gives 0 for the most negative number MOD -1, as the interpreter does", func() {
+			source := `BEGIN x := -9223372036854775807 - 1; WriteLn(x MOD -1) END.`
+			expected, err := interpret(source)
+			Expect(err).NotTo(HaveOccurred())
+
+			stdout, _, status := run(source)
+			Expect(status).To(BeZero())
+			Expect(stdout).To(Equal(expected))
+		})
+	})
+})
@@ -0,0 +1,330 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/ir"
+)
+
+var binary = map[ir.Op]Opcode{
+	ir.Add: I32Add,
+	ir.Sub: I32Sub,
+	ir.Mul: I32Mul,
+	ir.Div: I32DivS,
+	ir.Mod: I32RemS,
+	ir.Eq:  I32Eq,
+	ir.Ne:  I32Ne,
+	ir.Lt:  I32LtS,
+	ir.Le:  I32LeS,
+	ir.Gt:  I32GtS,
+	ir.Ge:  I32GeS,
+}
+
+type compiler struct {
+	globals map[*ir.Global]int
+	funcs   map[*ir.Func]int
+}
+
+// Compile translates a lowered program to a module. The functions must not
+// be in SSA form.
+func Compile(p *ir.Program) (*Module, error) {
+	c := &compiler{globals: map[*ir.Global]int{}, funcs: map[*ir.Func]int{}}
+	m := &Module{}
+
+	for n, g := range p.Globals {
+		name := strings.ToLower(g.Name)
+		if name == "main" {
+			return nil, fmt.Errorf("cannot export the global %s: main is the name of the main function", g.Name)
+		}
+		c.globals[g] = n
+		m.Globals = append(m.Globals, name)
+	}
+
+	for n, f := range p.Funcs {
+		c.funcs[f] = n
+	}
+
+	for _, f := range p.Funcs {
+		out, err := c.function(f)
+		if err != nil {
+			return nil, err
+		}
+		out.Export = f == p.Main
+		m.Funcs = append(m.Funcs, out)
+	}
+
+	return m, nil
+}
+
+// frameKind is the kind of structured instruction enclosing the code being
+// generated. A br to a loop repeats it, and a br to a block leaves it.
+type frameKind int
+
+const (
+	ifFrame frameKind = iota
+	loopFrame
+	blockFrame
+)
+
+// frame is a structured instruction. The block of a loop frame is its
+// header, and that of a block frame is the block which follows it.
+type frame struct {
+	kind  frameKind
+	block *ir.Block
+}
+
+// function translates the blocks of a function to structured control flow
+// as described by Norman Ramsey in "Beyond Relooper". The code for a block
+// holds the code for the blocks it dominates. A loop header is wrapped in a
+// loop, and a block reached from several others follows a block which they
+// leave with br.
+type function struct {
+	*compiler
+	fn      *ir.Func
+	out     *Func
+	locals  map[*ir.Var]int
+	dom     *ir.Dominators
+	context []frame
+}
+
+func (c *compiler) function(f *ir.Func) (*Func, error) {
+	if f.SSA {
+		return nil, fmt.Errorf("cannot compile %s: functions in SSA form are not supported", f.Name)
+	}
+	if err := check(f); err != nil {
+		return nil, err
+	}
+
+	fc := &function{
+		compiler: c,
+		fn:       f,
+		out:      &Func{Name: f.Name, Params: len(f.Params), Result: f.Result != nil},
+		locals:   map[*ir.Var]int{},
+		dom:      f.Dominators(),
+	}
+
+	for _, v := range append(append([]*ir.Var{}, f.Params...), f.Locals...) {
+		fc.locals[v] = len(fc.out.Locals)
+		fc.out.Locals = append(fc.out.Locals, v.String())
+	}
+
+	if err := fc.tree(f.Blocks[0]); err != nil {
+		return nil, err
+	}
+
+	// every way through the body returns, but validation cannot tell if it
+	// ends with a structured instruction
+	if body := fc.out.Body; fc.out.Result && body[len(body)-1].Op != Return {
+		fc.emit(Instr{Op: Unreachable})
+	}
+
+	return fc.out, nil
+}
+
+// check makes sure the constants of a function fit in 32 bits, and that it
+// has no output, for which a module would need imports
+func check(f *ir.Func) error {
+	for _, b := range f.Blocks {
+		for _, in := range b.Instrs {
+			if in.Op == ir.Write || in.Op == ir.WriteLn {
+				return fmt.Errorf("cannot compile %s: Write and WriteLn are not supported", f.Name)
+			}
+			for _, arg := range in.Args {
+				if c, ok := arg.(ir.Const); ok && (c < math.MinInt32 || c > math.MaxInt32) {
+					return fmt.Errorf("cannot compile %s: %d is outside the 32-bit range", f.Name, c)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (fc *function) emit(in Instr) {
+	fc.out.Body = append(fc.out.Body, in)
+}
+
+func (fc *function) push(kind frameKind, b *ir.Block) {
+	fc.context = append(fc.context, frame{kind: kind, block: b})
+}
+
+func (fc *function) pop() {
+	fc.context = fc.context[:len(fc.context)-1]
+}
+
+// backward is true for an edge which repeats a loop
+func backward(from, to *ir.Block) bool {
+	return to.ID <= from.ID
+}
+
+func loopHeader(b *ir.Block) bool {
+	for _, p := range b.Preds {
+		if backward(p, b) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// merge is true for a block entered by more than one forward edge
+func merge(b *ir.Block) bool {
+	forward := 0
+	for _, p := range b.Preds {
+		if !backward(p, b) {
+			forward++
+		}
+	}
+
+	return forward > 1
+}
+
+// tree generates the code for a block and those it dominates
+func (fc *function) tree(b *ir.Block) error {
+	var merges []*ir.Block
+	for _, child := range fc.dom.Children(b) {
+		if merge(child) {
+			merges = append(merges, child)
+		}
+	}
+	// the last merge block follows the outermost block
+	sort.Slice(merges, func(i, j int) bool { return merges[i].ID > merges[j].ID })
+
+	if !loopHeader(b) {
+		return fc.within(b, merges)
+	}
+
+	fc.emit(Instr{Op: Loop, Label: b.String()})
+	fc.push(loopFrame, b)
+	err := fc.within(b, merges)
+	fc.pop()
+	fc.emit(Instr{Op: End})
+
+	return err
+}
+
+// within generates the code for a block inside blocks followed by the
+// merge blocks it dominates
+func (fc *function) within(b *ir.Block, merges []*ir.Block) error {
+	if len(merges) == 0 {
+		return fc.block(b)
+	}
+
+	follow := merges[0]
+	fc.emit(Instr{Op: Block, Label: follow.String()})
+	fc.push(blockFrame, follow)
+	err := fc.within(b, merges[1:])
+	fc.pop()
+	fc.emit(Instr{Op: End})
+	if err != nil {
+		return err
+	}
+
+	return fc.tree(follow)
+}
+
+// block generates the instructions of a block
+func (fc *function) block(b *ir.Block) error {
+	for _, in := range b.Instrs[:len(b.Instrs)-1] {
+		fc.instr(in)
+	}
+
+	t := b.Terminator()
+	switch t.Op {
+	case ir.Jump:
+		return fc.branch(b, t.Targets[0])
+
+	case ir.Branch:
+		fc.value(t.Args[0])
+		fc.emit(Instr{Op: If})
+		fc.push(ifFrame, nil)
+		defer fc.pop()
+		for n, target := range t.Targets {
+			if n > 0 {
+				fc.emit(Instr{Op: Else})
+			}
+			if err := fc.branch(b, target); err != nil {
+				return err
+			}
+		}
+		fc.emit(Instr{Op: End})
+
+	case ir.Return:
+		if len(t.Args) > 0 {
+			fc.value(t.Args[0])
+		}
+		fc.emit(Instr{Op: Return})
+	}
+
+	return nil
+}
+
+// branch passes control from one block to another: back to the start of a
+// loop, out to a merge block, or into a block dominated by this one
+func (fc *function) branch(from, to *ir.Block) error {
+	switch {
+	case backward(from, to):
+		return fc.br(loopFrame, to)
+	case merge(to):
+		return fc.br(blockFrame, to)
+	}
+
+	return fc.tree(to)
+}
+
+func (fc *function) br(kind frameKind, to *ir.Block) error {
+	for depth := 0; depth < len(fc.context); depth++ {
+		f := fc.context[len(fc.context)-1-depth]
+		if f.kind == kind && f.block == to {
+			fc.emit(Instr{Op: Br, Imm: depth, Label: to.String()})
+			return nil
+		}
+	}
+
+	return fmt.Errorf("cannot compile %s: the control flow into %s is irreducible", fc.fn.Name, to)
+}
+
+func (fc *function) instr(in *ir.Instr) {
+	switch in.Op {
+	case ir.Copy:
+		fc.value(in.Args[0])
+	case ir.Neg:
+		fc.emit(Instr{Op: I32Const})
+		fc.value(in.Args[0])
+		fc.emit(Instr{Op: I32Sub})
+	case ir.Load:
+		fc.emit(Instr{Op: GlobalGet, Imm: fc.globals[in.Global]})
+	case ir.Store:
+		fc.value(in.Args[0])
+		fc.emit(Instr{Op: GlobalSet, Imm: fc.globals[in.Global]})
+	case ir.Call:
+		for _, arg := range in.Args {
+			fc.value(arg)
+		}
+		fc.emit(Instr{Op: Call, Imm: fc.funcs[in.Callee]})
+		if in.Dst == nil && in.Callee.Result != nil {
+			fc.emit(Instr{Op: Drop})
+		}
+	default:
+		fc.value(in.Args[0])
+		fc.value(in.Args[1])
+		fc.emit(Instr{Op: binary[in.Op]})
+	}
+
+	if in.Dst != nil {
+		fc.emit(Instr{Op: LocalSet, Imm: fc.locals[in.Dst]})
+	}
+}
+
+// value pushes a constant or the value of a variable
+func (fc *function) value(v ir.Value) {
+	if c, ok := v.(ir.Const); ok {
+		fc.emit(Instr{Op: I32Const, Imm: int(c)})
+		return
+	}
+
+	fc.emit(Instr{Op: LocalGet, Imm: fc.locals[v.(*ir.Var)]})
+}
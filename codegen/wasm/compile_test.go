@@ -0,0 +1,272 @@
+package wasm_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/codegen/wasm"
+	"github.com/kieron-dev/lsbasi/interpreter"
+	"github.com/kieron-dev/lsbasi/ir"
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/tetratelabs/wazero"
+)
+
+func compile(source string) (*wasm.Module, error) {
+	node, err := parser.NewParser(lexer.NewTokeniser(strings.NewReader(source))).Program()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	prog, err := ir.Lower(node)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	return wasm.Compile(prog)
+}
+
+// run instantiates a module with wazero and calls main, giving the values
+// of its globals
+func run(m *wasm.Module) (map[string]int, error) {
+	var bin bytes.Buffer
+	ExpectWithOffset(1, m.Encode(&bin)).To(Succeed())
+
+	ctx := context.Background()
+	r := wazero.NewRuntime(ctx)
+	defer r.Close(ctx)
+
+	mod, err := r.Instantiate(ctx, bin.Bytes())
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	if _, err := mod.ExportedFunction("main").Call(ctx); err != nil {
+		return nil, err
+	}
+
+	globals := map[string]int{}
+	for _, name := range m.Globals {
+		globals[name] = int(int32(mod.ExportedGlobal(name).Get()))
+	}
+
+	return globals, nil
+}
+
+// interpret runs a program with the interpreter, giving the values of its
+// globals as ints
+func interpret(source string) map[string]int {
+	interp := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(source))),
+		interpreter.WithIntegerMode(interpreter.Int32))
+	ExpectWithOffset(1, interp.Interpret()).To(Succeed())
+
+	globals := map[string]int{}
+	for name, value := range interp.GlobalScope() {
+		switch v := value.(type) {
+		case int:
+			globals[name] = v
+		case bool:
+			globals[name] = 0
+			if v {
+				globals[name] = 1
+			}
+		case interpreter.EnumValue:
+			globals[name] = v.Ord
+		}
+	}
+
+	return globals
+}
+
+var _ = Describe("Compile", func() {
+	It("writes the text format", func() {
+		m, err := compile(`FUNCTION Twice(n: INTEGER): INTEGER;
+BEGIN Twice := n * 2 END;
+BEGIN
+  a := Twice(4);
+  CASE a OF 8: b := 1 ELSE b := 2 END
+END.`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var out bytes.Buffer
+		Expect(m.WAT(&out)).To(Succeed())
+		Expect(out.String()).To(Equal(`(module
+  (global $a (export "a") (mut i32) (i32.const 0))
+  (global $b (export "b") (mut i32) (i32.const 0))
+  (func $main (export "main")
+    (local $%1 i32)
+    (local $%2 i32)
+    (local $%3 i32)
+    block $b3
+      i32.const 4
+      call $Twice
+      local.set $%1
+      local.get $%1
+      global.set $a
+      global.get $a
+      local.set $%2
+      local.get $%2
+      i32.const 8
+      i32.eq
+      local.set $%3
+      local.get $%3
+      if
+        i32.const 1
+        global.set $b
+        br $b3
+      else
+        i32.const 2
+        global.set $b
+        br $b3
+      end
+    end
+    return
+  )
+  (func $Twice (param $n i32) (result i32)
+    (local $Twice i32)
+    local.get $n
+    i32.const 2
+    i32.mul
+    local.set $Twice
+    local.get $Twice
+    return
+  )
+)
+`))
+	})
+
+	It("exports the globals and main", func() {
+		m, err := compile(`VAR Count: INTEGER; BEGIN Count := 3 END.`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var bin bytes.Buffer
+		Expect(m.Encode(&bin)).To(Succeed())
+		Expect(bin.Bytes()).To(HavePrefix("\x00asm\x01\x00\x00\x00"))
+
+		ctx := context.Background()
+		r := wazero.NewRuntime(ctx)
+		defer r.Close(ctx)
+
+		compiled, err := r.CompileModule(ctx, bin.Bytes())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(compiled.ExportedFunctions()).To(HaveLen(1))
+		Expect(compiled.ExportedFunctions()).To(HaveKey("main"))
+
+		mod, err := r.InstantiateModule(ctx, compiled, wazero.NewModuleConfig())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mod.ExportedGlobal("count").Get()).To(BeZero())
+
+		_, err = mod.ExportedFunction("main").Call(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mod.ExportedGlobal("count").Get()).To(BeEquivalentTo(3))
+	})
+
+	DescribeTable("running programs as the interpreter does", func(source string) {
+		m, err := compile(source)
+		Expect(err).NotTo(HaveOccurred())
+
+		globals, err := run(m)
+		Expect(err).NotTo(HaveOccurred())
+
+		expected := interpret(source)
+		Expect(expected).NotTo(BeEmpty())
+		for name, value := range expected {
+			Expect(globals).To(HaveKeyWithValue(name, value))
+		}
+	},
+		Entry("arithmetic", `BEGIN
+  a := 7; b := -a * 3 + 100 DIV 7;
+  c := -17 DIV 5; d := -17 MOD 5; e := 17 MOD -5
+END.`),
+		Entry("comparisons and built-ins", `BEGIN
+  a := Ord(3 < 4) + Ord(4 <= 3) * 2 + Ord(5 <> 5) * 4;
+  b := Sqr(-7) + Abs(-3) + Succ(1) + Pred(1) + Ord(Odd(9))
+END.`),
+		Entry("CASE with ranges, lists and ELSE", `VAR n, small, odds, other: INTEGER;
+BEGIN
+  small := 0; odds := 0; other := 0;
+  FOR n := 1 TO 20 DO
+    CASE n OF
+      1..5: small := small + 1;
+      7, 9, 11: odds := odds + n
+    ELSE
+      other := other + 1
+    END
+END.`),
+		Entry("nested loops", `VAR total: INTEGER;
+BEGIN
+  total := 0;
+  FOR i := 1 TO 10 DO
+    FOR j := i TO 10 DO
+      total := total + i * j;
+  FOR k := 10 TO 1 DO total := 0
+END.`),
+		Entry("enumerations and booleans", `TYPE Colour = (Red, Green, Blue);
+VAR c: Colour; done: BOOLEAN;
+BEGIN
+  c := Succ(Red);
+  done := c = Green;
+  FOR d := Red TO Blue DO n := Ord(d)
+END.`),
+		Entry("procedures and functions", `VAR total: INTEGER;
+PROCEDURE Add(n: INTEGER);
+BEGIN total := total + n END;
+FUNCTION Gcd(a, b: INTEGER): INTEGER;
+BEGIN
+  CASE Ord(b = 0) OF
+    1: Gcd := a
+  ELSE
+    Gcd := Gcd(b, a MOD b)
+  END
+END;
+FUNCTION Fib(n: INTEGER): INTEGER;
+VAR a, b, t: INTEGER;
+BEGIN
+  a := 0; b := 1;
+  FOR i := 1 TO n DO
+  BEGIN
+    t := a + b; a := b; b := t
+  END;
+  Fib := a
+END;
+BEGIN
+  total := 0;
+  Add(3); Add(Gcd(84, 36));
+  f := Fib(20)
+END.`),
+		Entry("nested procedures", `FUNCTION Outer(n: INTEGER): INTEGER;
+  FUNCTION Inner(m: INTEGER): INTEGER;
+  BEGIN Inner := m * m END;
+BEGIN Outer := Inner(n) + 1 END;
+BEGIN x := Outer(6) END.`),
+	)
+
+	It("traps on division by zero", func() {
+		m, err := compile(`BEGIN a := 0; b := 1 DIV a END.`)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = run(m)
+		Expect(err).To(MatchError(ContainSubstring("integer divide by zero")))
+	})
+
+	DescribeTable("rejecting what cannot be compiled", func(source, msg string) {
+		_, err := compile(source)
+		Expect(err).To(MatchError(msg))
+	},
+		Entry("a global named main", `BEGIN Main := 1 END.`,
+			"cannot export the global Main: main is the name of the main function"),
+		Entry("a constant too big for 32 bits", `BEGIN a := 3000000000 END.`,
+			"cannot compile main: 3000000000 is outside the 32-bit range"),
+		Entry("output", `BEGIN WriteLn(1) END.`,
+			"cannot compile main: Write and WriteLn are not supported"),
+	)
+
+	It("rejects functions in SSA form", func() {
+		node, err := parser.NewParser(lexer.NewTokeniser(strings.NewReader(`BEGIN a := 1 END.`))).Program()
+		Expect(err).NotTo(HaveOccurred())
+		prog, err := ir.Lower(node)
+		Expect(err).NotTo(HaveOccurred())
+		prog.ToSSA()
+
+		_, err = wasm.Compile(prog)
+		Expect(err).To(MatchError("cannot compile main: functions in SSA form are not supported"))
+	})
+})
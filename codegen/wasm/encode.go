@@ -0,0 +1,212 @@
+package wasm
+
+import (
+	"bytes"
+	"io"
+)
+
+// section and other codes of the binary format
+const (
+	customSection   = 0
+	typeSection     = 1
+	functionSection = 3
+	globalSection   = 6
+	exportSection   = 7
+	codeSection     = 10
+
+	funcType  = 0x60
+	i32       = 0x7f
+	emptyType = 0x40
+	mutable   = 0x01
+
+	funcExport   = 0x00
+	globalExport = 0x03
+
+	funcNames  = 1
+	localNames = 2
+)
+
+// Encode writes the module in the binary format, with a name section
+// giving the names of its functions and locals
+func (m *Module) Encode(w io.Writer) error {
+	var out bytes.Buffer
+	out.WriteString("\x00asm")
+	out.Write([]byte{1, 0, 0, 0})
+
+	types, typeOf := m.types()
+	section(&out, typeSection, func(b *bytes.Buffer) {
+		uleb(b, len(types))
+		for _, t := range types {
+			b.WriteByte(funcType)
+			uleb(b, t.params)
+			b.Write(bytes.Repeat([]byte{i32}, t.params))
+			if t.result {
+				b.Write([]byte{1, i32})
+			} else {
+				b.WriteByte(0)
+			}
+		}
+	})
+
+	section(&out, functionSection, func(b *bytes.Buffer) {
+		uleb(b, len(m.Funcs))
+		for n := range m.Funcs {
+			uleb(b, typeOf[n])
+		}
+	})
+
+	section(&out, globalSection, func(b *bytes.Buffer) {
+		uleb(b, len(m.Globals))
+		for range m.Globals {
+			b.Write([]byte{i32, mutable, byte(I32Const), 0, byte(End)})
+		}
+	})
+
+	section(&out, exportSection, func(b *bytes.Buffer) {
+		exports := len(m.Globals)
+		for _, f := range m.Funcs {
+			if f.Export {
+				exports++
+			}
+		}
+		uleb(b, exports)
+
+		for n, f := range m.Funcs {
+			if f.Export {
+				name(b, f.Name)
+				b.WriteByte(funcExport)
+				uleb(b, n)
+			}
+		}
+		for n, g := range m.Globals {
+			name(b, g)
+			b.WriteByte(globalExport)
+			uleb(b, n)
+		}
+	})
+
+	section(&out, codeSection, func(b *bytes.Buffer) {
+		uleb(b, len(m.Funcs))
+		for _, f := range m.Funcs {
+			var body bytes.Buffer
+			if locals := len(f.Locals) - f.Params; locals > 0 {
+				body.WriteByte(1)
+				uleb(&body, locals)
+				body.WriteByte(i32)
+			} else {
+				body.WriteByte(0)
+			}
+			for _, in := range f.Body {
+				in.encode(&body)
+			}
+			body.WriteByte(byte(End))
+
+			uleb(b, body.Len())
+			body.WriteTo(b)
+		}
+	})
+
+	section(&out, customSection, func(b *bytes.Buffer) {
+		name(b, "name")
+		section(b, funcNames, func(b *bytes.Buffer) {
+			uleb(b, len(m.Funcs))
+			for n, f := range m.Funcs {
+				uleb(b, n)
+				name(b, f.Name)
+			}
+		})
+		section(b, localNames, func(b *bytes.Buffer) {
+			uleb(b, len(m.Funcs))
+			for n, f := range m.Funcs {
+				uleb(b, n)
+				uleb(b, len(f.Locals))
+				for l, local := range f.Locals {
+					uleb(b, l)
+					name(b, local)
+				}
+			}
+		})
+	})
+
+	_, err := out.WriteTo(w)
+	return err
+}
+
+type signature struct {
+	params int
+	result bool
+}
+
+// types lists the distinct signatures of the functions, and gives the
+// index of the signature of each function
+func (m *Module) types() ([]signature, []int) {
+	var types []signature
+	index := map[signature]int{}
+	typeOf := make([]int, len(m.Funcs))
+
+	for n, f := range m.Funcs {
+		sig := signature{params: f.Params, result: f.Result}
+		if _, ok := index[sig]; !ok {
+			index[sig] = len(types)
+			types = append(types, sig)
+		}
+		typeOf[n] = index[sig]
+	}
+
+	return types, typeOf
+}
+
+func (in Instr) encode(b *bytes.Buffer) {
+	b.WriteByte(byte(in.Op))
+
+	switch in.Op {
+	case Block, Loop, If:
+		b.WriteByte(emptyType)
+	case Br, Call, LocalGet, LocalSet, GlobalGet, GlobalSet:
+		uleb(b, in.Imm)
+	case I32Const:
+		sleb(b, in.Imm)
+	}
+}
+
+// section writes a section, or a subsection of the name section, with its
+// size
+func section(out *bytes.Buffer, id byte, content func(b *bytes.Buffer)) {
+	var b bytes.Buffer
+	content(&b)
+
+	out.WriteByte(id)
+	uleb(out, b.Len())
+	b.WriteTo(out)
+}
+
+func name(b *bytes.Buffer, s string) {
+	uleb(b, len(s))
+	b.WriteString(s)
+}
+
+// uleb writes an unsigned LEB128 number
+func uleb(b *bytes.Buffer, n int) {
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			b.WriteByte(c)
+			return
+		}
+		b.WriteByte(c | 0x80)
+	}
+}
+
+// sleb writes a signed LEB128 number
+func sleb(b *bytes.Buffer, n int) {
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if (n == 0 && c&0x40 == 0) || (n == -1 && c&0x40 != 0) {
+			b.WriteByte(c)
+			return
+		}
+		b.WriteByte(c | 0x80)
+	}
+}
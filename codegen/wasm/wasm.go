@@ -0,0 +1,102 @@
+// Package wasm compiles lowered programs to WebAssembly modules, which can
+// be run in a browser or any other WebAssembly runtime.
+//
+// INTEGER and other ordinal values are 32-bit, as in the interpreter's
+// 32-bit mode, but arithmetic wraps around rather than failing when it
+// leaves the range. Division by zero traps. The variables of the main
+// program are exported as mutable globals, named in lower case, and
+// initialised to 0. The statements of the main program are exported as
+// the function main.
+package wasm
+
+// Opcode is a WebAssembly instruction
+type Opcode byte
+
+const (
+	Unreachable Opcode = 0x00
+	Block       Opcode = 0x02
+	Loop        Opcode = 0x03
+	If          Opcode = 0x04
+	Else        Opcode = 0x05
+	End         Opcode = 0x0b
+	Br          Opcode = 0x0c
+	Return      Opcode = 0x0f
+	Call        Opcode = 0x10
+	Drop        Opcode = 0x1a
+	LocalGet    Opcode = 0x20
+	LocalSet    Opcode = 0x21
+	GlobalGet   Opcode = 0x23
+	GlobalSet   Opcode = 0x24
+	I32Const    Opcode = 0x41
+	I32Eq       Opcode = 0x46
+	I32Ne       Opcode = 0x47
+	I32LtS      Opcode = 0x48
+	I32GtS      Opcode = 0x4a
+	I32LeS      Opcode = 0x4c
+	I32GeS      Opcode = 0x4e
+	I32Add      Opcode = 0x6a
+	I32Sub      Opcode = 0x6b
+	I32Mul      Opcode = 0x6c
+	I32DivS     Opcode = 0x6d
+	I32RemS     Opcode = 0x6f
+)
+
+var mnemonics = map[Opcode]string{
+	Unreachable: "unreachable",
+	Block:       "block",
+	Loop:        "loop",
+	If:          "if",
+	Else:        "else",
+	End:         "end",
+	Br:          "br",
+	Return:      "return",
+	Call:        "call",
+	Drop:        "drop",
+	LocalGet:    "local.get",
+	LocalSet:    "local.set",
+	GlobalGet:   "global.get",
+	GlobalSet:   "global.set",
+	I32Const:    "i32.const",
+	I32Eq:       "i32.eq",
+	I32Ne:       "i32.ne",
+	I32LtS:      "i32.lt_s",
+	I32GtS:      "i32.gt_s",
+	I32LeS:      "i32.le_s",
+	I32GeS:      "i32.ge_s",
+	I32Add:      "i32.add",
+	I32Sub:      "i32.sub",
+	I32Mul:      "i32.mul",
+	I32DivS:     "i32.div_s",
+	I32RemS:     "i32.rem_s",
+}
+
+func (op Opcode) String() string {
+	return mnemonics[op]
+}
+
+// Instr is an instruction with its immediate: the value of i32.const, the
+// index of a local, global or function, or the depth of the label a br
+// goes to. Label names the block or loop an instruction starts or a br
+// goes to, for the text format.
+type Instr struct {
+	Op    Opcode
+	Imm   int
+	Label string
+}
+
+// Func is a function of a module. Its locals are numbered from 0, starting
+// with the parameters.
+type Func struct {
+	Name   string
+	Params int
+	Result bool
+	Locals []string
+	Body   []Instr
+	Export bool
+}
+
+// Module is a compiled program
+type Module struct {
+	Globals []string
+	Funcs   []*Func
+}
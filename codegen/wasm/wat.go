@@ -0,0 +1,79 @@
+package wasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WAT writes the module in the text format
+func (m *Module) WAT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, "(module")
+
+	for _, g := range m.Globals {
+		fmt.Fprintf(out, "  (global $%s (export %q) (mut i32) (i32.const 0))\n", g, g)
+	}
+
+	for _, f := range m.Funcs {
+		m.function(out, f)
+	}
+
+	fmt.Fprintln(out, ")")
+
+	return out.Flush()
+}
+
+func (m *Module) function(out *bufio.Writer, f *Func) {
+	fmt.Fprintf(out, "  (func $%s", f.Name)
+	if f.Export {
+		fmt.Fprintf(out, " (export %q)", f.Name)
+	}
+	for _, p := range f.Locals[:f.Params] {
+		fmt.Fprintf(out, " (param $%s i32)", p)
+	}
+	if f.Result {
+		fmt.Fprint(out, " (result i32)")
+	}
+	fmt.Fprintln(out)
+
+	for _, l := range f.Locals[f.Params:] {
+		fmt.Fprintf(out, "    (local $%s i32)\n", l)
+	}
+
+	depth := 2
+	for _, in := range f.Body {
+		if in.Op == End || in.Op == Else {
+			depth--
+		}
+		fmt.Fprintf(out, "%s%s\n", strings.Repeat("  ", depth), m.text(f, in))
+		if in.Op == Block || in.Op == Loop || in.Op == If || in.Op == Else {
+			depth++
+		}
+	}
+
+	fmt.Fprintln(out, "  )")
+}
+
+// text gives an instruction with its immediate, naming the locals, globals,
+// functions and labels it refers to
+func (m *Module) text(f *Func, in Instr) string {
+	switch in.Op {
+	case Block, Loop:
+		return fmt.Sprintf("%s $%s", in.Op, in.Label)
+	case Br:
+		return fmt.Sprintf("br $%s", in.Label)
+	case Call:
+		return fmt.Sprintf("call $%s", m.Funcs[in.Imm].Name)
+	case LocalGet, LocalSet:
+		return fmt.Sprintf("%s $%s", in.Op, f.Locals[in.Imm])
+	case GlobalGet, GlobalSet:
+		return fmt.Sprintf("%s $%s", in.Op, m.Globals[in.Imm])
+	case I32Const:
+		return fmt.Sprintf("i32.const %d", in.Imm)
+	}
+
+	return in.Op.String()
+}
@@ -0,0 +1,413 @@
+// Package debugger steps through programs as they are interpreted
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/interpreter"
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/loader"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// ErrQuit is returned when the user quits before the program completes
+var ErrQuit = errors.New("debugger quit")
+
+type mode int
+
+const (
+	running mode = iota
+	stepInto
+	stepOver
+	stepOut
+)
+
+// Frame is a statement which is currently executing
+type Frame struct {
+	Node parser.ASTNode
+	Pos  lexer.Position
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%s %s", f.Pos, Describe(f.Node))
+}
+
+type Debugger struct {
+	in          *bufio.Scanner
+	out         io.Writer
+	interp      *interpreter.Interpreter
+	lines       []string
+	breakpoints map[int]bool
+	watches     []string
+	stack       []Frame
+	mode        mode
+	stepDepth   int
+	lastLine    int
+	lineDepth   int
+	searchPath  []string
+}
+
+func NewDebugger(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		in:          bufio.NewScanner(in),
+		out:         out,
+		breakpoints: map[int]bool{},
+		mode:        stepInto,
+		searchPath:  []string{"."},
+	}
+}
+
+// SetSearchPath sets the directories to search for the units a program
+// uses, which is the current directory by default
+func (d *Debugger) SetSearchPath(dirs ...string) {
+	d.searchPath = dirs
+}
+
+// SetBreakpoint pauses execution before the first statement on line
+func (d *Debugger) SetBreakpoint(line int) {
+	d.breakpoints[line] = true
+}
+
+func (d *Debugger) ClearBreakpoint(line int) {
+	delete(d.breakpoints, line)
+}
+
+func (d *Debugger) Breakpoints() []int {
+	lines := []int{}
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+
+	return lines
+}
+
+// Watch adds an expression to be evaluated every time execution pauses
+func (d *Debugger) Watch(expr string) error {
+	if _, err := parseExpr(expr); err != nil {
+		return err
+	}
+
+	d.watches = append(d.watches, expr)
+
+	return nil
+}
+
+// StopOnEntry controls whether execution pauses before the first statement
+func (d *Debugger) StopOnEntry(stop bool) {
+	if stop {
+		d.mode = stepInto
+	} else {
+		d.mode = running
+	}
+}
+
+// Run interprets the program in src, pausing for commands as required
+func (d *Debugger) Run(src io.Reader) (*interpreter.Interpreter, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("reading source: %w", err)
+	}
+	d.lines = strings.Split(string(data), "\n")
+
+	prog, err := loader.NewLoader(d.searchPath...).Load(strings.NewReader(string(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	d.interp = interpreter.NewInterpreter(prog,
+		interpreter.WithUnits(prog.UnitNodes()...),
+		interpreter.WithHook(d))
+
+	return d.interp, d.interp.Interpret()
+}
+
+// Stack returns the executing statements, innermost first
+func (d *Debugger) Stack() []Frame {
+	frames := make([]Frame, len(d.stack))
+	for i, frame := range d.stack {
+		frames[len(d.stack)-1-i] = frame
+	}
+
+	return frames
+}
+
+// Calls returns the procedure and function calls in progress, innermost
+// first
+func (d *Debugger) Calls() []interpreter.Call {
+	if d.interp == nil {
+		return nil
+	}
+
+	return d.interp.Calls()
+}
+
+// Vars returns the variables in the current scope: the parameters and
+// locals of the innermost call, or the globals outside any call
+func (d *Debugger) Vars() map[string]interface{} {
+	if d.interp == nil {
+		return map[string]interface{}{}
+	}
+
+	return d.interp.LocalScope()
+}
+
+// Eval evaluates expr against the current state of the program
+func (d *Debugger) Eval(expr string) (interface{}, error) {
+	node, err := parseExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.interp == nil {
+		return nil, errors.New("program is not running")
+	}
+
+	return d.interp.Eval(node)
+}
+
+func (d *Debugger) BeforeStatement(node parser.ASTNode, pos lexer.Position) error {
+	depth := len(d.stack)
+	d.stack = append(d.stack, Frame{Node: node, Pos: pos})
+
+	pause := d.shouldPause(depth, pos)
+	if pos.Line != d.lastLine {
+		d.lastLine = pos.Line
+		d.lineDepth = depth
+	}
+
+	if !pause {
+		return nil
+	}
+
+	return d.pause(depth)
+}
+
+func (d *Debugger) AfterStatement(node parser.ASTNode, err error) {
+	d.stack = d.stack[:len(d.stack)-1]
+
+	// once the outermost statement on the line finishes, running the line
+	// again, as the body of a loop does, reaches its breakpoint again
+	if len(d.stack) <= d.lineDepth {
+		d.lastLine = 0
+	}
+}
+
+func (d *Debugger) shouldPause(depth int, pos lexer.Position) bool {
+	switch d.mode {
+	case stepInto:
+		return true
+	case stepOver:
+		if depth <= d.stepDepth {
+			return true
+		}
+	case stepOut:
+		if depth < d.stepDepth {
+			return true
+		}
+	}
+
+	return d.breakpoints[pos.Line] && pos.Line != d.lastLine
+}
+
+func (d *Debugger) pause(depth int) error {
+	frame := d.stack[len(d.stack)-1]
+	fmt.Fprintf(d.out, "stopped at %s\n", frame)
+	if line := d.sourceLine(frame.Pos.Line); line != "" {
+		fmt.Fprintf(d.out, "%5d  %s\n", frame.Pos.Line, line)
+	}
+	d.printWatches()
+
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			d.mode = running
+			return nil
+		}
+
+		cmd, arg := splitCommand(d.in.Text())
+
+		switch cmd {
+		case "":
+			continue
+
+		case "s", "step":
+			d.mode = stepInto
+			return nil
+
+		case "n", "next":
+			d.mode = stepOver
+			d.stepDepth = depth
+			return nil
+
+		case "o", "out", "finish":
+			d.mode = stepOut
+			d.stepDepth = depth
+			return nil
+
+		case "c", "continue":
+			d.mode = running
+			return nil
+
+		case "q", "quit":
+			return ErrQuit
+
+		case "b", "break":
+			line, err := strconv.Atoi(arg)
+			if err != nil {
+				fmt.Fprintf(d.out, "invalid line number %q\n", arg)
+				continue
+			}
+			d.SetBreakpoint(line)
+			fmt.Fprintf(d.out, "breakpoint set at line %d\n", line)
+
+		case "clear":
+			line, err := strconv.Atoi(arg)
+			if err != nil {
+				fmt.Fprintf(d.out, "invalid line number %q\n", arg)
+				continue
+			}
+			d.ClearBreakpoint(line)
+			fmt.Fprintf(d.out, "breakpoint cleared at line %d\n", line)
+
+		case "bt", "stack":
+			d.printCalls()
+
+		case "v", "vars":
+			d.printVars()
+
+		case "p", "print":
+			val, err := d.Eval(arg)
+			if err != nil {
+				fmt.Fprintf(d.out, "error: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(d.out, "%s = %v\n", arg, val)
+
+		case "w", "watch":
+			if err := d.Watch(arg); err != nil {
+				fmt.Fprintf(d.out, "error: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(d.out, "watching %s\n", arg)
+
+		case "h", "help":
+			fmt.Fprint(d.out, help)
+
+		default:
+			fmt.Fprintf(d.out, "unknown command %q, try help\n", cmd)
+		}
+	}
+}
+
+const help = `commands:
+  s, step          step into the next statement
+  n, next          step over the current statement
+  o, out, finish   run until the enclosing statement completes
+  c, continue      run until the next breakpoint
+  b, break LINE    set a breakpoint
+  clear LINE       clear a breakpoint
+  bt, stack        print the procedure and function calls in progress
+  v, vars          print the variables in scope
+  p, print EXPR    evaluate an expression
+  w, watch EXPR    evaluate an expression at every pause
+  q, quit          abandon the program
+`
+
+// printCalls lists the calls in progress, each with the position it has
+// reached, ending with the main program
+func (d *Debugger) printCalls() {
+	pos := d.stack[len(d.stack)-1].Pos
+	calls := d.Calls()
+	for n, call := range calls {
+		fmt.Fprintf(d.out, "#%d %s at %s\n", n, call, pos)
+		pos = call.Pos
+	}
+	fmt.Fprintf(d.out, "#%d %s at %s\n", len(calls), interpreter.MainProgram, pos)
+}
+
+func (d *Debugger) printVars() {
+	vars := d.Vars()
+	names := []string{}
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(d.out, "%s = %v\n", name, vars[name])
+	}
+}
+
+func (d *Debugger) printWatches() {
+	for _, expr := range d.watches {
+		val, err := d.Eval(expr)
+		if err != nil {
+			fmt.Fprintf(d.out, "watch %s: %v\n", expr, err)
+			continue
+		}
+		fmt.Fprintf(d.out, "watch %s = %v\n", expr, val)
+	}
+}
+
+func (d *Debugger) sourceLine(line int) string {
+	if line < 1 || line > len(d.lines) {
+		return ""
+	}
+
+	return strings.TrimSpace(d.lines[line-1])
+}
+
+func splitCommand(input string) (string, string) {
+	input = strings.TrimSpace(input)
+	parts := strings.SplitN(input, " ", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], strings.TrimSpace(parts[1])
+}
+
+func parseExpr(expr string) (parser.ASTNode, error) {
+	pars := parser.NewParser(lexer.NewTokeniser(strings.NewReader(expr)))
+	if _, err := pars.NextToken(); err != nil {
+		return nil, err
+	}
+
+	node, err := pars.Expr()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := pars.CurrentToken(); token.Type != lexer.EOF {
+		return nil, fmt.Errorf("unexpected %s after expression", token.Type)
+	}
+
+	return node, nil
+}
+
+// Describe gives a short description of a statement node
+func Describe(node parser.ASTNode) string {
+	switch n := node.(type) {
+	case *parser.CompoundNode:
+		return "compound statement"
+	case *parser.AssignNode:
+		return fmt.Sprintf("assignment to %s", parser.Designator(n.Left))
+	case *parser.CaseNode:
+		return "case statement"
+	case *parser.ForNode:
+		return fmt.Sprintf("for loop over %s", n.Var.Value)
+	case *parser.ProcCallNode:
+		return fmt.Sprintf("call to %s", n.Name)
+	}
+
+	return fmt.Sprintf("%T", node)
+}
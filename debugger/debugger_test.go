@@ -0,0 +1,213 @@
+package debugger_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/debugger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const program = `BEGIN
+    BEGIN
+        number := 2;
+        a := number;
+        b := 10 * a + 10 * number DIV 4
+    END;
+    x := 11
+END.
+`
+
+var _ = Describe("Debugger", func() {
+	var (
+		commands string
+		out      *bytes.Buffer
+		dbg      *debugger.Debugger
+		runErr   error
+		result   map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		out = new(bytes.Buffer)
+		commands = ""
+	})
+
+	JustBeforeEach(func() {
+		dbg = debugger.NewDebugger(strings.NewReader(commands), out)
+		interp, err := dbg.Run(strings.NewReader(program))
+		runErr = err
+		if interp != nil {
+			result = interp.GlobalScope()
+		}
+	})
+
+	It("stops on entry and runs to completion when commands run out", func() {
+		Expect(runErr).NotTo(HaveOccurred())
+		Expect(out.String()).To(ContainSubstring("stopped at 1:1 compound statement"))
+		Expect(result).To(HaveKeyWithValue("x", 11))
+	})
+
+	Context("stepping", func() {
+		BeforeEach(func() {
+			commands = "step\nstep\nstack\nnext\nout\nvars\ncontinue\n"
+		})
+
+		It("steps into, over and out of statements", func() {
+			Expect(runErr).NotTo(HaveOccurred())
+			output := out.String()
+			Expect(output).To(ContainSubstring("stopped at 2:5 compound statement"))
+			Expect(output).To(ContainSubstring("stopped at 3:9 assignment to number"))
+			Expect(output).To(ContainSubstring("#0 <program> at 3:9\n"))
+			Expect(output).To(ContainSubstring("stopped at 4:9 assignment to a"))
+			Expect(output).To(ContainSubstring("stopped at 7:5 assignment to x"))
+			Expect(output).To(ContainSubstring("a = 2\nb = 25\nnumber = 2\n"))
+		})
+	})
+
+	Context("breakpoints and watches", func() {
+		BeforeEach(func() {
+			commands = "break 5\nwatch a * 3\ncontinue\nprint number + a\ncontinue\n"
+		})
+
+		It("stops at breakpoints and evaluates expressions", func() {
+			Expect(runErr).NotTo(HaveOccurred())
+			output := out.String()
+			Expect(output).To(ContainSubstring("stopped at 5:9 assignment to b"))
+			Expect(output).To(ContainSubstring("watch a * 3 = 6"))
+			Expect(output).To(ContainSubstring("number + a = 4"))
+		})
+	})
+
+	Context("quitting", func() {
+		BeforeEach(func() {
+			commands = "quit\n"
+		})
+
+		It("abandons the program", func() {
+			Expect(runErr).To(MatchError(debugger.ErrQuit))
+		})
+	})
+})
+
+var _ = Describe("Debugger Go API", func() {
+	It("sets breakpoints and watches without commands", func() {
+		out := new(bytes.Buffer)
+		dbg := debugger.NewDebugger(strings.NewReader("vars\n"), out)
+		dbg.StopOnEntry(false)
+		dbg.SetBreakpoint(7)
+		Expect(dbg.Watch("b - 1")).To(Succeed())
+		Expect(dbg.Watch("b +")).NotTo(Succeed())
+		Expect(dbg.Breakpoints()).To(Equal([]int{7}))
+
+		_, err := dbg.Run(strings.NewReader(program))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out.String()).To(HavePrefix("stopped at 7:5 assignment to x"))
+		Expect(out.String()).To(ContainSubstring("watch b - 1 = 24"))
+	})
+})
+
+var _ = Describe("Breakpoints in loops", func() {
+	It("stops on every iteration, but once for each pass over a line", func() {
+		out := new(bytes.Buffer)
+		dbg := debugger.NewDebugger(strings.NewReader("b 5\nb 6\nc\nc\nc\nc\nc\nc\nc\n"), out)
+
+		interp, err := dbg.Run(strings.NewReader("VAR i, x, y: INTEGER;\nBEGIN\n  x := 0; y := 0;\n  FOR i := 1 TO 3 DO\n    x := x + i;\n  FOR i := 1 TO 2 DO BEGIN y := y + i; y := y * 2 END\nEND."))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(interp.GlobalScope()).To(HaveKeyWithValue("x", 6))
+
+		output := out.String()
+		Expect(strings.Count(output, "stopped at 5:5 assignment to x")).To(Equal(3))
+		Expect(strings.Count(output, "stopped at 6:")).To(Equal(1))
+	})
+})
+
+var _ = Describe("Debugging a program which uses units", func() {
+	It("loads the units from the search path", func() {
+		out := new(bytes.Buffer)
+		dbg := debugger.NewDebugger(strings.NewReader("continue\n"), out)
+		dbg.SetSearchPath("../loader/testdata/units")
+
+		interp, err := dbg.Run(strings.NewReader("USES Counter;\nBEGIN\n  Reset;\n  Add(2);\n  x := count + Scaled(1)\nEND."))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(interp.GlobalScope()).To(HaveKeyWithValue("x", 7))
+	})
+
+	It("reports units which cannot be found", func() {
+		dbg := debugger.NewDebugger(strings.NewReader(""), new(bytes.Buffer))
+		dbg.SetSearchPath("testdata")
+
+		_, err := dbg.Run(strings.NewReader("USES Counter; BEGIN END."))
+		Expect(err).To(MatchError(ContainSubstring("unit Counter not found")))
+	})
+})
+
+var _ = Describe("Printing variables", func() {
+	It("prints values of any type", func() {
+		out := new(bytes.Buffer)
+		dbg := debugger.NewDebugger(strings.NewReader("step\nstep\nvars\n"), out)
+
+		_, err := dbg.Run(strings.NewReader("BEGIN r := 1.5; x := 1 END."))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out.String()).To(ContainSubstring("r = 1.5\n"))
+	})
+})
+
+var _ = Describe("Debugging procedures", func() {
+	const program = `VAR total: INTEGER;
+FUNCTION Square(n: INTEGER): INTEGER;
+VAR sq: INTEGER;
+BEGIN
+  sq := n * n;
+  Square := sq
+END;
+PROCEDURE Add(VAR sum: INTEGER; n: INTEGER);
+BEGIN
+  sum := sum + Square(n)
+END;
+BEGIN
+  total := 1;
+  Add(total, 3)
+END.
+`
+
+	It("shows the locals and calls of the innermost call", func() {
+		out := new(bytes.Buffer)
+		dbg := debugger.NewDebugger(strings.NewReader("vars\nbt\ncontinue\n"), out)
+		dbg.StopOnEntry(false)
+		dbg.SetBreakpoint(6)
+
+		interp, err := dbg.Run(strings.NewReader(program))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(interp.GlobalScope()).To(HaveKeyWithValue("total", 10))
+
+		output := out.String()
+		Expect(output).To(ContainSubstring("(debug) n = 3\nsq = 9\n(debug)"))
+		Expect(output).NotTo(ContainSubstring("total ="))
+		Expect(output).To(ContainSubstring("#0 Square(n: 3) at 6:3\n#1 Add(sum: 1, n: 3) at 10:16\n#2 <program> at 14:3\n"))
+	})
+	It("does not pause in functions called by watches", func() {
+		out := new(bytes.Buffer)
+		dbg := debugger.NewDebugger(strings.NewReader("watch Square(total)\nstep\nstep\ncontinue\n"), out)
+
+		interp, err := dbg.Run(strings.NewReader(program))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(interp.GlobalScope()).To(HaveKeyWithValue("total", 10))
+
+		output := out.String()
+		Expect(strings.Count(output, "stopped at")).To(Equal(3))
+		Expect(output).To(ContainSubstring("stopped at 14:3 call to Add\n"))
+		Expect(output).To(ContainSubstring("watch Square(total) = 1\n"))
+	})
+
+	It("shows VAR parameters with the value of the variable they share", func() {
+		out := new(bytes.Buffer)
+		dbg := debugger.NewDebugger(strings.NewReader("vars\n"), out)
+		dbg.StopOnEntry(false)
+		dbg.SetBreakpoint(10)
+
+		_, err := dbg.Run(strings.NewReader(program))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out.String()).To(ContainSubstring("(debug) n = 3\nsum = 1\n"))
+	})
+})
@@ -0,0 +1,499 @@
+package flow
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// Choice is a set of edges out of one block, any of which may be taken
+type Choice []*Edge
+
+func (c Choice) String() string {
+	ways := make([]string, len(c))
+	for n, e := range c {
+		ways[n] = e.String()
+	}
+
+	return strings.Join(ways, " or ")
+}
+
+// Read is a read of a variable which may not have been assigned. Path
+// holds the choices made on the ways to the read which do not assign the
+// variable. Always is true if no way to the read assigns it.
+type Read struct {
+	Name   string
+	Pos    lexer.Position
+	Path   []Choice
+	Always bool
+}
+
+// Message describes the read without its position
+func (r *Read) Message() string {
+	if r.Always {
+		return fmt.Sprintf("%q is read before it is assigned", r.Name)
+	}
+
+	return fmt.Sprintf("%q may be read before it is assigned when %s", r.Name, r.when())
+}
+
+func (r *Read) String() string {
+	if r.Always {
+		return fmt.Sprintf("%s at %s", r.Message(), r.Pos)
+	}
+
+	return fmt.Sprintf("%q may be read before it is assigned at %s when %s", r.Name, r.Pos, r.when())
+}
+
+func (r *Read) when() string {
+	choices := make([]string, len(r.Path))
+	for n, c := range r.Path {
+		choices[n] = c.String()
+	}
+
+	return strings.Join(choices, ", and ")
+}
+
+// kind is how a name is treated by the analysis. Only simple variables are
+// tracked: records and arrays are created with their elements, and names
+// declared by enclosing blocks may be assigned before the block runs.
+type kind int
+
+const (
+	untracked kind = iota
+	tracked
+	proc
+)
+
+type name struct {
+	kind  kind
+	byRef []bool
+}
+
+type scope struct {
+	names  map[string]*name
+	types  map[string]bool
+	parent *scope
+}
+
+func (s *scope) lookup(id string) (*name, bool) {
+	id = strings.ToLower(id)
+	for ; s != nil; s = s.parent {
+		if n, ok := s.names[id]; ok {
+			return n, true
+		}
+	}
+
+	return nil, false
+}
+
+// aggregate is true for a named RECORD or ARRAY type
+func (s *scope) aggregate(id string) bool {
+	id = strings.ToLower(id)
+	for ; s != nil; s = s.parent {
+		if agg, ok := s.types[id]; ok {
+			return agg
+		}
+	}
+
+	return false
+}
+
+// event is a read of a variable, or an assignment to it if def is true
+type event struct {
+	id   string
+	node *parser.VarNode
+	def  bool
+}
+
+type analyser struct {
+	scope *scope
+	reads []*Read
+}
+
+// Unassigned finds the reads of variables, in the main program and in
+// every procedure, which may happen before the variable is assigned
+func Unassigned(program parser.ASTNode) []*Read {
+	a := &analyser{}
+	if block, ok := program.(*parser.BlockNode); ok {
+		a.block(block, nil)
+	}
+
+	return a.reads
+}
+
+// block analyses the statements of a block, then the procedures it
+// declares. params are assigned on entry.
+func (a *analyser) block(node *parser.BlockNode, params []string) {
+	a.scope = &scope{names: map[string]*name{}, types: map[string]bool{}, parent: a.scope}
+	defer func() { a.scope = a.scope.parent }()
+
+	for _, p := range params {
+		a.scope.names[strings.ToLower(p)] = &name{kind: untracked}
+	}
+	a.declare(node)
+	a.implicit(node.Compound)
+
+	g := Build(node.Compound)
+	a.check(g)
+
+	for _, p := range node.Procs {
+		a.procedure(p)
+	}
+}
+
+func (a *analyser) procedure(node *parser.ProcDeclNode) {
+	var params []string
+	for _, group := range node.Params {
+		for _, v := range group.Names {
+			params = append(params, v.Value)
+		}
+	}
+
+	a.block(node.Block, params)
+}
+
+// declare records the names declared by a block
+func (a *analyser) declare(node *parser.BlockNode) {
+	for _, c := range node.Consts {
+		a.scope.names[strings.ToLower(c.Name)] = &name{kind: untracked}
+	}
+
+	for _, t := range node.Types {
+		a.scope.names[strings.ToLower(t.Name)] = &name{kind: untracked}
+		a.scope.types[strings.ToLower(t.Name)] = a.isAggregate(t.Type)
+
+		if enum, ok := t.Type.(*parser.EnumType); ok {
+			for _, v := range enum.Values {
+				a.scope.names[strings.ToLower(v.Name)] = &name{kind: untracked}
+			}
+		}
+	}
+
+	for _, decl := range node.Vars {
+		k := tracked
+		if a.isAggregate(decl.Type) {
+			k = untracked
+		}
+		for _, v := range decl.Names {
+			a.scope.names[strings.ToLower(v.Value)] = &name{kind: k}
+		}
+	}
+
+	for _, p := range node.Procs {
+		n := &name{kind: proc}
+		for _, group := range p.Params {
+			for range group.Names {
+				n.byRef = append(n.byRef, group.ByRef)
+			}
+		}
+		a.scope.names[strings.ToLower(p.Name)] = n
+	}
+}
+
+func (a *analyser) isAggregate(spec parser.TypeSpec) bool {
+	switch t := spec.(type) {
+	case *parser.RecordType, *parser.ArrayType:
+		return true
+	case *parser.NamedType:
+		return a.scope.aggregate(t.Name)
+	}
+
+	return false
+}
+
+// implicit declares the variables created by assigning to them, which are
+// the names assigned in the block and not declared
+func (a *analyser) implicit(stmt parser.ASTNode) {
+	g := Build(stmt)
+	for _, b := range g.Blocks {
+		for _, step := range b.Steps {
+			for _, e := range a.events(step) {
+				if _, ok := a.scope.lookup(e.id); e.def && !ok {
+					a.scope.names[e.id] = &name{kind: tracked}
+				}
+			}
+		}
+	}
+}
+
+// tracked is true for a variable of the block being analysed
+func (a *analyser) tracked(id string) bool {
+	n, ok := a.scope.names[id]
+	return ok && n.kind == tracked
+}
+
+// check runs the analysis over the graph of a block and records the reads
+// of its variables which may come before an assignment
+func (a *analyser) check(g *Graph) {
+	events := make([][]event, len(g.Blocks))
+	for _, b := range g.Blocks {
+		for _, step := range b.Steps {
+			events[b.ID] = append(events[b.ID], a.events(step)...)
+		}
+	}
+
+	// must holds the variables assigned on every way into a block, and may
+	// those assigned on some way into it
+	must := solve(g, events, true)
+	may := solve(g, events, false)
+
+	for _, b := range g.Blocks {
+		assigned := copySet(must[b.ID])
+		maybe := copySet(may[b.ID])
+
+		for _, e := range events[b.ID] {
+			if e.def {
+				assigned[e.id] = true
+				maybe[e.id] = true
+				continue
+			}
+			if !a.tracked(e.id) || assigned[e.id] {
+				continue
+			}
+
+			read := &Read{Name: e.node.Value, Pos: e.node.Pos, Always: !maybe[e.id]}
+			if !read.Always {
+				read.Path = witness(g, events, b, e.id)
+			}
+			a.reads = append(a.reads, read)
+
+			// report each variable once on each way through the block
+			assigned[e.id] = true
+		}
+	}
+}
+
+type set map[string]bool
+
+func copySet(s set) set {
+	c := set{}
+	for k, v := range s {
+		c[k] = v
+	}
+
+	return c
+}
+
+// solve finds the variables assigned on entry to each block: on every way
+// into it if must is true, or on some way into it otherwise
+func solve(g *Graph, events [][]event, must bool) []set {
+	universe := set{}
+	for _, evs := range events {
+		for _, e := range evs {
+			if e.def {
+				universe[e.id] = true
+			}
+		}
+	}
+
+	in := make([]set, len(g.Blocks))
+	out := make([]set, len(g.Blocks))
+	for n := range g.Blocks {
+		in[n] = set{}
+		out[n] = set{}
+		if must {
+			out[n] = copySet(universe)
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range g.Blocks {
+			entry := set{}
+			if b != g.Entry {
+				entry = meet(b.Preds, out, must)
+			}
+
+			exit := copySet(entry)
+			for _, e := range events[b.ID] {
+				if e.def {
+					exit[e.id] = true
+				}
+			}
+
+			// the sets only shrink for must and only grow otherwise, so a
+			// change shows in their size
+			if len(exit) != len(out[b.ID]) {
+				changed = true
+			}
+			in[b.ID], out[b.ID] = entry, exit
+		}
+	}
+
+	return in
+}
+
+// meet combines the sets leaving the predecessors of a block, by
+// intersection for must or union otherwise
+func meet(preds []*Edge, out []set, must bool) set {
+	s := set{}
+	for n, e := range preds {
+		from := out[e.From.ID]
+		if !must || n == 0 {
+			for id := range from {
+				s[id] = true
+			}
+			continue
+		}
+
+		for id := range s {
+			if !from[id] {
+				delete(s, id)
+			}
+		}
+	}
+
+	return s
+}
+
+// witness finds the choices on a way from the entry to a read in block b
+// which does not assign the variable. Each choice holds every way out of
+// its block which reaches the read unassigned, and choices are left out if
+// every way does.
+func witness(g *Graph, events [][]event, b *Block, id string) []Choice {
+	assigns := func(blk *Block) bool {
+		for _, e := range events[blk.ID] {
+			if e.def && e.id == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	// route finds a way from a block to b through blocks which do not
+	// assign the variable, giving the edge into each block on the way
+	route := func(from *Block) (map[*Block]*Edge, bool) {
+		via := map[*Block]*Edge{from: nil}
+		queue := []*Block{from}
+		for len(queue) > 0 {
+			blk := queue[0]
+			queue = queue[1:]
+			if blk == b {
+				return via, true
+			}
+			if assigns(blk) {
+				continue
+			}
+
+			for _, e := range blk.Succs {
+				if _, seen := via[e.To]; !seen {
+					via[e.To] = e
+					queue = append(queue, e.To)
+				}
+			}
+		}
+		return via, false
+	}
+
+	via, _ := route(g.Entry)
+
+	var path []Choice
+	for e := via[b]; e != nil; e = via[e.From] {
+		var ways Choice
+		for _, other := range e.From.Succs {
+			if _, reaches := route(other.To); reaches {
+				ways = append(ways, other)
+			}
+		}
+
+		if len(ways) < len(e.From.Succs) {
+			path = append([]Choice{ways}, path...)
+		}
+	}
+
+	return path
+}
+
+// events lists the reads and assignments made by a step, in the order they
+// happen
+func (a *analyser) events(step parser.ASTNode) []event {
+	var evs []event
+
+	var read func(node parser.ASTNode)
+	var def func(target parser.ASTNode)
+	var args func(callee string, list []parser.ASTNode)
+
+	read = func(node parser.ASTNode) {
+		switch n := node.(type) {
+		case *parser.VarNode:
+			evs = append(evs, event{id: strings.ToLower(n.Value), node: n})
+		case *parser.BinOpNode:
+			read(n.Left)
+			read(n.Right)
+		case *parser.UnaryNode:
+			read(n.Child)
+		case *parser.ToRealNode:
+			read(n.Child)
+		case *parser.SetNode:
+			for _, elem := range n.Elements {
+				read(elem.Low)
+				if elem.High != nil {
+					read(elem.High)
+				}
+			}
+		case *parser.DerefNode:
+			read(n.Pointer)
+		case *parser.FieldNode:
+			read(n.Record)
+		case *parser.IndexNode:
+			read(n.Array)
+			read(n.Index)
+		case *parser.CallNode:
+			args(n.Name, n.Args)
+		}
+	}
+
+	// def records an assignment to a designator. Storing into an element or
+	// field does not read the variable holding it.
+	def = func(target parser.ASTNode) {
+		switch t := target.(type) {
+		case *parser.VarNode:
+			evs = append(evs, event{id: strings.ToLower(t.Value), node: t, def: true})
+		case *parser.IndexNode:
+			read(t.Index)
+			if _, ok := t.Array.(*parser.VarNode); !ok {
+				def(t.Array)
+			}
+		case *parser.FieldNode:
+			if _, ok := t.Record.(*parser.VarNode); !ok {
+				def(t.Record)
+			}
+		default:
+			read(target)
+		}
+	}
+
+	args = func(callee string, list []parser.ASTNode) {
+		var byRef []bool
+		if n, ok := a.scope.lookup(callee); ok && n.kind == proc {
+			byRef = n.byRef
+		} else if !ok && strings.EqualFold(callee, "New") {
+			byRef = []bool{true}
+		}
+
+		for n, arg := range list {
+			if n < len(byRef) && byRef[n] {
+				def(arg)
+			} else {
+				read(arg)
+			}
+		}
+	}
+
+	switch s := step.(type) {
+	case *parser.AssignNode:
+		read(s.Right)
+		def(s.Left)
+	case *parser.ProcCallNode:
+		args(s.Name, s.Args)
+	case *parser.ForNode:
+		def(s.Var)
+	default:
+		read(step)
+	}
+
+	return evs
+}
@@ -0,0 +1,180 @@
+package flow_test
+
+import (
+	"github.com/kieron-dev/lsbasi/flow"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Unassigned", func() {
+	DescribeTable("reads before assignment",
+		func(source string, expected ...string) {
+			var reads []string
+			for _, r := range flow.Unassigned(parse(source)) {
+				reads = append(reads, r.String())
+			}
+
+			if len(expected) == 0 {
+				Expect(reads).To(BeEmpty())
+				return
+			}
+			Expect(reads).To(Equal(expected))
+		},
+
+		Entry("assigned first", `BEGIN x := 1; y := x + 1 END.`),
+
+		Entry("declared but never assigned", `VAR a: INTEGER;
+BEGIN
+  b := a + a;
+  a := b
+END.`,
+			`"a" is read before it is assigned at 3:8`,
+		),
+
+		Entry("read in its own assignment", `BEGIN n := n + 1 END.`,
+			`"n" is read before it is assigned at 1:12`,
+		),
+
+		Entry("assigned in some CASE branches", `BEGIN
+  n := 2;
+  CASE n OF
+    1: x := 1;
+    2: y := 2
+  ELSE
+    x := 3
+  END;
+  z := x
+END.`,
+			`"x" may be read before it is assigned at 9:8 when the CASE branch at 5:5 is taken`,
+		),
+
+		Entry("assigned in no branch", `BEGIN
+  n := 2;
+  CASE n OF
+    1: y := 1
+  ELSE
+    y := 2
+  END;
+  z := x;
+  x := 1
+END.`,
+			`"x" is read before it is assigned at 8:8`,
+		),
+
+		Entry("CASE without ELSE", `BEGIN
+  n := 2;
+  CASE n OF 1, 2: x := 1 END;
+  z := x
+END.`,
+			`"x" may be read before it is assigned at 4:8 when no label of the CASE at 3:3 matches`,
+		),
+
+		Entry("assigned in every branch", `BEGIN
+  n := 2;
+  CASE n OF 1: x := 1; 2: x := 2 ELSE x := 3 END;
+  z := x
+END.`),
+
+		Entry("several branches leave it unassigned", `BEGIN
+  n := 2;
+  CASE n OF
+    1: x := 1;
+    2: y := 2;
+    3: y := 3
+  END;
+  z := x
+END.`,
+			`"x" may be read before it is assigned at 8:8 when the CASE branch at 5:5 is taken or the CASE branch at 6:5 is taken or no label of the CASE at 3:3 matches`,
+		),
+
+		Entry("assigned in a FOR body", `BEGIN
+  n := 0;
+  FOR i := 1 TO n DO x := i;
+  z := x
+END.`,
+			`"x" may be read before it is assigned at 4:8 when the FOR loop at 3:3 runs no times`,
+		),
+
+		Entry("assigned later in a FOR body", `BEGIN
+  FOR i := 1 TO 3 DO
+  BEGIN
+    z := x;
+    x := i
+  END
+END.`,
+			`"x" may be read before it is assigned at 4:10 when the FOR loop at 2:3 runs`,
+		),
+
+		Entry("the FOR control variable", `BEGIN
+  FOR i := 1 TO 3 DO x := i;
+  y := i
+END.`,
+			`"i" may be read before it is assigned at 3:8 when the FOR loop at 2:3 runs no times`,
+		),
+
+		Entry("choices in sequence", `BEGIN
+  n := 1;
+  CASE n OF 1: x := 1 ELSE y := 1 END;
+  CASE n OF 1: y := 2 ELSE x := 2 END;
+  z := x
+END.`,
+			`"x" may be read before it is assigned at 5:8 when the ELSE at 3:23 is taken, and the CASE branch at 4:13 is taken`,
+		),
+
+		Entry("irrelevant choices are left out", `BEGIN
+  n := 1;
+  CASE n OF 1: y := 1 ELSE y := 2 END;
+  CASE n OF 1: x := 1 END;
+  z := x
+END.`,
+			`"x" may be read before it is assigned at 5:8 when no label of the CASE at 4:3 matches`,
+		),
+
+		Entry("variables assigned by procedures", `TYPE pt = ^INTEGER;
+VAR p: pt;
+PROCEDURE Get(VAR n: INTEGER);
+BEGIN n := 1 END;
+BEGIN
+  Get(x);
+  New(p);
+  y := x + p^
+END.`),
+
+		Entry("records and arrays start with their elements", `TYPE point = RECORD x, y: INTEGER END;
+VAR p: point; a: ARRAY [1..3] OF INTEGER;
+BEGIN
+  p.x := 1;
+  a[1] := p.x;
+  b := a[1]
+END.`),
+
+		Entry("in procedures", `VAR g: INTEGER;
+PROCEDURE P(n: INTEGER);
+VAR t: INTEGER;
+BEGIN
+  CASE n OF 1: t := 1 END;
+  g := t + n
+END;
+FUNCTION F: INTEGER;
+BEGIN
+  F := g;
+  CASE g OF 1: F := F + 1 END
+END;
+BEGIN
+  g := 1;
+  P(g);
+  g := F
+END.`,
+			`"t" may be read before it is assigned at 6:8 when no label of the CASE at 5:3 matches`,
+		),
+
+		Entry("globals assigned by procedures", `PROCEDURE Init;
+BEGIN g := 1 END;
+BEGIN
+  g := 0;
+  Init;
+  x := g
+END.`),
+	)
+})
@@ -0,0 +1,154 @@
+// Package flow builds control flow graphs of statements and analyses the
+// flow of values through them
+package flow
+
+import (
+	"fmt"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// EdgeKind is the reason control passes along an edge
+type EdgeKind int
+
+const (
+	Next EdgeKind = iota
+	Branch
+	Else
+	NoMatch
+	LoopBody
+	LoopSkip
+	LoopBack
+	LoopExit
+)
+
+// Edge passes control from one block to another. Pos is the position of
+// the CASE label, ELSE or FOR making the choice.
+type Edge struct {
+	From *Block
+	To   *Block
+	Kind EdgeKind
+	Pos  lexer.Position
+}
+
+func (e *Edge) String() string {
+	switch e.Kind {
+	case Branch:
+		return fmt.Sprintf("the CASE branch at %s is taken", e.Pos)
+	case Else:
+		return fmt.Sprintf("the ELSE at %s is taken", e.Pos)
+	case NoMatch:
+		return fmt.Sprintf("no label of the CASE at %s matches", e.Pos)
+	case LoopBody:
+		return fmt.Sprintf("the FOR loop at %s runs", e.Pos)
+	case LoopSkip:
+		return fmt.Sprintf("the FOR loop at %s runs no times", e.Pos)
+	case LoopBack:
+		return fmt.Sprintf("the FOR loop at %s repeats", e.Pos)
+	case LoopExit:
+		return fmt.Sprintf("the FOR loop at %s finishes", e.Pos)
+	}
+
+	return "next"
+}
+
+// Block is a sequence of steps always executed together. A step is an
+// assignment or procedure call statement, an expression evaluated to choose
+// a branch or bound a loop, or a FOR node, which stands for the assignment
+// of the control variable at the start of each pass through the body.
+type Block struct {
+	ID    int
+	Steps []parser.ASTNode
+	Succs []*Edge
+	Preds []*Edge
+}
+
+// Graph is the control flow graph of a statement. Exit is empty, and is
+// reached when the statement finishes.
+type Graph struct {
+	Blocks []*Block
+	Entry  *Block
+	Exit   *Block
+}
+
+func (g *Graph) newBlock() *Block {
+	b := &Block{ID: len(g.Blocks)}
+	g.Blocks = append(g.Blocks, b)
+
+	return b
+}
+
+func (g *Graph) connect(from, to *Block, kind EdgeKind, pos lexer.Position) {
+	e := &Edge{From: from, To: to, Kind: kind, Pos: pos}
+	from.Succs = append(from.Succs, e)
+	to.Preds = append(to.Preds, e)
+}
+
+// Build constructs the control flow graph of a statement
+func Build(stmt parser.ASTNode) *Graph {
+	g := &Graph{}
+	g.Entry = g.newBlock()
+
+	last := g.statement(g.Entry, stmt)
+
+	g.Exit = g.newBlock()
+	g.connect(last, g.Exit, Next, lexer.Position{})
+
+	return g
+}
+
+// statement adds a statement to the graph, starting in block b, and
+// returns the block where control continues after it
+func (g *Graph) statement(b *Block, stmt parser.ASTNode) *Block {
+	switch n := stmt.(type) {
+	case *parser.CompoundNode:
+		for _, child := range n.Children {
+			b = g.statement(b, child)
+		}
+		return b
+
+	case *parser.NoOpNode:
+		return b
+
+	case *parser.CaseNode:
+		b.Steps = append(b.Steps, n.Expr)
+		after := g.newBlock()
+
+		for _, branch := range n.Branches {
+			body := g.newBlock()
+			g.connect(b, body, Branch, branch.Labels[0].Pos)
+			g.connect(g.statement(body, branch.Body), after, Next, lexer.Position{})
+		}
+
+		if n.Else != nil {
+			body := g.newBlock()
+			g.connect(b, body, Else, n.Else.Pos)
+			g.connect(g.statement(body, n.Else), after, Next, lexer.Position{})
+		} else {
+			g.connect(b, after, NoMatch, n.Pos)
+		}
+
+		return after
+
+	case *parser.ForNode:
+		b.Steps = append(b.Steps, n.Start, n.End)
+
+		body := g.newBlock()
+		body.Steps = append(body.Steps, n)
+		after := g.newBlock()
+
+		g.connect(b, body, LoopBody, n.Pos)
+		g.connect(b, after, LoopSkip, n.Pos)
+
+		last := g.statement(body, n.Body)
+		g.connect(last, body, LoopBack, n.Pos)
+		g.connect(last, after, LoopExit, n.Pos)
+
+		return after
+	}
+
+	b.Steps = append(b.Steps, stmt)
+
+	return b
+}
@@ -0,0 +1,97 @@
+package flow_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/flow"
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func parse(source string) *parser.BlockNode {
+	node, err := parser.NewParser(lexer.NewTokeniser(strings.NewReader(source))).Program()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	return node.(*parser.BlockNode)
+}
+
+var _ = Describe("Build", func() {
+	var graph *flow.Graph
+
+	// edges lists the edges of the graph as from->to:kind
+	edges := func() []string {
+		var s []string
+		for _, b := range graph.Blocks {
+			for _, e := range b.Succs {
+				s = append(s, fmt.Sprintf("%d->%d:%d", e.From.ID, e.To.ID, e.Kind))
+			}
+		}
+		return s
+	}
+
+	It("puts straight line code in one block", func() {
+		graph = flow.Build(parse(`BEGIN x := 1; y := x END.`).Compound)
+
+		Expect(graph.Blocks).To(HaveLen(2))
+		Expect(graph.Entry.Steps).To(HaveLen(2))
+		Expect(graph.Entry.Succs).To(HaveLen(1))
+		Expect(graph.Entry.Succs[0].To).To(Equal(graph.Exit))
+		Expect(graph.Exit.Steps).To(BeEmpty())
+	})
+
+	It("branches for each CASE branch and the ELSE", func() {
+		program := parse(`BEGIN
+  CASE n OF
+    1: x := 1;
+    2, 3: x := 2
+  ELSE
+    x := 3
+  END;
+  y := x
+END.`)
+		graph = flow.Build(program.Compound)
+
+		caseNode := program.Compound.Children[0].(*parser.CaseNode)
+		Expect(graph.Entry.Steps).To(Equal([]parser.ASTNode{caseNode.Expr}))
+
+		Expect(graph.Entry.Succs).To(HaveLen(3))
+		Expect(graph.Entry.Succs[0].Kind).To(Equal(flow.Branch))
+		Expect(graph.Entry.Succs[0].Pos).To(Equal(lexer.Position{Line: 3, Column: 5}))
+		Expect(graph.Entry.Succs[1].Kind).To(Equal(flow.Branch))
+		Expect(graph.Entry.Succs[2].Kind).To(Equal(flow.Else))
+		Expect(graph.Entry.Succs[2].Pos).To(Equal(lexer.Position{Line: 5, Column: 3}))
+
+		after := graph.Blocks[1]
+		Expect(after.Preds).To(HaveLen(3))
+		Expect(after.Steps).To(HaveLen(1))
+	})
+
+	It("passes over a CASE when no label matches", func() {
+		graph = flow.Build(parse(`BEGIN CASE n OF 1: x := 1 END END.`).Compound)
+
+		Expect(edges()).To(ConsistOf("0->2:1", "0->1:3", "2->1:0", "1->3:0"))
+	})
+
+	It("loops back to the start of a FOR body", func() {
+		program := parse(`BEGIN FOR i := 1 TO n DO x := i END.`)
+		graph = flow.Build(program.Compound)
+
+		forNode := program.Compound.Children[0].(*parser.ForNode)
+		Expect(graph.Entry.Steps).To(Equal([]parser.ASTNode{forNode.Start, forNode.End}))
+
+		body := graph.Blocks[1]
+		Expect(body.Steps).To(HaveLen(2))
+		Expect(body.Steps[0]).To(Equal(forNode))
+
+		Expect(edges()).To(ConsistOf(
+			"0->1:4", // runs
+			"0->2:5", // runs no times
+			"1->1:6", // repeats
+			"1->2:7", // finishes
+			"2->3:0",
+		))
+	})
+})
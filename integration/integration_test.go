@@ -1,19 +1,22 @@
 package integration_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/kieron-dev/lsbasi/interpreter"
 	"github.com/kieron-dev/lsbasi/lexer"
 	"github.com/kieron-dev/lsbasi/parser"
+	"github.com/kieron-dev/lsbasi/semantic"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("Integration", func() {
-	DescribeTable("interpreting expressions", func(expr string, res int) {
+	DescribeTable("interpreting expressions", func(expr string, res interface{}) {
 		program := fmt.Sprintf(`
 BEGIN
 	res := %s;
@@ -47,9 +50,23 @@ END.
 		Entry("unary plus", "+ 5  + 3", 8),
 		Entry("unary minus minus", "- - 5  + 3", 8),
 		Entry("unary minus parens", "-(3+2)", -5),
+		Entry("hex literal", "$FF + $a", 265),
+		Entry("real literal", "1.5 * 2", 3.0),
+		Entry("real exponent", "-2.5E-1 + 1", 0.75),
+		Entry("MOD", "17 MOD 5 * 2", 4),
+		Entry("Abs", "Abs(3 - 10) + abs(-1.5)", 8.5),
+		Entry("Sqr", "Sqr(-4)", 16),
+		Entry("Odd", "Odd(7)", true),
+		Entry("Ord", "Ord(Odd(2)) + Ord(5)", 5),
+		Entry("Succ and Pred", "Succ(Pred(9) * 2)", 17),
+		Entry("Trunc", "Trunc(-2.7)", -2),
+		Entry("Round", "Round(2.5) + Round(-2.5)", 0),
+		Entry("Sqrt", "Sqrt(16)", 4.0),
+		Entry("Sin and Cos", "Sqr(Sin(0.5)) + Sqr(Cos(0.5))", 1.0),
+		Entry("Exp and Ln", "Round(Ln(Exp(3)))", 3),
 	)
 
-	DescribeTable("interpreting programs", func(program string, res map[string]int) {
+	DescribeTable("interpreting programs", func(program string, res map[string]interface{}) {
 		tokeniser := lexer.NewTokeniser(strings.NewReader(program))
 		pars := parser.NewParser(tokeniser)
 		interp := interpreter.NewInterpreter(pars)
@@ -58,9 +75,9 @@ END.
 		Expect(interp.GlobalScope()).To(Equal(res))
 	},
 
-		Entry("empty block", "BEGIN END.", map[string]int{}),
-		Entry("simple assignment", "BEGIN a := 1 END.", map[string]int{"a": 1}),
-		Entry("assignment to a var", "BEGIN a := 1; b := a END.", map[string]int{"a": 1, "b": 1}),
+		Entry("empty block", "BEGIN END.", map[string]interface{}{}),
+		Entry("simple assignment", "BEGIN a := 1 END.", map[string]interface{}{"a": 1}),
+		Entry("assignment to a var", "BEGIN a := 1; b := a END.", map[string]interface{}{"a": 1, "b": 1}),
 		Entry("sample prog from chapter 9", `
 BEGIN
     BEGIN
@@ -72,7 +89,731 @@ BEGIN
     x := 11;
 END.
 `,
-			map[string]int{"number": 2, "a": 2, "b": 25, "c": 27, "x": 11},
+			map[string]interface{}{"number": 2, "a": 2, "b": 25, "c": 27, "x": 11},
 		),
+		Entry("case with a single label", "BEGIN CASE 2 OF 1: a := 1; 2: a := 2 END END.", map[string]interface{}{"a": 2}),
+		Entry("case with a label list", "BEGIN CASE 3 OF 1: a := 1; 2, 3: a := 2 END END.", map[string]interface{}{"a": 2}),
+		Entry("case with a range", `
+BEGIN
+	x := -4;
+	CASE x * 2 OF
+		-1..1: a := 1;
+		-9..-5: a := 2;
+		4: a := 3;
+	END
+END.
+`,
+			map[string]interface{}{"x": -4, "a": 2},
+		),
+		Entry("case with else", "BEGIN CASE 9 OF 1: a := 1 ELSE a := 2; b := 3 END END.", map[string]interface{}{"a": 2, "b": 3}),
+		Entry("case with no match", "BEGIN CASE 9 OF 1: a := 1 END END.", map[string]interface{}{}),
+		Entry("constants", `
+CONST
+	rate = 15;
+	threshold = rate * 10 - 1;
+BEGIN
+	price := 200;
+	tax := price * rate DIV 100;
+	CASE price OF
+		0..threshold: band := 1;
+		threshold + 1..threshold * 2: band := 2;
+	END
+END.
+`,
+			map[string]interface{}{"price": 200, "tax": 30, "band": 2},
+		),
+		Entry("for loops", `
+VAR i: INTEGER;
+BEGIN
+	total := 0;
+	FOR i := 1 TO 4 DO total := total + i;
+	FOR i := 3 DOWNTO 1 DO BEGIN total := total * 2; last := i END;
+	FOR i := 2 TO 1 DO total := 0
+END.
+`,
+			map[string]interface{}{"i": 1, "total": 80, "last": 1},
+		),
+		Entry("comparisons", `
+BEGIN
+	a := 1 < 2; b := 2.5 >= 3; c := 4 = 4.0; d := 1 <> 1; e := Odd(1) > Odd(2)
+END.
+`,
+			map[string]interface{}{"a": true, "b": false, "c": true, "d": false, "e": true},
+		),
+		Entry("declared REAL variables", "VAR r: REAL; BEGIN r := 2 END.", map[string]interface{}{"r": 2.0}),
+	)
+
+	Describe("enumerated and subrange types", func() {
+		var (
+			source string
+			interp *interpreter.Interpreter
+			err    error
+		)
+
+		JustBeforeEach(func() {
+			tokeniser := lexer.NewTokeniser(strings.NewReader(source))
+			interp = interpreter.NewInterpreter(parser.NewParser(tokeniser))
+			err = interp.Interpret()
+		})
+
+		Context("a state machine", func() {
+			BeforeEach(func() {
+				source = `
+TYPE
+	State = (Idle, Running, Done);
+	Digit = 0..9;
+	Active = Idle..Running;
+VAR
+	s: State;
+	d: Digit;
+	a: Active;
+BEGIN
+	s := Idle;
+	n := 0;
+	FOR s := Idle TO Done DO n := n + Ord(s);
+	CASE Succ(Idle) OF
+		Idle: d := 1;
+		Running..Done: d := 2
+	END;
+	a := Pred(Running);
+	first := Ord(Idle);
+	last := Pred(Done)
+END.`
+			})
+
+			It("runs", func() {
+				Expect(err).NotTo(HaveOccurred())
+				scope := interp.GlobalScope()
+				Expect(fmt.Sprint(scope["s"])).To(Equal("Done"))
+				Expect(scope["n"]).To(Equal(3))
+				Expect(scope["d"]).To(Equal(2))
+				Expect(fmt.Sprint(scope["a"])).To(Equal("Idle"))
+				Expect(scope["first"]).To(Equal(0))
+				Expect(fmt.Sprint(scope["last"])).To(Equal("Running"))
+			})
+		})
+	})
+
+	Describe("sets", func() {
+		It("supports membership, set operations and comparisons", func() {
+			program := `
+TYPE
+	Day = (Mon, Tue, Wed, Thu, Fri, Sat, Sun);
+	Days = SET OF Day;
+VAR
+	weekend, work, all: Days;
+	digits: SET OF 0..9;
+BEGIN
+	weekend := [Sat, Sun];
+	all := [Mon..Sun];
+	work := all - weekend;
+	digits := [1, 3..5] + [9] * [8, 9];
+	isWork := Wed IN work;
+	isDigit := 7 IN digits;
+	sub := weekend <= all;
+	super := weekend >= all;
+	same := work + weekend = all;
+	differ := [] <> digits
+END.`
+			tokeniser := lexer.NewTokeniser(strings.NewReader(program))
+			interp := interpreter.NewInterpreter(parser.NewParser(tokeniser))
+			Expect(interp.Interpret()).To(Succeed())
+
+			scope := interp.GlobalScope()
+			Expect(fmt.Sprint(scope["work"])).To(Equal("[Mon..Fri]"))
+			Expect(fmt.Sprint(scope["weekend"])).To(Equal("[Sat, Sun]"))
+			Expect(fmt.Sprint(scope["digits"])).To(Equal("[1, 3..5, 9]"))
+			Expect(scope["iswork"]).To(BeTrue())
+			Expect(scope["isdigit"]).To(BeFalse())
+			Expect(scope["sub"]).To(BeTrue())
+			Expect(scope["super"]).To(BeFalse())
+			Expect(scope["same"]).To(BeTrue())
+			Expect(scope["differ"]).To(BeTrue())
+		})
+
+		It("stores members as bits", func() {
+			program := "BEGIN s := [0, 63..65, 255] END."
+			tokeniser := lexer.NewTokeniser(strings.NewReader(program))
+			interp := interpreter.NewInterpreter(parser.NewParser(tokeniser))
+			Expect(interp.Interpret()).To(Succeed())
+
+			s := interp.GlobalScope()["s"].(interpreter.Set)
+			Expect(s.Members()).To(Equal([]int{0, 63, 64, 65, 255}))
+			Expect(s.Contains(64)).To(BeTrue())
+			Expect(s.Contains(62)).To(BeFalse())
+		})
+
+		DescribeTable("rejecting misuse", func(expr, msg string) {
+			program := "BEGIN a := " + expr + " END."
+			tokeniser := lexer.NewTokeniser(strings.NewReader(program))
+			interp := interpreter.NewInterpreter(parser.NewParser(tokeniser))
+			Expect(interp.Interpret()).To(MatchError(msg))
+		},
+			Entry("member too large", "[256]", "set member 256 is out of range 0..255 at 1:13"),
+			Entry("negative member", "[1, -1]", "set member -1 is out of range 0..255 at 1:16"),
+			Entry("range past the largest member", "[250..300]", "set member 300 is out of range 0..255 at 1:13"),
+			Entry("BOOLEAN and INTEGER members", "[TRUE, 1]", "set member 1 of type INTEGER in a set of BOOLEAN at 1:19"),
+			Entry("IN a number", "1 IN 2", "IN needs a set on the right, got INTEGER at 1:14"),
+			Entry("a set and a number", "[1] + 1", "cannot combine a set with INTEGER at 1:16"),
+			Entry("a strict subset", "[1] < [1, 2]", "less than is not defined on sets at 1:16"),
+			Entry("comparing a set", "[1] = 1", "cannot compare SET with INTEGER at 1:16"),
+		)
+
+		DescribeTable("checking assignments to set variables", func(src, msg string) {
+			tokeniser := lexer.NewTokeniser(strings.NewReader(src))
+			interp := interpreter.NewInterpreter(parser.NewParser(tokeniser))
+			if msg == "" {
+				Expect(interp.Interpret()).To(Succeed())
+				return
+			}
+			Expect(interp.Interpret()).To(MatchError(msg))
+		},
+			Entry("a member out of a subrange", "VAR s: SET OF 0..9; BEGIN s := [1, 12] END.",
+				`12 is out of range 0..9 of variable "s" at 1:27`),
+			Entry("INTEGER members of an enum set", "TYPE Color = (Red, Green); VAR s: SET OF Color; BEGIN s := [1, 2] END.",
+				`cannot assign SET OF INTEGER to SET OF Color "s" at 1:55`),
+			Entry("enum members of an INTEGER set", "TYPE Color = (Red, Green); VAR s: SET OF 0..9; BEGIN s := [Red] END.",
+				`cannot assign SET OF Color to SET OF 0..9 "s" at 1:54`),
+			Entry("a member out of an enum subrange", "TYPE Color = (Red, Green, Blue); VAR s: SET OF Red..Green; BEGIN s := [Blue] END.",
+				`Blue is out of range Red..Green of variable "s" at 1:66`),
+			Entry("an enum member of an INTEGER set", "TYPE Color = (Red, Green); BEGIN s := [1, Red] END.",
+				"set member Red of type Color in a set of INTEGER at 1:43"),
+			Entry("combining INTEGER and enum sets", "TYPE Color = (Red, Green); BEGIN s := [Red] + [1] END.",
+				"cannot combine SET OF Color with SET OF INTEGER at 1:45"),
+			Entry("comparing INTEGER and enum sets", "TYPE Color = (Red, Green); BEGIN b := [0] = [Red] END.",
+				"cannot compare SET OF INTEGER with SET OF Color at 1:43"),
+			Entry("testing for an INTEGER in an enum set", "TYPE Color = (Red, Green); BEGIN b := 0 IN [Red] END.",
+				"cannot test for INTEGER in SET OF Color at 1:41"),
+			Entry("members in range", "TYPE Color = (Red, Green, Blue); VAR s: SET OF Green..Blue; d: SET OF 0..9; BEGIN s := [Green..Blue]; d := [0, 9]; d := [] END.", ""),
+		)
+	})
+
+	DescribeTable("rejecting out of range ordinals", func(src, msg string) {
+		tokeniser := lexer.NewTokeniser(strings.NewReader(src))
+		err := interpreter.NewInterpreter(parser.NewParser(tokeniser)).Interpret()
+		Expect(err).To(MatchError(msg))
+	},
+		Entry("subrange assignment", "TYPE Digit = 0..9; VAR d: Digit; BEGIN d := 3 * 4 END.",
+			`12 is out of range 0..9 of variable "d" at 1:40`),
+		Entry("subrange in a for loop", "VAR d: 1..3; BEGIN FOR d := 1 TO 5 DO END.",
+			`4 is out of range 1..3 of variable "d" at 1:20`),
+		Entry("enum subrange", "TYPE C = (R, G, B); VAR x: R..G; BEGIN x := B END.",
+			`B is out of range R..G of variable "x" at 1:40`),
+		Entry("succ of the last value", "TYPE C = (R, G, B); BEGIN x := Succ(B) END.",
+			"Succ(B) is out of range at 1:32"),
+		Entry("pred of the first value", "TYPE C = (R, G, B); BEGIN x := Pred(R) END.",
+			"Pred(R) is out of range at 1:32"),
+		Entry("arithmetic on enums", "TYPE C = (R, G, B); BEGIN x := R + 1 END.",
+			"R is not a number at 1:34"),
+		Entry("unknown type", "VAR x: Colour; BEGIN END.",
+			`unknown type "Colour"`),
+		Entry("REAL to INTEGER", "VAR x: INTEGER; BEGIN x := 2.5 END.",
+			`cannot assign REAL to INTEGER "x" at 1:23`),
+		Entry("INTEGER to BOOLEAN", "VAR b: BOOLEAN; BEGIN b := 1 END.",
+			`cannot assign INTEGER to BOOLEAN "b" at 1:23`),
+		Entry("BOOLEAN to REAL", "VAR r: REAL; BEGIN r := TRUE END.",
+			`cannot assign BOOLEAN to REAL "r" at 1:20`),
+		Entry("INTEGER to an enum", "TYPE Color = (R, G, B); VAR c: Color; BEGIN c := 7 END.",
+			`cannot assign INTEGER to Color "c" at 1:45`),
+		Entry("another enum", "TYPE Color = (R, G); Size = (S, L); VAR c: Color; BEGIN c := L END.",
+			`cannot assign Size to Color "c" at 1:57`),
+		Entry("INTEGER to an enum subrange", "TYPE Color = (R, G, B); VAR c: R..G; BEGIN c := 1 END.",
+			`cannot assign INTEGER to Color "c" at 1:44`),
+		Entry("BOOLEAN to an INTEGER subrange", "VAR d: 0..9; BEGIN d := FALSE END.",
+			`cannot assign BOOLEAN to INTEGER "d" at 1:20`),
 	)
+
+	It("assigns values of the declared types", func() {
+		src := "TYPE Color = (Red, Green, Blue); VAR x: INTEGER; r: REAL; b: BOOLEAN; c: Color; s: Green..Blue; f: FALSE..TRUE;\n" +
+			"BEGIN x := 2; r := x; b := TRUE; c := Green; s := Blue; f := x > 1 END."
+		interp := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(src))))
+		Expect(interp.Interpret()).To(Succeed())
+		Expect(interp.GlobalScope()).To(HaveKeyWithValue("r", 2.0))
+		Expect(interp.GlobalScope()).To(HaveKeyWithValue("b", true))
+		Expect(interp.GlobalScope()).To(HaveKeyWithValue("f", true))
+	})
+
+	Describe("pointers and records", func() {
+		run := func(source string) (*interpreter.Interpreter, error) {
+			tokeniser := lexer.NewTokeniser(strings.NewReader(source))
+			interp := interpreter.NewInterpreter(semantic.NewChecker(parser.NewParser(tokeniser)))
+
+			return interp, interp.Interpret()
+		}
+
+		It("builds and walks a linked list, reporting what is never disposed", func() {
+			interp, err := run(`
+TYPE
+	List = ^Node;
+	Node = RECORD
+		value: INTEGER;
+		next: List
+	END;
+VAR
+	head, p: List;
+BEGIN
+	head := NIL;
+	FOR i := 1 TO 3 DO
+	BEGIN
+		New(p);
+		p^.value := i * 10;
+		p^.next := head;
+		head := p
+	END;
+	total := 0;
+	count := 0;
+	FOR i := 1 TO 3 DO
+	BEGIN
+		total := total + p^.value;
+		count := count + Ord(p^.next <> NIL);
+		p := p^.next
+	END;
+	atEnd := p = NIL;
+	p := head^.next;
+	Dispose(head);
+	head := p
+END.`)
+			Expect(err).NotTo(HaveOccurred())
+
+			scope := interp.GlobalScope()
+			Expect(scope["total"]).To(Equal(60))
+			Expect(scope["count"]).To(Equal(2))
+			Expect(scope["atend"]).To(BeTrue())
+			Expect(fmt.Sprint(scope["head"])).To(Equal("^Node#2"))
+
+			leaks := interp.Leaks()
+			Expect(leaks).To(HaveLen(2))
+			Expect(leaks[0].Type).To(Equal("Node"))
+			Expect(leaks[0].Pos).To(Equal(lexer.Position{Line: 14, Column: 3}))
+		})
+
+		It("copies records on assignment", func() {
+			interp, err := run(`
+TYPE Point = RECORD x, y: INTEGER END;
+VAR a, b: Point;
+BEGIN
+	a.x := 1; a.y := 2;
+	b := a;
+	b.x := 5
+END.`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fmt.Sprint(interp.GlobalScope()["a"])).To(Equal("(x: 1; y: 2)"))
+			Expect(fmt.Sprint(interp.GlobalScope()["b"])).To(Equal("(x: 5; y: 2)"))
+			Expect(interp.Leaks()).To(BeEmpty())
+		})
+
+		It("does not exhaust the interpreter's own stack with calls nested in expressions", func() {
+			src := "FUNCTION F(n: INTEGER): INTEGER;\nBEGIN F := " + strings.Repeat("1 + (", 60) + "F(n + 1)" + strings.Repeat(")", 60) + " END;\nBEGIN x := F(0) END."
+			interp := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(src))), interpreter.WithMaxDepth(interpreter.MaxDepthLimit))
+			err := interp.Interpret()
+			Expect(err).To(MatchError(ContainSubstring("stack overflow: more than 500000 nested statements, expressions and calls")))
+
+			var stackErr *interpreter.StackError
+			Expect(errors.As(err, &stackErr)).To(BeTrue())
+			Expect(len(stackErr.Stack)).To(BeNumerically("<", interpreter.MaxDepthLimit))
+		})
+
+		DescribeTable("rejecting programs nested too deeply to walk", func(src, msg string) {
+			_, err := parser.NewParser(lexer.NewTokeniser(strings.NewReader(src))).Program()
+			Expect(err).To(MatchError(msg))
+		},
+			Entry("parentheses", "BEGIN x := "+strings.Repeat("(", 20000)+"1"+strings.Repeat(")", 20000)+" END.",
+				"nested more than 10000 levels deep at 1:10011"),
+			Entry("a long sum", "BEGIN x := 1"+strings.Repeat(" + 1", 3000000)+" END.",
+				"nested more than 10000 levels deep at 1:40006"),
+			Entry("negations", "BEGIN x := "+strings.Repeat("-", 20000)+"1 END.",
+				"nested more than 10000 levels deep at 1:10010"),
+			Entry("compound statements", strings.Repeat("BEGIN ", 20000)+strings.Repeat("END ", 20000)+".",
+				"nested more than 10000 levels deep at 1:60007"),
+		)
+
+		DescribeTable("runtime errors", func(body, msg string) {
+			_, err := run(`TYPE R = RECORD n: INTEGER END; RPtr = ^R; VAR p, q: RPtr;
+` + body)
+			Expect(err).To(MatchError(msg))
+
+			var runtimeErr *interpreter.Error
+			Expect(errors.As(err, &runtimeErr)).To(BeTrue())
+		},
+			Entry("NIL dereference", "BEGIN p := NIL; x := p^.n END.",
+				"NIL pointer dereference at 2:23"),
+			Entry("use after dispose", "BEGIN New(p); q := p; Dispose(p); q^.n := 1 END.",
+				"use of disposed pointer (allocated at 2:7, disposed at 2:23) at 2:36"),
+			Entry("use after dispose through a VAR parameter", `PROCEDURE Clear(VAR n: INTEGER); BEGIN Dispose(p); n := 0 END;
+BEGIN New(p); Clear(p^.n) END.`,
+				"use of disposed pointer (allocated at 3:7, disposed at 2:40) at 2:52"),
+			Entry("read after dispose through a VAR parameter", `PROCEDURE Show(VAR n: INTEGER); BEGIN Dispose(p); x := n END;
+BEGIN New(p); p^.n := 1; Show(p^.n) END.`,
+				"use of disposed pointer (allocated at 3:7, disposed at 2:39) at 2:56"),
+			Entry("double dispose", "BEGIN New(p); Dispose(p); Dispose(p) END.",
+				"pointer disposed twice (allocated at 2:7, disposed at 2:15) at 2:27"),
+			Entry("dispose NIL", "BEGIN p := NIL; Dispose(p) END.",
+				"Dispose of NIL pointer at 2:17"),
+			Entry("unassigned field", "BEGIN New(p); x := p^.n END.",
+				"p^.n is used before it is assigned at 2:23"),
+			Entry("INTEGER to a pointer", "BEGIN p := 1 END.",
+				`cannot assign INTEGER to pointer "p" at 2:7`),
+			Entry("unknown field", "BEGIN New(p); p^.m := 1 END.",
+				`R has no field "m" at 2:18`),
+		)
+	})
+
+	Describe("procedures and arrays", func() {
+		run := func(source string) (*interpreter.Interpreter, error) {
+			tokeniser := lexer.NewTokeniser(strings.NewReader(source))
+			interp := interpreter.NewInterpreter(semantic.NewChecker(parser.NewParser(tokeniser)))
+
+			return interp, interp.Interpret()
+		}
+
+		It("aliases VAR parameters and copies value parameters", func() {
+			interp, err := run(`
+TYPE
+	Point = RECORD x, y: INTEGER END;
+	Row = ARRAY [1..5] OF INTEGER;
+VAR
+	a, b: INTEGER;
+	pt: Point;
+	r, copy: Row;
+
+PROCEDURE Swap(VAR x, y: INTEGER);
+VAR t: INTEGER;
+BEGIN
+	t := x; x := y; y := t
+END;
+
+PROCEDURE Clear(r: Row);
+BEGIN
+	r[1] := 0
+END;
+
+FUNCTION Sum(r: Row): INTEGER;
+VAR n: INTEGER;
+BEGIN
+	Sum := 0;
+	FOR n := 1 TO 5 DO Sum := Sum + r[n]
+END;
+
+BEGIN
+	a := 1; b := 6;
+	Swap(a, b);
+	pt.x := 10; pt.y := 20;
+	Swap(pt.x, pt.y);
+	FOR i := 1 TO 5 DO r[i] := 2 * i;
+	Swap(r[1], r[4]);
+	copy := r;
+	copy[1] := 10;
+	Clear(r);
+	s := Sum(r)
+END.`)
+			Expect(err).NotTo(HaveOccurred())
+
+			scope := interp.GlobalScope()
+			Expect(scope["a"]).To(Equal(6))
+			Expect(scope["b"]).To(Equal(1))
+			Expect(fmt.Sprint(scope["pt"])).To(Equal("(x: 20; y: 10)"))
+			Expect(fmt.Sprint(scope["r"])).To(Equal("(8, 4, 6, 2, 10)"))
+			Expect(fmt.Sprint(scope["copy"])).To(Equal("(10, 4, 6, 2, 10)"))
+			Expect(scope["s"]).To(Equal(30))
+		})
+
+		Context("when the whole variable is assigned through a VAR parameter's call", func() {
+			const declarations = `
+TYPE
+	Row = ARRAY [1..3] OF INTEGER;
+	Cell = RECORD v: INTEGER END;
+VAR
+	a, b: Row;
+	r, s: Cell;
+
+PROCEDURE SetIt(VAR n: INTEGER);
+BEGIN
+	a := b; r := s; n := 99
+END;
+`
+
+			It("keeps an element parameter aliasing the array", func() {
+				interp, err := run(declarations + `
+BEGIN
+	a[1] := 7; b[1] := 5; s.v := 3;
+	SetIt(a[1]);
+	x := a[1] * 1000 + b[1]
+END.`)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(interp.GlobalScope()["x"]).To(Equal(99005))
+			})
+
+			It("keeps a field parameter aliasing the record", func() {
+				interp, err := run(declarations + `
+BEGIN
+	r.v := 7; s.v := 5; b[1] := 3;
+	SetIt(r.v);
+	x := r.v * 1000 + s.v
+END.`)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(interp.GlobalScope()["x"]).To(Equal(99005))
+			})
+		})
+
+		It("calls functions recursively", func() {
+			interp, err := run(`
+FUNCTION Fact(n: INTEGER): INTEGER;
+BEGIN
+	CASE n OF 0, 1: Fact := 1 ELSE Fact := n * Fact(n - 1) END
+END;
+BEGIN
+	f := Fact(10)
+END.`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(interp.GlobalScope()["f"]).To(Equal(3628800))
+		})
+
+		It("gives each call its own variables when walking a tree", func() {
+			interp, err := run(`
+TYPE
+	Tree = ^Node;
+	Node = RECORD value: INTEGER; left, right: Tree END;
+VAR
+	root: Tree;
+
+PROCEDURE Insert(VAR t: Tree; v: INTEGER);
+BEGIN
+	CASE Ord(t = NIL) OF
+		1: BEGIN New(t); t^.value := v; t^.left := NIL; t^.right := NIL END;
+		0: CASE Ord(v < t^.value) OF
+			1: Insert(t^.left, v);
+			0: Insert(t^.right, v)
+		END
+	END
+END;
+
+FUNCTION Depth(t: Tree): INTEGER;
+VAR l, r: INTEGER;
+BEGIN
+	CASE Ord(t = NIL) OF
+		1: Depth := 0;
+		0: BEGIN
+			l := Depth(t^.left);
+			r := Depth(t^.right);
+			CASE Ord(l > r) OF 1: Depth := l + 1; 0: Depth := r + 1 END
+		END
+	END
+END;
+
+BEGIN
+	root := NIL;
+	Insert(root, 5); Insert(root, 2); Insert(root, 8); Insert(root, 1); Insert(root, 3); Insert(root, 4);
+	d := Depth(root)
+END.`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(interp.GlobalScope()["d"]).To(Equal(4))
+		})
+
+		It("resolves the locals of enclosing procedures through the declaring call", func() {
+			interp, err := run(`
+VAR log: INTEGER;
+
+PROCEDURE Outer(depth: INTEGER);
+VAR mine: INTEGER;
+
+	PROCEDURE Note;
+	BEGIN
+		log := log * 10 + mine
+	END;
+
+	PROCEDURE Deeper(mine: INTEGER);
+	BEGIN
+		Note
+	END;
+
+BEGIN
+	mine := depth;
+	CASE depth OF 3: Deeper(9) ELSE Outer(depth + 1) END;
+	Note
+END;
+
+BEGIN
+	log := 0;
+	Outer(1)
+END.`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(interp.GlobalScope()["log"]).To(Equal(3321))
+		})
+
+		It("reports a stack overflow with the calls which had not returned", func() {
+			tokeniser := lexer.NewTokeniser(strings.NewReader(`
+FUNCTION Sum(n: INTEGER): INTEGER;
+BEGIN
+	CASE n OF 0: Sum := 0 ELSE Sum := n + Sum(n - 1) END
+END;
+BEGIN
+	s := Sum(20)
+END.`))
+			interp := interpreter.NewInterpreter(parser.NewParser(tokeniser), interpreter.WithMaxDepth(5))
+			err := interp.Interpret()
+			Expect(err).To(MatchError("stack overflow: more than 5 nested calls at 4:40"))
+
+			var runtimeErr *interpreter.Error
+			Expect(errors.As(err, &runtimeErr)).To(BeTrue())
+
+			var stackErr *interpreter.StackError
+			Expect(errors.As(err, &stackErr)).To(BeTrue())
+			Expect(stackErr.Stack).To(HaveLen(5))
+			Expect(stackErr.Stack[4].Pos).To(Equal(lexer.Position{Line: 7, Column: 7}))
+		})
+
+		It("traces the calls in progress at a runtime error, with their arguments", func() {
+			_, err := run(`
+TYPE Row = ARRAY [1..3] OF INTEGER;
+VAR r: Row; total: INTEGER;
+
+PROCEDURE Fill(VAR a: Row; n: INTEGER);
+BEGIN
+	a[n] := n
+END;
+
+PROCEDURE FillAll(VAR a: Row; VAR count: INTEGER);
+VAR n: INTEGER;
+BEGIN
+	FOR n := 1 TO 4 DO
+	BEGIN
+		Fill(a, n);
+		count := count + 1
+	END
+END;
+
+BEGIN
+	total := 0;
+	FillAll(r, total)
+END.`)
+			Expect(err).To(MatchError("index 4 is out of range 1..3 at 7:3"))
+
+			var stackErr *interpreter.StackError
+			Expect(errors.As(err, &stackErr)).To(BeTrue())
+			Expect(stackErr.StackTrace()).To(Equal(`Fill(a: (1, 2, 3), n: 4)
+	at 7:3
+FillAll(a: (?, ?, ?), count: 0)
+	at 15:3
+<program>
+	at 22:2
+`))
+		})
+
+		It("traces arithmetic errors from the operation which failed", func() {
+			_, err := run(`
+FUNCTION Ratio(a, b: INTEGER): INTEGER;
+BEGIN
+	Ratio := a DIV b
+END;
+
+BEGIN
+	x := Ratio(6, 0)
+END.`)
+			Expect(err).To(MatchError("division by zero at 4:13"))
+
+			var stackErr *interpreter.StackError
+			Expect(errors.As(err, &stackErr)).To(BeTrue())
+			Expect(stackErr.StackTrace()).To(Equal(`Ratio(a: 6, b: 0)
+	at 4:13
+<program>
+	at 8:7
+`))
+		})
+
+		It("does not exhaust the interpreter's own stack at the deepest depth allowed", func() {
+			tokeniser := lexer.NewTokeniser(strings.NewReader(`
+PROCEDURE Forever; BEGIN Forever END;
+BEGIN Forever END.`))
+			interp := interpreter.NewInterpreter(parser.NewParser(tokeniser), interpreter.WithMaxDepth(interpreter.MaxDepthLimit+1))
+			Expect(interp.Interpret()).To(MatchError(ContainSubstring("stack overflow: more than 100000 nested calls")))
+		})
+
+		It("does not exhaust the interpreter's own stack with calls nested in many statements", func() {
+			src := "PROCEDURE Forever;\nBEGIN " + strings.Repeat("BEGIN ", 30) + "Forever" + strings.Repeat(" END", 30) + " END;\nBEGIN Forever END."
+			interp := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(src))), interpreter.WithMaxDepth(interpreter.MaxDepthLimit))
+			err := interp.Interpret()
+			Expect(err).To(MatchError(ContainSubstring("stack overflow: more than 500000 nested statements, expressions and calls")))
+
+			var stackErr *interpreter.StackError
+			Expect(errors.As(err, &stackErr)).To(BeTrue())
+			Expect(len(stackErr.Stack)).To(BeNumerically("<", interpreter.MaxDepthLimit))
+		})
+
+		DescribeTable("runtime errors", func(body, msg string) {
+			_, err := run(`VAR a: ARRAY [1..3] OF INTEGER;
+FUNCTION F(n: INTEGER): INTEGER; BEGIN CASE n OF 1: F := n ELSE END END;
+` + body)
+			Expect(err).To(MatchError(msg))
+
+			var runtimeErr *interpreter.Error
+			Expect(errors.As(err, &runtimeErr)).To(BeTrue())
+		},
+			Entry("index out of range", "BEGIN a[4] := 1 END.",
+				"index 4 is out of range 1..3 at 3:8"),
+			Entry("unassigned element", "BEGIN a[1] := 1; x := a[2] END.",
+				"a[2] is used before it is assigned at 3:24"),
+			Entry("function without a result", "BEGIN x := F(0) END.",
+				"function F did not assign a result at 3:12"),
+		)
+	})
+
+	It("rejects assignment to a constant", func() {
+		program := "CONST a = 1; BEGIN a := 2 END."
+		tokeniser := lexer.NewTokeniser(strings.NewReader(program))
+		interp := interpreter.NewInterpreter(parser.NewParser(tokeniser))
+		Expect(interp.Interpret()).To(MatchError(`cannot assign to constant "a"`))
+	})
+
+	DescribeTable("rejecting bad built-in calls", func(expr, msg string) {
+		program := "BEGIN a := " + expr + " END."
+		tokeniser := lexer.NewTokeniser(strings.NewReader(program))
+		interp := interpreter.NewInterpreter(parser.NewParser(tokeniser))
+		Expect(interp.Interpret()).To(MatchError(msg))
+	},
+		Entry("unknown function", "Max(1, 2)", `unknown function "Max" at 1:12`),
+		Entry("wrong number of arguments", "Abs(1, 2)", "Abs expects 1 argument(s), got 2 at 1:12"),
+		Entry("REAL ordinal", "Succ(1.5)", "Succ expects an ordinal argument, got REAL at 1:12"),
+		Entry("BOOLEAN number", "Sqrt(Odd(1))", "Sqrt expects an INTEGER or REAL argument, got BOOLEAN at 1:12"),
+		Entry("REAL Odd", "Odd(2.0)", "Odd expects an INTEGER argument, got REAL at 1:12"),
+		Entry("negative Sqrt", "Sqrt(-1)", "Sqrt of a negative number at 1:12"),
+		Entry("zero Ln", "Ln(0)", "Ln of a number which is not positive at 1:12"),
+		Entry("Trunc overflow", "Trunc(1E30)", "integer overflow: 1000000000000000019884624838656 is outside the 64-bit range -9223372036854775808..9223372036854775807 at 1:12"),
+	)
+
+	Describe("output", func() {
+		run := func(source string) (string, error) {
+			var out bytes.Buffer
+			tokeniser := lexer.NewTokeniser(strings.NewReader(source))
+			interp := interpreter.NewInterpreter(semantic.NewChecker(parser.NewParser(tokeniser)),
+				interpreter.WithOutput(&out))
+
+			err := interp.Interpret()
+
+			return out.String(), err
+		}
+
+		It("writes numbers, booleans and enumerated values", func() {
+			out, err := run(`TYPE Suit = (Clubs, Hearts);
+BEGIN
+  FOR s := Clubs TO Hearts DO Write(s, Ord(s));
+  WriteLn;
+  WriteLn(-12, 3 > 2, 1.5);
+  Write(Odd(2))
+END.`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(Equal("Clubs0Hearts1\n-12TRUE1.5\nFALSE"))
+		})
+
+		It("rejects values which cannot be written", func() {
+			_, err := run(`TYPE Ptr = ^INTEGER; VAR p: Ptr; BEGIN New(p); WriteLn(p) END.`)
+			Expect(err).To(MatchError("WriteLn cannot write a value of type pointer at 1:48"))
+		})
+	})
+
+	It("rejects DIV with a REAL operand", func() {
+		program := "BEGIN a := 1.0 DIV 2 END."
+		tokeniser := lexer.NewTokeniser(strings.NewReader(program))
+		interp := interpreter.NewInterpreter(parser.NewParser(tokeniser))
+		Expect(interp.Interpret()).To(MatchError("DIV needs INTEGER operands at 1:16"))
+	})
 })
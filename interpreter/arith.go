@@ -0,0 +1,220 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// IntegerMode selects the range of INTEGER values. Arithmetic which leaves
+// the range is an error rather than wrapping around. In the fixed width
+// modes INTEGER values are ints, and in BigInt mode they are *big.Ints.
+type IntegerMode int
+
+const (
+	Int64 IntegerMode = iota
+	Int32
+	Int16
+	BigInt
+)
+
+func (m IntegerMode) String() string {
+	return []string{
+		"64",
+		"32",
+		"16",
+		"big",
+	}[m]
+}
+
+// ParseIntegerMode parses the name of a mode, as given by String
+func ParseIntegerMode(s string) (IntegerMode, error) {
+	for _, m := range []IntegerMode{Int64, Int32, Int16, BigInt} {
+		if strings.EqualFold(s, m.String()) {
+			return m, nil
+		}
+	}
+
+	return Int64, fmt.Errorf("unknown integer mode %q: expected 16, 32, 64 or big", s)
+}
+
+// WithIntegerMode sets the range of INTEGER arithmetic, which is 64-bit by
+// default
+func WithIntegerMode(mode IntegerMode) Option {
+	return func(i *Interpreter) {
+		i.mode = mode
+	}
+}
+
+// Bounds returns the least and greatest INTEGER of the mode, or nils for
+// BigInt, which has neither
+func (m IntegerMode) Bounds() (min, max *big.Int) {
+	switch m {
+	case Int16:
+		return big.NewInt(-1 << 15), big.NewInt(1<<15 - 1)
+	case Int32:
+		return big.NewInt(-1 << 31), big.NewInt(1<<31 - 1)
+	case Int64:
+		return big.NewInt(-1 << 63), big.NewInt(1<<63 - 1)
+	}
+
+	return nil, nil
+}
+
+// integer converts n to the representation of the mode, failing if it is
+// out of range
+func (m IntegerMode) integer(n *big.Int) (interface{}, error) {
+	if m == BigInt {
+		return n, nil
+	}
+
+	min, max := m.Bounds()
+	if n.Cmp(min) < 0 || n.Cmp(max) > 0 {
+		return nil, fmt.Errorf("integer overflow: %s is outside the %s-bit range %s..%s", n, m, min, max)
+	}
+
+	return int(n.Int64()), nil
+}
+
+func isInteger(val interface{}) bool {
+	switch val.(type) {
+	case int, *big.Int:
+		return true
+	}
+
+	return false
+}
+
+func toBig(val interface{}) *big.Int {
+	if n, ok := val.(*big.Int); ok {
+		return n
+	}
+
+	return big.NewInt(int64(val.(int)))
+}
+
+// arithmetic applies a binary operator to INTEGER and REAL (float64) values.
+// Mixing the two gives a REAL.
+func (m IntegerMode) arithmetic(op lexer.TokenType, left, right interface{}) (interface{}, error) {
+	if isInteger(left) && isInteger(right) {
+		l, r := toBig(left), toBig(right)
+		n := new(big.Int)
+
+		switch op {
+		case lexer.Plus:
+			n.Add(l, r)
+		case lexer.Minus:
+			n.Sub(l, r)
+		case lexer.Mult:
+			n.Mul(l, r)
+		case lexer.Div:
+			if r.Sign() == 0 {
+				return nil, errors.New("division by zero")
+			}
+			// DIV truncates towards zero
+			n.Quo(l, r)
+		case lexer.Mod:
+			if r.Sign() == 0 {
+				return nil, errors.New("division by zero")
+			}
+			// as in Turbo Pascal, the result has the sign of the dividend
+			n.Rem(l, r)
+		default:
+			return nil, fmt.Errorf("unknown operator %s", op)
+		}
+
+		return m.integer(n)
+	}
+
+	if op == lexer.Div || op == lexer.Mod {
+		return nil, fmt.Errorf("%s needs INTEGER operands", keyword(op))
+	}
+
+	lf, err := real(left)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := real(right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case lexer.Plus:
+		return lf + rf, nil
+	case lexer.Minus:
+		return lf - rf, nil
+	case lexer.Mult:
+		return lf * rf, nil
+	}
+
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+func (m IntegerMode) negate(val interface{}) (interface{}, error) {
+	if isInteger(val) {
+		return m.integer(new(big.Int).Neg(toBig(val)))
+	}
+
+	if f, ok := val.(float64); ok {
+		return -f, nil
+	}
+
+	return nil, fmt.Errorf("cannot negate %v", val)
+}
+
+func real(val interface{}) (float64, error) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, nil
+	case float64:
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("%v is not a number", val)
+}
+
+// ordinal is the integer value of an ordinal, such as a CASE selector
+func ordinal(val interface{}) (int, error) {
+	switch v := val.(type) {
+	case int:
+		return v, nil
+	case *big.Int:
+		if v.IsInt64() {
+			return int(v.Int64()), nil
+		}
+	case EnumValue:
+		return v.Ord, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	return 0, fmt.Errorf("%v is not an ordinal value", val)
+}
+
+func keyword(op lexer.TokenType) string {
+	if op == lexer.Mod {
+		return "MOD"
+	}
+
+	return "DIV"
+}
+
+func (i *Interpreter) VisitToReal(node *parser.ToRealNode) (interface{}, error) {
+	val, err := node.Child.Accept(i)
+	if err != nil {
+		return nil, err
+	}
+
+	return real(val)
+}
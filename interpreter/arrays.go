@@ -0,0 +1,132 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// arrayType is an ARRAY indexed by the ordinals low..high of an ordinal type
+type arrayType struct {
+	index interface{}
+	low   int
+	high  int
+	elem  interface{}
+}
+
+// Array is a value of an ARRAY type. Arrays are copied when they are
+// assigned; a nil element has not been assigned.
+type Array struct {
+	typ   *arrayType
+	Elems []interface{}
+}
+
+func (a *Array) String() string {
+	elems := make([]string, len(a.Elems))
+	for n, v := range a.Elems {
+		if v == nil {
+			v = "?"
+		}
+		elems[n] = fmt.Sprint(v)
+	}
+
+	return "(" + strings.Join(elems, ", ") + ")"
+}
+
+// MarshalText gives the elements of the array, for traces
+func (a *Array) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Array) clone() *Array {
+	c := &Array{typ: a.typ, Elems: make([]interface{}, len(a.Elems))}
+	for n, v := range a.Elems {
+		c.Elems[n] = copyValue(v)
+	}
+
+	return c
+}
+
+func (i *Interpreter) arrayType(spec *parser.ArrayType) (*arrayType, error) {
+	index, err := i.resolveType(spec.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &arrayType{index: index}
+	switch idx := index.(type) {
+	case *subrange:
+		t.low, _ = ordinal(idx.low)
+		t.high, _ = ordinal(idx.high)
+	case *Enum:
+		t.high = len(idx.Values) - 1
+	default:
+		if index != booleanType {
+			return nil, fmt.Errorf("cannot index an array by %s", typeString(index))
+		}
+		t.high = 1
+	}
+
+	if t.elem, err = i.resolveType(spec.Elem); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (i *Interpreter) VisitIndex(node *parser.IndexNode) (interface{}, error) {
+	return i.load(node, node.Pos)
+}
+
+// element finds the element of an array named by an index designator
+func (i *Interpreter) element(node *parser.IndexNode) (*cell, error) {
+	val, alloc, err := i.part(node.Array)
+	if err != nil {
+		return nil, err
+	}
+
+	arr, ok := val.(*Array)
+	if !ok {
+		return nil, errorAt(node.Pos, "%s is not an array", parser.Designator(node.Array))
+	}
+
+	index, err := node.Index.Accept(i)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := ordinal(index)
+	if err != nil {
+		return nil, errorAt(node.Pos, "array index: %v", err)
+	}
+
+	t := arr.typ
+	if n < t.low || n > t.high {
+		low, _ := i.mode.ordinalLike(indexLike(t), t.low)
+		high, _ := i.mode.ordinalLike(indexLike(t), t.high)
+		return nil, errorAt(node.Pos, "index %v is out of range %v..%v", index, low, high)
+	}
+	n -= t.low
+
+	return &cell{
+		name:  fmt.Sprintf("%s[%v]", parser.Designator(node.Array), index),
+		typ:   t.elem,
+		load:  func() interface{} { return arr.Elems[n] },
+		store: func(v interface{}) { arr.Elems[n] = v },
+		alloc: alloc,
+	}, nil
+}
+
+// indexLike gives a value of the index type of an array, for rendering its
+// bounds
+func indexLike(t *arrayType) interface{} {
+	switch idx := t.index.(type) {
+	case *subrange:
+		return idx.low
+	case *Enum:
+		return EnumValue{Type: idx}
+	}
+
+	return false
+}
@@ -0,0 +1,238 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// Builtin is a predeclared function. Call is given the integer mode of the
+// interpreter and arguments which have already been evaluated.
+type Builtin struct {
+	Arity int
+	Call  func(mode IntegerMode, args []interface{}) (interface{}, error)
+}
+
+// WithBuiltin registers a function, replacing any built-in of the same name
+func WithBuiltin(name string, b Builtin) Option {
+	return func(i *Interpreter) {
+		i.builtins[strings.ToLower(name)] = b
+	}
+}
+
+// Builtins lists the names of the standard built-in functions
+func Builtins() []string {
+	names := []string{}
+	for _, b := range standardBuiltins {
+		names = append(names, b.name)
+	}
+
+	return names
+}
+
+var standardBuiltins = []struct {
+	name string
+	fn   Builtin
+}{
+	{"Abs", unary(func(m IntegerMode, x interface{}) (interface{}, error) {
+		if isInteger(x) {
+			return m.integer(new(big.Int).Abs(toBig(x)))
+		}
+		f, err := number("Abs", x)
+		return math.Abs(f), err
+	})},
+	{"Sqr", unary(func(m IntegerMode, x interface{}) (interface{}, error) {
+		if _, err := number("Sqr", x); err != nil {
+			return nil, err
+		}
+		return m.arithmetic(lexer.Mult, x, x)
+	})},
+	{"Odd", unary(func(m IntegerMode, x interface{}) (interface{}, error) {
+		if !isInteger(x) {
+			return nil, argError("Odd", "an INTEGER", x)
+		}
+		return toBig(x).Bit(0) == 1, nil
+	})},
+	{"Succ", unary(func(m IntegerMode, x interface{}) (interface{}, error) {
+		return m.step("Succ", x, 1)
+	})},
+	{"Pred", unary(func(m IntegerMode, x interface{}) (interface{}, error) {
+		return m.step("Pred", x, -1)
+	})},
+	{"Ord", unary(func(m IntegerMode, x interface{}) (interface{}, error) {
+		if isInteger(x) {
+			return x, nil
+		}
+		n, err := ordinal(x)
+		if err != nil {
+			return nil, argError("Ord", "an ordinal", x)
+		}
+		return m.integer(big.NewInt(int64(n)))
+	})},
+	{"Trunc", unary(func(m IntegerMode, x interface{}) (interface{}, error) {
+		f, err := number("Trunc", x)
+		if err != nil {
+			return nil, err
+		}
+		return m.fromReal(math.Trunc(f))
+	})},
+	{"Round", unary(func(m IntegerMode, x interface{}) (interface{}, error) {
+		f, err := number("Round", x)
+		if err != nil {
+			return nil, err
+		}
+		// halves round away from zero
+		return m.fromReal(math.Round(f))
+	})},
+	{"Sqrt", realFunc("Sqrt", func(f float64) (float64, error) {
+		if f < 0 {
+			return 0, errors.New("Sqrt of a negative number")
+		}
+		return math.Sqrt(f), nil
+	})},
+	{"Sin", realFunc("Sin", func(f float64) (float64, error) { return math.Sin(f), nil })},
+	{"Cos", realFunc("Cos", func(f float64) (float64, error) { return math.Cos(f), nil })},
+	{"Exp", realFunc("Exp", func(f float64) (float64, error) { return math.Exp(f), nil })},
+	{"Ln", realFunc("Ln", func(f float64) (float64, error) {
+		if f <= 0 {
+			return 0, errors.New("Ln of a number which is not positive")
+		}
+		return math.Log(f), nil
+	})},
+}
+
+// step gives the ordinal value after or before x
+func (m IntegerMode) step(name string, x interface{}, by int) (interface{}, error) {
+	if isInteger(x) {
+		return m.arithmetic(lexer.Plus, x, by)
+	}
+
+	n, err := ordinal(x)
+	if err != nil {
+		return nil, argError(name, "an ordinal", x)
+	}
+
+	limit := 1
+	if e, ok := x.(EnumValue); ok {
+		limit = len(e.Type.Values) - 1
+	}
+
+	if n+by < 0 || n+by > limit {
+		return nil, fmt.Errorf("%s(%v) is out of range", name, x)
+	}
+
+	return m.ordinalLike(x, n+by)
+}
+
+func unary(fn func(mode IntegerMode, x interface{}) (interface{}, error)) Builtin {
+	return Builtin{
+		Arity: 1,
+		Call: func(mode IntegerMode, args []interface{}) (interface{}, error) {
+			return fn(mode, args[0])
+		},
+	}
+}
+
+// realFunc is a function from REAL to REAL, which also accepts INTEGERs
+func realFunc(name string, fn func(float64) (float64, error)) Builtin {
+	return unary(func(_ IntegerMode, x interface{}) (interface{}, error) {
+		f, err := number(name, x)
+		if err != nil {
+			return nil, err
+		}
+		return fn(f)
+	})
+}
+
+// number converts an INTEGER or REAL argument to a float64
+func number(name string, x interface{}) (float64, error) {
+	if _, ok := x.(bool); ok {
+		return 0, argError(name, "an INTEGER or REAL", x)
+	}
+
+	f, err := real(x)
+	if err != nil {
+		return 0, argError(name, "an INTEGER or REAL", x)
+	}
+
+	return f, nil
+}
+
+func argError(name, want string, x interface{}) error {
+	return fmt.Errorf("%s expects %s argument, got %s", name, want, typeName(x))
+}
+
+// fromReal converts a whole REAL to an INTEGER
+func (m IntegerMode) fromReal(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("integer overflow: %v is not a finite number", f)
+	}
+
+	n, _ := big.NewFloat(f).Int(nil)
+
+	return m.integer(n)
+}
+
+// typeName is the Pascal type of a value
+func typeName(x interface{}) string {
+	switch v := x.(type) {
+	case int, *big.Int:
+		return "INTEGER"
+	case float64:
+		return "REAL"
+	case bool:
+		return "BOOLEAN"
+	case Set:
+		return "SET"
+	case EnumValue:
+		if v.Type.Name != "" {
+			return v.Type.Name
+		}
+		return "enumeration"
+	case Pointer:
+		return "pointer"
+	case *Record:
+		return typeString(v.Type)
+	case *Array:
+		return typeString(v.typ)
+	}
+
+	return fmt.Sprintf("%T", x)
+}
+
+func (i *Interpreter) VisitCall(node *parser.CallNode) (interface{}, error) {
+	if p, ok := i.procedure(strings.ToLower(node.Name)); ok {
+		if p.result == nil {
+			return nil, errorAt(node.Pos, "procedure %s does not return a value", node.Name)
+		}
+
+		return i.call(p, node.Args, node.Pos)
+	}
+
+	b, ok := i.builtins[strings.ToLower(node.Name)]
+	if !ok {
+		return nil, errorAt(node.Pos, "unknown function %q", node.Name)
+	}
+
+	if len(node.Args) != b.Arity {
+		return nil, errorAt(node.Pos, "%s expects %d argument(s), got %d", node.Name, b.Arity, len(node.Args))
+	}
+
+	args := make([]interface{}, len(node.Args))
+	for n, arg := range node.Args {
+		val, err := arg.Accept(i)
+		if err != nil {
+			return nil, err
+		}
+		args[n] = val
+	}
+
+	val, err := b.Call(i.mode, args)
+
+	return val, positioned(node.Pos, err)
+}
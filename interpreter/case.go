@@ -0,0 +1,131 @@
+package interpreter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// caseTable maps the labels of a CASE statement to branch indices. Single
+// labels are looked up directly and ranges by binary search, relying on the
+// semantic analyser to have rejected overlapping labels.
+type caseTable struct {
+	single map[int]int
+	ranges []caseRange
+}
+
+type caseRange struct {
+	low    int
+	high   int
+	branch int
+}
+
+func (t *caseTable) lookup(value int) (int, bool) {
+	if branch, ok := t.single[value]; ok {
+		return branch, true
+	}
+
+	n := sort.Search(len(t.ranges), func(i int) bool {
+		return t.ranges[i].high >= value
+	})
+	if n < len(t.ranges) && t.ranges[n].low <= value {
+		return t.ranges[n].branch, true
+	}
+
+	return 0, false
+}
+
+func (i *Interpreter) VisitCase(node *parser.CaseNode) (interface{}, error) {
+	if err := i.before(node, node.Pos); err != nil {
+		return nil, err
+	}
+
+	err := i.caseStatement(node)
+	i.after(node, err)
+
+	return nil, err
+}
+
+func (i *Interpreter) caseStatement(node *parser.CaseNode) error {
+	table, err := i.caseTable(node)
+	if err != nil {
+		return err
+	}
+
+	val, err := node.Expr.Accept(i)
+	if err != nil {
+		return err
+	}
+
+	selector, err := ordinal(val)
+	if err != nil {
+		return fmt.Errorf("case selector: %w", err)
+	}
+
+	if branch, ok := table.lookup(selector); ok {
+		_, err := node.Branches[branch].Body.Accept(i)
+		return err
+	}
+
+	if node.Else != nil {
+		return i.compound(node.Else)
+	}
+
+	return nil
+}
+
+// caseTable builds the dispatch table for a CASE statement the first time
+// it is executed
+func (i *Interpreter) caseTable(node *parser.CaseNode) (*caseTable, error) {
+	if table, ok := i.caseTables[node]; ok {
+		return table, nil
+	}
+
+	table := &caseTable{single: map[int]int{}}
+
+	for n, branch := range node.Branches {
+		for _, label := range branch.Labels {
+			low, err := i.caseLabel(label.Low)
+			if err != nil {
+				return nil, err
+			}
+
+			if label.High == nil {
+				if _, ok := table.single[low]; !ok {
+					table.single[low] = n
+				}
+				continue
+			}
+
+			high, err := i.caseLabel(label.High)
+			if err != nil {
+				return nil, err
+			}
+
+			table.ranges = append(table.ranges, caseRange{low: low, high: high, branch: n})
+		}
+	}
+
+	sort.Slice(table.ranges, func(a, b int) bool {
+		return table.ranges[a].low < table.ranges[b].low
+	})
+
+	i.caseTables[node] = table
+
+	return table, nil
+}
+
+func (i *Interpreter) caseLabel(node parser.ASTNode) (int, error) {
+	val, err := node.Accept(i)
+	if err != nil {
+		return 0, err
+	}
+
+	label, err := ordinal(val)
+	if err != nil {
+		return 0, fmt.Errorf("case label: %w", err)
+	}
+
+	return label, nil
+}
@@ -0,0 +1,294 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// frame holds the names declared by the main program, by one call of
+// a procedure or by a section of a unit. Names not declared in a frame are
+// looked up in its parent, the frame of the enclosing block: this static
+// link follows the nesting of the source, not the chain of calls. Names
+// declared by none of them are looked up in the units each frame uses.
+type frame struct {
+	name     string
+	unit     string
+	parent   *frame
+	uses     []*frame
+	consts   map[string]interface{}
+	types    map[string]interface{}
+	vars     map[string]interface{}
+	varTypes map[string]interface{}
+	refs     map[string]*cell
+	procs    map[string]*procedure
+}
+
+func newFrame(name string, parent *frame) *frame {
+	return &frame{
+		name:     name,
+		parent:   parent,
+		consts:   map[string]interface{}{},
+		types:    map[string]interface{}{},
+		vars:     map[string]interface{}{},
+		varTypes: map[string]interface{}{},
+		refs:     map[string]*cell{},
+		procs:    map[string]*procedure{},
+	}
+}
+
+func (f *frame) declares(name string) bool {
+	if _, ok := f.consts[name]; ok {
+		return true
+	}
+	if _, ok := f.vars[name]; ok {
+		return true
+	}
+	if _, ok := f.varTypes[name]; ok {
+		return true
+	}
+	if _, ok := f.refs[name]; ok {
+		return true
+	}
+	if _, ok := f.types[name]; ok {
+		return true
+	}
+	_, ok := f.procs[name]
+
+	return ok
+}
+
+// resolve finds the innermost frame declaring a lowercased name, or else
+// the INTERFACE section of a unit which declares it. Later units in a USES
+// clause hide earlier ones.
+func (i *Interpreter) resolve(name string) (*frame, bool) {
+	for f := i.frame; f != nil; f = f.parent {
+		if f.declares(name) {
+			return f, true
+		}
+	}
+
+	for f := i.frame; f != nil; f = f.parent {
+		for n := len(f.uses) - 1; n >= 0; n-- {
+			if f.uses[n].declares(name) {
+				return f.uses[n], true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+func (i *Interpreter) constant(name string) (interface{}, bool) {
+	f, ok := i.resolve(name)
+	if !ok {
+		return nil, false
+	}
+	val, ok := f.consts[name]
+
+	return val, ok
+}
+
+func (i *Interpreter) procedure(name string) (*procedure, bool) {
+	for f := i.frame; f != nil; f = f.parent {
+		if p, ok := f.procs[name]; ok {
+			return p, true
+		}
+	}
+
+	for f := i.frame; f != nil; f = f.parent {
+		for n := len(f.uses) - 1; n >= 0; n-- {
+			if p, ok := f.uses[n].procs[name]; ok {
+				return p, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// unitOf names the unit whose code runs in a frame, or is empty for the
+// main program
+func unitOf(f *frame) string {
+	for ; f != nil; f = f.parent {
+		if f.unit != "" {
+			return f.unit
+		}
+	}
+
+	return ""
+}
+
+// param is a formal parameter with its resolved type
+type param struct {
+	name  string
+	typ   interface{}
+	byRef bool
+}
+
+// procedure is a declared procedure or function, with the frame it was
+// declared in. A nested procedure is declared afresh by each call of the
+// procedure enclosing it, so it sees the locals of that call.
+type procedure struct {
+	decl   *parser.ProcDeclNode
+	params []param
+	result interface{}
+	parent *frame
+}
+
+// qualifiedName names a procedure after the procedures and unit it is
+// declared in, such as Outer.Inner or Unit.Proc, so that procedures of the
+// same name declared in different places are told apart
+func (p *procedure) qualifiedName() string {
+	name := p.decl.Name
+	for f := p.parent; f != nil && f.parent != nil && f.unit == ""; f = f.parent {
+		name = f.name + "." + name
+	}
+	if unit := unitOf(p.parent); unit != "" {
+		name = unit + "." + name
+	}
+
+	return name
+}
+
+func (i *Interpreter) VisitProcDecl(node *parser.ProcDeclNode) (interface{}, error) {
+	p := &procedure{decl: node, parent: i.frame}
+
+	for _, group := range node.Params {
+		t, err := i.resolveType(group.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range group.Names {
+			p.params = append(p.params, param{name: strings.ToLower(name.Value), typ: t, byRef: group.ByRef})
+		}
+	}
+
+	if node.Result != nil {
+		t, err := i.resolveType(node.Result)
+		if err != nil {
+			return nil, err
+		}
+		p.result = t
+	}
+	i.frame.procs[strings.ToLower(node.Name)] = p
+
+	return nil, nil
+}
+
+// call runs a procedure in a new frame. Value arguments are evaluated and
+// copied; VAR arguments share the caller's variable.
+func (i *Interpreter) call(p *procedure, args []parser.ASTNode, pos lexer.Position) (interface{}, error) {
+	name := p.decl.Name
+	if len(args) != len(p.params) {
+		return nil, errorAt(pos, "%s expects %d argument(s), got %d", name, len(p.params), len(args))
+	}
+
+	// the static link is the frame declaring the procedure, not the caller
+	f := newFrame(name, p.parent)
+	for n, prm := range p.params {
+		if prm.byRef {
+			if parser.DesignatorVar(args[n]) == nil {
+				return nil, errorAt(pos, "argument %d of %s must be a variable", n+1, name)
+			}
+
+			c, err := i.designate(args[n])
+			if err != nil {
+				return nil, err
+			}
+
+			if c.typ != nil && typeString(c.typ) != typeString(prm.typ) {
+				return nil, errorAt(pos, "argument %d of %s must be a variable of type %s, got %s",
+					n+1, name, typeString(prm.typ), typeString(c.typ))
+			}
+			f.refs[prm.name] = c
+
+			continue
+		}
+
+		val, err := args[n].Accept(i)
+		if err != nil {
+			return nil, err
+		}
+
+		if val, err = i.convert(pos, prm.name, prm.typ, val); err != nil {
+			return nil, err
+		}
+		f.vars[prm.name] = copyValue(val)
+		f.varTypes[prm.name] = prm.typ
+	}
+
+	// a function returns the value assigned to its name
+	result := strings.ToLower(name)
+	if p.result != nil {
+		f.varTypes[result] = p.result
+		if zero := zeroValue(p.result); zero != nil {
+			f.vars[result] = zero
+		}
+	}
+
+	if err := i.push(p, f, pos); err != nil {
+		return nil, err
+	}
+
+	caller := i.frame
+	i.frame = f
+	i.beforeCall(name, pos)
+	_, err := p.decl.Block.Accept(i)
+	err = i.traced(err)
+	i.afterCall(name, err)
+	i.frame = caller
+	i.pop()
+
+	if err != nil || p.result == nil {
+		return nil, err
+	}
+
+	val, ok := f.vars[result]
+	if !ok {
+		return nil, errorAt(pos, "function %s did not assign a result", name)
+	}
+
+	return val, nil
+}
+
+func (i *Interpreter) VisitProcCall(node *parser.ProcCallNode) (interface{}, error) {
+	if err := i.before(node, node.Pos); err != nil {
+		return nil, err
+	}
+
+	err := i.procCall(node)
+	i.after(node, err)
+
+	return nil, err
+}
+
+func (i *Interpreter) procCall(node *parser.ProcCallNode) error {
+	name := strings.ToLower(node.Name)
+	if p, ok := i.procedure(name); ok {
+		_, err := i.call(p, node.Args, node.Pos)
+		return err
+	}
+
+	var proc func(*parser.ProcCallNode) error
+
+	switch name {
+	case "new":
+		proc = i.newProc
+	case "dispose":
+		proc = i.disposeProc
+	case "write", "writeln":
+		return i.write(node, name == "writeln")
+	default:
+		return fmt.Errorf("unknown procedure %q", node.Name)
+	}
+
+	if len(node.Args) != 1 {
+		return fmt.Errorf("%s expects 1 argument(s), got %d", node.Name, len(node.Args))
+	}
+
+	return proc(node)
+}
@@ -0,0 +1,519 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// Error is a runtime error at a position in the program
+type Error struct {
+	Pos lexer.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at %s", e.Msg, e.Pos)
+}
+
+func errorAt(pos lexer.Position, format string, args ...interface{}) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// positioned gives an error raised by an operation the position of the
+// operation. Errors which already have a position, or a stack trace, are
+// returned as they are.
+func positioned(pos lexer.Position, err error) error {
+	var posErr *Error
+	var stackErr *StackError
+	if err == nil || errors.As(err, &posErr) || errors.As(err, &stackErr) {
+		return err
+	}
+
+	return errorAt(pos, "%v", err)
+}
+
+// pointerType points to the named type, which is looked up when it is used
+// so that a pointer can be declared before the type it points to
+type pointerType struct {
+	name string
+}
+
+// RecordType is a RECORD type. Name is empty for an anonymous type.
+type RecordType struct {
+	Name   string
+	Fields []string
+	types  []interface{}
+}
+
+func (t *RecordType) field(name string) (int, bool) {
+	for n, f := range t.Fields {
+		if strings.EqualFold(f, name) {
+			return n, true
+		}
+	}
+
+	return 0, false
+}
+
+// Record is a value of a RECORD type. Records are copied when they are
+// assigned; a nil field has not been assigned.
+type Record struct {
+	Type   *RecordType
+	Fields []interface{}
+}
+
+func (r *Record) String() string {
+	fields := make([]string, len(r.Fields))
+	for n, v := range r.Fields {
+		if v == nil {
+			v = "?"
+		}
+		fields[n] = fmt.Sprintf("%s: %v", r.Type.Fields[n], v)
+	}
+
+	return "(" + strings.Join(fields, "; ") + ")"
+}
+
+// MarshalText gives the fields of the record, for traces
+func (r *Record) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Record) clone() *Record {
+	c := &Record{Type: r.Type, Fields: make([]interface{}, len(r.Fields))}
+	for n, v := range r.Fields {
+		c.Fields[n] = copyValue(v)
+	}
+
+	return c
+}
+
+// copyValue gives records and arrays value semantics on assignment
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *Record:
+		return v.clone()
+	case *Array:
+		return v.clone()
+	}
+
+	return v
+}
+
+// overwrite copies a record or array into the storage of the one it
+// replaces, so VAR parameters bound to its parts still refer to the
+// variable. It reports false when there is no such storage to reuse.
+func overwrite(dst, src interface{}) bool {
+	switch d := dst.(type) {
+	case *Record:
+		s, ok := src.(*Record)
+		if !ok || s.Type != d.Type {
+			return false
+		}
+		if s != d {
+			for n, v := range s.Fields {
+				if !overwrite(d.Fields[n], v) {
+					d.Fields[n] = copyValue(v)
+				}
+			}
+		}
+		return true
+
+	case *Array:
+		s, ok := src.(*Array)
+		if !ok || len(s.Elems) != len(d.Elems) {
+			return false
+		}
+		if s != d {
+			for n, v := range s.Elems {
+				if !overwrite(d.Elems[n], v) {
+					d.Elems[n] = copyValue(v)
+				}
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// zeroValue is the value of a newly declared or allocated variable: records
+// and arrays are allocated so their parts can be assigned, everything else
+// is unset
+func zeroValue(t interface{}) interface{} {
+	switch t := t.(type) {
+	case *RecordType:
+		r := &Record{Type: t, Fields: make([]interface{}, len(t.Fields))}
+		for n, ft := range t.types {
+			r.Fields[n] = zeroValue(ft)
+		}
+		return r
+
+	case *arrayType:
+		a := &Array{typ: t, Elems: make([]interface{}, t.high-t.low+1)}
+		for n := range a.Elems {
+			a.Elems[n] = zeroValue(t.elem)
+		}
+		return a
+	}
+
+	return nil
+}
+
+// allocation is a variable created by New
+type allocation struct {
+	id         int
+	typ        interface{}
+	value      interface{}
+	pos        lexer.Position
+	disposed   bool
+	disposedAt lexer.Position
+}
+
+// Pointer is a pointer to a variable created by New. The zero Pointer is NIL.
+type Pointer struct {
+	alloc *allocation
+}
+
+func (p Pointer) String() string {
+	if p.alloc == nil {
+		return "NIL"
+	}
+
+	return fmt.Sprintf("^%s#%d", typeString(p.alloc.typ), p.alloc.id)
+}
+
+// MarshalText gives the allocation pointed to, for traces
+func (p Pointer) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// Allocation describes a variable created by New
+type Allocation struct {
+	Type string
+	Pos  lexer.Position
+}
+
+// Leaks lists the variables created by New which have not been disposed, in
+// the order they were allocated
+func (i *Interpreter) Leaks() []Allocation {
+	leaks := []Allocation{}
+	for _, a := range i.heap {
+		if !a.disposed {
+			leaks = append(leaks, Allocation{Type: typeString(a.typ), Pos: a.pos})
+		}
+	}
+
+	return leaks
+}
+
+func typeString(t interface{}) string {
+	switch t := t.(type) {
+	case basic:
+		return string(t)
+	case *Enum:
+		if t.Name != "" {
+			return t.Name
+		}
+		return "enumeration"
+	case *RecordType:
+		if t.Name != "" {
+			return t.Name
+		}
+		return "RECORD"
+	case *pointerType:
+		return "^" + t.name
+	case *setType:
+		return "SET OF " + typeString(t.elem)
+	case *subrange:
+		return fmt.Sprintf("%v..%v", t.low, t.high)
+	case *arrayType:
+		return fmt.Sprintf("ARRAY [%s] OF %s", typeString(t.index), typeString(t.elem))
+	}
+
+	return "unknown"
+}
+
+// cell is an assignable location: a variable, a field of a record or a
+// variable created by New. typ is nil when the type is not declared. alloc
+// is the variable created by New which holds the location, if any.
+type cell struct {
+	name  string
+	typ   interface{}
+	load  func() interface{}
+	store func(interface{})
+	alloc *allocation
+}
+
+// live fails if the cell is held by a variable which has been disposed
+// since it was found, as a VAR parameter can be
+func (c *cell) live(pos lexer.Position) error {
+	if c.alloc == nil || !c.alloc.disposed {
+		return nil
+	}
+
+	return errorAt(pos, "use of disposed pointer (allocated at %s, disposed at %s)",
+		c.alloc.pos, c.alloc.disposedAt)
+}
+
+// designate finds the location named by a designator such as p^.next
+func (i *Interpreter) designate(node parser.ASTNode) (*cell, error) {
+	switch n := node.(type) {
+	case *parser.VarNode:
+		name := strings.ToLower(n.Value)
+
+		// an undeclared variable is declared by assigning to it
+		f, ok := i.resolve(name)
+		if !ok {
+			f = i.frame
+		}
+
+		if c, ok := f.refs[name]; ok {
+			return c, c.live(n.Pos)
+		}
+
+		if _, ok := f.consts[name]; ok {
+			return nil, fmt.Errorf("cannot assign to constant %q", n.Value)
+		}
+
+		return &cell{
+			name:  n.Value,
+			typ:   f.varTypes[name],
+			load:  func() interface{} { return f.vars[name] },
+			store: func(v interface{}) { f.vars[name] = v },
+		}, nil
+
+	case *parser.DerefNode:
+		alloc, err := i.deref(n)
+		if err != nil {
+			return nil, err
+		}
+
+		return &cell{
+			name:  parser.Designator(n),
+			typ:   alloc.typ,
+			load:  func() interface{} { return alloc.value },
+			store: func(v interface{}) { alloc.value = v },
+			alloc: alloc,
+		}, nil
+
+	case *parser.FieldNode:
+		val, alloc, err := i.part(n.Record)
+		if err != nil {
+			return nil, err
+		}
+
+		rec, ok := val.(*Record)
+		if !ok {
+			return nil, errorAt(n.Pos, "%s is not a record", parser.Designator(n.Record))
+		}
+
+		idx, ok := rec.Type.field(n.Name)
+		if !ok {
+			return nil, errorAt(n.Pos, "%s has no field %q", typeString(rec.Type), n.Name)
+		}
+
+		return &cell{
+			name:  parser.Designator(n),
+			typ:   rec.Type.types[idx],
+			load:  func() interface{} { return rec.Fields[idx] },
+			store: func(v interface{}) { rec.Fields[idx] = v },
+			alloc: alloc,
+		}, nil
+
+	case *parser.IndexNode:
+		return i.element(n)
+	}
+
+	return nil, fmt.Errorf("cannot assign to %T", node)
+}
+
+// part evaluates the record or array containing a field or element, with
+// the variable created by New which holds it, if any
+func (i *Interpreter) part(node parser.ASTNode) (interface{}, *allocation, error) {
+	var pos lexer.Position
+	switch n := node.(type) {
+	case *parser.DerefNode:
+		pos = n.Pos
+	case *parser.FieldNode:
+		pos = n.Pos
+	case *parser.IndexNode:
+		pos = n.Pos
+	case *parser.VarNode:
+		// only a VAR parameter can stand for a part of a variable
+		// created by New
+		name := strings.ToLower(n.Value)
+		if f, ok := i.resolve(name); !ok || f.refs[name] == nil {
+			val, err := node.Accept(i)
+			return val, nil, err
+		}
+		pos = n.Pos
+	default:
+		val, err := node.Accept(i)
+		return val, nil, err
+	}
+
+	c, err := i.designate(node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	val := c.load()
+	if val == nil {
+		return nil, nil, errorAt(pos, "%s is used before it is assigned", c.name)
+	}
+
+	return val, c.alloc, nil
+}
+
+// assignTo stores a value in the location named by a designator, reporting
+// a value not of its type at pos
+func (i *Interpreter) assignTo(target parser.ASTNode, value interface{}, pos lexer.Position) error {
+	_, err := i.storeIn(target, value, pos)
+
+	return err
+}
+
+// storeIn is assignTo, returning the location the value was stored in
+func (i *Interpreter) storeIn(target parser.ASTNode, value interface{}, pos lexer.Position) (*cell, error) {
+	c, err := i.designate(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.typ != nil {
+		if value, err = i.convert(pos, c.name, c.typ, value); err != nil {
+			return nil, err
+		}
+	}
+	if !overwrite(c.load(), value) {
+		c.store(copyValue(value))
+	}
+
+	return c, nil
+}
+
+func (i *Interpreter) deref(node *parser.DerefNode) (*allocation, error) {
+	val, err := node.Pointer.Accept(i)
+	if err != nil {
+		return nil, err
+	}
+
+	p, ok := val.(Pointer)
+	if !ok {
+		return nil, errorAt(node.Pos, "cannot dereference %s", typeName(val))
+	}
+
+	if p.alloc == nil {
+		return nil, errorAt(node.Pos, "NIL pointer dereference")
+	}
+
+	if p.alloc.disposed {
+		return nil, errorAt(node.Pos, "use of disposed pointer (allocated at %s, disposed at %s)",
+			p.alloc.pos, p.alloc.disposedAt)
+	}
+
+	return p.alloc, nil
+}
+
+func (i *Interpreter) VisitNil(node *parser.NilNode) (interface{}, error) {
+	return Pointer{}, nil
+}
+
+func (i *Interpreter) VisitDeref(node *parser.DerefNode) (interface{}, error) {
+	return i.load(node, node.Pos)
+}
+
+func (i *Interpreter) VisitField(node *parser.FieldNode) (interface{}, error) {
+	return i.load(node, node.Pos)
+}
+
+func (i *Interpreter) load(node parser.ASTNode, pos lexer.Position) (interface{}, error) {
+	c, err := i.designate(node)
+	if err != nil {
+		return nil, err
+	}
+
+	val := c.load()
+	if val == nil {
+		return nil, errorAt(pos, "%s is used before it is assigned", c.name)
+	}
+
+	return val, nil
+}
+
+func (i *Interpreter) newProc(node *parser.ProcCallNode) error {
+	c, err := i.designate(node.Args[0])
+	if err != nil {
+		return err
+	}
+
+	pt, ok := c.typ.(*pointerType)
+	if !ok {
+		return errorAt(node.Pos, "New needs a pointer variable, got %s", c.name)
+	}
+
+	t, err := i.resolveType(&parser.NamedType{Name: pt.name})
+	if err != nil {
+		return err
+	}
+
+	alloc := &allocation{
+		id:    len(i.heap) + 1,
+		typ:   t,
+		value: zeroValue(t),
+		pos:   node.Pos,
+	}
+	i.heap = append(i.heap, alloc)
+	c.store(Pointer{alloc: alloc})
+
+	return nil
+}
+
+func (i *Interpreter) disposeProc(node *parser.ProcCallNode) error {
+	val, err := node.Args[0].Accept(i)
+	if err != nil {
+		return err
+	}
+
+	p, ok := val.(Pointer)
+	if !ok {
+		return errorAt(node.Pos, "Dispose needs a pointer, got %s", typeName(val))
+	}
+
+	if p.alloc == nil {
+		return errorAt(node.Pos, "Dispose of NIL pointer")
+	}
+
+	if p.alloc.disposed {
+		return errorAt(node.Pos, "pointer disposed twice (allocated at %s, disposed at %s)",
+			p.alloc.pos, p.alloc.disposedAt)
+	}
+
+	p.alloc.disposed = true
+	p.alloc.disposedAt = node.Pos
+	p.alloc.value = nil
+
+	return nil
+}
+
+func pointerRelation(op lexer.TokenType, left, right interface{}) (interface{}, error) {
+	l, lok := left.(Pointer)
+	r, rok := right.(Pointer)
+	if !lok || !rok {
+		return nil, fmt.Errorf("cannot compare %s with %s", typeName(left), typeName(right))
+	}
+
+	switch op {
+	case lexer.Equal:
+		return l == r, nil
+	case lexer.NotEqual:
+		return l != r, nil
+	}
+
+	return nil, fmt.Errorf("%s is not defined on pointers", op)
+}
@@ -3,6 +3,9 @@ package interpreter
 
 import (
 	"fmt"
+	"io"
+	"math/big"
+	"os"
 	"strings"
 
 	"github.com/kieron-dev/lsbasi/lexer"
@@ -17,16 +20,80 @@ type Programmer interface {
 	Program() (parser.ASTNode, error)
 }
 
+// Hook is notified around the execution of each statement
+type Hook interface {
+	BeforeStatement(node parser.ASTNode, pos lexer.Position) error
+	AfterStatement(node parser.ASTNode, err error)
+}
+
+type Option func(*Interpreter)
+
+// WithHook installs a hook which is called around each statement visit.
+// Hooks are called in the order they are installed before a statement, and
+// in reverse order after it.
+func WithHook(hook Hook) Option {
+	return func(i *Interpreter) {
+		i.hooks = append(i.hooks, hook)
+	}
+}
+
+// CallHook is a Hook which is also notified around each call of a declared
+// procedure or function
+type CallHook interface {
+	Hook
+	BeforeCall(name string, pos lexer.Position)
+	AfterCall(name string, err error)
+}
+
+// AssignHook is a Hook which is also told the variable and value stored by
+// each assignment statement, before the statement finishes
+type AssignHook interface {
+	Hook
+	AfterAssign(name string, value interface{})
+}
+
 type Interpreter struct {
-	pars          Programmer
-	globalSymbols map[string]int
+	pars       Programmer
+	globals    *frame
+	frame      *frame
+	hooks      []Hook
+	caseTables map[*parser.CaseNode]*caseTable
+	mode       IntegerMode
+	builtins   map[string]Builtin
+	heap       []*allocation
+	calls      []activation
+	maxDepth   int
+	nesting    int
+	output     io.Writer
+	units      map[string]*parser.UnitNode
+	unitFrames map[string]*frame
 }
 
-func NewInterpreter(pars Programmer) *Interpreter {
-	return &Interpreter{
-		pars:          pars,
-		globalSymbols: map[string]int{},
+func NewInterpreter(pars Programmer, opts ...Option) *Interpreter {
+	globals := newFrame(MainProgram, nil)
+	globals.consts["false"] = false
+	globals.consts["true"] = true
+	i := &Interpreter{
+		pars:       pars,
+		globals:    globals,
+		frame:      globals,
+		caseTables: map[*parser.CaseNode]*caseTable{},
+		builtins:   map[string]Builtin{},
+		maxDepth:   DefaultMaxDepth,
+		output:     os.Stdout,
+		units:      map[string]*parser.UnitNode{},
+		unitFrames: map[string]*frame{},
 	}
+
+	for _, b := range standardBuiltins {
+		i.builtins[strings.ToLower(b.name)] = b.fn
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	return i
 }
 
 func (i *Interpreter) Interpret() error {
@@ -39,11 +106,89 @@ func (i *Interpreter) Interpret() error {
 	return err
 }
 
+// Eval evaluates an expression against the current state of the program.
+// Hooks are suspended meanwhile, so that the statements of a function
+// called by the expression are neither traced nor paused in.
+func (i *Interpreter) Eval(expr parser.ASTNode) (interface{}, error) {
+	hooks := i.hooks
+	i.hooks = nil
+	defer func() { i.hooks = hooks }()
+
+	return expr.Accept(i)
+}
+
+func (i *Interpreter) before(node parser.ASTNode, pos lexer.Position) error {
+	if err := i.nest(pos); err != nil {
+		return err
+	}
+
+	for _, hook := range i.hooks {
+		if err := hook.BeforeStatement(node, pos); err != nil {
+			i.nesting--
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *Interpreter) after(node parser.ASTNode, err error) {
+	i.nesting--
+	for n := len(i.hooks) - 1; n >= 0; n-- {
+		i.hooks[n].AfterStatement(node, err)
+	}
+}
+
+func (i *Interpreter) beforeCall(name string, pos lexer.Position) {
+	for _, hook := range i.hooks {
+		if h, ok := hook.(CallHook); ok {
+			h.BeforeCall(name, pos)
+		}
+	}
+}
+
+func (i *Interpreter) afterCall(name string, err error) {
+	for n := len(i.hooks) - 1; n >= 0; n-- {
+		if h, ok := i.hooks[n].(CallHook); ok {
+			h.AfterCall(name, err)
+		}
+	}
+}
+
+func (i *Interpreter) afterAssign(name string, value interface{}) {
+	for n := len(i.hooks) - 1; n >= 0; n-- {
+		if h, ok := i.hooks[n].(AssignHook); ok {
+			h.AfterAssign(name, value)
+		}
+	}
+}
+
 func (i *Interpreter) VisitNum(node *parser.NumNode) (interface{}, error) {
+	return i.literal(node.Int(), node.Token.Text, node.Token.Pos)
+}
+
+// literal checks the value of an integer literal against the integer mode,
+// naming the literal as it is written if it is out of range. A literal
+// made by folding constants has no text, and is reported as an overflow.
+func (i *Interpreter) literal(n *big.Int, text string, pos lexer.Position) (interface{}, error) {
+	val, err := i.mode.integer(n)
+	if err != nil && text != "" {
+		return nil, errorAt(pos, "integer literal %s is out of range", text)
+	}
+
+	return val, err
+}
+
+func (i *Interpreter) VisitReal(node *parser.RealNode) (interface{}, error) {
 	return node.Value, nil
 }
 
 func (i *Interpreter) VisitBinOp(node *parser.BinOpNode) (interface{}, error) {
+	if err := i.nest(node.Token.Pos); err != nil {
+		return nil, err
+	}
+	defer func() { i.nesting-- }()
+
 	leftVal, err := node.Left.Accept(i)
 	if err != nil {
 		return nil, err
@@ -52,62 +197,156 @@ func (i *Interpreter) VisitBinOp(node *parser.BinOpNode) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	left := leftVal.(int)
-	right := rightVal.(int)
 
-	switch node.Token.Type {
-	case lexer.Plus:
-		return left + right, nil
-	case lexer.Minus:
-		return left - right, nil
-	case lexer.Mult:
-		return left * right, nil
-	case lexer.Div:
-		return left / right, nil
+	val, err := i.binOp(node.Token.Type, leftVal, rightVal)
+
+	return val, positioned(node.Token.Pos, err)
+}
+
+func (i *Interpreter) binOp(op lexer.TokenType, left, right interface{}) (interface{}, error) {
+	if _, ok := left.(Pointer); ok {
+		return pointerRelation(op, left, right)
+	}
+	if _, ok := right.(Pointer); ok {
+		return pointerRelation(op, left, right)
+	}
+
+	if op == lexer.In || op == lexer.Equal || op == lexer.NotEqual || op == lexer.Less ||
+		op == lexer.LessEqual || op == lexer.Greater || op == lexer.GreaterEqual {
+		return relation(op, left, right)
 	}
 
-	return nil, fmt.Errorf("weird bin op node: %v", *node)
+	if l, ok := left.(Set); ok {
+		r, ok := right.(Set)
+		if !ok {
+			return nil, fmt.Errorf("cannot combine a set with %s", typeName(right))
+		}
+
+		return setOperation(op, l, r)
+	}
+
+	return i.mode.arithmetic(op, left, right)
 }
 
 func (i *Interpreter) VisitUnary(node *parser.UnaryNode) (interface{}, error) {
+	// a negative literal is checked after negation, so that the most
+	// negative INTEGER can be written
+	if num, ok := node.Child.(*parser.NumNode); ok && node.Token.Type == lexer.Minus {
+		text := num.Token.Text
+		if text != "" {
+			text = "-" + text
+		}
+
+		val, err := i.literal(new(big.Int).Neg(num.Int()), text, node.Token.Pos)
+		return val, positioned(node.Token.Pos, err)
+	}
+
+	if err := i.nest(node.Token.Pos); err != nil {
+		return nil, err
+	}
 	child, err := node.Child.Accept(i)
+	i.nesting--
 	if err != nil {
 		return nil, err
 	}
-	val := child.(int)
 
 	if node.Token.Type == lexer.Minus {
-		return -val, nil
+		val, err := i.mode.negate(child)
+		return val, positioned(node.Token.Pos, err)
 	}
 
-	return val, nil
+	return child, nil
 }
 
 func (i *Interpreter) VisitCompound(node *parser.CompoundNode) (interface{}, error) {
+	if err := i.before(node, node.Pos); err != nil {
+		return nil, err
+	}
+
+	err := i.compound(node)
+	i.after(node, err)
+
+	return nil, err
+}
+
+func (i *Interpreter) compound(node *parser.CompoundNode) error {
 	for _, child := range node.Children {
 		_, err := child.Accept(i)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	return nil, nil
+	return nil
 }
 
 func (i *Interpreter) VisitAssign(node *parser.AssignNode) (interface{}, error) {
-	varName := strings.ToLower(node.Left.Value)
+	if err := i.before(node, node.Pos); err != nil {
+		return nil, err
+	}
+
+	err := i.assign(node)
+	i.after(node, err)
+
+	return nil, err
+}
+
+func (i *Interpreter) assign(node *parser.AssignNode) error {
+	if v, ok := node.Left.(*parser.VarNode); ok {
+		if _, ok := i.constant(strings.ToLower(v.Value)); ok {
+			return fmt.Errorf("cannot assign to constant %q", v.Value)
+		}
+	}
+
 	value, err := node.Right.Accept(i)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	i.globalSymbols[varName] = value.(int)
 
-	return nil, nil
+	c, err := i.storeIn(node.Left, value, node.Pos)
+	if err != nil {
+		return err
+	}
+
+	// a VAR parameter is named as it is in the procedure, not by the
+	// variable of the caller which it stands for
+	name := c.name
+	if _, ok := node.Left.(*parser.VarNode); ok {
+		name = parser.Designator(node.Left)
+	}
+	i.afterAssign(name, c.load())
+
+	return nil
 }
 
 func (i *Interpreter) VisitVar(node *parser.VarNode) (interface{}, error) {
 	varName := strings.ToLower(node.Value)
-	val, ok := i.globalSymbols[varName]
+	f, ok := i.resolve(varName)
+	if !ok {
+		return nil, fmt.Errorf("unknown var %q", node.Value)
+	}
+
+	if val, ok := f.consts[varName]; ok {
+		return val, nil
+	}
+
+	if c, ok := f.refs[varName]; ok {
+		if err := c.live(node.Pos); err != nil {
+			return nil, err
+		}
+
+		if val := c.load(); val != nil {
+			return val, nil
+		}
+
+		return nil, fmt.Errorf("unknown var %q", node.Value)
+	}
+
+	if p, ok := f.procs[varName]; ok {
+		return i.call(p, nil, node.Pos)
+	}
+
+	val, ok := f.vars[varName]
 	if !ok {
 		return nil, fmt.Errorf("unknown var %q", node.Value)
 	}
@@ -115,10 +354,77 @@ func (i *Interpreter) VisitVar(node *parser.VarNode) (interface{}, error) {
 	return val, nil
 }
 
+func (i *Interpreter) VisitBlock(node *parser.BlockNode) (interface{}, error) {
+	if len(node.Uses) > 0 {
+		uses, err := i.uses(node.Uses)
+		if err != nil {
+			return nil, err
+		}
+		i.frame.uses = uses
+	}
+
+	for _, decl := range node.Consts {
+		if _, err := decl.Accept(i); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, decl := range node.Types {
+		if _, err := decl.Accept(i); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, decl := range node.Vars {
+		if _, err := decl.Accept(i); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, decl := range node.Procs {
+		if _, err := decl.Accept(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return node.Compound.Accept(i)
+}
+
+func (i *Interpreter) VisitConstDecl(node *parser.ConstDeclNode) (interface{}, error) {
+	value, err := node.Value.Accept(i)
+	if err != nil {
+		return nil, err
+	}
+	i.frame.consts[strings.ToLower(node.Name)] = value
+
+	return nil, nil
+}
+
+func (i *Interpreter) VisitUnit(node *parser.UnitNode) (interface{}, error) {
+	return nil, fmt.Errorf("unit %s cannot be run as a program", node.Name)
+}
+
 func (i *Interpreter) VisitNoOp(node *parser.NoOpNode) (interface{}, error) {
 	return nil, nil
 }
 
-func (i *Interpreter) GlobalScope() map[string]int {
-	return i.globalSymbols
+func (i *Interpreter) GlobalScope() map[string]interface{} {
+	return i.globals.vars
+}
+
+// LocalScope returns the variables of the innermost call in progress,
+// including its parameters and the result of a function, or the global
+// variables outside any call
+func (i *Interpreter) LocalScope() map[string]interface{} {
+	vars := map[string]interface{}{}
+	for name, val := range i.frame.vars {
+		vars[name] = val
+	}
+	for name, c := range i.frame.refs {
+		if val := c.load(); val != nil {
+			vars[name] = val
+		}
+	}
+
+	return vars
 }
@@ -1,6 +1,12 @@
 package interpreter_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
 	"github.com/kieron-dev/lsbasi/interpreter"
 	"github.com/kieron-dev/lsbasi/interpreter/interpreterfakes"
 	"github.com/kieron-dev/lsbasi/lexer"
@@ -62,7 +68,7 @@ var _ = Describe("Interpreter", func() {
 		),
 	)
 
-	DescribeTable("programs", func(program *parser.CompoundNode, expectedValue map[string]int) {
+	DescribeTable("programs", func(program *parser.CompoundNode, expectedValue map[string]interface{}) {
 		pars := new(interpreterfakes.FakeProgrammer)
 		pars.ProgramReturns(program, nil)
 		interp := interpreter.NewInterpreter(pars)
@@ -78,7 +84,7 @@ var _ = Describe("Interpreter", func() {
 					&parser.NoOpNode{},
 				},
 			},
-			map[string]int{},
+			map[string]interface{}{},
 		),
 
 		Entry("var assignment",
@@ -94,7 +100,7 @@ var _ = Describe("Interpreter", func() {
 					},
 				},
 			},
-			map[string]int{"a": 42},
+			map[string]interface{}{"a": 42},
 		),
 
 		Entry("A := 42; b := a - 1",
@@ -124,7 +130,296 @@ var _ = Describe("Interpreter", func() {
 					},
 				},
 			},
-			map[string]int{"a": 42, "b": 41},
+			map[string]interface{}{"a": 42, "b": 41},
 		),
 	)
+
+	DescribeTable("integer modes", func(mode interpreter.IntegerMode, expr string, expected interface{}, errMsg string) {
+		src := "BEGIN res := " + expr + " END."
+		interp := interpreter.NewInterpreter(
+			parser.NewParser(lexer.NewTokeniser(strings.NewReader(src), lexer.WithIntegerRange(mode.Bounds()))),
+			interpreter.WithIntegerMode(mode),
+		)
+
+		err := interp.Interpret()
+		if errMsg != "" {
+			Expect(err).To(MatchError(errMsg))
+			return
+		}
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(interp.GlobalScope()["res"]).To(Equal(expected))
+	},
+		Entry("16-bit in range", interpreter.Int16, "32767", 32767, ""),
+		Entry("16-bit literal out of range", interpreter.Int16, "40000", nil, "integer literal 40000 is out of range at 1:14"),
+		Entry("16-bit negative literal out of range", interpreter.Int16, "1 + -40000", nil, "integer literal 40000 is out of range at 1:19"),
+		Entry("16-bit most negative literal", interpreter.Int16, "-32768", -32768, ""),
+		Entry("64-bit most negative literal", interpreter.Int64, "-9223372036854775808", -9223372036854775808, ""),
+		Entry("64-bit literal out of range", interpreter.Int64, "9223372036854775808", nil, "integer literal 9223372036854775808 is out of range at 1:14"),
+		Entry("integer overflow", interpreter.Int64, "99999999999999999999", nil, "integer literal 99999999999999999999 is out of range at 1:14"),
+		Entry("hex overflow", interpreter.Int64, "$1FFFFFFFFFFFFFFFF", nil, "integer literal $1FFFFFFFFFFFFFFFF is out of range at 1:14"),
+		Entry("big literal", interpreter.BigInt, "99999999999999999999 + 1", bigInt("100000000000000000000"), ""),
+		Entry("16-bit sum overflow", interpreter.Int16, "30000 + 30000", nil, "integer overflow: 60000 is outside the 16-bit range -32768..32767 at 1:20"),
+		Entry("16-bit negation overflow", interpreter.Int16, "-(-32767 - 1)", nil, "integer overflow: 32768 is outside the 16-bit range -32768..32767 at 1:14"),
+		Entry("32-bit product overflow", interpreter.Int32, "65536 * 32768", nil, "integer overflow: 2147483648 is outside the 32-bit range -2147483648..2147483647 at 1:20"),
+		Entry("64-bit overflow", interpreter.Int64, "9223372036854775807 + 1", nil, "integer overflow: 9223372036854775808 is outside the 64-bit range -9223372036854775808..9223372036854775807 at 1:34"),
+		Entry("big", interpreter.BigInt, "9223372036854775807 * 4", bigInt("36893488147419103228"), ""),
+		Entry("DIV truncates towards zero", interpreter.Int16, "-7 DIV 2", -3, ""),
+		Entry("MOD takes the sign of the dividend", interpreter.Int32, "-7 MOD 2", -1, ""),
+		Entry("MOD with a negative divisor", interpreter.Int32, "7 MOD -2", 1, ""),
+		Entry("big MOD", interpreter.BigInt, "-7 MOD 2", bigInt("-1"), ""),
+		Entry("MOD by zero", interpreter.Int64, "7 MOD 0", nil, "division by zero at 1:16"),
+		Entry("MOD of a REAL", interpreter.Int64, "7.5 MOD 2", nil, "MOD needs INTEGER operands at 1:18"),
+		Entry("REAL with big", interpreter.BigInt, "2 * 1.5", 3.0, ""),
+	)
+
+	It("calls functions registered as built-ins", func() {
+		max := interpreter.Builtin{
+			Arity: 2,
+			Call: func(_ interpreter.IntegerMode, args []interface{}) (interface{}, error) {
+				if args[0].(int) > args[1].(int) {
+					return args[0], nil
+				}
+				return args[1], nil
+			},
+		}
+
+		src := "BEGIN res := MAX(3, 7) + Abs(-1) END."
+		interp := interpreter.NewInterpreter(
+			parser.NewParser(lexer.NewTokeniser(strings.NewReader(src))),
+			interpreter.WithBuiltin("Max", max),
+		)
+
+		Expect(interp.Interpret()).To(Succeed())
+		Expect(interp.GlobalScope()["res"]).To(Equal(8))
+	})
+
+	It("parses integer modes", func() {
+		mode, err := interpreter.ParseIntegerMode("BIG")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mode).To(Equal(interpreter.BigInt))
+
+		_, err = interpreter.ParseIntegerMode("8")
+		Expect(err).To(MatchError(`unknown integer mode "8": expected 16, 32, 64 or big`))
+	})
+
+	Describe("hooks", func() {
+		It("notifies the hook around each statement", func() {
+			program := &parser.CompoundNode{
+				Children: []parser.ASTNode{
+					&parser.AssignNode{
+						Left:  &parser.VarNode{Value: "a"},
+						Right: &parser.NumNode{Value: 1},
+						Pos:   lexer.Position{Line: 2, Column: 3},
+					},
+				},
+				Pos: lexer.Position{Line: 1, Column: 1},
+			}
+			pars := new(interpreterfakes.FakeProgrammer)
+			pars.ProgramReturns(program, nil)
+
+			hook := &recordingHook{}
+			interp := interpreter.NewInterpreter(pars, interpreter.WithHook(hook))
+			Expect(interp.Interpret()).To(Succeed())
+
+			Expect(hook.events).To(Equal([]string{
+				"before 1:1", "before 2:3", "after", "after",
+			}))
+		})
+	})
+
+	Describe("tracing and profiling", func() {
+		var program *parser.CompoundNode
+
+		BeforeEach(func() {
+			program = &parser.CompoundNode{
+				Children: []parser.ASTNode{
+					&parser.AssignNode{
+						Left:  &parser.VarNode{Value: "a"},
+						Right: &parser.NumNode{Value: 0},
+						Pos:   lexer.Position{Line: 2, Column: 3},
+					},
+					&parser.AssignNode{
+						Left:  &parser.VarNode{Value: "b"},
+						Right: &parser.VarNode{Value: "nope"},
+						Pos:   lexer.Position{Line: 3, Column: 3},
+					},
+				},
+				Pos: lexer.Position{Line: 1, Column: 1},
+			}
+		})
+
+		It("writes a JSON line for each statement, assignment and error", func() {
+			pars := new(interpreterfakes.FakeProgrammer)
+			pars.ProgramReturns(program, nil)
+			out := new(bytes.Buffer)
+
+			interp := interpreter.NewInterpreter(pars, interpreter.WithTracer(out))
+			Expect(interp.Interpret()).NotTo(Succeed())
+
+			Expect(strings.Split(strings.TrimSpace(out.String()), "\n")).To(Equal([]string{
+				`{"event":"statement","kind":"compound","line":1,"column":1,"depth":0}`,
+				`{"event":"statement","kind":"assign","line":2,"column":3,"depth":1}`,
+				`{"event":"assign","kind":"assign","line":2,"column":3,"depth":1,"name":"a","value":0}`,
+				`{"event":"statement","kind":"assign","line":3,"column":3,"depth":1}`,
+				`{"event":"error","kind":"assign","line":3,"column":3,"depth":1,"error":"unknown var \"nope\""}`,
+				`{"event":"error","kind":"compound","line":1,"column":1,"depth":0,"error":"unknown var \"nope\""}`,
+			}))
+		})
+
+		It("writes the calls of functions in expressions", func() {
+			src := "FUNCTION Two: INTEGER;\nBEGIN\n  Two := 2\nEND;\nBEGIN\n  a := Two + 1\nEND."
+			out := new(bytes.Buffer)
+
+			interp := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(src))), interpreter.WithTracer(out))
+			Expect(interp.Interpret()).To(Succeed())
+
+			Expect(strings.Split(strings.TrimSpace(out.String()), "\n")).To(Equal([]string{
+				`{"event":"statement","kind":"compound","line":5,"column":1,"depth":0}`,
+				`{"event":"statement","kind":"assign","line":6,"column":3,"depth":1}`,
+				`{"event":"call","kind":"call","line":6,"column":8,"depth":2,"name":"Two"}`,
+				`{"event":"statement","kind":"compound","line":2,"column":1,"depth":2}`,
+				`{"event":"statement","kind":"assign","line":3,"column":3,"depth":3}`,
+				`{"event":"assign","kind":"assign","line":3,"column":3,"depth":3,"name":"Two","value":2}`,
+				`{"event":"return","kind":"call","line":6,"column":8,"depth":2,"name":"Two"}`,
+				`{"event":"assign","kind":"assign","line":6,"column":3,"depth":1,"name":"a","value":3}`,
+			}))
+		})
+
+		It("traces the element assigned without evaluating its index again", func() {
+			src := "VAR a: ARRAY [1..3] OF INTEGER; n: INTEGER;\nFUNCTION Next: INTEGER;\nBEGIN\n  n := n + 1;\n  Next := n\nEND;\nBEGIN\n  n := 0;\n  a[Next] := 7\nEND."
+			out := new(bytes.Buffer)
+
+			interp := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(src))), interpreter.WithTracer(out))
+			Expect(interp.Interpret()).To(Succeed())
+
+			Expect(interp.GlobalScope()).To(HaveKeyWithValue("n", 1))
+			Expect(out.String()).To(ContainSubstring(`{"event":"assign","kind":"assign","line":9,"column":3,"depth":1,"name":"a[1]","value":7}`))
+		})
+
+		It("runs the program to the end when the trace cannot be written", func() {
+			program.Children = program.Children[:1]
+			pars := new(interpreterfakes.FakeProgrammer)
+			pars.ProgramReturns(program, nil)
+
+			interp := interpreter.NewInterpreter(pars, interpreter.WithTracer(failingWriter{}))
+			Expect(interp.Interpret()).To(Succeed())
+			Expect(interp.GlobalScope()).To(HaveKeyWithValue("a", 0))
+		})
+
+		It("counts executions per line and procedure", func() {
+			program.Children = program.Children[:1]
+			pars := new(interpreterfakes.FakeProgrammer)
+			pars.ProgramReturns(program, nil)
+			profiler := interpreter.NewProfiler()
+
+			interp := interpreter.NewInterpreter(pars, interpreter.WithProfiler(profiler))
+			Expect(interp.Interpret()).To(Succeed())
+
+			lines := profiler.Lines()
+			Expect(lines).To(HaveLen(2))
+			Expect(lines[0].Line).To(Equal(1))
+			Expect(lines[0].Count).To(Equal(1))
+			Expect(lines[1].Line).To(Equal(2))
+			Expect(lines[1].Count).To(Equal(1))
+			Expect(lines[0].Time).To(BeNumerically(">=", lines[1].Time))
+
+			procs := profiler.Procedures()
+			Expect(procs).To(HaveLen(1))
+			Expect(procs[0].Name).To(Equal(interpreter.MainProgram))
+			Expect(procs[0].Count).To(Equal(1))
+
+			report := new(bytes.Buffer)
+			profiler.Report(report)
+			Expect(report.String()).To(ContainSubstring(interpreter.MainProgram))
+		})
+
+		It("counts the time of recursive calls once", func() {
+			src := "FUNCTION F(n: INTEGER): INTEGER;\nBEGIN\n  CASE n OF 0: F := 0 ELSE F := F(n - 1) + 1 END\nEND;\nBEGIN\n  a := F(200)\nEND."
+			profiler := interpreter.NewProfiler()
+
+			interp := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(src))), interpreter.WithProfiler(profiler))
+			Expect(interp.Interpret()).To(Succeed())
+
+			procs := profiler.Procedures()
+			Expect(procs).To(HaveLen(2))
+			Expect(procs[0].Name).To(Equal(interpreter.MainProgram))
+			Expect(procs[1].Name).To(Equal("F"))
+			Expect(procs[1].Count).To(Equal(201))
+			Expect(procs[1].Time).To(BeNumerically("<=", procs[0].Time))
+
+			for _, line := range profiler.Lines() {
+				Expect(line.Time).To(BeNumerically("<=", procs[0].Time))
+			}
+		})
+
+		It("tells apart lines and procedures of the same name in units", func() {
+			unit, err := parser.NewParser(lexer.NewTokeniser(strings.NewReader(`UNIT Steps;
+INTERFACE
+VAR n: INTEGER;
+PROCEDURE Step;
+IMPLEMENTATION
+PROCEDURE Step;
+BEGIN
+  n := n + 1
+END;
+END.`))).Unit()
+			Expect(err).NotTo(HaveOccurred())
+
+			src := `USES Steps;
+VAR m: INTEGER;
+PROCEDURE Outer;
+  PROCEDURE Step;
+  BEGIN m := m + 1 END;
+BEGIN
+  Step
+END;
+BEGIN
+  n := 0; m := 0;
+  Outer;
+  Step
+END.`
+			profiler := interpreter.NewProfiler()
+			interp := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(src))),
+				interpreter.WithUnits(unit), interpreter.WithProfiler(profiler))
+			Expect(interp.Interpret()).To(Succeed())
+
+			lines := []string{}
+			for _, line := range profiler.Lines() {
+				lines = append(lines, fmt.Sprintf("%s x%d", line, line.Count))
+			}
+			Expect(lines).To(Equal([]string{"5 x2", "6 x1", "7 x1", "9 x1", "10 x2", "11 x1", "12 x1", "Steps:7 x1", "Steps:8 x1"}))
+
+			names := []string{}
+			for _, proc := range profiler.Procedures() {
+				names = append(names, proc.Name)
+			}
+			Expect(names).To(Equal([]string{interpreter.MainProgram, "Outer", "Outer.Step", "Steps.Step"}))
+		})
+	})
 })
+
+type recordingHook struct {
+	events []string
+}
+
+func (h *recordingHook) BeforeStatement(node parser.ASTNode, pos lexer.Position) error {
+	h.events = append(h.events, "before "+pos.String())
+	return nil
+}
+
+func (h *recordingHook) AfterStatement(node parser.ASTNode, err error) {
+	h.events = append(h.events, "after")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func bigInt(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 10)
+
+	return n
+}
@@ -0,0 +1,202 @@
+package interpreter
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// MainProgram names the outermost block in profiles
+const MainProgram = "<program>"
+
+// Stats are the execution count and cumulative time of a line or procedure.
+// Time includes any nested statements. Time spent in a recursive call, or
+// in a line run again by one, is counted once, by the outermost.
+type Stats struct {
+	Count int
+	Time  time.Duration
+}
+
+// LineStats are the statistics of a line of the unit Unit, or of the main
+// program if Unit is empty
+type LineStats struct {
+	Unit string
+	Line int
+	Stats
+}
+
+// ProcedureStats are the statistics of a procedure, named after the
+// procedures and unit it is declared in, such as Unit.Outer.Inner
+type ProcedureStats struct {
+	Name string
+	Stats
+}
+
+// lineKey tells apart lines of the same number in different units
+type lineKey struct {
+	unit string
+	line int
+}
+
+// Profiler accumulates per-line and per-procedure statistics
+type Profiler struct {
+	lines      map[lineKey]*Stats
+	procedures map[string]*Stats
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{
+		lines:      map[lineKey]*Stats{},
+		procedures: map[string]*Stats{},
+	}
+}
+
+// WithProfiler records statistics for each statement in p
+func WithProfiler(p *Profiler) Option {
+	return func(i *Interpreter) {
+		i.hooks = append(i.hooks, &profileHook{
+			profiler:    p,
+			interp:      i,
+			activeLines: map[lineKey]int{},
+			activeProcs: map[string]int{},
+		})
+	}
+}
+
+// profileHook asks the interpreter which unit each statement is in, and
+// which procedure each call is of, as the hook is told neither
+type profileHook struct {
+	profiler *Profiler
+	interp   *Interpreter
+	lines    []lineKey
+	starts   []time.Time
+	procs    []string
+	calls    []time.Time
+
+	// how many executions of each line and calls of each procedure are
+	// in progress
+	activeLines map[lineKey]int
+	activeProcs map[string]int
+}
+
+func (h *profileHook) BeforeStatement(node parser.ASTNode, pos lexer.Position) error {
+	line := lineKey{unit: unitOf(h.interp.frame), line: pos.Line}
+	h.lines = append(h.lines, line)
+	h.starts = append(h.starts, time.Now())
+	h.activeLines[line]++
+
+	return nil
+}
+
+func (h *profileHook) AfterStatement(node parser.ASTNode, err error) {
+	line := h.lines[len(h.lines)-1]
+	elapsed := time.Since(h.starts[len(h.starts)-1])
+	h.lines = h.lines[:len(h.lines)-1]
+	h.starts = h.starts[:len(h.starts)-1]
+
+	h.activeLines[line]--
+	if h.activeLines[line] > 0 {
+		elapsed = 0
+	}
+
+	h.profiler.addLine(line, elapsed)
+	if len(h.starts) == 0 {
+		h.profiler.addProcedure(MainProgram, elapsed)
+	}
+}
+
+func (h *profileHook) BeforeCall(name string, pos lexer.Position) {
+	calls := h.interp.calls
+	name = calls[len(calls)-1].proc.qualifiedName()
+	h.procs = append(h.procs, name)
+	h.calls = append(h.calls, time.Now())
+	h.activeProcs[name]++
+}
+
+func (h *profileHook) AfterCall(_ string, err error) {
+	name := h.procs[len(h.procs)-1]
+	elapsed := time.Since(h.calls[len(h.calls)-1])
+	h.procs = h.procs[:len(h.procs)-1]
+	h.calls = h.calls[:len(h.calls)-1]
+
+	h.activeProcs[name]--
+	if h.activeProcs[name] > 0 {
+		elapsed = 0
+	}
+
+	h.profiler.addProcedure(name, elapsed)
+}
+
+func (p *Profiler) addLine(line lineKey, elapsed time.Duration) {
+	s, ok := p.lines[line]
+	if !ok {
+		s = &Stats{}
+		p.lines[line] = s
+	}
+	s.Count++
+	s.Time += elapsed
+}
+
+func (p *Profiler) addProcedure(name string, elapsed time.Duration) {
+	s, ok := p.procedures[name]
+	if !ok {
+		s = &Stats{}
+		p.procedures[name] = s
+	}
+	s.Count++
+	s.Time += elapsed
+}
+
+// Lines returns the statistics for each executed line, those of the main
+// program first and then those of each unit by name, in line order
+func (p *Profiler) Lines() []LineStats {
+	lines := []LineStats{}
+	for key, s := range p.lines {
+		lines = append(lines, LineStats{Unit: key.unit, Line: key.line, Stats: *s})
+	}
+	sort.Slice(lines, func(a, b int) bool {
+		if lines[a].Unit != lines[b].Unit {
+			return lines[a].Unit < lines[b].Unit
+		}
+		return lines[a].Line < lines[b].Line
+	})
+
+	return lines
+}
+
+// String gives the line as Unit:Line, or just its number in the program
+func (l LineStats) String() string {
+	if l.Unit == "" {
+		return fmt.Sprint(l.Line)
+	}
+
+	return fmt.Sprintf("%s:%d", l.Unit, l.Line)
+}
+
+// Procedures returns the statistics for each called procedure, by name
+func (p *Profiler) Procedures() []ProcedureStats {
+	procs := []ProcedureStats{}
+	for name, s := range p.procedures {
+		procs = append(procs, ProcedureStats{Name: name, Stats: *s})
+	}
+	sort.Slice(procs, func(a, b int) bool { return procs[a].Name < procs[b].Name })
+
+	return procs
+}
+
+// Report writes the statistics as a plain text table
+func (p *Profiler) Report(w io.Writer) {
+	fmt.Fprintf(w, "%-20s %8s %14s\n", "line", "count", "time")
+	for _, l := range p.Lines() {
+		fmt.Fprintf(w, "%-20s %8d %14s\n", l, l.Count, l.Time)
+	}
+
+	fmt.Fprintf(w, "\n%-20s %8s %14s\n", "procedure", "calls", "time")
+	for _, proc := range p.Procedures() {
+		fmt.Fprintf(w, "%-20s %8d %14s\n", proc.Name, proc.Count, proc.Time)
+	}
+}
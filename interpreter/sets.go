@@ -0,0 +1,293 @@
+package interpreter
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// maxSetElement is the largest ordinal a set can hold, as in Turbo Pascal
+const maxSetElement = 255
+
+// Set is a SET value, stored as a bitset of the ordinals of its members.
+// Elem is the enumeration of the members, or nil for INTEGER members and for
+// an empty set literal.
+type Set struct {
+	Elem *Enum
+	bits [(maxSetElement + 1) / 64]uint64
+}
+
+// Contains reports whether the ordinal n is a member of the set
+func (s Set) Contains(n int) bool {
+	if n < 0 || n > maxSetElement {
+		return false
+	}
+
+	return s.bits[n/64]&(1<<(uint(n)%64)) != 0
+}
+
+// Members returns the ordinals of the members in ascending order
+func (s Set) Members() []int {
+	members := []int{}
+	for w, word := range s.bits {
+		for word != 0 {
+			b := bits.TrailingZeros64(word)
+			members = append(members, w*64+b)
+			word &^= 1 << uint(b)
+		}
+	}
+
+	return members
+}
+
+func (s *Set) add(n int) {
+	s.bits[n/64] |= 1 << (uint(n) % 64)
+}
+
+// typed is false for a set which could be of any type, such as [], whose
+// members say nothing about its type
+func (s Set) typed() bool {
+	return s.Elem != nil || s.bits != [len(s.bits)]uint64{}
+}
+
+// compatible reports whether two sets can be combined or compared, which
+// they can unless they have members of different types
+func (s Set) compatible(other Set) bool {
+	return s.Elem == other.Elem || !s.typed() || !other.typed()
+}
+
+func (s Set) String() string {
+	name := func(n int) string {
+		if s.Elem != nil {
+			return s.Elem.Values[n]
+		}
+		return fmt.Sprint(n)
+	}
+
+	parts := []string{}
+	members := s.Members()
+	for i := 0; i < len(members); {
+		j := i
+		for j+1 < len(members) && members[j+1] == members[j]+1 {
+			j++
+		}
+
+		if j-i >= 2 {
+			parts = append(parts, name(members[i])+".."+name(members[j]))
+		} else {
+			for k := i; k <= j; k++ {
+				parts = append(parts, name(members[k]))
+			}
+		}
+		i = j + 1
+	}
+
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// MarshalText gives the set in Pascal syntax, for traces
+func (s Set) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (i *Interpreter) VisitSet(node *parser.SetNode) (interface{}, error) {
+	s := Set{}
+
+	// the type of the members so far, which every member must share
+	elemType := ""
+	for _, elem := range node.Elements {
+		low, err := elem.Low.Accept(i)
+		if err != nil {
+			return nil, err
+		}
+
+		high := low
+		if elem.High != nil {
+			if high, err = elem.High.Accept(i); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, v := range []interface{}{low, high} {
+			if elemType == "" {
+				elemType = typeName(v)
+			} else if typeName(v) != elemType {
+				return nil, errorAt(elem.Pos, "set member %v of type %s in a set of %s", v, typeName(v), elemType)
+			}
+
+			if e, ok := v.(EnumValue); ok {
+				s.Elem = e.Type
+			}
+		}
+
+		from, err := ordinal(low)
+		if err != nil {
+			return nil, positioned(elem.Pos, fmt.Errorf("set member: %w", err))
+		}
+
+		to, err := ordinal(high)
+		if err != nil {
+			return nil, positioned(elem.Pos, fmt.Errorf("set member: %w", err))
+		}
+
+		for _, n := range []int{from, to} {
+			if n < 0 || n > maxSetElement {
+				return nil, errorAt(elem.Pos, "set member %d is out of range 0..%d", n, maxSetElement)
+			}
+		}
+		for n := from; n <= to; n++ {
+			s.add(n)
+		}
+	}
+
+	return s, nil
+}
+
+// setOperation applies +, * or - to two sets
+func setOperation(op lexer.TokenType, left, right Set) (interface{}, error) {
+	if !left.compatible(right) {
+		return nil, fmt.Errorf("cannot combine %s with %s", setName(left), setName(right))
+	}
+
+	result := Set{Elem: left.Elem}
+	if result.Elem == nil {
+		result.Elem = right.Elem
+	}
+
+	for w := range result.bits {
+		switch op {
+		case lexer.Plus:
+			result.bits[w] = left.bits[w] | right.bits[w]
+		case lexer.Mult:
+			result.bits[w] = left.bits[w] & right.bits[w]
+		case lexer.Minus:
+			result.bits[w] = left.bits[w] &^ right.bits[w]
+		default:
+			return nil, fmt.Errorf("%s is not defined on sets", op)
+		}
+	}
+
+	return result, nil
+}
+
+func (s Set) subsetOf(other Set) bool {
+	for w := range s.bits {
+		if s.bits[w]&^other.bits[w] != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// relation applies a relational operator, giving a BOOLEAN
+func relation(op lexer.TokenType, left, right interface{}) (interface{}, error) {
+	if op == lexer.In {
+		s, ok := right.(Set)
+		if !ok {
+			return nil, fmt.Errorf("IN needs a set on the right, got %s", typeName(right))
+		}
+
+		n, err := ordinal(left)
+		if err != nil {
+			return nil, fmt.Errorf("IN: %w", err)
+		}
+
+		if e, _ := left.(EnumValue); s.typed() && e.Type != s.Elem {
+			return nil, fmt.Errorf("cannot test for %s in %s", typeName(left), setName(s))
+		}
+
+		return s.Contains(n), nil
+	}
+
+	ls, lok := left.(Set)
+	rs, rok := right.(Set)
+	if lok || rok {
+		if !lok || !rok {
+			return nil, fmt.Errorf("cannot compare %s with %s", typeName(left), typeName(right))
+		}
+		if !ls.compatible(rs) {
+			return nil, fmt.Errorf("cannot compare %s with %s", setName(ls), setName(rs))
+		}
+
+		switch op {
+		case lexer.Equal:
+			return ls.bits == rs.bits, nil
+		case lexer.NotEqual:
+			return ls.bits != rs.bits, nil
+		case lexer.LessEqual:
+			return ls.subsetOf(rs), nil
+		case lexer.GreaterEqual:
+			return rs.subsetOf(ls), nil
+		}
+
+		return nil, fmt.Errorf("%s is not defined on sets", op)
+	}
+
+	cmp, err := compare(left, right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case lexer.Equal:
+		return cmp == 0, nil
+	case lexer.NotEqual:
+		return cmp != 0, nil
+	case lexer.Less:
+		return cmp < 0, nil
+	case lexer.LessEqual:
+		return cmp <= 0, nil
+	case lexer.Greater:
+		return cmp > 0, nil
+	case lexer.GreaterEqual:
+		return cmp >= 0, nil
+	}
+
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// compare orders two numbers, or two ordinals of the same type
+func compare(left, right interface{}) (int, error) {
+	if isInteger(left) && isInteger(right) {
+		return toBig(left).Cmp(toBig(right)), nil
+	}
+
+	lf, lerr := real(left)
+	rf, rerr := real(right)
+	if lerr == nil && rerr == nil {
+		switch {
+		case lf < rf:
+			return -1, nil
+		case lf > rf:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	if typeName(left) != typeName(right) {
+		return 0, fmt.Errorf("cannot compare %s with %s", typeName(left), typeName(right))
+	}
+
+	l, err := ordinal(left)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := ordinal(right)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case l < r:
+		return -1, nil
+	case l > r:
+		return 1, nil
+	}
+
+	return 0, nil
+}
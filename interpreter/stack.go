@@ -0,0 +1,203 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+)
+
+// DefaultMaxDepth is the number of nested procedure and function calls
+// allowed unless WithMaxDepth says otherwise
+const DefaultMaxDepth = 10000
+
+// MaxDepthLimit bounds the depth which can be configured
+const MaxDepthLimit = 100000
+
+// maxNesting bounds the statements, expressions and calls in progress
+// together, so that a recursion is reported as a stack overflow before it
+// exhausts the stack of the interpreter itself, however deeply its calls
+// are nested in statements and expressions. A level takes a few hundred
+// bytes of the 1GB Go stack.
+const maxNesting = 500000
+
+// tracedFrames is how many frames are shown at each end of a long trace
+const tracedFrames = 50
+
+// WithMaxDepth sets the number of nested calls allowed before a stack
+// overflow. Depths outside 1..MaxDepthLimit are clamped to that range. A
+// stack overflow is also reported when the calls and the statements and
+// expressions they are executing are nested too deeply, whatever the depth
+// allowed.
+// Calls in tail position are not eliminated: each one counts towards the
+// depth and appears in stack traces, like any other call.
+func WithMaxDepth(depth int) Option {
+	return func(i *Interpreter) {
+		if depth < 1 {
+			depth = 1
+		}
+		if depth > MaxDepthLimit {
+			depth = MaxDepthLimit
+		}
+		i.maxDepth = depth
+	}
+}
+
+// Arg is the value an argument of a call had when the call was made
+type Arg struct {
+	Name  string
+	Value interface{}
+}
+
+func (a Arg) String() string {
+	if a.Value == nil {
+		return a.Name + ": ?"
+	}
+
+	return fmt.Sprintf("%s: %v", a.Name, a.Value)
+}
+
+// Call is a call of a procedure or function which had not returned. Pos
+// is where it was called from.
+type Call struct {
+	Name string
+	Pos  lexer.Position
+	Args []Arg
+}
+
+func (c Call) String() string {
+	args := make([]string, len(c.Args))
+	for n, arg := range c.Args {
+		args[n] = arg.String()
+	}
+
+	return fmt.Sprintf("%s(%s)", c.Name, strings.Join(args, ", "))
+}
+
+// activation is a call in progress, with the values of its arguments
+type activation struct {
+	proc *procedure
+	pos  lexer.Position
+	args []Arg
+}
+
+// StackError is a runtime error raised inside a procedure or function,
+// with the calls which had not returned when it happened, innermost first
+type StackError struct {
+	Err   error
+	Stack []Call
+}
+
+func (e *StackError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StackError) Unwrap() error {
+	return e.Err
+}
+
+// StackTrace lists the calls like a Go panic does: each call with the
+// values of its arguments, followed by the position it had reached. Only
+// the innermost and outermost frames of a deep stack are shown.
+func (e *StackError) StackTrace() string {
+	var b strings.Builder
+
+	var runtimeErr *Error
+	var pos *lexer.Position
+	if errors.As(e.Err, &runtimeErr) {
+		pos = &runtimeErr.Pos
+	}
+
+	frame := func(name string, pos *lexer.Position) {
+		b.WriteString(name + "\n")
+		if pos != nil {
+			fmt.Fprintf(&b, "\tat %s\n", pos)
+		}
+	}
+
+	for n, call := range e.Stack {
+		if n == tracedFrames && len(e.Stack) > 2*tracedFrames {
+			fmt.Fprintf(&b, "...%d frames elided...\n", len(e.Stack)-2*tracedFrames)
+		}
+		if n < tracedFrames || n >= len(e.Stack)-tracedFrames {
+			frame(call.String(), pos)
+		}
+		pos = &e.Stack[n].Pos
+	}
+	frame(MainProgram, pos)
+
+	return b.String()
+}
+
+// push records a call, failing with a stack overflow if there are already
+// too many
+func (i *Interpreter) push(p *procedure, f *frame, pos lexer.Position) error {
+	if len(i.calls) == i.maxDepth {
+		return i.traced(errorAt(pos, "stack overflow: more than %d nested calls", i.maxDepth))
+	}
+	if err := i.nest(pos); err != nil {
+		return err
+	}
+	i.calls = append(i.calls, activation{proc: p, pos: pos, args: args(p, f)})
+
+	return nil
+}
+
+func (i *Interpreter) pop() {
+	i.calls = i.calls[:len(i.calls)-1]
+	i.nesting--
+}
+
+// nest counts a statement, expression or call starting, failing with a
+// stack overflow if too many are in progress
+func (i *Interpreter) nest(pos lexer.Position) error {
+	if i.nesting == maxNesting {
+		return i.traced(errorAt(pos, "stack overflow: more than %d nested statements, expressions and calls", maxNesting))
+	}
+	i.nesting++
+
+	return nil
+}
+
+// traced attaches the calls in progress to an error raised by the
+// innermost of them; an error already traced is passed on as it is
+func (i *Interpreter) traced(err error) error {
+	var stackErr *StackError
+	if err == nil || errors.As(err, &stackErr) {
+		return err
+	}
+
+	return &StackError{Err: err, Stack: i.Calls()}
+}
+
+// args records the values of the parameters of a call as it starts. They
+// are copied, as a VAR parameter or the parts of a record or array can be
+// changed by the call.
+func args(p *procedure, f *frame) []Arg {
+	var args []Arg
+	for _, group := range p.decl.Params {
+		for _, v := range group.Names {
+			name := strings.ToLower(v.Value)
+			val := f.vars[name]
+			if c, ok := f.refs[name]; ok {
+				val = c.load()
+			}
+			args = append(args, Arg{Name: v.Value, Value: copyValue(val)})
+		}
+	}
+
+	return args
+}
+
+// Calls returns the calls in progress, innermost first, with the values of
+// their arguments
+func (i *Interpreter) Calls() []Call {
+	calls := []Call{}
+	for n := len(i.calls) - 1; n >= 0; n-- {
+		a := i.calls[n]
+		calls = append(calls, Call{Name: a.proc.decl.Name, Pos: a.pos, Args: a.args})
+	}
+
+	return calls
+}
@@ -0,0 +1,146 @@
+package interpreter
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// TraceEvent is written as a JSON line for every statement executed, and
+// again with the value when an assignment completes. Calls of procedures
+// and functions, including functions called in expressions, are written
+// as a call event when they start and a return event when they finish.
+type TraceEvent struct {
+	Event  string      `json:"event"`
+	Kind   string      `json:"kind"`
+	Line   int         `json:"line"`
+	Column int         `json:"column"`
+	Depth  int         `json:"depth"`
+	Name   string      `json:"name,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// WithTracer writes a TraceEvent to w as each statement executes
+func WithTracer(w io.Writer) Option {
+	return func(i *Interpreter) {
+		i.hooks = append(i.hooks, &tracer{enc: json.NewEncoder(w)})
+	}
+}
+
+type tracer struct {
+	enc   *json.Encoder
+	stack []lexer.Position
+	calls []lexer.Position
+
+	// the variable and value stored by the assignment finishing
+	assigned string
+	value    interface{}
+}
+
+func (t *tracer) BeforeStatement(node parser.ASTNode, pos lexer.Position) error {
+	t.stack = append(t.stack, pos)
+
+	// a failed trace write must not change the outcome of the program
+	_ = t.enc.Encode(TraceEvent{
+		Event:  "statement",
+		Kind:   NodeKind(node),
+		Line:   pos.Line,
+		Column: pos.Column,
+		Depth:  len(t.stack) - 1,
+	})
+
+	return nil
+}
+
+func (t *tracer) AfterStatement(node parser.ASTNode, err error) {
+	pos := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+
+	event := TraceEvent{
+		Kind:   NodeKind(node),
+		Line:   pos.Line,
+		Column: pos.Column,
+		Depth:  len(t.stack),
+	}
+
+	switch {
+	case err != nil:
+		event.Event = "error"
+		event.Error = err.Error()
+	case isAssign(node):
+		event.Event = "assign"
+		event.Name = t.assigned
+		event.Value = t.value
+	default:
+		return
+	}
+
+	_ = t.enc.Encode(event)
+}
+
+// AfterAssign is told what an assignment stored, so that the target is not
+// evaluated again, which could call functions a second time
+func (t *tracer) AfterAssign(name string, value interface{}) {
+	t.assigned = name
+	t.value = value
+}
+
+func (t *tracer) BeforeCall(name string, pos lexer.Position) {
+	t.calls = append(t.calls, pos)
+
+	_ = t.enc.Encode(TraceEvent{
+		Event:  "call",
+		Kind:   "call",
+		Line:   pos.Line,
+		Column: pos.Column,
+		Depth:  len(t.stack),
+		Name:   name,
+	})
+}
+
+func (t *tracer) AfterCall(name string, err error) {
+	pos := t.calls[len(t.calls)-1]
+	t.calls = t.calls[:len(t.calls)-1]
+
+	event := TraceEvent{
+		Event:  "return",
+		Kind:   "call",
+		Line:   pos.Line,
+		Column: pos.Column,
+		Depth:  len(t.stack),
+		Name:   name,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	_ = t.enc.Encode(event)
+}
+
+func isAssign(node parser.ASTNode) bool {
+	_, ok := node.(*parser.AssignNode)
+	return ok
+}
+
+// NodeKind is a short name for the kind of a statement node
+func NodeKind(node parser.ASTNode) string {
+	switch node.(type) {
+	case *parser.CompoundNode:
+		return "compound"
+	case *parser.AssignNode:
+		return "assign"
+	case *parser.NoOpNode:
+		return "noop"
+	case *parser.CaseNode:
+		return "case"
+	case *parser.ForNode:
+		return "for"
+	case *parser.ProcCallNode:
+		return "call"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,393 @@
+package interpreter
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// Enum is an enumerated type. Name is empty for an anonymous type, such as
+// one declared directly in a VAR section.
+type Enum struct {
+	Name   string
+	Values []string
+}
+
+// EnumValue is a value of an enumerated type
+type EnumValue struct {
+	Type *Enum
+	Ord  int
+}
+
+func (v EnumValue) String() string {
+	return v.Type.Values[v.Ord]
+}
+
+// MarshalText gives the name of the value, for traces
+func (v EnumValue) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// basic is one of the predeclared types
+type basic string
+
+const (
+	integerType basic = "INTEGER"
+	realType    basic = "REAL"
+	booleanType basic = "BOOLEAN"
+)
+
+// setType is a SET OF an ordinal type
+type setType struct {
+	elem interface{}
+}
+
+// subrange is an inclusive range of ordinal values
+type subrange struct {
+	low  interface{}
+	high interface{}
+}
+
+func (i *Interpreter) VisitTypeDecl(node *parser.TypeDeclNode) (interface{}, error) {
+	t, err := i.resolveType(node.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	if e, ok := t.(*Enum); ok && e.Name == "" {
+		e.Name = node.Name
+	}
+	if r, ok := t.(*RecordType); ok && r.Name == "" {
+		r.Name = node.Name
+	}
+	i.frame.types[strings.ToLower(node.Name)] = t
+
+	return nil, nil
+}
+
+func (i *Interpreter) VisitVarDecl(node *parser.VarDeclNode) (interface{}, error) {
+	t, err := i.resolveType(node.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range node.Names {
+		i.frame.varTypes[strings.ToLower(v.Value)] = t
+		if zero := zeroValue(t); zero != nil {
+			i.frame.vars[strings.ToLower(v.Value)] = zero
+		}
+	}
+
+	return nil, nil
+}
+
+func (i *Interpreter) resolveType(spec parser.TypeSpec) (interface{}, error) {
+	switch t := spec.(type) {
+	case *parser.NamedType:
+		name := strings.ToUpper(t.Name)
+		for _, b := range []basic{integerType, realType, booleanType} {
+			if name == string(b) {
+				return b, nil
+			}
+		}
+
+		if f, ok := i.resolve(strings.ToLower(t.Name)); ok {
+			if found, ok := f.types[strings.ToLower(t.Name)]; ok {
+				return found, nil
+			}
+		}
+
+		return nil, fmt.Errorf("unknown type %q", t.Name)
+
+	case *parser.EnumType:
+		e := &Enum{}
+		for n, v := range t.Values {
+			e.Values = append(e.Values, v.Name)
+			i.frame.consts[strings.ToLower(v.Name)] = EnumValue{Type: e, Ord: n}
+		}
+
+		return e, nil
+
+	case *parser.SubrangeType:
+		low, err := t.Low.Accept(i)
+		if err != nil {
+			return nil, err
+		}
+
+		high, err := t.High.Accept(i)
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := ordinal(low)
+		if err != nil {
+			return nil, fmt.Errorf("subrange bound: %w", err)
+		}
+
+		h, err := ordinal(high)
+		if err != nil {
+			return nil, fmt.Errorf("subrange bound: %w", err)
+		}
+
+		if l > h {
+			return nil, fmt.Errorf("subrange %v..%v is empty", low, high)
+		}
+
+		return &subrange{low: low, high: high}, nil
+
+	case *parser.SetType:
+		elem, err := i.resolveType(t.Elem)
+		if err != nil {
+			return nil, err
+		}
+
+		return &setType{elem: elem}, nil
+
+	case *parser.PointerType:
+		return &pointerType{name: t.Name}, nil
+
+	case *parser.RecordType:
+		r := &RecordType{}
+		for _, decl := range t.Fields {
+			ft, err := i.resolveType(decl.Type)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, f := range decl.Names {
+				r.Fields = append(r.Fields, f.Value)
+				r.types = append(r.types, ft)
+			}
+		}
+
+		return r, nil
+
+	case *parser.ArrayType:
+		return i.arrayType(t)
+	}
+
+	return nil, fmt.Errorf("unknown type %v", spec)
+}
+
+// convert checks that a value assigned to a declared variable belongs to its
+// type, converting INTEGERs assigned to REAL variables. Errors are reported
+// at pos, the position of the assignment.
+func (i *Interpreter) convert(pos lexer.Position, name string, t interface{}, value interface{}) (interface{}, error) {
+	switch t := t.(type) {
+	case basic:
+		if t == realType && isInteger(value) {
+			return real(value)
+		}
+
+		if !belongs(t, value) {
+			return nil, errorAt(pos, "cannot assign %s to %s %q", typeName(value), typeString(t), name)
+		}
+
+	case *Enum:
+		if !belongs(t, value) {
+			return nil, errorAt(pos, "cannot assign %s to %s %q", typeName(value), typeString(t), name)
+		}
+
+	case *setType:
+		s, ok := value.(Set)
+		if !ok {
+			return nil, errorAt(pos, "cannot assign %s to set variable %q", typeName(value), name)
+		}
+
+		if err := t.check(pos, name, s); err != nil {
+			return nil, err
+		}
+
+	case *pointerType:
+		if _, ok := value.(Pointer); !ok {
+			return nil, errorAt(pos, "cannot assign %s to pointer %q", typeName(value), name)
+		}
+
+	case *RecordType:
+		if r, ok := value.(*Record); !ok || r.Type != t {
+			return nil, errorAt(pos, "cannot assign %s to %s %q", typeName(value), typeString(t), name)
+		}
+
+	case *arrayType:
+		if a, ok := value.(*Array); !ok || a.typ != t {
+			return nil, errorAt(pos, "cannot assign %s to %s %q", typeName(value), typeString(t), name)
+		}
+
+	case *subrange:
+		if base := t.base(); !belongs(base, value) {
+			return nil, errorAt(pos, "cannot assign %s to %s %q", typeName(value), typeString(base), name)
+		}
+
+		n, err := ordinal(value)
+		if err != nil {
+			return nil, errorAt(pos, "assigning to %q: %v", name, err)
+		}
+
+		low, _ := ordinal(t.low)
+		high, _ := ordinal(t.high)
+
+		if n < low || n > high {
+			return nil, errorAt(pos, "%v is out of range %v..%v of variable %q", value, t.low, t.high, name)
+		}
+	}
+
+	return value, nil
+}
+
+// check checks that the members of a set assigned to a variable are of the
+// element type, and in its range. An empty set belongs to every set type.
+func (t *setType) check(pos lexer.Position, name string, s Set) error {
+	members := s.Members()
+	if len(members) == 0 {
+		return nil
+	}
+
+	elem := t.elem
+	var low, high interface{} = 0, maxSetElement
+	if r, ok := elem.(*subrange); ok {
+		elem = r.base()
+		low, high = r.low, r.high
+	}
+
+	// the members of a set of INTEGER or BOOLEAN have no enumeration
+	e, _ := elem.(*Enum)
+	if s.Elem != e {
+		return errorAt(pos, "cannot assign %s to %s %q", setName(s), typeString(t), name)
+	}
+	if _, ok := t.elem.(*Enum); ok {
+		low, high = EnumValue{Type: e}, EnumValue{Type: e, Ord: len(e.Values) - 1}
+	}
+	if t.elem == booleanType {
+		low, high = false, true
+	}
+
+	l, _ := ordinal(low)
+	h, _ := ordinal(high)
+	for _, n := range members {
+		if n < l || n > h {
+			member := interface{}(n)
+			if e != nil {
+				member = EnumValue{Type: e, Ord: n}
+			}
+			return errorAt(pos, "%v is out of range %v..%v of variable %q", member, low, high, name)
+		}
+	}
+
+	return nil
+}
+
+// setName names the type of the members of a set
+func setName(s Set) string {
+	if s.Elem != nil {
+		return "SET OF " + typeString(s.Elem)
+	}
+
+	return "SET OF INTEGER"
+}
+
+// belongs reports whether value is of the basic or enumerated type t
+func belongs(t interface{}, value interface{}) bool {
+	switch t {
+	case integerType:
+		return isInteger(value)
+	case realType:
+		_, ok := value.(float64)
+		return ok
+	case booleanType:
+		_, ok := value.(bool)
+		return ok
+	}
+
+	v, ok := value.(EnumValue)
+
+	return ok && v.Type == t
+}
+
+// base is the type of the bounds of a subrange
+func (s *subrange) base() interface{} {
+	switch v := s.low.(type) {
+	case EnumValue:
+		return v.Type
+	case bool:
+		return booleanType
+	}
+
+	return integerType
+}
+
+// ordinalLike returns the value of the same ordinal type as like, with
+// ordinal number n
+func (m IntegerMode) ordinalLike(like interface{}, n int) (interface{}, error) {
+	switch v := like.(type) {
+	case EnumValue:
+		return EnumValue{Type: v.Type, Ord: n}, nil
+	case bool:
+		return n != 0, nil
+	}
+
+	return m.integer(big.NewInt(int64(n)))
+}
+
+func (i *Interpreter) VisitFor(node *parser.ForNode) (interface{}, error) {
+	if err := i.before(node, node.Pos); err != nil {
+		return nil, err
+	}
+
+	err := i.forStatement(node)
+	i.after(node, err)
+
+	return nil, err
+}
+
+func (i *Interpreter) forStatement(node *parser.ForNode) error {
+	start, err := node.Start.Accept(i)
+	if err != nil {
+		return err
+	}
+
+	end, err := node.End.Accept(i)
+	if err != nil {
+		return err
+	}
+
+	from, err := ordinal(start)
+	if err != nil {
+		return fmt.Errorf("for loop start: %w", err)
+	}
+
+	to, err := ordinal(end)
+	if err != nil {
+		return fmt.Errorf("for loop end: %w", err)
+	}
+
+	step := 1
+	if node.Down {
+		step = -1
+	}
+
+	if (to-from)*step < 0 {
+		return nil
+	}
+
+	for n := from; ; n += step {
+		val, err := i.mode.ordinalLike(start, n)
+		if err != nil {
+			return err
+		}
+
+		if err := i.assignTo(node.Var, val, node.Pos); err != nil {
+			return err
+		}
+
+		if _, err := node.Body.Accept(i); err != nil {
+			return err
+		}
+
+		if n == to {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package interpreter
+
+import (
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// WithUnits makes units available to the USES clauses of the program and
+// of each other. A unit's declarations are made when it is first used.
+func WithUnits(units ...*parser.UnitNode) Option {
+	return func(i *Interpreter) {
+		for _, u := range units {
+			i.units[strings.ToLower(u.Name)] = u
+		}
+	}
+}
+
+// uses declares the units named in a USES clause, returning the frames of
+// their INTERFACE sections
+func (i *Interpreter) uses(refs []*parser.UnitRef) ([]*frame, error) {
+	frames := []*frame{}
+	for _, ref := range refs {
+		f, err := i.unit(ref)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, f)
+	}
+
+	return frames, nil
+}
+
+// unit declares a unit the first time it is used. The frame of its
+// IMPLEMENTATION section is nested in that of its INTERFACE section, which
+// holds the procedures declared by the headings of the INTERFACE section.
+func (i *Interpreter) unit(ref *parser.UnitRef) (*frame, error) {
+	key := strings.ToLower(ref.Name)
+	if f, ok := i.unitFrames[key]; ok {
+		return f, nil
+	}
+
+	node, ok := i.units[key]
+	if !ok {
+		return nil, errorAt(ref.Pos, "unit %s has not been loaded", ref.Name)
+	}
+
+	iface := newFrame(node.Name, nil)
+	impl := newFrame(node.Name, iface)
+	iface.unit, impl.unit = node.Name, node.Name
+	i.unitFrames[key] = iface
+
+	var err error
+	if iface.uses, err = i.uses(node.InterfaceUses); err != nil {
+		return nil, err
+	}
+	if impl.uses, err = i.uses(node.ImplUses); err != nil {
+		return nil, err
+	}
+
+	caller := i.frame
+	defer func() { i.frame = caller }()
+
+	i.frame = iface
+	if err := i.section(node.Interface); err != nil {
+		return nil, err
+	}
+
+	i.frame = impl
+	if err := i.section(node.Implementation); err != nil {
+		return nil, err
+	}
+
+	for _, heading := range node.Interface.Procs {
+		name := strings.ToLower(heading.Name)
+		if p, ok := impl.procs[name]; ok {
+			iface.procs[name] = p
+			delete(impl.procs, name)
+		}
+	}
+
+	return iface, nil
+}
+
+// section makes the declarations of a section of a unit in the current
+// frame. Procedure headings are declared by their implementations.
+func (i *Interpreter) section(node *parser.UnitSection) error {
+	for _, decl := range node.Consts {
+		if _, err := decl.Accept(i); err != nil {
+			return err
+		}
+	}
+
+	for _, decl := range node.Types {
+		if _, err := decl.Accept(i); err != nil {
+			return err
+		}
+	}
+
+	for _, decl := range node.Vars {
+		if _, err := decl.Accept(i); err != nil {
+			return err
+		}
+	}
+
+	for _, decl := range node.Procs {
+		if decl.Block == nil {
+			continue
+		}
+		if _, err := decl.Accept(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package interpreter
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// WithOutput sets where Write and WriteLn write, which is standard output
+// by default
+func WithOutput(w io.Writer) Option {
+	return func(i *Interpreter) {
+		i.output = w
+	}
+}
+
+// write runs Write or WriteLn, which write their arguments one after
+// another, WriteLn then ending the line. INTEGER and REAL values are
+// written as numbers, BOOLEAN values as TRUE or FALSE and enumerated values
+// by name.
+func (i *Interpreter) write(node *parser.ProcCallNode, newline bool) error {
+	for _, arg := range node.Args {
+		val, err := arg.Accept(i)
+		if err != nil {
+			return err
+		}
+
+		var s string
+		switch v := val.(type) {
+		case int, *big.Int, float64, EnumValue:
+			s = fmt.Sprint(v)
+		case bool:
+			s = "FALSE"
+			if v {
+				s = "TRUE"
+			}
+		default:
+			return errorAt(node.Pos, "%s cannot write a value of type %s", node.Name, typeName(val))
+		}
+
+		if _, err := io.WriteString(i.output, s); err != nil {
+			return err
+		}
+	}
+
+	if newline {
+		if _, err := io.WriteString(i.output, "\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
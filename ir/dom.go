@@ -0,0 +1,121 @@
+package ir
+
+// Dominators holds the dominator tree of a function. A block dominates
+// another if every way from the entry to the other passes through it.
+type Dominators struct {
+	idom     map[*Block]*Block
+	children map[*Block][]*Block
+	frontier map[*Block][]*Block
+}
+
+// Dominators computes the dominator tree and dominance frontiers, with the
+// algorithm of Cooper, Harvey and Kennedy. It relies on the blocks being
+// numbered in reverse postorder.
+func (f *Func) Dominators() *Dominators {
+	entry := f.Blocks[0]
+	idom := map[*Block]*Block{entry: entry}
+
+	intersect := func(a, b *Block) *Block {
+		for a != b {
+			for a.ID > b.ID {
+				a = idom[a]
+			}
+			for b.ID > a.ID {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks[1:] {
+			var dom *Block
+			for _, p := range b.Preds {
+				if _, done := idom[p]; !done {
+					continue
+				}
+				if dom == nil {
+					dom = p
+				} else {
+					dom = intersect(p, dom)
+				}
+			}
+
+			if idom[b] != dom {
+				idom[b] = dom
+				changed = true
+			}
+		}
+	}
+
+	d := &Dominators{
+		idom:     idom,
+		children: map[*Block][]*Block{},
+		frontier: map[*Block][]*Block{},
+	}
+
+	for _, b := range f.Blocks[1:] {
+		d.children[idom[b]] = append(d.children[idom[b]], b)
+	}
+
+	// b is in the frontier of the blocks which dominate a predecessor of b
+	// but not b itself
+	for _, b := range f.Blocks {
+		if len(b.Preds) < 2 {
+			continue
+		}
+		for _, p := range b.Preds {
+			for runner := p; runner != idom[b]; runner = idom[runner] {
+				if !contains(d.frontier[runner], b) {
+					d.frontier[runner] = append(d.frontier[runner], b)
+				}
+			}
+		}
+	}
+
+	return d
+}
+
+func contains(blocks []*Block, b *Block) bool {
+	for _, c := range blocks {
+		if c == b {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Idom returns the immediate dominator of a block, or nil for the entry
+func (d *Dominators) Idom(b *Block) *Block {
+	if d.idom[b] == b {
+		return nil
+	}
+
+	return d.idom[b]
+}
+
+// Dominates is true if a dominates b. A block dominates itself.
+func (d *Dominators) Dominates(a, b *Block) bool {
+	for {
+		if a == b {
+			return true
+		}
+		if d.idom[b] == b {
+			return false
+		}
+		b = d.idom[b]
+	}
+}
+
+// Children returns the blocks immediately dominated by a block
+func (d *Dominators) Children(b *Block) []*Block {
+	return d.children[b]
+}
+
+// Frontier returns the dominance frontier of a block: the blocks where its
+// dominance ends, because they can also be reached another way
+func (d *Dominators) Frontier(b *Block) []*Block {
+	return d.frontier[b]
+}
@@ -0,0 +1,71 @@
+package ir
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DOT writes the control flow graph of the function in the DOT language
+// of Graphviz
+func (f *Func) DOT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "digraph %s {\n", quote(f.Name))
+	fmt.Fprintln(out, "  node [shape=box, fontname=\"monospace\"];")
+	f.dot(out, "", "  ")
+	fmt.Fprintln(out, "}")
+
+	return out.Flush()
+}
+
+// DOT writes the control flow graphs of the functions of the program in
+// the DOT language of Graphviz, each in a cluster of its own
+func (p *Program) DOT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, "digraph program {")
+	fmt.Fprintln(out, "  node [shape=box, fontname=\"monospace\"];")
+	for n, f := range p.Funcs {
+		fmt.Fprintf(out, "  subgraph cluster_%d {\n", n)
+		fmt.Fprintf(out, "    label = %s;\n", quote(f.Name))
+		f.dot(out, fmt.Sprintf("f%d", n), "    ")
+		fmt.Fprintln(out, "  }")
+	}
+	fmt.Fprintln(out, "}")
+
+	return out.Flush()
+}
+
+// dot writes the blocks and edges of the function, naming the blocks with
+// a prefix to keep them apart from those of other functions
+func (f *Func) dot(out io.Writer, prefix, indent string) {
+	for _, b := range f.Blocks {
+		var label strings.Builder
+		fmt.Fprintf(&label, "%s:\\l", b)
+		for _, in := range b.Instrs {
+			fmt.Fprintf(&label, "  %s\\l", escape(in.String()))
+		}
+		fmt.Fprintf(out, "%s%s%s [label=\"%s\"];\n", indent, prefix, b, label.String())
+	}
+
+	for _, b := range f.Blocks {
+		term := b.Terminator()
+		for n, t := range term.Targets {
+			attrs := ""
+			if term.Op == Branch {
+				attrs = []string{" [label=\"true\"]", " [label=\"false\"]"}[n]
+			}
+			fmt.Fprintf(out, "%s%s%s -> %s%s%s;\n", indent, prefix, b, prefix, t, attrs)
+		}
+	}
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+func quote(s string) string {
+	return `"` + escape(s) + `"`
+}
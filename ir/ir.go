@@ -0,0 +1,304 @@
+// Package ir lowers programs to instructions in basic blocks, as a base
+// for analyses, optimisations and code generators.
+//
+// The IR handles INTEGER and other ordinal values. Variables of the main
+// program are globals, read and written with load and store. Parameters,
+// local variables and temporaries are the virtual registers of a function,
+// which can be converted to SSA form.
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op is the operation of an instruction
+type Op int
+
+const (
+	Copy Op = iota
+	Neg
+	Add
+	Sub
+	Mul
+	Div
+	Mod
+	Eq
+	Ne
+	Lt
+	Le
+	Gt
+	Ge
+	Load
+	Store
+	Call
+	Write
+	WriteLn
+	Phi
+	Jump
+	Branch
+	Return
+)
+
+var binary = map[Op]string{
+	Add: "+",
+	Sub: "-",
+	Mul: "*",
+	Div: "DIV",
+	Mod: "MOD",
+	Eq:  "=",
+	Ne:  "<>",
+	Lt:  "<",
+	Le:  "<=",
+	Gt:  ">",
+	Ge:  ">=",
+}
+
+// IsTerminator is true for the operations which end a block
+func (op Op) IsTerminator() bool {
+	return op == Jump || op == Branch || op == Return
+}
+
+// Value is an operand of an instruction: a *Var or a Const
+type Value interface {
+	String() string
+}
+
+// Const is an integer constant. Comparisons give 1 for true and 0 for
+// false.
+type Const int
+
+func (c Const) String() string {
+	return fmt.Sprint(int(c))
+}
+
+// Var is a virtual register of a function. Temporaries are named %1, %2
+// and so on. In SSA form each assignment defines a new version of the
+// variable it assigns.
+type Var struct {
+	Name    string
+	Version int
+}
+
+func (v *Var) String() string {
+	if v.Version == 0 {
+		return v.Name
+	}
+
+	return fmt.Sprintf("%s.%d", v.Name, v.Version)
+}
+
+// Global is a variable of the main program
+type Global struct {
+	Name string
+}
+
+// Instr is an instruction. Dst is the variable it assigns, if any.
+//
+// Load copies Global to Dst and Store copies Args[0] to Global. Call calls
+// Callee with Args. Write writes Args[0], as a number or as the element of
+// Names it selects if there are Names, and WriteLn ends the line. Phi
+// chooses the Arg for the predecessor control came
+// from, in the order of Block.Preds. Branch goes to Targets[0] if Args[0]
+// is not 0, and to Targets[1] otherwise. Return returns Args[0] from a
+// function.
+type Instr struct {
+	Op      Op
+	Dst     *Var
+	Args    []Value
+	Global  *Global
+	Callee  *Func
+	Names   []string
+	Targets []*Block
+}
+
+func (in *Instr) String() string {
+	var s string
+	switch in.Op {
+	case Copy:
+		s = in.Args[0].String()
+	case Neg:
+		s = "-" + in.Args[0].String()
+	case Load:
+		s = "load " + in.Global.Name
+	case Store:
+		return fmt.Sprintf("store %s, %s", in.Global.Name, in.Args[0])
+	case Call:
+		s = fmt.Sprintf("call %s(%s)", in.Callee.Name, join(in.Args))
+	case Write:
+		if in.Names == nil {
+			return "write " + in.Args[0].String()
+		}
+		return fmt.Sprintf("write %s (%s)", in.Args[0], strings.Join(in.Names, ", "))
+	case WriteLn:
+		return "writeln"
+	case Phi:
+		s = "phi " + join(in.Args)
+	case Jump:
+		return "jump " + in.Targets[0].String()
+	case Branch:
+		return fmt.Sprintf("branch %s, %s, %s", in.Args[0], in.Targets[0], in.Targets[1])
+	case Return:
+		if len(in.Args) == 0 {
+			return "return"
+		}
+		return "return " + in.Args[0].String()
+	default:
+		s = fmt.Sprintf("%s %s %s", in.Args[0], binary[in.Op], in.Args[1])
+	}
+
+	if in.Dst == nil {
+		return s
+	}
+
+	return in.Dst.String() + " = " + s
+}
+
+func join(values []Value) string {
+	s := make([]string, len(values))
+	for n, v := range values {
+		s[n] = v.String()
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// Block is a basic block: a sequence of instructions ending with its only
+// terminator
+type Block struct {
+	ID     int
+	Instrs []*Instr
+	Preds  []*Block
+	Succs  []*Block
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+// Terminator returns the last instruction of the block
+func (b *Block) Terminator() *Instr {
+	return b.Instrs[len(b.Instrs)-1]
+}
+
+// Func is a procedure, a function if it has a Result, or the main program.
+// Blocks[0] is the entry block. Locals holds the variables other than the
+// parameters, including the result and temporaries.
+type Func struct {
+	Name   string
+	Params []*Var
+	Result *Var
+	Locals []*Var
+	Blocks []*Block
+	SSA    bool
+}
+
+func (f *Func) String() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "func %s(%s)", f.Name, join(vars(f.Params)))
+	if f.Result != nil {
+		fmt.Fprintf(&sb, " %s", f.Result)
+	}
+	sb.WriteString(":\n")
+
+	for _, b := range f.Blocks {
+		sb.WriteString(b.String())
+		if len(b.Preds) > 1 {
+			preds := make([]string, len(b.Preds))
+			for n, p := range b.Preds {
+				preds[n] = p.String()
+			}
+			fmt.Fprintf(&sb, " (from %s)", strings.Join(preds, ", "))
+		}
+		sb.WriteString(":\n")
+		for _, in := range b.Instrs {
+			fmt.Fprintf(&sb, "  %s\n", in)
+		}
+	}
+
+	return sb.String()
+}
+
+func vars(vs []*Var) []Value {
+	values := make([]Value, len(vs))
+	for n, v := range vs {
+		values[n] = v
+	}
+
+	return values
+}
+
+// Program is a lowered program. Main runs the statements of the main
+// program; Funcs holds it and every procedure and function.
+type Program struct {
+	Globals []*Global
+	Funcs   []*Func
+	Main    *Func
+}
+
+func (p *Program) String() string {
+	var sb strings.Builder
+
+	for _, g := range p.Globals {
+		fmt.Fprintf(&sb, "global %s\n", g.Name)
+	}
+
+	for _, f := range p.Funcs {
+		sb.WriteString("\n")
+		sb.WriteString(f.String())
+	}
+
+	return sb.String()
+}
+
+// ToSSA converts every function of the program to SSA form
+func (p *Program) ToSSA() {
+	for _, f := range p.Funcs {
+		f.ToSSA()
+	}
+}
+
+// link records the predecessors and successors of each block from their
+// terminators
+func (f *Func) link() {
+	for _, b := range f.Blocks {
+		b.Preds, b.Succs = nil, nil
+	}
+
+	for _, b := range f.Blocks {
+		for _, t := range b.Terminator().Targets {
+			b.Succs = append(b.Succs, t)
+			t.Preds = append(t.Preds, b)
+		}
+	}
+}
+
+// prune removes the blocks which cannot be reached from the entry, and
+// numbers the rest in reverse postorder, so that a block comes before
+// those it dominates
+func (f *Func) prune() {
+	var order []*Block
+	reached := map[*Block]bool{}
+
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		reached[b] = true
+		targets := b.Terminator().Targets
+		for n := len(targets) - 1; n >= 0; n-- {
+			if !reached[targets[n]] {
+				visit(targets[n])
+			}
+		}
+		order = append(order, b)
+	}
+	visit(f.Blocks[0])
+
+	f.Blocks = make([]*Block, len(order))
+	for n, b := range order {
+		b.ID = len(order) - 1 - n
+		f.Blocks[b.ID] = b
+	}
+
+	f.link()
+}
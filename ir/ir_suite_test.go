@@ -0,0 +1,13 @@
+package ir_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestIR(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "IR Suite")
+}
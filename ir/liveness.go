@@ -0,0 +1,122 @@
+package ir
+
+import "sort"
+
+// Liveness holds the variables live on entry to and exit from each block:
+// those which may be read before they are next assigned. The arguments of
+// a phi are live at the end of the predecessor they come from.
+type Liveness struct {
+	order map[*Var]int
+	in    map[*Block]map[*Var]bool
+	out   map[*Block]map[*Var]bool
+}
+
+// Liveness solves the liveness of the variables of the function
+func (f *Func) Liveness() *Liveness {
+	lv := &Liveness{
+		order: map[*Var]int{},
+		in:    map[*Block]map[*Var]bool{},
+		out:   map[*Block]map[*Var]bool{},
+	}
+	for n, v := range append(append([]*Var{}, f.Params...), f.Locals...) {
+		lv.order[v] = n
+	}
+
+	uses := map[*Block]map[*Var]bool{}
+	defs := map[*Block]map[*Var]bool{}
+	// phiUses holds the variables used by phis along each edge
+	phiUses := map[*Block]map[*Block][]*Var{}
+
+	for _, b := range f.Blocks {
+		uses[b], defs[b] = map[*Var]bool{}, map[*Var]bool{}
+		lv.in[b], lv.out[b] = map[*Var]bool{}, map[*Var]bool{}
+
+		for _, in := range b.Instrs {
+			if in.Op == Phi {
+				for n, arg := range in.Args {
+					if v, ok := arg.(*Var); ok {
+						pred := b.Preds[n]
+						if phiUses[pred] == nil {
+							phiUses[pred] = map[*Block][]*Var{}
+						}
+						phiUses[pred][b] = append(phiUses[pred][b], v)
+					}
+				}
+			} else {
+				for _, arg := range in.Args {
+					if v, ok := arg.(*Var); ok && !defs[b][v] {
+						uses[b][v] = true
+					}
+				}
+			}
+
+			if in.Dst != nil {
+				defs[b][in.Dst] = true
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for n := len(f.Blocks) - 1; n >= 0; n-- {
+			b := f.Blocks[n]
+
+			out := map[*Var]bool{}
+			for _, s := range b.Succs {
+				for v := range lv.in[s] {
+					out[v] = true
+				}
+				for _, v := range phiUses[b][s] {
+					out[v] = true
+				}
+			}
+
+			in := map[*Var]bool{}
+			for v := range uses[b] {
+				in[v] = true
+			}
+			for v := range out {
+				if !defs[b][v] {
+					in[v] = true
+				}
+			}
+
+			// the sets only grow, so a change shows in their size
+			if len(in) != len(lv.in[b]) || len(out) != len(lv.out[b]) {
+				changed = true
+			}
+			lv.in[b], lv.out[b] = in, out
+		}
+	}
+
+	return lv
+}
+
+// LiveIn returns the variables live on entry to a block
+func (lv *Liveness) LiveIn(b *Block) []*Var {
+	return lv.sorted(lv.in[b])
+}
+
+// LiveOut returns the variables live on exit from a block
+func (lv *Liveness) LiveOut(b *Block) []*Var {
+	return lv.sorted(lv.out[b])
+}
+
+// IsLiveIn is true if a variable is live on entry to a block
+func (lv *Liveness) IsLiveIn(b *Block, v *Var) bool {
+	return lv.in[b][v]
+}
+
+// sorted lists a set of variables in the order the function declares them
+func (lv *Liveness) sorted(set map[*Var]bool) []*Var {
+	var vs []*Var
+	for v := range set {
+		vs = append(vs, v)
+	}
+
+	sort.Slice(vs, func(a, b int) bool {
+		return lv.order[vs[a]] < lv.order[vs[b]]
+	})
+
+	return vs
+}
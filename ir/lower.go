@@ -0,0 +1,851 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+	"github.com/kieron-dev/lsbasi/typecheck"
+)
+
+// Error is a construct which cannot be lowered, at a position in the
+// source
+type Error struct {
+	Pos lexer.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at %s", e.Msg, e.Pos)
+}
+
+func errorAt(pos lexer.Position, format string, args ...interface{}) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// binding is what a name means in a scope. A function binds its own name
+// to both the function and its result.
+type binding struct {
+	global   *Global
+	local    *Var
+	owner    *Func
+	fn       *Func
+	value    int
+	constant bool
+	typ      bool
+	ordinal  bool
+	subrange bool
+}
+
+type scope struct {
+	names  map[string]*binding
+	parent *scope
+}
+
+func (s *scope) lookup(name string) (*binding, bool) {
+	name = strings.ToLower(name)
+	for ; s != nil; s = s.parent {
+		if b, ok := s.names[name]; ok {
+			return b, true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *scope) bind(name string, b *binding) {
+	s.names[strings.ToLower(name)] = b
+}
+
+// universe holds the predeclared constants, which may be redeclared
+var universe = &scope{names: map[string]*binding{
+	"false": {value: 0, constant: true},
+	"true":  {value: 1, constant: true},
+}}
+
+// lowerer holds the function and block being lowered into
+type lowerer struct {
+	prog  *Program
+	types *typecheck.TypeChecker
+	scope *scope
+	fn    *Func
+	block *Block
+	temps int
+}
+
+// Lower converts a program to IR. The program is analysed and type checked
+// first, so that it is rejected where the interpreter would reject it.
+func Lower(program parser.ASTNode) (*Program, error) {
+	node, ok := program.(*parser.BlockNode)
+	if !ok {
+		return nil, fmt.Errorf("cannot lower a unit")
+	}
+	if len(node.Uses) > 0 {
+		return nil, errorAt(node.Uses[0].Pos, "units are not supported")
+	}
+
+	types := typecheck.NewTypeChecker()
+	if err := types.Check(program); err != nil {
+		return nil, err
+	}
+
+	main := &Func{Name: "main"}
+	l := &lowerer{
+		prog:  &Program{Main: main, Funcs: []*Func{main}},
+		types: types,
+		scope: &scope{names: map[string]*binding{}, parent: universe},
+	}
+	l.start(main)
+
+	if err := l.body(node); err != nil {
+		return nil, err
+	}
+	l.emit(&Instr{Op: Return})
+	main.prune()
+
+	return l.prog, nil
+}
+
+// start begins lowering into the entry block of a function
+func (l *lowerer) start(f *Func) {
+	l.fn = f
+	l.temps = 0
+	l.block = l.newBlock()
+}
+
+func (l *lowerer) newBlock() *Block {
+	b := &Block{ID: len(l.fn.Blocks)}
+	l.fn.Blocks = append(l.fn.Blocks, b)
+
+	return b
+}
+
+func (l *lowerer) emit(in *Instr) {
+	l.block.Instrs = append(l.block.Instrs, in)
+}
+
+func (l *lowerer) temp() *Var {
+	l.temps++
+	v := &Var{Name: fmt.Sprintf("%%%d", l.temps)}
+	l.fn.Locals = append(l.fn.Locals, v)
+
+	return v
+}
+
+// jump ends the current block with a jump and continues in the target
+func (l *lowerer) jump(to *Block) {
+	l.emit(&Instr{Op: Jump, Targets: []*Block{to}})
+	l.block = to
+}
+
+// branch ends the current block with a branch on cond
+func (l *lowerer) branch(cond Value, yes, no *Block) {
+	l.emit(&Instr{Op: Branch, Args: []Value{cond}, Targets: []*Block{yes, no}})
+}
+
+// body lowers the declarations and statements of a block
+func (l *lowerer) body(node *parser.BlockNode) error {
+	for _, decl := range node.Consts {
+		value, ok := l.constant(decl.Value)
+		if !ok {
+			return errorAt(decl.Pos, "cannot lower constant %q: only INTEGER constants are supported", decl.Name)
+		}
+		l.scope.bind(decl.Name, &binding{constant: true, value: value})
+	}
+
+	for _, decl := range node.Types {
+		if enum, ok := decl.Type.(*parser.EnumType); ok {
+			for n, v := range enum.Values {
+				l.scope.bind(v.Name, &binding{constant: true, value: n})
+			}
+		}
+		l.scope.bind(decl.Name, &binding{typ: true, ordinal: l.ordinal(decl.Type), subrange: l.subrange(decl.Type)})
+	}
+
+	for _, decl := range node.Vars {
+		if l.subrange(decl.Type) {
+			return errorAt(decl.Pos, "cannot lower variables of type %s: subranges are not range checked", typeName(decl.Type))
+		}
+		if !l.ordinal(decl.Type) {
+			return errorAt(decl.Pos, "cannot lower variables of type %s: only ordinal types are supported", typeName(decl.Type))
+		}
+		for _, v := range decl.Names {
+			l.variable(v.Value)
+		}
+	}
+
+	l.implicit(node.Compound)
+
+	for _, decl := range node.Procs {
+		if err := l.procedure(decl); err != nil {
+			return err
+		}
+	}
+
+	return l.statement(node.Compound)
+}
+
+// variable declares a global in the main program, or a local in
+// a procedure
+func (l *lowerer) variable(name string) {
+	if l.fn == l.prog.Main {
+		g := &Global{Name: name}
+		l.prog.Globals = append(l.prog.Globals, g)
+		l.scope.bind(name, &binding{global: g})
+		return
+	}
+
+	v := &Var{Name: name}
+	l.fn.Locals = append(l.fn.Locals, v)
+	l.scope.bind(name, &binding{local: v, owner: l.fn})
+}
+
+// implicit declares the variables created by assigning to them
+func (l *lowerer) implicit(stmt parser.ASTNode) {
+	switch n := stmt.(type) {
+	case *parser.CompoundNode:
+		for _, child := range n.Children {
+			l.implicit(child)
+		}
+
+	case *parser.CaseNode:
+		for _, branch := range n.Branches {
+			l.implicit(branch.Body)
+		}
+		if n.Else != nil {
+			l.implicit(n.Else)
+		}
+
+	case *parser.ForNode:
+		l.declareTarget(n.Var)
+		l.implicit(n.Body)
+
+	case *parser.AssignNode:
+		l.declareTarget(n.Left)
+	}
+}
+
+func (l *lowerer) declareTarget(target parser.ASTNode) {
+	v, ok := target.(*parser.VarNode)
+	if !ok {
+		return
+	}
+
+	if _, ok := l.scope.lookup(v.Value); !ok {
+		l.variable(v.Value)
+	}
+}
+
+// ordinal is true for the types which can be lowered
+func (l *lowerer) ordinal(spec parser.TypeSpec) bool {
+	switch t := spec.(type) {
+	case *parser.EnumType, *parser.SubrangeType:
+		return true
+
+	case *parser.NamedType:
+		switch strings.ToUpper(t.Name) {
+		case "INTEGER", "BOOLEAN":
+			return true
+		}
+		b, ok := l.scope.lookup(t.Name)
+		return ok && b.typ && b.ordinal
+	}
+
+	return false
+}
+
+// subrange is true for subrange types, which are not lowered as values
+// assigned to them would have to be checked against their bounds
+func (l *lowerer) subrange(spec parser.TypeSpec) bool {
+	switch t := spec.(type) {
+	case *parser.SubrangeType:
+		return true
+
+	case *parser.NamedType:
+		b, ok := l.scope.lookup(t.Name)
+		return ok && b.typ && b.subrange
+	}
+
+	return false
+}
+
+func typeName(spec parser.TypeSpec) string {
+	switch t := spec.(type) {
+	case *parser.NamedType:
+		return t.Name
+	case *parser.SubrangeType:
+		return "subrange"
+	case *parser.SetType:
+		return "SET"
+	case *parser.PointerType:
+		return "^" + t.Name
+	case *parser.RecordType:
+		return "RECORD"
+	case *parser.ArrayType:
+		return "ARRAY"
+	}
+
+	return "?"
+}
+
+func (l *lowerer) procedure(node *parser.ProcDeclNode) error {
+	f := &Func{Name: node.Name}
+	if l.fn != l.prog.Main {
+		f.Name = l.fn.Name + "." + node.Name
+	}
+	l.prog.Funcs = append(l.prog.Funcs, f)
+	l.scope.bind(node.Name, &binding{fn: f})
+
+	outer, fn, block, temps := l.scope, l.fn, l.block, l.temps
+	defer func() {
+		l.scope, l.fn, l.block, l.temps = outer, fn, block, temps
+	}()
+
+	l.scope = &scope{names: map[string]*binding{}, parent: outer}
+	l.start(f)
+
+	for _, group := range node.Params {
+		if group.ByRef {
+			return errorAt(group.Pos, "cannot lower %s: VAR parameters are not supported", node.Name)
+		}
+		if l.subrange(group.Type) {
+			return errorAt(group.Pos, "cannot lower parameters of type %s: subranges are not range checked", typeName(group.Type))
+		}
+		if !l.ordinal(group.Type) {
+			return errorAt(group.Pos, "cannot lower parameters of type %s: only ordinal types are supported", typeName(group.Type))
+		}
+		for _, v := range group.Names {
+			p := &Var{Name: v.Value}
+			f.Params = append(f.Params, p)
+			l.scope.bind(v.Value, &binding{local: p, owner: f})
+		}
+	}
+
+	if node.Result != nil {
+		if l.subrange(node.Result) {
+			return errorAt(node.Pos, "cannot lower function %s returning %s: subranges are not range checked", node.Name, typeName(node.Result))
+		}
+		if !l.ordinal(node.Result) {
+			return errorAt(node.Pos, "cannot lower function %s returning %s: only ordinal types are supported", node.Name, typeName(node.Result))
+		}
+		f.Result = &Var{Name: node.Name}
+		f.Locals = append(f.Locals, f.Result)
+		l.scope.bind(node.Name, &binding{fn: f, local: f.Result, owner: f})
+	}
+
+	if err := l.body(node.Block); err != nil {
+		return err
+	}
+
+	if f.Result != nil {
+		l.emit(&Instr{Op: Return, Args: []Value{f.Result}})
+	} else {
+		l.emit(&Instr{Op: Return})
+	}
+	f.prune()
+
+	return nil
+}
+
+func (l *lowerer) statement(stmt parser.ASTNode) error {
+	switch n := stmt.(type) {
+	case *parser.CompoundNode:
+		for _, child := range n.Children {
+			if err := l.statement(child); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *parser.NoOpNode:
+		return nil
+
+	case *parser.AssignNode:
+		value, err := l.expr(n.Right)
+		if err != nil {
+			return err
+		}
+		return l.assign(n.Left, value)
+
+	case *parser.ProcCallNode:
+		if _, declared := l.scope.lookup(n.Name); !declared {
+			switch strings.ToLower(n.Name) {
+			case "write", "writeln":
+				return l.write(n)
+			}
+		}
+		_, err := l.call(n.Name, n.Args, n.Pos, false)
+		return err
+
+	case *parser.CaseNode:
+		return l.caseStatement(n)
+
+	case *parser.ForNode:
+		return l.forStatement(n)
+	}
+
+	return fmt.Errorf("cannot lower %T", stmt)
+}
+
+// assign stores a value in a variable
+func (l *lowerer) assign(target parser.ASTNode, value Value) error {
+	v, ok := target.(*parser.VarNode)
+	if !ok {
+		return errorAt(position(target), "cannot lower assignment to %s: only variables are supported", parser.Designator(target))
+	}
+
+	b, ok := l.scope.lookup(v.Value)
+	switch {
+	case !ok:
+		return errorAt(v.Pos, "unknown variable %q", v.Value)
+
+	case b.global != nil:
+		l.emit(&Instr{Op: Store, Global: b.global, Args: []Value{value}})
+		return nil
+
+	case b.local != nil:
+		if b.owner != l.fn {
+			return errorAt(v.Pos, "cannot lower %q: variables of enclosing procedures are not supported", v.Value)
+		}
+		l.copy(b.local, value)
+		return nil
+	}
+
+	return errorAt(v.Pos, "cannot assign to %q", v.Value)
+}
+
+// copy assigns a value to a local. A temporary just computed is replaced
+// by the local.
+func (l *lowerer) copy(dst *Var, value Value) {
+	if n := len(l.block.Instrs); n > 0 {
+		last := l.block.Instrs[n-1]
+		if last.Dst != nil && last.Dst == value && strings.HasPrefix(last.Dst.Name, "%") {
+			last.Dst = dst
+			l.fn.Locals = l.fn.Locals[:len(l.fn.Locals)-1]
+			l.temps--
+			return
+		}
+	}
+
+	l.emit(&Instr{Op: Copy, Dst: dst, Args: []Value{value}})
+}
+
+func (l *lowerer) caseStatement(node *parser.CaseNode) error {
+	selector, err := l.expr(node.Expr)
+	if err != nil {
+		return err
+	}
+
+	after := l.newBlock()
+
+	var bodies []*Block
+	for _, branch := range node.Branches {
+		body := l.newBlock()
+		bodies = append(bodies, body)
+
+		for _, label := range branch.Labels {
+			next := l.newBlock()
+			if err := l.label(selector, label, body, next); err != nil {
+				return err
+			}
+			l.block = next
+		}
+	}
+
+	// no label matched
+	if node.Else != nil {
+		if err := l.statement(node.Else); err != nil {
+			return err
+		}
+	}
+	l.jump(after)
+
+	for n, branch := range node.Branches {
+		l.block = bodies[n]
+		if err := l.statement(branch.Body); err != nil {
+			return err
+		}
+		l.jump(after)
+	}
+
+	l.block = after
+
+	return nil
+}
+
+// label tests whether the selector matches a CASE label
+func (l *lowerer) label(selector Value, label *parser.CaseLabel, match, next *Block) error {
+	low, ok := l.constant(label.Low)
+	if !ok {
+		return errorAt(label.Pos, "CASE labels must be constant")
+	}
+
+	if label.High == nil {
+		cond := l.temp()
+		l.emit(&Instr{Op: Eq, Dst: cond, Args: []Value{selector, Const(low)}})
+		l.branch(cond, match, next)
+		return nil
+	}
+
+	high, ok := l.constant(label.High)
+	if !ok {
+		return errorAt(label.Pos, "CASE labels must be constant")
+	}
+
+	above := l.temp()
+	l.emit(&Instr{Op: Ge, Dst: above, Args: []Value{selector, Const(low)}})
+	upper := l.newBlock()
+	l.branch(above, upper, next)
+
+	l.block = upper
+	below := l.temp()
+	l.emit(&Instr{Op: Le, Dst: below, Args: []Value{selector, Const(high)}})
+	l.branch(below, match, next)
+
+	return nil
+}
+
+// forStatement lowers a FOR loop, which evaluates its bounds once and
+// assigns the control variable from a hidden counter on each pass
+func (l *lowerer) forStatement(node *parser.ForNode) error {
+	start, err := l.expr(node.Start)
+	if err != nil {
+		return err
+	}
+	end, err := l.expr(node.End)
+	if err != nil {
+		return err
+	}
+
+	step, past := Add, Gt
+	if node.Down {
+		step, past = Sub, Lt
+	}
+
+	// keep the end if it is a variable the body could change
+	if v, ok := end.(*Var); ok && !strings.HasPrefix(v.Name, "%") {
+		limit := l.temp()
+		l.emit(&Instr{Op: Copy, Dst: limit, Args: []Value{end}})
+		end = limit
+	}
+
+	counter := l.temp()
+	l.emit(&Instr{Op: Copy, Dst: counter, Args: []Value{start}})
+
+	skip := l.temp()
+	l.emit(&Instr{Op: past, Dst: skip, Args: []Value{counter, end}})
+
+	body, next, after := l.newBlock(), l.newBlock(), l.newBlock()
+	l.branch(skip, after, body)
+
+	l.block = body
+	if err := l.assign(node.Var, counter); err != nil {
+		return err
+	}
+	if err := l.statement(node.Body); err != nil {
+		return err
+	}
+
+	done := l.temp()
+	l.emit(&Instr{Op: Eq, Dst: done, Args: []Value{counter, end}})
+	l.branch(done, after, next)
+
+	l.block = next
+	l.emit(&Instr{Op: step, Dst: counter, Args: []Value{counter, Const(1)}})
+	l.jump(body)
+
+	l.block = after
+
+	return nil
+}
+
+var operators = map[lexer.TokenType]Op{
+	lexer.Plus:         Add,
+	lexer.Minus:        Sub,
+	lexer.Mult:         Mul,
+	lexer.Div:          Div,
+	lexer.Mod:          Mod,
+	lexer.Equal:        Eq,
+	lexer.NotEqual:     Ne,
+	lexer.Less:         Lt,
+	lexer.LessEqual:    Le,
+	lexer.Greater:      Gt,
+	lexer.GreaterEqual: Ge,
+}
+
+// expr lowers an expression, returning the value it computes
+func (l *lowerer) expr(node parser.ASTNode) (Value, error) {
+	switch n := node.(type) {
+	case *parser.NumNode:
+		if n.Big != nil {
+			return nil, fmt.Errorf("integer literal %s is too large to compile", n.Big)
+		}
+		return Const(n.Value), nil
+
+	case *parser.VarNode:
+		return l.read(n)
+
+	case *parser.UnaryNode:
+		value, err := l.expr(n.Child)
+		if err != nil || n.Token.Type != lexer.Minus {
+			return value, err
+		}
+		if c, ok := value.(Const); ok {
+			return -c, nil
+		}
+		dst := l.temp()
+		l.emit(&Instr{Op: Neg, Dst: dst, Args: []Value{value}})
+		return dst, nil
+
+	case *parser.BinOpNode:
+		op, ok := operators[n.Token.Type]
+		if !ok {
+			return nil, errorAt(n.Token.Pos, "cannot lower the %s operator", n.Token.Type)
+		}
+		left, err := l.expr(n.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := l.expr(n.Right)
+		if err != nil {
+			return nil, err
+		}
+		dst := l.temp()
+		l.emit(&Instr{Op: op, Dst: dst, Args: []Value{left, right}})
+		return dst, nil
+
+	case *parser.CallNode:
+		return l.call(n.Name, n.Args, n.Pos, true)
+	}
+
+	return nil, errorAt(position(node), "cannot lower %s: only ordinal values are supported", describe(node))
+}
+
+// read gives the value of a name in an expression
+func (l *lowerer) read(node *parser.VarNode) (Value, error) {
+	b, ok := l.scope.lookup(node.Value)
+	if !ok {
+		return nil, errorAt(node.Pos, "unknown variable %q", node.Value)
+	}
+
+	switch {
+	case b.constant:
+		return Const(b.value), nil
+
+	case b.global != nil:
+		dst := l.temp()
+		l.emit(&Instr{Op: Load, Dst: dst, Global: b.global})
+		return dst, nil
+
+	case b.fn != nil:
+		// inside a function its name calls it, as elsewhere
+		return l.call(node.Value, nil, node.Pos, true)
+
+	case b.local != nil:
+		if b.owner != l.fn {
+			return nil, errorAt(node.Pos, "cannot lower %q: variables of enclosing procedures are not supported", node.Value)
+		}
+		return b.local, nil
+	}
+
+	return nil, errorAt(node.Pos, "%q is not a value", node.Value)
+}
+
+// call lowers a call of a procedure, function or standard function. If
+// value is true, the call must return a value.
+func (l *lowerer) call(name string, args []parser.ASTNode, pos lexer.Position, value bool) (Value, error) {
+	b, ok := l.scope.lookup(name)
+	if !ok || b.fn == nil {
+		return l.builtin(name, args, pos)
+	}
+
+	f := b.fn
+	if value && f.Result == nil {
+		return nil, errorAt(pos, "procedure %s has no value", name)
+	}
+	if len(args) != len(f.Params) {
+		return nil, errorAt(pos, "%s expects %d argument(s), got %d", name, len(f.Params), len(args))
+	}
+
+	in := &Instr{Op: Call, Callee: f}
+	for _, arg := range args {
+		v, err := l.expr(arg)
+		if err != nil {
+			return nil, err
+		}
+		in.Args = append(in.Args, v)
+	}
+
+	if f.Result != nil {
+		in.Dst = l.temp()
+	}
+	l.emit(in)
+
+	if in.Dst == nil {
+		return nil, nil
+	}
+
+	return in.Dst, nil
+}
+
+// builtin lowers the standard functions on ordinal values
+func (l *lowerer) builtin(name string, args []parser.ASTNode, pos lexer.Position) (Value, error) {
+	op := strings.ToLower(name)
+	switch op {
+	case "ord", "succ", "pred", "sqr", "odd", "abs":
+	default:
+		return nil, errorAt(pos, "cannot lower a call of %s", name)
+	}
+
+	if len(args) != 1 {
+		return nil, errorAt(pos, "%s expects 1 argument(s), got %d", name, len(args))
+	}
+
+	x, err := l.expr(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	emit := func(op Op, args ...Value) *Var {
+		dst := l.temp()
+		l.emit(&Instr{Op: op, Dst: dst, Args: args})
+		return dst
+	}
+
+	switch op {
+	case "succ":
+		return emit(Add, x, Const(1)), nil
+	case "pred":
+		return emit(Sub, x, Const(1)), nil
+	case "sqr":
+		return emit(Mul, x, x), nil
+	case "odd":
+		return emit(Ne, emit(Mod, x, Const(2)), Const(0)), nil
+	case "abs":
+		result := emit(Copy, x)
+		negative, done := l.newBlock(), l.newBlock()
+		l.branch(emit(Lt, x, Const(0)), negative, done)
+		l.block = negative
+		l.emit(&Instr{Op: Neg, Dst: result, Args: []Value{x}})
+		l.jump(done)
+		return result, nil
+	}
+
+	return x, nil
+}
+
+// write lowers Write and WriteLn. BOOLEAN and enumerated values are written
+// by name, using the types of the arguments found by the type checker.
+func (l *lowerer) write(node *parser.ProcCallNode) error {
+	for _, arg := range node.Args {
+		var names []string
+		switch t := l.types.TypeOf(arg).(type) {
+		case *typecheck.Enum:
+			names = t.Values
+		case *typecheck.Subrange:
+			if e, ok := t.Base.(*typecheck.Enum); ok {
+				names = e.Values
+			}
+		case typecheck.Basic:
+			if t == typecheck.Boolean {
+				names = []string{"FALSE", "TRUE"}
+			}
+		}
+
+		v, err := l.expr(arg)
+		if err != nil {
+			return err
+		}
+		l.emit(&Instr{Op: Write, Args: []Value{v}, Names: names})
+	}
+
+	if strings.EqualFold(node.Name, "WriteLn") {
+		l.emit(&Instr{Op: WriteLn})
+	}
+
+	return nil
+}
+
+// constant evaluates a constant ordinal expression
+func (l *lowerer) constant(node parser.ASTNode) (int, bool) {
+	switch n := node.(type) {
+	case *parser.NumNode:
+		return n.Value, n.Big == nil
+
+	case *parser.VarNode:
+		if b, ok := l.scope.lookup(n.Value); ok && b.constant {
+			return b.value, true
+		}
+
+	case *parser.UnaryNode:
+		v, ok := l.constant(n.Child)
+		if ok && n.Token.Type == lexer.Minus {
+			return -v, true
+		}
+		return v, ok
+
+	case *parser.BinOpNode:
+		left, lok := l.constant(n.Left)
+		right, rok := l.constant(n.Right)
+		if !lok || !rok {
+			return 0, false
+		}
+		switch n.Token.Type {
+		case lexer.Plus:
+			return left + right, true
+		case lexer.Minus:
+			return left - right, true
+		case lexer.Mult:
+			return left * right, true
+		case lexer.Div:
+			if right != 0 {
+				return left / right, true
+			}
+		case lexer.Mod:
+			if right != 0 {
+				return left % right, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func position(node parser.ASTNode) lexer.Position {
+	switch n := node.(type) {
+	case *parser.RealNode:
+		return n.Token.Pos
+	case *parser.ToRealNode:
+		return n.Pos
+	case *parser.SetNode:
+		return n.Pos
+	case *parser.NilNode:
+		return n.Pos
+	case *parser.DerefNode:
+		return n.Pos
+	case *parser.FieldNode:
+		return n.Pos
+	case *parser.IndexNode:
+		return n.Pos
+	}
+
+	return lexer.Position{}
+}
+
+func describe(node parser.ASTNode) string {
+	switch node.(type) {
+	case *parser.RealNode, *parser.ToRealNode:
+		return "REAL values"
+	case *parser.SetNode:
+		return "sets"
+	case *parser.NilNode, *parser.DerefNode:
+		return "pointers"
+	case *parser.FieldNode:
+		return "records"
+	case *parser.IndexNode:
+		return "arrays"
+	}
+
+	return fmt.Sprintf("%T", node)
+}
@@ -0,0 +1,279 @@
+package ir_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/interpreter"
+	"github.com/kieron-dev/lsbasi/ir"
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func lower(source string) (*ir.Program, error) {
+	node, err := parser.NewParser(lexer.NewTokeniser(strings.NewReader(source))).Program()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	return ir.Lower(node)
+}
+
+// interpret runs a program with the interpreter, giving the values of its
+// globals as ints
+func interpret(source string, out io.Writer) map[string]int {
+	interp := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(source))),
+		interpreter.WithOutput(out))
+	ExpectWithOffset(1, interp.Interpret()).To(Succeed())
+
+	globals := map[string]int{}
+	for name, value := range interp.GlobalScope() {
+		switch v := value.(type) {
+		case int:
+			globals[name] = v
+		case bool:
+			globals[name] = 0
+			if v {
+				globals[name] = 1
+			}
+		case interpreter.EnumValue:
+			globals[name] = v.Ord
+		}
+	}
+
+	return globals
+}
+
+func lowercase(values map[string]int) map[string]int {
+	lower := map[string]int{}
+	for name, v := range values {
+		lower[strings.ToLower(name)] = v
+	}
+
+	return lower
+}
+
+var _ = Describe("Lower", func() {
+	It("lowers statements to blocks", func() {
+		prog, err := lower(`VAR a: INTEGER;
+FUNCTION Twice(n: INTEGER): INTEGER;
+BEGIN Twice := n * 2 END;
+BEGIN
+  a := Twice(4);
+  CASE a OF
+    1..5: b := 1;
+    8: b := 2
+  END;
+  FOR i := 1 TO a DO b := b + i
+END.`)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(prog.String()).To(Equal(`global a
+global b
+global i
+
+func main():
+b0:
+  %1 = call Twice(4)
+  store a, %1
+  %2 = load a
+  %3 = %2 >= 1
+  branch %3, b1, b3
+b1:
+  %4 = %2 <= 5
+  branch %4, b2, b3
+b2:
+  store b, 1
+  jump b6
+b3 (from b0, b1):
+  %5 = %2 = 8
+  branch %5, b4, b5
+b4:
+  store b, 2
+  jump b6
+b5:
+  jump b6
+b6 (from b2, b4, b5):
+  %6 = load a
+  %7 = 1
+  %8 = %7 > %6
+  branch %8, b8, b7
+b7 (from b6, b9):
+  store i, %7
+  %9 = load b
+  %10 = load i
+  %11 = %9 + %10
+  store b, %11
+  %12 = %7 = %6
+  branch %12, b8, b9
+b8 (from b6, b7):
+  return
+b9:
+  %7 = %7 + 1
+  jump b7
+
+func Twice(n) Twice:
+b0:
+  Twice = n * 2
+  return Twice
+`))
+	})
+
+	It("names nested procedures after the procedures declaring them", func() {
+		prog, err := lower(`PROCEDURE Outer;
+  PROCEDURE Inner;
+  BEGIN x := 1 END;
+BEGIN Inner END;
+BEGIN Outer END.`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var names []string
+		for _, f := range prog.Funcs {
+			names = append(names, f.Name)
+		}
+		Expect(names).To(Equal([]string{"main", "Outer", "Outer.Inner"}))
+		Expect(prog.Main).To(Equal(prog.Funcs[0]))
+	})
+
+	DescribeTable("programs it cannot lower",
+		func(source, msg string) {
+			_, err := lower(source)
+			Expect(err).To(MatchError(msg))
+		},
+
+		Entry("REAL", `BEGIN x := 1.5 END.`, "cannot lower REAL values: only ordinal values are supported at 1:12"),
+		Entry("REAL variables", `VAR r: REAL; BEGIN r := 1 END.`, "cannot lower variables of type REAL: only ordinal types are supported at 1:5"),
+		Entry("arrays", `VAR a: ARRAY [1..3] OF INTEGER; BEGIN a[1] := 1 END.`, "cannot lower variables of type ARRAY: only ordinal types are supported at 1:5"),
+		Entry("VAR parameters", `PROCEDURE P(VAR n: INTEGER); BEGIN n := 1 END; BEGIN x := 1; P(x) END.`, "cannot lower P: VAR parameters are not supported at 1:13"),
+		Entry("enclosing variables", `PROCEDURE P;
+VAR n: INTEGER;
+  PROCEDURE Q;
+  BEGIN n := 1 END;
+BEGIN Q END;
+BEGIN P END.`, `cannot lower "n": variables of enclosing procedures are not supported at 4:9`),
+		Entry("REAL functions", `BEGIN x := Sqrt(4) END.`, "cannot lower a call of Sqrt at 1:12"),
+		Entry("type errors", `VAR a: INTEGER; BEGIN a := TRUE END.`, "cannot assign BOOLEAN to a of type INTEGER at 1:23"),
+		Entry("subrange variables", `TYPE Digit = 0..9; VAR d: Digit; BEGIN d := 10; WriteLn(d) END.`, "cannot lower variables of type Digit: subranges are not range checked at 1:24"),
+		Entry("anonymous subrange variables", `VAR d: 0..9; BEGIN d := 1 END.`, "cannot lower variables of type subrange: subranges are not range checked at 1:5"),
+		Entry("subrange parameters", `TYPE Digit = 0..9; PROCEDURE P(d: Digit); BEGIN END; BEGIN P(10) END.`, "cannot lower parameters of type Digit: subranges are not range checked at 1:32"),
+	)
+
+	DescribeTable("running lowered programs",
+		func(source string) {
+			var expectedOut, out bytes.Buffer
+			expected := interpret(source, &expectedOut)
+
+			prog, err := lower(source)
+			Expect(err).NotTo(HaveOccurred())
+
+			globals, err := prog.Run(&out)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lowercase(globals)).To(Equal(expected))
+			Expect(out.String()).To(Equal(expectedOut.String()))
+
+			prog.ToSSA()
+			out.Reset()
+			globals, err = prog.Run(&out)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lowercase(globals)).To(Equal(expected))
+			Expect(out.String()).To(Equal(expectedOut.String()))
+		},
+
+		Entry("arithmetic", `CONST k = 3;
+BEGIN
+  a := 7 * k - -2;
+  b := a DIV 4;
+  c := a MOD 4;
+  d := Ord(a > b) + Ord(a = b) * 10;
+  e := Abs(b - a) + Sqr(c) + Succ(Pred(d));
+  f := Ord(Odd(a)) + Ord(Odd(b))
+END.`),
+
+		Entry("CASE", `TYPE colour = (red, green, blue);
+VAR c: colour;
+BEGIN
+  c := blue;
+  CASE c OF
+    red, green: x := 1;
+    blue: x := 2
+  END;
+  CASE x OF
+    0..1: y := 10
+  ELSE
+    y := 20
+  END;
+  CASE y OF 1: z := 1 END;
+  z := 0
+END.`),
+
+		Entry("FOR loops", `BEGIN
+  total := 0;
+  FOR i := 1 TO 10 DO total := total + i;
+  FOR j := 10 DOWNTO 1 DO
+    FOR k := j TO j + 2 DO
+      total := total + k * j;
+  n := 0;
+  FOR m := 1 TO n DO total := 0;
+  FOR m := 3 TO 3 DO total := total + m
+END.`),
+
+		Entry("recursive functions", `FUNCTION Fib(n: INTEGER): INTEGER;
+BEGIN
+  CASE n OF
+    0, 1: Fib := n
+  ELSE
+    Fib := Fib(n - 1) + Fib(n - 2)
+  END
+END;
+FUNCTION Gcd(a, b: INTEGER): INTEGER;
+BEGIN
+  CASE b OF
+    0: Gcd := a
+  ELSE
+    Gcd := Gcd(b, a MOD b)
+  END
+END;
+BEGIN
+  f := Fib(15);
+  g := Gcd(1071, 462)
+END.`),
+
+		Entry("procedures changing globals", `VAR count: INTEGER;
+PROCEDURE Add(n: INTEGER);
+VAR t: INTEGER;
+BEGIN
+  t := n;
+  FOR i := 1 TO n DO t := t + i;
+  count := count + t
+END;
+BEGIN
+  count := 0;
+  Add(3);
+  Add(4)
+END.`),
+
+		Entry("locals assigned on some paths", `FUNCTION Sign(n: INTEGER): INTEGER;
+VAR s: INTEGER;
+BEGIN
+  s := 0;
+  CASE Ord(n > 0) OF 1: s := 1 END;
+  CASE Ord(n < 0) OF 1: s := -1 END;
+  Sign := s
+END;
+BEGIN
+  a := Sign(-5) * 100 + Sign(0) * 10 + Sign(7)
+END.`),
+
+		Entry("writing values", `TYPE colour = (red, green, blue);
+VAR c: colour; n: INTEGER; b: BOOLEAN;
+BEGIN
+  n := -42; b := n < 0;
+  FOR c := red TO blue DO Write(c, Ord(c));
+  WriteLn;
+  WriteLn(n, b, Odd(n), Succ(red));
+  Write(n * 2)
+END.`),
+	)
+})
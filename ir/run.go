@@ -0,0 +1,190 @@
+package ir
+
+import (
+	"fmt"
+	"io"
+)
+
+// maxDepth limits the nesting of calls made by Run
+const maxDepth = 10000
+
+// Run executes the program, writing its output to out, and returns the
+// final values of the globals it assigned. It shows that lowering and
+// transforming a program keep its meaning.
+func (p *Program) Run(out io.Writer) (map[string]int, error) {
+	r := &runner{globals: map[*Global]int{}, out: out}
+	if _, err := r.call(p.Main, nil, 0); err != nil {
+		return nil, err
+	}
+
+	results := map[string]int{}
+	for g, v := range r.globals {
+		results[g.Name] = v
+	}
+
+	return results, nil
+}
+
+type runner struct {
+	globals map[*Global]int
+	out     io.Writer
+}
+
+func (r *runner) call(f *Func, args []int, depth int) (int, error) {
+	if depth > maxDepth {
+		return 0, fmt.Errorf("stack overflow: more than %d nested calls", maxDepth)
+	}
+
+	regs := map[*Var]int{}
+	for n, p := range f.Params {
+		regs[p] = args[n]
+	}
+
+	value := func(v Value) int {
+		if c, ok := v.(Const); ok {
+			return int(c)
+		}
+		return regs[v.(*Var)]
+	}
+
+	var prev *Block
+	b := f.Blocks[0]
+	for {
+		// the phis of a block choose their values together on entry
+		chosen := map[*Var]int{}
+		for _, in := range b.Instrs {
+			if in.Op == Phi {
+				chosen[in.Dst] = value(in.Args[predIndex(b, prev)])
+			}
+		}
+		for v, val := range chosen {
+			regs[v] = val
+		}
+
+		next, result, done, err := r.execute(f, b, regs, value, depth)
+		if err != nil || done {
+			return result, err
+		}
+		prev, b = b, next
+	}
+}
+
+// execute runs the instructions of a block, returning the block to go to
+// next, or the result of the function if it returns
+func (r *runner) execute(f *Func, b *Block, regs map[*Var]int, value func(Value) int, depth int) (*Block, int, bool, error) {
+	for _, in := range b.Instrs {
+		args := make([]int, len(in.Args))
+		if in.Op != Phi {
+			for n, arg := range in.Args {
+				args[n] = value(arg)
+			}
+		}
+
+		switch in.Op {
+		case Phi:
+		case Copy:
+			regs[in.Dst] = args[0]
+		case Neg:
+			regs[in.Dst] = -args[0]
+		case Add:
+			regs[in.Dst] = args[0] + args[1]
+		case Sub:
+			regs[in.Dst] = args[0] - args[1]
+		case Mul:
+			regs[in.Dst] = args[0] * args[1]
+		case Div, Mod:
+			if args[1] == 0 {
+				return nil, 0, false, fmt.Errorf("division by zero in %s", f.Name)
+			}
+			if in.Op == Div {
+				regs[in.Dst] = args[0] / args[1]
+			} else {
+				regs[in.Dst] = args[0] % args[1]
+			}
+		case Eq, Ne, Lt, Le, Gt, Ge:
+			regs[in.Dst] = compare(in.Op, args[0], args[1])
+
+		case Load:
+			val, ok := r.globals[in.Global]
+			if !ok {
+				return nil, 0, false, fmt.Errorf("%s is read before it is assigned", in.Global.Name)
+			}
+			regs[in.Dst] = val
+		case Store:
+			r.globals[in.Global] = args[0]
+
+		case Call:
+			result, err := r.call(in.Callee, args, depth+1)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			if in.Dst != nil {
+				regs[in.Dst] = result
+			}
+
+		case Write:
+			var err error
+			if in.Names == nil {
+				_, err = fmt.Fprint(r.out, args[0])
+			} else {
+				_, err = io.WriteString(r.out, in.Names[args[0]])
+			}
+			if err != nil {
+				return nil, 0, false, err
+			}
+		case WriteLn:
+			if _, err := io.WriteString(r.out, "\n"); err != nil {
+				return nil, 0, false, err
+			}
+
+		case Jump:
+			return in.Targets[0], 0, false, nil
+		case Branch:
+			if args[0] != 0 {
+				return in.Targets[0], 0, false, nil
+			}
+			return in.Targets[1], 0, false, nil
+		case Return:
+			if len(args) == 0 {
+				return nil, 0, true, nil
+			}
+			return nil, args[0], true, nil
+		}
+	}
+
+	return nil, 0, false, fmt.Errorf("block %s of %s has no terminator", b, f.Name)
+}
+
+func predIndex(b, pred *Block) int {
+	for n, p := range b.Preds {
+		if p == pred {
+			return n
+		}
+	}
+
+	return -1
+}
+
+func compare(op Op, a, b int) int {
+	var result bool
+	switch op {
+	case Eq:
+		result = a == b
+	case Ne:
+		result = a != b
+	case Lt:
+		result = a < b
+	case Le:
+		result = a <= b
+	case Gt:
+		result = a > b
+	case Ge:
+		result = a >= b
+	}
+
+	if result {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,135 @@
+package ir
+
+// ToSSA converts the function to SSA form, where every variable is
+// assigned once. Phis are placed on the dominance frontiers of the blocks
+// assigning a variable, where the variable is live, and the assignments
+// are then renamed to new versions walking down the dominator tree.
+// A variable assigned only once keeps its name. A read of a variable never
+// assigned reads 0.
+func (f *Func) ToSSA() {
+	if f.SSA {
+		return
+	}
+
+	dom := f.Dominators()
+	live := f.Liveness()
+
+	all := append(append([]*Var{}, f.Params...), f.Locals...)
+	defBlocks := map[*Var][]*Block{}
+	defs := map[*Var]int{}
+	for _, p := range f.Params {
+		defBlocks[p] = []*Block{f.Blocks[0]}
+		defs[p]++
+	}
+	for _, b := range f.Blocks {
+		for _, in := range b.Instrs {
+			if in.Dst != nil {
+				if !contains(defBlocks[in.Dst], b) {
+					defBlocks[in.Dst] = append(defBlocks[in.Dst], b)
+				}
+				defs[in.Dst]++
+			}
+		}
+	}
+
+	// place the phis
+	original := map[*Instr]*Var{}
+	for _, v := range all {
+		hasPhi := map[*Block]bool{}
+		work := append([]*Block{}, defBlocks[v]...)
+		for len(work) > 0 {
+			b := work[0]
+			work = work[1:]
+
+			for _, d := range dom.Frontier(b) {
+				if hasPhi[d] || !live.IsLiveIn(d, v) {
+					continue
+				}
+				phi := &Instr{Op: Phi, Dst: v, Args: make([]Value, len(d.Preds))}
+				d.Instrs = append([]*Instr{phi}, d.Instrs...)
+				original[phi] = v
+				hasPhi[d] = true
+				defs[v]++
+
+				if !contains(defBlocks[v], d) {
+					defBlocks[v] = append(defBlocks[v], d)
+					work = append(work, d)
+				}
+			}
+		}
+	}
+
+	// rename the assignments
+	var locals []*Var
+	versions := map[*Var]int{}
+	stacks := map[*Var][]*Var{}
+	for _, p := range f.Params {
+		stacks[p] = []*Var{p}
+	}
+
+	current := func(v *Var) Value {
+		stack := stacks[v]
+		if len(stack) == 0 {
+			return Const(0)
+		}
+		return stack[len(stack)-1]
+	}
+
+	define := func(v *Var) *Var {
+		if defs[v] == 1 {
+			locals = append(locals, v)
+			return v
+		}
+		versions[v]++
+		nv := &Var{Name: v.Name, Version: versions[v]}
+		locals = append(locals, nv)
+		return nv
+	}
+
+	var rename func(b *Block)
+	rename = func(b *Block) {
+		var pushed []*Var
+
+		for _, in := range b.Instrs {
+			if in.Op != Phi {
+				for n, arg := range in.Args {
+					if v, ok := arg.(*Var); ok {
+						in.Args[n] = current(v)
+					}
+				}
+			}
+
+			if in.Dst != nil {
+				v := in.Dst
+				in.Dst = define(v)
+				stacks[v] = append(stacks[v], in.Dst)
+				pushed = append(pushed, v)
+			}
+		}
+
+		for _, s := range b.Succs {
+			for n, pred := range s.Preds {
+				if pred != b {
+					continue
+				}
+				for _, in := range s.Instrs {
+					if v, ok := original[in]; ok {
+						in.Args[n] = current(v)
+					}
+				}
+			}
+		}
+
+		for _, c := range dom.Children(b) {
+			rename(c)
+		}
+
+		for _, v := range pushed {
+			stacks[v] = stacks[v][:len(stacks[v])-1]
+		}
+	}
+	rename(f.Blocks[0])
+
+	f.Locals = locals
+	f.SSA = true
+}
@@ -0,0 +1,203 @@
+package ir_test
+
+import (
+	"bytes"
+
+	"github.com/kieron-dev/lsbasi/ir"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Analyses", func() {
+	const source = `FUNCTION Max(a, b: INTEGER): INTEGER;
+BEGIN
+  Max := a;
+  CASE Ord(b > a) OF 1: Max := b END
+END;
+FUNCTION Sum(n: INTEGER): INTEGER;
+VAR s: INTEGER;
+BEGIN
+  s := 0;
+  FOR i := 1 TO n DO s := s + i;
+  Sum := s
+END;
+BEGIN x := Max(1, 2) + Sum(3) END.`
+
+	var prog *ir.Program
+
+	BeforeEach(func() {
+		var err error
+		prog, err = lower(source)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	function := func(name string) *ir.Func {
+		for _, f := range prog.Funcs {
+			if f.Name == name {
+				return f
+			}
+		}
+		Fail("no function " + name)
+		return nil
+	}
+
+	names := func(vs []*ir.Var) []string {
+		s := []string{}
+		for _, v := range vs {
+			s = append(s, v.String())
+		}
+		return s
+	}
+
+	Describe("Dominators", func() {
+		It("finds the dominator tree of a diamond", func() {
+			f := function("Max")
+			b := f.Blocks
+			dom := f.Dominators()
+
+			Expect(dom.Idom(b[0])).To(BeNil())
+			Expect(dom.Idom(b[1])).To(Equal(b[0]))
+			Expect(dom.Idom(b[2])).To(Equal(b[0]))
+			Expect(dom.Idom(b[3])).To(Equal(b[0]))
+			Expect(dom.Children(b[0])).To(Equal([]*ir.Block{b[1], b[2], b[3]}))
+
+			Expect(dom.Dominates(b[0], b[3])).To(BeTrue())
+			Expect(dom.Dominates(b[1], b[3])).To(BeFalse())
+			Expect(dom.Dominates(b[3], b[3])).To(BeTrue())
+
+			Expect(dom.Frontier(b[1])).To(Equal([]*ir.Block{b[3]}))
+			Expect(dom.Frontier(b[2])).To(Equal([]*ir.Block{b[3]}))
+			Expect(dom.Frontier(b[0])).To(BeEmpty())
+		})
+
+		It("finds the loop header in the frontier of the loop body", func() {
+			f := function("Sum")
+			b := f.Blocks
+			dom := f.Dominators()
+
+			Expect(dom.Idom(b[1])).To(Equal(b[0]))
+			Expect(dom.Idom(b[2])).To(Equal(b[0]))
+			Expect(dom.Idom(b[3])).To(Equal(b[1]))
+
+			Expect(dom.Frontier(b[1])).To(ConsistOf(b[1], b[2]))
+			Expect(dom.Frontier(b[3])).To(Equal([]*ir.Block{b[1]}))
+		})
+	})
+
+	Describe("Liveness", func() {
+		It("finds the variables live around a loop", func() {
+			f := function("Sum")
+			b := f.Blocks
+			live := f.Liveness()
+
+			Expect(names(live.LiveIn(b[0]))).To(Equal([]string{"n"}))
+			Expect(names(live.LiveOut(b[0]))).To(Equal([]string{"s", "%1", "%2"}))
+			Expect(names(live.LiveIn(b[1]))).To(Equal([]string{"s", "%1", "%2"}))
+			Expect(names(live.LiveIn(b[2]))).To(Equal([]string{"s"}))
+			Expect(names(live.LiveOut(b[2]))).To(BeEmpty())
+			Expect(names(live.LiveIn(b[3]))).To(Equal([]string{"s", "%1", "%2"}))
+		})
+
+		It("makes phi arguments live out of their predecessors", func() {
+			f := function("Max")
+			f.ToSSA()
+			b := f.Blocks
+			live := f.Liveness()
+
+			Expect(names(live.LiveOut(b[1]))).To(Equal([]string{"Max.2"}))
+			Expect(names(live.LiveOut(b[2]))).To(Equal([]string{"Max.1"}))
+			Expect(names(live.LiveIn(b[3]))).To(BeEmpty())
+		})
+	})
+
+	Describe("ToSSA", func() {
+		It("places phis where versions meet", func() {
+			f := function("Sum")
+			f.ToSSA()
+
+			Expect(f.SSA).To(BeTrue())
+			Expect(f.String()).To(Equal(`func Sum(n) Sum:
+b0:
+  s.1 = 0
+  %1 = n
+  %2.1 = 1
+  %3 = %2.1 > %1
+  branch %3, b2, b1
+b1 (from b0, b3):
+  %2.2 = phi %2.1, %2.3
+  s.2 = phi s.1, s.3
+  i = %2.2
+  s.3 = s.2 + i
+  %4 = %2.2 = %1
+  branch %4, b2, b3
+b2 (from b0, b1):
+  s.4 = phi s.1, s.3
+  Sum = s.4
+  return Sum
+b3:
+  %2.3 = %2.2 + 1
+  jump b1
+`))
+		})
+
+		It("assigns each variable once", func() {
+			prog.ToSSA()
+
+			for _, f := range prog.Funcs {
+				defined := map[*ir.Var]bool{}
+				for _, p := range f.Params {
+					defined[p] = true
+				}
+				for _, b := range f.Blocks {
+					for _, in := range b.Instrs {
+						if in.Dst == nil {
+							continue
+						}
+						Expect(defined[in.Dst]).To(BeFalse(), "%s is assigned twice in %s", in.Dst, f.Name)
+						defined[in.Dst] = true
+					}
+				}
+				Expect(len(defined)).To(Equal(len(f.Params) + len(f.Locals)))
+			}
+		})
+
+		It("is only done once", func() {
+			f := function("Max")
+			f.ToSSA()
+			before := f.String()
+			f.ToSSA()
+			Expect(f.String()).To(Equal(before))
+		})
+	})
+
+	Describe("DOT", func() {
+		It("writes the graph of a function", func() {
+			var out bytes.Buffer
+			Expect(function("Max").DOT(&out)).To(Succeed())
+
+			Expect(out.String()).To(Equal(`digraph "Max" {
+  node [shape=box, fontname="monospace"];
+  b0 [label="b0:\l  Max = a\l  %1 = b > a\l  %2 = %1 = 1\l  branch %2, b1, b2\l"];
+  b1 [label="b1:\l  Max = b\l  jump b3\l"];
+  b2 [label="b2:\l  jump b3\l"];
+  b3 [label="b3:\l  return Max\l"];
+  b0 -> b1 [label="true"];
+  b0 -> b2 [label="false"];
+  b1 -> b3;
+  b2 -> b3;
+}
+`))
+		})
+
+		It("writes each function of a program in a cluster", func() {
+			var out bytes.Buffer
+			Expect(prog.DOT(&out)).To(Succeed())
+
+			Expect(out.String()).To(HavePrefix("digraph program {\n"))
+			Expect(out.String()).To(ContainSubstring(`  subgraph cluster_1 {
+    label = "Max";
+    f1b0 [label=`))
+			Expect(out.String()).To(ContainSubstring("    f2b3 -> f2b1;\n"))
+		})
+	})
+})
@@ -5,8 +5,12 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type TokenType int
@@ -27,6 +31,43 @@ const (
 	Dot
 	Semi
 	Assign
+	Range
+	Colon
+	Comma
+	Case
+	Of
+	Else
+	Const
+	Equal
+	Unit
+	Interface
+	Implementation
+	Uses
+	Whitespace
+	Comment
+	Real
+	Mod
+	Type
+	Var
+	For
+	To
+	Downto
+	Do
+	LBracket
+	RBracket
+	Set
+	In
+	NotEqual
+	Less
+	LessEqual
+	Greater
+	GreaterEqual
+	Caret
+	Nil
+	Record
+	Procedure
+	Function
+	Array
 )
 
 func (tt TokenType) String() string {
@@ -46,45 +87,284 @@ func (tt TokenType) String() string {
 		"dot",
 		"semicolon",
 		"assignment",
+		"range",
+		"colon",
+		"comma",
+		"case",
+		"of",
+		"else",
+		"const",
+		"equals",
+		"unit",
+		"interface",
+		"implementation",
+		"uses",
+		"whitespace",
+		"comment",
+		"Real",
+		"mod",
+		"type",
+		"var",
+		"for",
+		"to",
+		"downto",
+		"do",
+		"left bracket",
+		"right bracket",
+		"set",
+		"in",
+		"not equals",
+		"less than",
+		"less or equal",
+		"greater than",
+		"greater or equal",
+		"caret",
+		"nil",
+		"record",
+		"procedure",
+		"function",
+		"array",
 	}[tt]
 }
 
+// Position is a 1-based line and column in the source
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Error is a lexical error at a position in the source
+type Error struct {
+	Pos Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at %s", e.Msg, e.Pos)
+}
+
+// Token is a token of the source. Text is the spelling of an integer
+// literal, such as $FF, for reporting it when it is out of range.
 type Token struct {
 	Type  TokenType
 	Value interface{}
+	Pos   Position
+	Text  string
+}
+
+// IsTrivia is true for tokens which do not affect the meaning of a program
+func (t Token) IsTrivia() bool {
+	return t.Type == Whitespace || t.Type == Comment
 }
 
 type Tokeniser struct {
-	currentToken Token
-	buf          *bufio.Reader
+	buf        *bufio.Reader
+	line       int
+	column     int
+	lastColumn int
+	keepTrivia bool
+	lookahead  []Token
+	err        error
+	started    bool
+	min, max   *big.Int
+	previous   TokenType
+	negation   bool
+}
+
+type Option func(*Tokeniser)
+
+// WithIntegerRange sets the range of INTEGER values, outside which integer
+// literals are rejected. It is the 64-bit range by default, and nil bounds
+// accept any literal.
+func WithIntegerRange(min, max *big.Int) Option {
+	return func(t *Tokeniser) {
+		t.min, t.max = min, max
+	}
+}
+
+// KeepTrivia makes the tokeniser return whitespace and comment tokens, which
+// are skipped by default
+func KeepTrivia() Option {
+	return func(t *Tokeniser) {
+		t.keepTrivia = true
+	}
 }
 
 var reservedWords = map[string]TokenType{
-	"BEGIN": Begin,
-	"END":   End,
-	"DIV":   Div,
+	"BEGIN":          Begin,
+	"END":            End,
+	"DIV":            Div,
+	"MOD":            Mod,
+	"CASE":           Case,
+	"OF":             Of,
+	"ELSE":           Else,
+	"CONST":          Const,
+	"UNIT":           Unit,
+	"INTERFACE":      Interface,
+	"IMPLEMENTATION": Implementation,
+	"USES":           Uses,
+	"TYPE":           Type,
+	"VAR":            Var,
+	"FOR":            For,
+	"TO":             To,
+	"DOWNTO":         Downto,
+	"DO":             Do,
+	"SET":            Set,
+	"IN":             In,
+	"NIL":            Nil,
+	"RECORD":         Record,
+	"PROCEDURE":      Procedure,
+	"FUNCTION":       Function,
+	"ARRAY":          Array,
+}
+
+// ReservedWords lists the language keywords in alphabetical order
+func ReservedWords() []string {
+	words := []string{}
+	for word := range reservedWords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+
+	return words
 }
 
-func NewTokeniser(data io.Reader) *Tokeniser {
-	return &Tokeniser{
-		buf: bufio.NewReader(data),
+func NewTokeniser(data io.Reader, opts ...Option) *Tokeniser {
+	t := &Tokeniser{
+		buf:  bufio.NewReader(data),
+		line: 1,
+		min:  big.NewInt(math.MinInt64),
+		max:  big.NewInt(math.MaxInt64),
 	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
 }
 
+// NextToken returns the next token and moves past it. After the end of the
+// input it keeps returning EOF tokens.
 func (t *Tokeniser) NextToken() (Token, error) {
-	c := byte(' ')
-	for c == ' ' || c == '\t' || c == '\n' {
-		var err error
-		c, err = t.buf.ReadByte()
+	if err := t.fill(1); err != nil {
+		return Token{}, err
+	}
+
+	token := t.lookahead[0]
+	t.lookahead = t.lookahead[1:]
+
+	return token, nil
+}
+
+// Peek returns the token n places ahead without moving past it, so that
+// Peek(1) is the token the next call to NextToken will return
+func (t *Tokeniser) Peek(n int) (Token, error) {
+	if n < 1 {
+		return Token{}, fmt.Errorf("cannot peek %d tokens ahead", n)
+	}
+
+	if err := t.fill(n); err != nil {
+		return Token{}, err
+	}
+
+	return t.lookahead[n-1], nil
+}
+
+// Unread pushes a token back, to be returned by the next call to NextToken
+func (t *Tokeniser) Unread(token Token) {
+	t.lookahead = append([]Token{token}, t.lookahead...)
+}
+
+// All returns the remaining tokens, ending with EOF
+func (t *Tokeniser) All() ([]Token, error) {
+	tokens := []Token{}
+	for {
+		token, err := t.NextToken()
 		if err != nil {
-			if err != io.EOF {
-				return Token{}, fmt.Errorf("read error getting next char: %w", err)
+			return tokens, err
+		}
+
+		tokens = append(tokens, token)
+		if token.Type == EOF {
+			return tokens, nil
+		}
+	}
+}
+
+// fill scans until there are at least n tokens of lookahead. A scanning
+// error is returned once all the tokens before it have been consumed.
+func (t *Tokeniser) fill(n int) error {
+	for len(t.lookahead) < n {
+		if len(t.lookahead) > 0 && t.lookahead[len(t.lookahead)-1].Type == EOF {
+			t.lookahead = append(t.lookahead, t.lookahead[len(t.lookahead)-1])
+			continue
+		}
+
+		if t.err != nil {
+			return t.err
+		}
+
+		token, err := t.scan()
+		if err != nil {
+			t.err = err
+			continue
+		}
+
+		if token.IsTrivia() {
+			if !t.keepTrivia {
+				continue
 			}
+		} else {
+			t.negation = token.Type == Minus && !endsOperand(t.previous)
+			t.previous = token.Type
+		}
+
+		t.lookahead = append(t.lookahead, token)
+	}
 
-			return Token{
-				Type: EOF,
-			}, nil
+	return nil
+}
+
+func isSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isLetter(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+// scan reads the next token from the input, including trivia
+func (t *Tokeniser) scan() (Token, error) {
+	c, err := t.readRune()
+	if err != nil {
+		if err != io.EOF {
+			return Token{}, err
+		}
+
+		return Token{
+			Type: EOF,
+			Pos:  t.pos(),
+		}, nil
+	}
+
+	pos := t.pos()
+
+	if isSpace(c) {
+		s, err := t.readWhile(c, isSpace)
+		if err != nil {
+			return Token{}, fmt.Errorf("error getting whitespace: %w", err)
 		}
+
+		return Token{Type: Whitespace, Value: s, Pos: pos}, nil
 	}
 
 	var byte2 byte
@@ -100,46 +380,82 @@ func (t *Tokeniser) NextToken() (Token, error) {
 	var token Token
 
 	switch {
+	case c == '{':
+		text, err := t.readComment(pos, string(c), "}")
+		if err != nil {
+			return Token{}, err
+		}
+
+		return Token{Type: Comment, Value: text, Pos: pos}, nil
+
+	case c == '(' && byte2 == '*':
+		if _, err := t.readRune(); err != nil {
+			return Token{}, fmt.Errorf("trying to discard next byte: %w", err)
+		}
+
+		text, err := t.readComment(pos, "(*", "*)")
+		if err != nil {
+			return Token{}, err
+		}
+
+		return Token{Type: Comment, Value: text, Pos: pos}, nil
+
 	case c == '+':
 		token = Token{
 			Type:  Plus,
-			Value: c,
+			Value: byte(c),
 		}
 
 	case c == '-':
 		token = Token{
 			Type:  Minus,
-			Value: c,
+			Value: byte(c),
 		}
 
 	case c == '*':
 		token = Token{
 			Type:  Mult,
-			Value: c,
+			Value: byte(c),
 		}
 
 	case c == '(':
 		token = Token{
 			Type:  LParen,
-			Value: c,
+			Value: byte(c),
 		}
 
 	case c == ')':
 		token = Token{
 			Type:  RParen,
-			Value: c,
+			Value: byte(c),
+		}
+
+	case c == '.' && byte2 == '.':
+		token = Token{
+			Type:  Range,
+			Value: "..",
+		}
+		_, err := t.readRune()
+		if err != nil && err != io.EOF {
+			return Token{}, fmt.Errorf("trying to discard next byte: %w", err)
 		}
 
 	case c == '.':
 		token = Token{
 			Type:  Dot,
-			Value: c,
+			Value: byte(c),
+		}
+
+	case c == ',':
+		token = Token{
+			Type:  Comma,
+			Value: byte(c),
 		}
 
 	case c == ';':
 		token = Token{
 			Type:  Semi,
-			Value: c,
+			Value: byte(c),
 		}
 
 	case c == ':' && byte2 == '=':
@@ -147,53 +463,112 @@ func (t *Tokeniser) NextToken() (Token, error) {
 			Type:  Assign,
 			Value: ":=",
 		}
-		_, err := t.buf.Discard(1)
+		_, err := t.readRune()
 		if err != nil && err != io.EOF {
 			return Token{}, fmt.Errorf("trying to discard next byte: %w", err)
 		}
 
-	case c >= '0' && c <= '9':
-		n, err := t.readNumber(c)
-		if err != nil {
-			return Token{}, fmt.Errorf("error getting number: %w", err)
+	case c == ':':
+		token = Token{
+			Type:  Colon,
+			Value: byte(c),
+		}
+
+	case c == '=':
+		token = Token{
+			Type:  Equal,
+			Value: byte(c),
+		}
+
+	case c == '<' && (byte2 == '>' || byte2 == '='):
+		token = Token{
+			Type:  NotEqual,
+			Value: "<>",
+		}
+		if byte2 == '=' {
+			token = Token{
+				Type:  LessEqual,
+				Value: "<=",
+			}
+		}
+		_, err := t.readRune()
+		if err != nil && err != io.EOF {
+			return Token{}, fmt.Errorf("trying to discard next byte: %w", err)
+		}
+
+	case c == '<':
+		token = Token{
+			Type:  Less,
+			Value: byte(c),
+		}
+
+	case c == '>' && byte2 == '=':
+		token = Token{
+			Type:  GreaterEqual,
+			Value: ">=",
+		}
+		_, err := t.readRune()
+		if err != nil && err != io.EOF {
+			return Token{}, fmt.Errorf("trying to discard next byte: %w", err)
+		}
+
+	case c == '>':
+		token = Token{
+			Type:  Greater,
+			Value: byte(c),
+		}
+
+	case c == '^':
+		token = Token{
+			Type:  Caret,
+			Value: byte(c),
+		}
+
+	case c == '[':
+		token = Token{
+			Type:  LBracket,
+			Value: byte(c),
 		}
 
+	case c == ']':
 		token = Token{
-			Type:  Number,
-			Value: n,
+			Type:  RBracket,
+			Value: byte(c),
 		}
 
-	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_':
+	case isDigit(c):
+		return t.readNumber(c, pos)
+
+	case c == '$':
+		return t.readHex(pos)
+
+	case isLetter(c):
 		id, err := t.readID(c)
 		if err != nil {
 			return Token{}, fmt.Errorf("error geting id: %w", err)
 		}
 
 		if tokenType, ok := reservedWords[strings.ToUpper(id)]; ok {
-			return Token{Type: tokenType, Value: id}, nil
+			return Token{Type: tokenType, Value: id, Pos: pos}, nil
 		}
 
-		return Token{Type: ID, Value: id}, nil
+		return Token{Type: ID, Value: id, Pos: pos}, nil
 	}
 
 	if token.Type == Unknown {
-		return token, fmt.Errorf("unexpected character: %q", c)
+		return token, &Error{Pos: pos, Msg: fmt.Sprintf("unexpected character: %q", c)}
 	}
 
-	t.currentToken = token
+	token.Pos = pos
 
 	return token, nil
 }
 
-func (t *Tokeniser) readID(c byte) (string, error) {
-	first := true
-	var s string
-	for first || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
-		first = false
-		s += string(c)
-
-		var err error
-		c, err = t.buf.ReadByte()
+// readWhile reads c and the runes following it while they match
+func (t *Tokeniser) readWhile(c rune, match func(rune) bool) (string, error) {
+	s := string(c)
+	for {
+		c, err := t.readRune()
 		if err != nil {
 			if err != io.EOF {
 				return "", err
@@ -201,30 +576,238 @@ func (t *Tokeniser) readID(c byte) (string, error) {
 
 			return s, nil
 		}
+
+		if !match(c) {
+			t.unreadRune()
+			return s, nil
+		}
+
+		s += string(c)
 	}
+}
 
-	t.buf.UnreadByte()
+// readComment reads up to and including the closing delimiter of a comment
+// whose opening delimiter has been read
+func (t *Tokeniser) readComment(pos Position, start, end string) (string, error) {
+	s := start
+	for !strings.HasSuffix(s, end) || len(s) < len(start)+len(end) {
+		c, err := t.readRune()
+		if err != nil {
+			if err != io.EOF {
+				return "", err
+			}
+
+			return "", &Error{Pos: pos, Msg: "unterminated comment"}
+		}
+
+		s += string(c)
+	}
 
 	return s, nil
 }
 
-func (t *Tokeniser) readNumber(c byte) (int, error) {
-	var s string
-	for c >= '0' && c <= '9' {
-		s += string(c)
+func (t *Tokeniser) readID(c rune) (string, error) {
+	return t.readWhile(c, func(c rune) bool {
+		return isLetter(c) || isDigit(c)
+	})
+}
+
+// readNumber reads a decimal integer, or a real with a fraction and/or an
+// exponent such as 1.5E-3
+func (t *Tokeniser) readNumber(c rune, pos Position) (Token, error) {
+	s, err := t.readWhile(c, isDigit)
+	if err != nil {
+		return Token{}, err
+	}
+
+	isReal := false
 
-		var err error
-		c, err = t.buf.ReadByte()
+	// 1..5 is a range, not the real 1. followed by .5
+	if next := t.peekBytes(2); len(next) == 2 && next[0] == '.' && isDigit(rune(next[1])) {
+		if _, err := t.readRune(); err != nil {
+			return Token{}, err
+		}
+
+		c, err := t.readRune()
 		if err != nil {
-			if err != io.EOF {
-				return 0, err
-			}
+			return Token{}, err
+		}
+
+		frac, err := t.readWhile(c, isDigit)
+		if err != nil {
+			return Token{}, err
+		}
+		s += "." + frac
+		isReal = true
+	}
+
+	if exp, err := t.readExponent(); err != nil {
+		return Token{}, err
+	} else if exp != "" {
+		s += exp
+		isReal = true
+	}
+
+	if isReal {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return Token{}, &Error{Pos: pos, Msg: fmt.Sprintf("real literal %s is out of range", s)}
+		}
+
+		return Token{Type: Real, Value: f, Pos: pos}, nil
+	}
+
+	return t.integer(s, 10, s, pos)
+}
+
+// integer makes the token of an integer literal, whose value is an int, or
+// a *big.Int if it is too large for an int. A literal negated by a unary
+// minus may be as large as the most negative INTEGER.
+func (t *Tokeniser) integer(digits string, base int, text string, pos Position) (Token, error) {
+	n, _ := new(big.Int).SetString(digits, base)
+
+	max := t.max
+	if t.negation && t.min != nil {
+		max = new(big.Int).Neg(t.min)
+	}
+	if max != nil && n.Cmp(max) > 0 {
+		return Token{}, &Error{Pos: pos, Msg: fmt.Sprintf("integer literal %s is out of range", text)}
+	}
+
+	token := Token{Type: Number, Value: n, Pos: pos, Text: text}
+	if n.IsInt64() && int64(int(n.Int64())) == n.Int64() {
+		token.Value = int(n.Int64())
+	}
+
+	return token, nil
+}
 
-			return strconv.Atoi(s)
+// readExponent reads an exponent such as E-3, if one follows
+func (t *Tokeniser) readExponent() (string, error) {
+	next := t.peekBytes(3)
+	if len(next) < 2 || (next[0] != 'e' && next[0] != 'E') {
+		return "", nil
+	}
+
+	n := 1
+	if next[1] == '+' || next[1] == '-' {
+		n = 2
+	}
+
+	if len(next) <= n || !isDigit(rune(next[n])) {
+		return "", nil
+	}
+
+	s := ""
+	for ; n >= 0; n-- {
+		c, err := t.readRune()
+		if err != nil {
+			return "", err
 		}
+		s += string(c)
+	}
+
+	digits, err := t.readWhile(rune(s[len(s)-1]), isDigit)
+	if err != nil {
+		return "", err
+	}
+
+	return s[:len(s)-1] + digits, nil
+}
+
+// readHex reads the digits of a hex literal such as $FF, after the $
+func (t *Tokeniser) readHex(pos Position) (Token, error) {
+	next := t.peekBytes(1)
+	if len(next) == 0 || !isHexDigit(rune(next[0])) {
+		return Token{}, &Error{Pos: pos, Msg: "expected hex digits after $"}
 	}
 
-	t.buf.UnreadByte()
+	c, err := t.readRune()
+	if err != nil {
+		return Token{}, err
+	}
+
+	s, err := t.readWhile(c, isHexDigit)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return t.integer(s, 16, "$"+s, pos)
+}
+
+// endsOperand is true for the tokens which can end an operand, after which
+// a minus subtracts rather than negates
+func endsOperand(tt TokenType) bool {
+	switch tt {
+	case Number, Real, ID, RParen, RBracket, Caret, Nil:
+		return true
+	}
 
-	return strconv.Atoi(s)
+	return false
+}
+
+func isHexDigit(c rune) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// peekBytes returns up to n bytes of the input without consuming them
+func (t *Tokeniser) peekBytes(n int) []byte {
+	next, _ := t.buf.Peek(n)
+
+	return next
+}
+
+// pos is the position of the most recently read byte. A newline belongs at
+// the end of the line it terminates.
+func (t *Tokeniser) pos() Position {
+	if t.column == 0 && t.line > 1 {
+		return Position{Line: t.line - 1, Column: t.lastColumn + 1}
+	}
+
+	return Position{Line: t.line, Column: t.column}
+}
+
+// readRune reads the next UTF-8 encoded rune, skipping a byte order mark at
+// the start of the input
+func (t *Tokeniser) readRune() (rune, error) {
+	c, size, err := t.buf.ReadRune()
+	if err != nil {
+		if err != io.EOF {
+			return c, fmt.Errorf("read error getting next char: %w", err)
+		}
+
+		return c, err
+	}
+
+	if !t.started {
+		t.started = true
+		if c == '\uFEFF' {
+			return t.readRune()
+		}
+	}
+
+	t.lastColumn = t.column
+	if c == '\n' {
+		t.line++
+		t.column = 0
+	} else {
+		t.column++
+	}
+
+	if c == utf8.RuneError && size == 1 {
+		return c, &Error{Pos: t.pos(), Msg: "invalid UTF-8 encoding"}
+	}
+
+	return c, nil
+}
+
+func (t *Tokeniser) unreadRune() {
+	if err := t.buf.UnreadRune(); err != nil {
+		return
+	}
+
+	if t.column == 0 {
+		t.line--
+	}
+	t.column = t.lastColumn
 }
@@ -1,6 +1,8 @@
 package lexer_test
 
 import (
+	"math"
+	"math/big"
 	"strings"
 
 	"github.com/kieron-dev/lsbasi/lexer"
@@ -20,9 +22,14 @@ var _ = DescribeTable("individual tokens", func(expr string, t lexer.TokenType,
 },
 
 	Entry("multi-digit number", "31", lexer.Number, 31),
+	Entry("hex number", "$1F", lexer.Number, 31),
+	Entry("real", "3.25", lexer.Real, 3.25),
+	Entry("real with exponent", "1.5E-3", lexer.Real, 0.0015),
+	Entry("real with only an exponent", "2e+2", lexer.Real, 200.0),
 	Entry("minus", "-", lexer.Minus, nil),
 	Entry("mult", "*", lexer.Mult, nil),
 	Entry("div", "div", lexer.Div, nil),
+	Entry("mod", "Mod", lexer.Mod, nil),
 	Entry("lparen", "(", lexer.LParen, nil),
 	Entry("rparen", ")", lexer.RParen, nil),
 	Entry("begin", "BEGIN", lexer.Begin, nil),
@@ -30,9 +37,32 @@ var _ = DescribeTable("individual tokens", func(expr string, t lexer.TokenType,
 	Entry("end", "END", lexer.End, nil),
 	Entry("an ID", "foo8", lexer.ID, "foo8"),
 	Entry("an ID with _", "_foo8", lexer.ID, "_foo8"),
+	Entry("an ID with inner _", "my_var_2", lexer.ID, "my_var_2"),
+	Entry("an ID after a BOM", "\uFEFFfoo", lexer.ID, "foo"),
 	Entry("dot", ".", lexer.Dot, nil),
 	Entry("semi", ";", lexer.Semi, nil),
 	Entry("assignment", ":=", lexer.Assign, nil),
+	Entry("range", "..", lexer.Range, nil),
+	Entry("colon", ":", lexer.Colon, nil),
+	Entry("comma", ",", lexer.Comma, nil),
+	Entry("case", "case", lexer.Case, nil),
+	Entry("of", "OF", lexer.Of, nil),
+	Entry("else", "Else", lexer.Else, nil),
+	Entry("set", "SET", lexer.Set, nil),
+	Entry("caret", "^", lexer.Caret, nil),
+	Entry("nil", "nil", lexer.Nil, nil),
+	Entry("record", "Record", lexer.Record, nil),
+	Entry("procedure", "PROCEDURE", lexer.Procedure, nil),
+	Entry("function", "function", lexer.Function, nil),
+	Entry("array", "ARRAY", lexer.Array, nil),
+	Entry("in", "in", lexer.In, nil),
+	Entry("left bracket", "[", lexer.LBracket, nil),
+	Entry("right bracket", "]", lexer.RBracket, nil),
+	Entry("not equal", "<>", lexer.NotEqual, "<>"),
+	Entry("less", "<", lexer.Less, nil),
+	Entry("less or equal", "<=", lexer.LessEqual, "<="),
+	Entry("greater", ">", lexer.Greater, nil),
+	Entry("greater or equal", ">=", lexer.GreaterEqual, ">="),
 )
 
 var _ = Describe("Tokeniser", func() {
@@ -56,6 +86,8 @@ var _ = Describe("Tokeniser", func() {
 			Expect(token).To(Equal(lexer.Token{
 				Type:  lexer.Number,
 				Value: 3,
+				Pos:   lexer.Position{Line: 1, Column: 1},
+				Text:  "3",
 			}))
 
 			token, err = tokeniser.NextToken()
@@ -63,6 +95,7 @@ var _ = Describe("Tokeniser", func() {
 			Expect(token).To(Equal(lexer.Token{
 				Type:  lexer.Plus,
 				Value: byte('+'),
+				Pos:   lexer.Position{Line: 1, Column: 2},
 			}))
 
 			token, err = tokeniser.NextToken()
@@ -70,12 +103,15 @@ var _ = Describe("Tokeniser", func() {
 			Expect(token).To(Equal(lexer.Token{
 				Type:  lexer.Number,
 				Value: 5,
+				Pos:   lexer.Position{Line: 1, Column: 3},
+				Text:  "5",
 			}))
 
 			token, err = tokeniser.NextToken()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(token).To(Equal(lexer.Token{
 				Type: lexer.EOF,
+				Pos:  lexer.Position{Line: 1, Column: 3},
 			}))
 		})
 
@@ -95,6 +131,42 @@ var _ = Describe("Tokeniser", func() {
 			})
 		})
 
+		Context("a number followed by a range", func() {
+			BeforeEach(func() {
+				expr = "4..9"
+			})
+
+			It("does not treat the dots as part of the number", func() {
+				for _, e := range []lexer.TokenType{lexer.Number, lexer.Range, lexer.Number, lexer.EOF} {
+					t, err := tokeniser.NextToken()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(t.Type).To(Equal(e))
+				}
+			})
+		})
+
+		Context("positions", func() {
+			BeforeEach(func() {
+				expr = "BEGIN\n  a := 10\nEND"
+			})
+
+			It("records the line and column of each token", func() {
+				expected := []lexer.Position{
+					{Line: 1, Column: 1},
+					{Line: 2, Column: 3},
+					{Line: 2, Column: 5},
+					{Line: 2, Column: 8},
+					{Line: 3, Column: 1},
+				}
+
+				for _, e := range expected {
+					t, err := tokeniser.NextToken()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(t.Pos).To(Equal(e))
+				}
+			})
+		})
+
 		Context("invalid input", func() {
 			BeforeEach(func() {
 				expr = "&asdf"
@@ -113,16 +185,16 @@ var _ = Describe("Tokeniser", func() {
 
 			It("next functions as expected", func() {
 				expected := []lexer.Token{
-					{Type: lexer.Begin, Value: "BEGIN"},
-					{Type: lexer.ID, Value: "a"},
-					{Type: lexer.Assign, Value: ":="},
-					{Type: lexer.Number, Value: 3},
-					{Type: lexer.Mult, Value: byte('*')},
-					{Type: lexer.Minus, Value: byte('-')},
-					{Type: lexer.Number, Value: 9},
-					{Type: lexer.Semi, Value: byte(';')},
-					{Type: lexer.End, Value: "END"},
-					{Type: lexer.EOF, Value: nil},
+					{Type: lexer.Begin, Value: "BEGIN", Pos: lexer.Position{Line: 1, Column: 1}},
+					{Type: lexer.ID, Value: "a", Pos: lexer.Position{Line: 1, Column: 7}},
+					{Type: lexer.Assign, Value: ":=", Pos: lexer.Position{Line: 1, Column: 9}},
+					{Type: lexer.Number, Value: 3, Pos: lexer.Position{Line: 1, Column: 12}, Text: "3"},
+					{Type: lexer.Mult, Value: byte('*'), Pos: lexer.Position{Line: 1, Column: 14}},
+					{Type: lexer.Minus, Value: byte('-'), Pos: lexer.Position{Line: 1, Column: 16}},
+					{Type: lexer.Number, Value: 9, Pos: lexer.Position{Line: 1, Column: 18}, Text: "9"},
+					{Type: lexer.Semi, Value: byte(';'), Pos: lexer.Position{Line: 1, Column: 19}},
+					{Type: lexer.End, Value: "END", Pos: lexer.Position{Line: 1, Column: 21}},
+					{Type: lexer.EOF, Value: nil, Pos: lexer.Position{Line: 1, Column: 23}},
 				}
 
 				for _, e := range expected {
@@ -134,3 +206,192 @@ var _ = Describe("Tokeniser", func() {
 		})
 	})
 })
+
+var _ = Describe("Token streams", func() {
+	types := func(tokens []lexer.Token) []lexer.TokenType {
+		tt := []lexer.TokenType{}
+		for _, t := range tokens {
+			tt = append(tt, t.Type)
+		}
+
+		return tt
+	}
+
+	It("returns all the tokens, skipping comments", func() {
+		tokeniser := lexer.NewTokeniser(strings.NewReader("a { set a } := (* to\n 1 *) 1"))
+		tokens, err := tokeniser.All()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(types(tokens)).To(Equal([]lexer.TokenType{lexer.ID, lexer.Assign, lexer.Number, lexer.EOF}))
+		Expect(tokens[2].Pos).To(Equal(lexer.Position{Line: 2, Column: 7}))
+	})
+
+	It("keeps whitespace and comments as trivia when asked", func() {
+		tokeniser := lexer.NewTokeniser(strings.NewReader("a {x}\n\t:= 1"), lexer.KeepTrivia())
+		tokens, err := tokeniser.All()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tokens).To(Equal([]lexer.Token{
+			{Type: lexer.ID, Value: "a", Pos: lexer.Position{Line: 1, Column: 1}},
+			{Type: lexer.Whitespace, Value: " ", Pos: lexer.Position{Line: 1, Column: 2}},
+			{Type: lexer.Comment, Value: "{x}", Pos: lexer.Position{Line: 1, Column: 3}},
+			{Type: lexer.Whitespace, Value: "\n\t", Pos: lexer.Position{Line: 1, Column: 6}},
+			{Type: lexer.Assign, Value: ":=", Pos: lexer.Position{Line: 2, Column: 2}},
+			{Type: lexer.Whitespace, Value: " ", Pos: lexer.Position{Line: 2, Column: 4}},
+			{Type: lexer.Number, Value: 1, Pos: lexer.Position{Line: 2, Column: 5}, Text: "1"},
+			{Type: lexer.EOF, Pos: lexer.Position{Line: 2, Column: 5}},
+		}))
+		Expect(tokens[1].IsTrivia()).To(BeTrue())
+		Expect(tokens[0].IsTrivia()).To(BeFalse())
+	})
+
+	It("reports unterminated comments", func() {
+		tokeniser := lexer.NewTokeniser(strings.NewReader("a (* b *"))
+		_, err := tokeniser.All()
+		Expect(err).To(MatchError("unterminated comment at 1:3"))
+	})
+
+	It("does not close a comment with the opening star", func() {
+		tokeniser := lexer.NewTokeniser(strings.NewReader("(*) a *) b"))
+		tokens, err := tokeniser.All()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tokens[0].Value).To(Equal("b"))
+	})
+
+	Describe("lookahead", func() {
+		var tokeniser *lexer.Tokeniser
+
+		BeforeEach(func() {
+			tokeniser = lexer.NewTokeniser(strings.NewReader("a := b"))
+		})
+
+		It("peeks without consuming", func() {
+			t, err := tokeniser.Peek(2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Type).To(Equal(lexer.Assign))
+
+			t, err = tokeniser.Peek(1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Type).To(Equal(lexer.ID))
+
+			t, err = tokeniser.NextToken()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Value).To(Equal("a"))
+		})
+
+		It("peeks past the end as EOF", func() {
+			t, err := tokeniser.Peek(10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Type).To(Equal(lexer.EOF))
+
+			tokens, err := tokeniser.All()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokens).To(HaveLen(4))
+		})
+
+		It("rejects peeking at the current position", func() {
+			_, err := tokeniser.Peek(0)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns unread tokens first", func() {
+			first, err := tokeniser.NextToken()
+			Expect(err).NotTo(HaveOccurred())
+			tokeniser.Unread(first)
+
+			tokens, err := tokeniser.All()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(types(tokens)).To(Equal([]lexer.TokenType{lexer.ID, lexer.Assign, lexer.ID, lexer.EOF}))
+		})
+
+		It("returns the tokens before a lexical error", func() {
+			tokeniser = lexer.NewTokeniser(strings.NewReader("a & b"))
+			_, err := tokeniser.Peek(2)
+			Expect(err).To(MatchError(ContainSubstring("unexpected character")))
+
+			t, err := tokeniser.NextToken()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.Value).To(Equal("a"))
+
+			_, err = tokeniser.NextToken()
+			Expect(err).To(MatchError(ContainSubstring("unexpected character")))
+		})
+	})
+})
+
+var _ = Describe("Unicode source", func() {
+	It("treats CRLF line endings and form feeds as whitespace", func() {
+		tokeniser := lexer.NewTokeniser(strings.NewReader("BEGIN\r\n\fa := 1\r\nEND"))
+		tokens, err := tokeniser.All()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tokens).To(HaveLen(6))
+		Expect(tokens[1].Pos).To(Equal(lexer.Position{Line: 2, Column: 2}))
+		Expect(tokens[4].Pos).To(Equal(lexer.Position{Line: 3, Column: 1}))
+	})
+
+	It("counts columns in characters rather than bytes", func() {
+		tokeniser := lexer.NewTokeniser(strings.NewReader("{ größe } a"))
+		t, err := tokeniser.NextToken()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Pos).To(Equal(lexer.Position{Line: 1, Column: 11}))
+	})
+
+	It("keeps non-ASCII comments intact", func() {
+		tokeniser := lexer.NewTokeniser(strings.NewReader("(* 日本語 *)"), lexer.KeepTrivia())
+		t, err := tokeniser.NextToken()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t.Value).To(Equal("(* 日本語 *)"))
+	})
+
+	It("rejects non-ASCII letters outside comments", func() {
+		tokeniser := lexer.NewTokeniser(strings.NewReader("a := é"))
+		_, err := tokeniser.All()
+		Expect(err).To(MatchError("unexpected character: 'é' at 1:6"))
+	})
+
+	It("rejects invalid UTF-8", func() {
+		tokeniser := lexer.NewTokeniser(strings.NewReader("a { \xff }"))
+		_, err := tokeniser.All()
+		Expect(err).To(MatchError("invalid UTF-8 encoding at 1:5"))
+	})
+})
+
+var _ = DescribeTable("invalid numbers", func(expr string, msg string) {
+	tokeniser := lexer.NewTokeniser(strings.NewReader(expr))
+	_, err := tokeniser.All()
+	Expect(err).To(MatchError(msg))
+},
+
+	Entry("real overflow", "  1E999", "real literal 1E999 is out of range at 1:3"),
+	Entry("missing hex digits", "$G", "expected hex digits after $ at 1:1"),
+	Entry("integer overflow", "99999999999999999999", "integer literal 99999999999999999999 is out of range at 1:1"),
+	Entry("hex overflow", "$1FFFFFFFFFFFFFFFF", "integer literal $1FFFFFFFFFFFFFFFF is out of range at 1:1"),
+	Entry("most negative INTEGER without a minus", "9223372036854775808", "integer literal 9223372036854775808 is out of range at 1:1"),
+	Entry("most negative INTEGER subtracted", "1 - 9223372036854775808", "integer literal 9223372036854775808 is out of range at 1:5"),
+	Entry("overflow in code which never runs", "CASE 0 OF 1: x := 99999999999999999999 END", "integer literal 99999999999999999999 is out of range at 1:19"),
+)
+
+var _ = DescribeTable("integer ranges", func(min, max *big.Int, expr string, v interface{}, msg string) {
+	tokeniser := lexer.NewTokeniser(strings.NewReader(expr), lexer.WithIntegerRange(min, max))
+	tokens, err := tokeniser.All()
+	if msg != "" {
+		Expect(err).To(MatchError(msg))
+		return
+	}
+
+	Expect(err).NotTo(HaveOccurred())
+	Expect(tokens[len(tokens)-2].Value).To(Equal(v))
+},
+
+	Entry("16-bit in range", big.NewInt(-32768), big.NewInt(32767), "32767", 32767, ""),
+	Entry("16-bit out of range", big.NewInt(-32768), big.NewInt(32767), "32768", nil, "integer literal 32768 is out of range at 1:1"),
+	Entry("16-bit most negative", big.NewInt(-32768), big.NewInt(32767), "-32768", 32768, ""),
+	Entry("16-bit negative out of range", big.NewInt(-32768), big.NewInt(32767), "x := -$8001", nil, "integer literal $8001 is out of range at 1:7"),
+	Entry("most negative INTEGER negated", big.NewInt(math.MinInt64), big.NewInt(math.MaxInt64), "-9223372036854775808", bigInt("9223372036854775808", 10), ""),
+	Entry("unbounded", nil, nil, "99999999999999999999", bigInt("99999999999999999999", 10), ""),
+	Entry("unbounded hex", nil, nil, "$1FFFFFFFFFFFFFFFF", bigInt("1FFFFFFFFFFFFFFFF", 16), ""),
+)
+
+func bigInt(digits string, base int) *big.Int {
+	n, _ := new(big.Int).SetString(digits, base)
+
+	return n
+}
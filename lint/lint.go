@@ -0,0 +1,189 @@
+// Package lint warns about suspicious but legal code in a program
+package lint
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/flow"
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// Kind names a check. It is the name used to suppress its warnings.
+type Kind string
+
+const (
+	AssignedNotRead  Kind = "assigned-not-read"
+	ReadBeforeAssign Kind = "read-before-assign"
+	Unreachable      Kind = "unreachable"
+	EmptyBlock       Kind = "empty-block"
+	Shadow           Kind = "shadow"
+	UnusedProcedure  Kind = "unused-procedure"
+)
+
+// Kinds lists every check
+var Kinds = []Kind{
+	AssignedNotRead,
+	ReadBeforeAssign,
+	Unreachable,
+	EmptyBlock,
+	Shadow,
+	UnusedProcedure,
+}
+
+// Error is a malformed lint directive at a position in the source
+type Error struct {
+	Pos lexer.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at %s", e.Msg, e.Pos)
+}
+
+// Warning is a problem found by a check at a position in the source
+type Warning struct {
+	Pos  lexer.Position
+	Kind Kind
+	Msg  string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s (%s)", w.Pos, w.Msg, w.Kind)
+}
+
+// Lint parses a program and returns its warnings in source order.
+//
+// Warnings are suppressed by a comment directive. { lint:ignore kind ... }
+// suppresses the kinds named on the line of the comment and the line after
+// it, and { lint:ignore-file kind ... } suppresses them everywhere.
+func Lint(src io.Reader) ([]Warning, error) {
+	text, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := lexer.NewTokeniser(strings.NewReader(string(text)), lexer.KeepTrivia()).All()
+	if err != nil {
+		return nil, err
+	}
+	ignore, err := directives(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	program, err := parser.NewParser(lexer.NewTokeniser(strings.NewReader(string(text)))).Program()
+	if err != nil {
+		return nil, err
+	}
+
+	l := newLinter()
+	if _, err := program.Accept(l); err != nil {
+		return nil, err
+	}
+
+	for _, r := range flow.Unassigned(program) {
+		l.warn(r.Pos, ReadBeforeAssign, "%s", r.Message())
+	}
+
+	var warnings []Warning
+	for _, w := range l.warnings {
+		if !ignore.suppresses(w) {
+			warnings = append(warnings, w)
+		}
+	}
+
+	sort.SliceStable(warnings, func(a, b int) bool {
+		pa, pb := warnings[a].Pos, warnings[b].Pos
+		if pa.Line != pb.Line {
+			return pa.Line < pb.Line
+		}
+		return pa.Column < pb.Column
+	})
+
+	return warnings, nil
+}
+
+// suppressions holds the kinds ignored by directives, for the whole file
+// and by line
+type suppressions struct {
+	file  map[Kind]bool
+	lines map[int]map[Kind]bool
+}
+
+func (s suppressions) suppresses(w Warning) bool {
+	return s.file[w.Kind] || s.lines[w.Pos.Line][w.Kind]
+}
+
+// directives reads the lint directives from the comments in the source
+func directives(tokens []lexer.Token) (suppressions, error) {
+	s := suppressions{
+		file:  map[Kind]bool{},
+		lines: map[int]map[Kind]bool{},
+	}
+
+	for _, token := range tokens {
+		if token.Type != lexer.Comment {
+			continue
+		}
+
+		fields := strings.Fields(commentText(token.Value.(string)))
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "lint:") {
+			continue
+		}
+
+		var kinds []Kind
+		for _, f := range fields[1:] {
+			kind := Kind(strings.ToLower(f))
+			if !known(kind) {
+				return s, &Error{Pos: token.Pos, Msg: fmt.Sprintf("unknown lint check %q", f)}
+			}
+			kinds = append(kinds, kind)
+		}
+
+		switch fields[0] {
+		case "lint:ignore":
+			for _, line := range []int{token.Pos.Line, token.Pos.Line + 1} {
+				if s.lines[line] == nil {
+					s.lines[line] = map[Kind]bool{}
+				}
+				for _, kind := range kinds {
+					s.lines[line][kind] = true
+				}
+			}
+
+		case "lint:ignore-file":
+			for _, kind := range kinds {
+				s.file[kind] = true
+			}
+
+		default:
+			return s, &Error{Pos: token.Pos, Msg: fmt.Sprintf("unknown lint directive %q", fields[0])}
+		}
+	}
+
+	return s, nil
+}
+
+// commentText strips the delimiters from a comment
+func commentText(comment string) string {
+	if strings.HasPrefix(comment, "{") {
+		return strings.TrimSuffix(strings.TrimPrefix(comment, "{"), "}")
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(comment, "(*"), "*)")
+}
+
+func known(kind Kind) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
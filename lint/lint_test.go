@@ -0,0 +1,238 @@
+package lint_test
+
+import (
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lint"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lint", func() {
+	warnings := func(source string) []string {
+		found, err := lint.Lint(strings.NewReader(source))
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+		var s []string
+		for _, w := range found {
+			s = append(s, w.String())
+		}
+		return s
+	}
+
+	It("has nothing to say about a tidy program", func() {
+		Expect(warnings(`PROCEDURE Double(VAR n: INTEGER);
+VAR t: INTEGER;
+BEGIN
+  t := n * 2;
+  n := t
+END;
+BEGIN
+  x := 4;
+  Double(x)
+END.`)).To(BeEmpty())
+	})
+
+	DescribeTable("warnings",
+		func(source string, expected ...string) {
+			if len(expected) == 0 {
+				Expect(warnings(source)).To(BeEmpty())
+				return
+			}
+			Expect(warnings(source)).To(Equal(expected))
+		},
+
+		Entry("local variable assigned but never read", `PROCEDURE P;
+VAR a, b: INTEGER;
+BEGIN
+  a := 1;
+  b := 2;
+  c := a
+END;
+BEGIN P END.`,
+			`2:8: "b" is assigned but never read (assigned-not-read)`,
+			`6:3: "c" is assigned but never read (assigned-not-read)`,
+		),
+
+		Entry("global variables are the results of the program", `BEGIN x := 1 END.`),
+
+		Entry("variable read before it is assigned", `VAR a, b: INTEGER;
+BEGIN
+  b := a + a;
+  a := 1;
+  b := a
+END.`,
+			`3:8: "a" is read before it is assigned (read-before-assign)`,
+		),
+
+		Entry("read on some paths", `VAR x: INTEGER;
+PROCEDURE P;
+VAR sum: INTEGER;
+BEGIN
+  FOR i := 1 TO 3 DO sum := sum + i;
+  x := sum
+END;
+BEGIN P END.`,
+			`5:29: "sum" may be read before it is assigned when the FOR loop at 5:3 runs (read-before-assign)`,
+			`6:8: "sum" may be read before it is assigned when the FOR loop at 5:3 runs no times (read-before-assign)`,
+		),
+
+		Entry("globals read in procedures and VAR arguments are not checked", `VAR g, h: INTEGER;
+PROCEDURE Store(VAR n: INTEGER);
+BEGIN n := g END;
+BEGIN
+  g := 1;
+  Store(h);
+  x := h
+END.`),
+
+		Entry("CASE branch on a constant-false condition", `CONST debug = 0;
+BEGIN
+  CASE Ord(debug > 0) OF
+    1: x := 1;
+    0: x := 2
+  END
+END.`,
+			`4:5: unreachable CASE branch: the selector is always 0 (unreachable)`,
+		),
+
+		Entry("ELSE after a branch which is always taken", `TYPE colour = (red, green);
+BEGIN
+  CASE green OF
+    red: x := 1;
+    green: x := 2
+  ELSE
+    x := 3
+  END
+END.`,
+			`4:5: unreachable CASE branch: the selector is always 1 (unreachable)`,
+			`6:3: unreachable ELSE: the selector is always 1 (unreachable)`,
+		),
+
+		Entry("CASE on a variable", `BEGIN
+  n := 1;
+  CASE n OF 1: x := 1; 2: x := 2 END
+END.`),
+
+		Entry("FOR which never runs", `CONST n = 0;
+BEGIN
+  x := 0;
+  FOR i := 1 TO n DO x := x + i;
+  FOR i := 1 DOWNTO 2 DO x := x + i
+END.`,
+			`4:3: unreachable FOR body: 1 TO 0 never runs (unreachable)`,
+			`5:3: unreachable FOR body: 1 DOWNTO 2 never runs (unreachable)`,
+		),
+
+		Entry("empty blocks", `PROCEDURE P;
+BEGIN
+END;
+BEGIN
+  P;
+  CASE 1 OF 1: BEGIN ; END END
+END.`,
+			`2:1: empty BEGIN END block (empty-block)`,
+			`6:16: empty BEGIN END block (empty-block)`,
+		),
+
+		Entry("shadowed identifiers", `CONST n = 3;
+VAR total, x: INTEGER;
+PROCEDURE Outer(n: INTEGER);
+VAR total: INTEGER;
+  PROCEDURE Inner;
+  CONST total = 1;
+  BEGIN x := total END;
+BEGIN
+  Inner;
+  total := n;
+  x := total
+END;
+BEGIN
+  total := 0;
+  Outer(n)
+END.`,
+			`3:17: "n" shadows the constant declared at 1:7 (shadow)`,
+			`4:5: "total" shadows the variable declared at 2:5 (shadow)`,
+			`6:9: "total" shadows the variable declared at 4:5 (shadow)`,
+		),
+
+		Entry("procedures and functions never called", `VAR x: INTEGER;
+PROCEDURE Unused;
+BEGIN x := 1 END;
+FUNCTION Fact(n: INTEGER): INTEGER;
+BEGIN
+  CASE n OF
+    0: Fact := 1
+  ELSE
+    Fact := n * Fact(n - 1)
+  END
+END;
+FUNCTION Used: INTEGER;
+BEGIN Used := 1 END;
+BEGIN
+  x := Used
+END.`,
+			`2:11: procedure "Unused" is never called (unused-procedure)`,
+			`4:10: function "Fact" is never called (unused-procedure)`,
+		),
+	)
+
+	Describe("directives", func() {
+		source := `PROCEDURE P;
+VAR a: INTEGER;
+BEGIN
+  %s
+  b := a;
+  a := b
+END;
+BEGIN
+END.`
+
+		DescribeTable("suppressing warnings",
+			func(directive string, expected ...string) {
+				Expect(warnings(strings.Replace(source, "%s", directive, 1))).To(Equal(expected))
+			},
+
+			Entry("none", "",
+				`1:11: procedure "P" is never called (unused-procedure)`,
+				`5:8: "a" is read before it is assigned (read-before-assign)`,
+				`8:1: empty BEGIN END block (empty-block)`,
+			),
+
+			Entry("on the next line", "{ lint:ignore read-before-assign }",
+				`1:11: procedure "P" is never called (unused-procedure)`,
+				`8:1: empty BEGIN END block (empty-block)`,
+			),
+
+			Entry("another kind on the next line", "{ lint:ignore shadow }",
+				`1:11: procedure "P" is never called (unused-procedure)`,
+				`5:8: "a" is read before it is assigned (read-before-assign)`,
+				`8:1: empty BEGIN END block (empty-block)`,
+			),
+
+			Entry("in the whole file", "(* lint:ignore-file empty-block unused-procedure *)",
+				`5:8: "a" is read before it is assigned (read-before-assign)`,
+			),
+		)
+
+		It("suppresses warnings on the line of the comment", func() {
+			Expect(warnings(`BEGIN CASE 0 OF 1: x := 1 END { lint:ignore unreachable } END.`)).To(BeEmpty())
+		})
+
+		DescribeTable("malformed directives",
+			func(directive, msg string) {
+				_, err := lint.Lint(strings.NewReader(directive + " BEGIN x := 1 END."))
+				Expect(err).To(MatchError(msg))
+			},
+
+			Entry("unknown check", "{ lint:ignore everything }", `unknown lint check "everything" at 1:1`),
+			Entry("unknown directive", "{ lint:disable shadow }", `unknown lint directive "lint:disable" at 1:1`),
+		)
+	})
+
+	It("reports syntax errors", func() {
+		_, err := lint.Lint(strings.NewReader("BEGIN x := END."))
+		Expect(err).To(HaveOccurred())
+	})
+})
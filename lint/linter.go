@@ -0,0 +1,682 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+type symbolKind int
+
+const (
+	variable symbolKind = iota
+	parameter
+	constant
+	typeName
+	procedure
+	function
+)
+
+func (k symbolKind) String() string {
+	return []string{
+		"variable",
+		"parameter",
+		"constant",
+		"type",
+		"procedure",
+		"function",
+	}[k]
+}
+
+// symbol is a declared name and what the program does with it. byRef marks
+// the VAR parameters of a procedure.
+type symbol struct {
+	name     string
+	kind     symbolKind
+	pos      lexer.Position
+	value    int
+	hasValue bool
+	byRef    []bool
+	assigned bool
+	read     bool
+	called   bool
+}
+
+// scope holds the symbols declared in a block, in order. The variables of
+// a local scope belong to a procedure; the others are the results of the
+// program.
+type scope struct {
+	symbols map[string]*symbol
+	order   []*symbol
+	parent  *scope
+	local   bool
+}
+
+// linter walks the AST in source order, recording what is read, assigned
+// and called. Reads before assignment are found by the flow package.
+type linter struct {
+	scope    *scope
+	procs    []*symbol
+	warnings []Warning
+}
+
+func newLinter() *linter {
+	return &linter{
+		scope: &scope{symbols: map[string]*symbol{}},
+	}
+}
+
+func (l *linter) warn(pos lexer.Position, kind Kind, format string, args ...interface{}) {
+	l.warnings = append(l.warnings, Warning{Pos: pos, Kind: kind, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) lookup(name string) (*symbol, bool) {
+	name = strings.ToLower(name)
+	for s := l.scope; s != nil; s = s.parent {
+		if sym, ok := s.symbols[name]; ok {
+			return sym, true
+		}
+	}
+
+	return nil, false
+}
+
+// define declares a symbol in the current scope, warning if it hides one
+// declared in an enclosing scope
+func (l *linter) define(sym *symbol) {
+	name := strings.ToLower(sym.name)
+	for s := l.scope.parent; s != nil; s = s.parent {
+		if prev, ok := s.symbols[name]; ok {
+			l.warn(sym.pos, Shadow, "%q shadows the %s declared at %s", sym.name, prev.kind, prev.pos)
+			break
+		}
+	}
+
+	l.scope.symbols[name] = sym
+	l.scope.order = append(l.scope.order, sym)
+}
+
+// inside is true while linting the body of the procedure
+func (l *linter) inside(sym *symbol) bool {
+	for _, p := range l.procs {
+		if p == sym {
+			return true
+		}
+	}
+
+	return false
+}
+
+// call records a call of a procedure. Recursive calls do not count.
+func (l *linter) call(sym *symbol) {
+	if !l.inside(sym) {
+		sym.called = true
+	}
+}
+
+func (l *linter) VisitBlock(node *parser.BlockNode) (interface{}, error) {
+	for _, decl := range node.Consts {
+		if _, err := decl.Accept(l); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, decl := range node.Types {
+		if _, err := decl.Accept(l); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, decl := range node.Vars {
+		if _, err := decl.Accept(l); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, decl := range node.Procs {
+		if _, err := decl.Accept(l); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := node.Compound.Accept(l); err != nil {
+		return nil, err
+	}
+
+	l.unused()
+
+	return nil, nil
+}
+
+// unused warns about the variables and procedures of the current scope
+// which were never used
+func (l *linter) unused() {
+	for _, sym := range l.scope.order {
+		switch sym.kind {
+		case variable:
+			if l.scope.local && sym.assigned && !sym.read {
+				l.warn(sym.pos, AssignedNotRead, "%q is assigned but never read", sym.name)
+			}
+
+		case procedure, function:
+			if !sym.called {
+				l.warn(sym.pos, UnusedProcedure, "%s %q is never called", sym.kind, sym.name)
+			}
+		}
+	}
+}
+
+func (l *linter) VisitUnit(node *parser.UnitNode) (interface{}, error) {
+	for _, section := range []*parser.UnitSection{node.Interface, node.Implementation} {
+		decls := []parser.ASTNode{}
+		for _, decl := range section.Consts {
+			decls = append(decls, decl)
+		}
+		for _, decl := range section.Types {
+			decls = append(decls, decl)
+		}
+		for _, decl := range section.Vars {
+			decls = append(decls, decl)
+		}
+		for _, decl := range section.Procs {
+			decls = append(decls, decl)
+		}
+
+		for _, decl := range decls {
+			if _, err := decl.Accept(l); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (l *linter) VisitConstDecl(node *parser.ConstDeclNode) (interface{}, error) {
+	if _, err := node.Value.Accept(l); err != nil {
+		return nil, err
+	}
+
+	value, ok := l.constant(node.Value)
+	l.define(&symbol{name: node.Name, kind: constant, pos: node.Pos, value: value, hasValue: ok})
+
+	return nil, nil
+}
+
+func (l *linter) VisitTypeDecl(node *parser.TypeDeclNode) (interface{}, error) {
+	l.define(&symbol{name: node.Name, kind: typeName, pos: node.Pos})
+
+	return nil, l.typeSpec(node.Type)
+}
+
+// typeSpec declares the values of enumerations and reads the constants
+// used in a type
+func (l *linter) typeSpec(spec parser.TypeSpec) error {
+	switch t := spec.(type) {
+	case *parser.EnumType:
+		for n, v := range t.Values {
+			l.define(&symbol{name: v.Name, kind: constant, pos: v.Pos, value: n, hasValue: true})
+		}
+
+	case *parser.SubrangeType:
+		if _, err := t.Low.Accept(l); err != nil {
+			return err
+		}
+		if _, err := t.High.Accept(l); err != nil {
+			return err
+		}
+
+	case *parser.SetType:
+		return l.typeSpec(t.Elem)
+
+	case *parser.RecordType:
+		for _, f := range t.Fields {
+			if err := l.typeSpec(f.Type); err != nil {
+				return err
+			}
+		}
+
+	case *parser.ArrayType:
+		if err := l.typeSpec(t.Index); err != nil {
+			return err
+		}
+		return l.typeSpec(t.Elem)
+	}
+
+	return nil
+}
+
+func (l *linter) VisitVarDecl(node *parser.VarDeclNode) (interface{}, error) {
+	if err := l.typeSpec(node.Type); err != nil {
+		return nil, err
+	}
+
+	for _, v := range node.Names {
+		l.define(&symbol{name: v.Value, kind: variable, pos: v.Pos})
+	}
+
+	return nil, nil
+}
+
+func (l *linter) VisitProcDecl(node *parser.ProcDeclNode) (interface{}, error) {
+	sym := &symbol{name: node.Name, kind: procedure, pos: node.Pos}
+	if node.Result != nil {
+		sym.kind = function
+	}
+	for _, group := range node.Params {
+		for range group.Names {
+			sym.byRef = append(sym.byRef, group.ByRef)
+		}
+	}
+	l.define(sym)
+	if node.Block == nil {
+		return nil, nil
+	}
+
+	outer := l.scope
+	l.scope = &scope{symbols: map[string]*symbol{}, parent: outer, local: true}
+	l.procs = append(l.procs, sym)
+	defer func() {
+		l.scope = outer
+		l.procs = l.procs[:len(l.procs)-1]
+	}()
+
+	for _, group := range node.Params {
+		if err := l.typeSpec(group.Type); err != nil {
+			return nil, err
+		}
+		for _, v := range group.Names {
+			l.define(&symbol{name: v.Value, kind: parameter, pos: v.Pos, assigned: true})
+		}
+	}
+
+	return node.Block.Accept(l)
+}
+
+func (l *linter) VisitCompound(node *parser.CompoundNode) (interface{}, error) {
+	if empty(node) {
+		l.warn(node.Pos, EmptyBlock, "empty BEGIN END block")
+	}
+
+	return nil, l.statements(node)
+}
+
+// empty is true for a statement list with no statements in it
+func empty(node *parser.CompoundNode) bool {
+	for _, child := range node.Children {
+		if _, ok := child.(*parser.NoOpNode); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (l *linter) statements(node *parser.CompoundNode) error {
+	for _, child := range node.Children {
+		if _, err := child.Accept(l); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *linter) VisitNoOp(node *parser.NoOpNode) (interface{}, error) {
+	return nil, nil
+}
+
+func (l *linter) VisitAssign(node *parser.AssignNode) (interface{}, error) {
+	if _, err := node.Right.Accept(l); err != nil {
+		return nil, err
+	}
+
+	return nil, l.assign(node.Left)
+}
+
+// assign records an assignment to a designator. Assigning to an element
+// or field counts as assigning to the whole variable.
+func (l *linter) assign(target parser.ASTNode) error {
+	switch t := target.(type) {
+	case *parser.VarNode:
+		sym, ok := l.lookup(t.Value)
+		if !ok {
+			l.define(&symbol{name: t.Value, kind: variable, pos: t.Pos, assigned: true})
+			return nil
+		}
+		sym.assigned = true
+
+	case *parser.IndexNode:
+		if _, err := t.Index.Accept(l); err != nil {
+			return err
+		}
+		return l.assign(t.Array)
+
+	case *parser.FieldNode:
+		return l.assign(t.Record)
+
+	default:
+		// the pointer is read to find the variable assigned
+		_, err := target.Accept(l)
+		return err
+	}
+
+	return nil
+}
+
+// reference records a variable passed to a VAR parameter, which may be
+// read or assigned by the procedure
+func (l *linter) reference(arg parser.ASTNode) error {
+	switch a := arg.(type) {
+	case *parser.VarNode:
+		if sym, ok := l.lookup(a.Value); ok {
+			sym.read = true
+			sym.assigned = true
+		}
+
+	case *parser.IndexNode:
+		if _, err := a.Index.Accept(l); err != nil {
+			return err
+		}
+		return l.reference(a.Array)
+
+	case *parser.FieldNode:
+		return l.reference(a.Record)
+
+	default:
+		_, err := arg.Accept(l)
+		return err
+	}
+
+	return nil
+}
+
+func (l *linter) VisitVar(node *parser.VarNode) (interface{}, error) {
+	sym, ok := l.lookup(node.Value)
+	if !ok {
+		return nil, nil
+	}
+
+	switch sym.kind {
+	case procedure, function:
+		l.call(sym)
+
+	default:
+		sym.read = true
+	}
+
+	return nil, nil
+}
+
+func (l *linter) VisitCall(node *parser.CallNode) (interface{}, error) {
+	return nil, l.callArgs(node.Name, node.Args)
+}
+
+func (l *linter) VisitProcCall(node *parser.ProcCallNode) (interface{}, error) {
+	return nil, l.callArgs(node.Name, node.Args)
+}
+
+// callArgs records a call of a procedure or standard function and the use
+// of its arguments
+func (l *linter) callArgs(name string, args []parser.ASTNode) error {
+	var byRef []bool
+	sym, ok := l.lookup(name)
+	if ok && (sym.kind == procedure || sym.kind == function) {
+		l.call(sym)
+		byRef = sym.byRef
+	} else if !ok && strings.EqualFold(name, "New") {
+		byRef = []bool{true}
+	}
+
+	for n, arg := range args {
+		var err error
+		if n < len(byRef) && byRef[n] {
+			err = l.reference(arg)
+		} else {
+			_, err = arg.Accept(l)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *linter) VisitCase(node *parser.CaseNode) (interface{}, error) {
+	if _, err := node.Expr.Accept(l); err != nil {
+		return nil, err
+	}
+	selector, fixed := l.constant(node.Expr)
+
+	matched := false
+	for _, branch := range node.Branches {
+		hit := false
+		for _, label := range branch.Labels {
+			if _, err := label.Low.Accept(l); err != nil {
+				return nil, err
+			}
+			if label.High != nil {
+				if _, err := label.High.Accept(l); err != nil {
+					return nil, err
+				}
+			}
+			hit = hit || l.matches(label, selector)
+		}
+
+		if fixed && !hit {
+			l.warn(branch.Labels[0].Pos, Unreachable, "unreachable CASE branch: the selector is always %d", selector)
+		}
+		matched = matched || hit
+
+		if _, err := branch.Body.Accept(l); err != nil {
+			return nil, err
+		}
+	}
+
+	if node.Else == nil {
+		return nil, nil
+	}
+
+	if fixed && matched {
+		l.warn(node.Else.Pos, Unreachable, "unreachable ELSE: the selector is always %d", selector)
+	}
+
+	return nil, l.statements(node.Else)
+}
+
+// matches is true if a label may select the value. A label which is not
+// constant may select any value.
+func (l *linter) matches(label *parser.CaseLabel, value int) bool {
+	low, ok := l.constant(label.Low)
+	if !ok {
+		return true
+	}
+
+	high := low
+	if label.High != nil {
+		if high, ok = l.constant(label.High); !ok {
+			return true
+		}
+	}
+
+	return low <= value && value <= high
+}
+
+func (l *linter) VisitFor(node *parser.ForNode) (interface{}, error) {
+	if _, err := node.Start.Accept(l); err != nil {
+		return nil, err
+	}
+	if _, err := node.End.Accept(l); err != nil {
+		return nil, err
+	}
+
+	// the loop itself reads its control variable
+	if err := l.assign(node.Var); err != nil {
+		return nil, err
+	}
+	if sym, ok := l.lookup(node.Var.Value); ok {
+		sym.read = true
+	}
+
+	start, sok := l.constant(node.Start)
+	end, eok := l.constant(node.End)
+	if sok && eok && ((!node.Down && start > end) || (node.Down && start < end)) {
+		dir := "TO"
+		if node.Down {
+			dir = "DOWNTO"
+		}
+		l.warn(node.Pos, Unreachable, "unreachable FOR body: %d %s %d never runs", start, dir, end)
+	}
+
+	return node.Body.Accept(l)
+}
+
+// constant evaluates an ordinal expression whose value is known before the
+// program runs. Comparisons give 1 if true and 0 if false, like Ord.
+func (l *linter) constant(node parser.ASTNode) (int, bool) {
+	switch n := node.(type) {
+	case *parser.NumNode:
+		return n.Value, n.Big == nil
+
+	case *parser.VarNode:
+		sym, ok := l.lookup(n.Value)
+		if ok && sym.kind == constant && sym.hasValue {
+			return sym.value, true
+		}
+
+	case *parser.UnaryNode:
+		val, ok := l.constant(n.Child)
+		if ok && n.Token.Type == lexer.Minus {
+			return -val, true
+		}
+		return val, ok
+
+	case *parser.BinOpNode:
+		left, lok := l.constant(n.Left)
+		right, rok := l.constant(n.Right)
+		if lok && rok {
+			return fold(n.Token.Type, left, right)
+		}
+
+	case *parser.CallNode:
+		if len(n.Args) != 1 {
+			break
+		}
+		if _, ok := l.lookup(n.Name); ok {
+			break
+		}
+		val, ok := l.constant(n.Args[0])
+		switch {
+		case !ok:
+		case strings.EqualFold(n.Name, "Ord"):
+			return val, true
+		case strings.EqualFold(n.Name, "Odd"):
+			return truth(val%2 != 0), true
+		}
+	}
+
+	return 0, false
+}
+
+func fold(op lexer.TokenType, l, r int) (int, bool) {
+	switch op {
+	case lexer.Plus:
+		return l + r, true
+	case lexer.Minus:
+		return l - r, true
+	case lexer.Mult:
+		return l * r, true
+	case lexer.Div:
+		if r != 0 {
+			return l / r, true
+		}
+	case lexer.Mod:
+		if r != 0 {
+			return l % r, true
+		}
+	case lexer.Equal:
+		return truth(l == r), true
+	case lexer.NotEqual:
+		return truth(l != r), true
+	case lexer.Less:
+		return truth(l < r), true
+	case lexer.LessEqual:
+		return truth(l <= r), true
+	case lexer.Greater:
+		return truth(l > r), true
+	case lexer.GreaterEqual:
+		return truth(l >= r), true
+	}
+
+	return 0, false
+}
+
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (l *linter) VisitBinOp(node *parser.BinOpNode) (interface{}, error) {
+	if _, err := node.Left.Accept(l); err != nil {
+		return nil, err
+	}
+
+	return node.Right.Accept(l)
+}
+
+func (l *linter) VisitUnary(node *parser.UnaryNode) (interface{}, error) {
+	return node.Child.Accept(l)
+}
+
+func (l *linter) VisitToReal(node *parser.ToRealNode) (interface{}, error) {
+	return node.Child.Accept(l)
+}
+
+func (l *linter) VisitNum(node *parser.NumNode) (interface{}, error) {
+	return nil, nil
+}
+
+func (l *linter) VisitReal(node *parser.RealNode) (interface{}, error) {
+	return nil, nil
+}
+
+func (l *linter) VisitNil(node *parser.NilNode) (interface{}, error) {
+	return nil, nil
+}
+
+func (l *linter) VisitSet(node *parser.SetNode) (interface{}, error) {
+	for _, elem := range node.Elements {
+		if _, err := elem.Low.Accept(l); err != nil {
+			return nil, err
+		}
+		if elem.High != nil {
+			if _, err := elem.High.Accept(l); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (l *linter) VisitDeref(node *parser.DerefNode) (interface{}, error) {
+	return node.Pointer.Accept(l)
+}
+
+func (l *linter) VisitField(node *parser.FieldNode) (interface{}, error) {
+	return node.Record.Accept(l)
+}
+
+func (l *linter) VisitIndex(node *parser.IndexNode) (interface{}, error) {
+	if _, err := node.Array.Accept(l); err != nil {
+		return nil, err
+	}
+
+	return node.Index.Accept(l)
+}
@@ -0,0 +1,232 @@
+// Package loader reads a program and the units it uses from a search path,
+// checking each of them as it is loaded
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+	"github.com/kieron-dev/lsbasi/semantic"
+)
+
+// Unit is a loaded and checked unit
+type Unit struct {
+	Name    string
+	Path    string
+	Node    *parser.UnitNode
+	Exports []*semantic.Symbol
+}
+
+// Error is an error in the unit at Path, found when loading it for the
+// USES clause naming it at Pos
+type Error struct {
+	Path string
+	Pos  lexer.Position
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Program is a checked program with the units it depends on, in the order
+// they were loaded. It is a Programmer for the interpreter.
+type Program struct {
+	Block *parser.BlockNode
+	Units []*Unit
+}
+
+func (p *Program) Program() (parser.ASTNode, error) {
+	return p.Block, nil
+}
+
+// UnitNodes returns the units the program depends on, in the order they
+// were loaded
+func (p *Program) UnitNodes() []*parser.UnitNode {
+	nodes := []*parser.UnitNode{}
+	for _, unit := range p.Units {
+		nodes = append(nodes, unit.Node)
+	}
+
+	return nodes
+}
+
+// Analyser returns a semantic analyser to which the units the program uses
+// are available, for checking the program again
+func (p *Program) Analyser() *semantic.Analyser {
+	return analyser(p.Units)
+}
+
+type Loader struct {
+	searchPath []string
+	lexOpts    []lexer.Option
+	units      map[string]*Unit
+	order      []*Unit
+	loading    []string
+}
+
+// NewLoader looks for units in the directories of searchPath, in order
+func NewLoader(searchPath ...string) *Loader {
+	return &Loader{
+		searchPath: searchPath,
+		units:      map[string]*Unit{},
+	}
+}
+
+// SetIntegerRange sets the range of INTEGER values, outside which integer
+// literals are rejected. It is the 64-bit range by default, and nil bounds
+// accept any literal.
+func (l *Loader) SetIntegerRange(min, max *big.Int) {
+	l.lexOpts = []lexer.Option{lexer.WithIntegerRange(min, max)}
+}
+
+// Load parses and checks the program in src, and every unit it uses
+func (l *Loader) Load(src io.Reader) (*Program, error) {
+	node, err := parser.NewParser(lexer.NewTokeniser(src, l.lexOpts...)).Program()
+	if err != nil {
+		return nil, err
+	}
+
+	block, ok := node.(*parser.BlockNode)
+	if !ok {
+		return nil, fmt.Errorf("expected a program, got %T", node)
+	}
+
+	if err := l.LoadUses(block.Uses); err != nil {
+		return nil, err
+	}
+
+	if err := l.Analyser().Analyse(block); err != nil {
+		return nil, err
+	}
+
+	return &Program{
+		Block: block,
+		Units: l.order,
+	}, nil
+}
+
+// LoadUses loads each of the units named in a USES clause
+func (l *Loader) LoadUses(uses []*parser.UnitRef) error {
+	for _, ref := range uses {
+		if _, err := l.load(ref); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Analyser returns a semantic analyser to which every unit loaded so far is
+// available
+func (l *Loader) Analyser() *semantic.Analyser {
+	return analyser(l.order)
+}
+
+func analyser(units []*Unit) *semantic.Analyser {
+	a := semantic.NewAnalyser()
+	for _, unit := range units {
+		a.Import(unit.Name, unit.Exports)
+	}
+
+	return a
+}
+
+func (l *Loader) load(ref *parser.UnitRef) (*Unit, error) {
+	key := strings.ToLower(ref.Name)
+	if unit, ok := l.units[key]; ok {
+		return unit, nil
+	}
+
+	for n, name := range l.loading {
+		if strings.ToLower(name) == key {
+			cycle := append(append([]string{}, l.loading[n:]...), ref.Name)
+			return nil, fmt.Errorf("import cycle: %s at %s", strings.Join(cycle, " -> "), ref.Pos)
+		}
+	}
+
+	path, err := l.find(ref.Name)
+	if err != nil {
+		return nil, fmt.Errorf("%w at %s", err, ref.Pos)
+	}
+
+	l.loading = append(l.loading, ref.Name)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	unit, err := l.loadFile(ref.Name, path)
+	if err != nil {
+		return nil, &Error{Path: path, Pos: ref.Pos, Err: err}
+	}
+
+	l.units[key] = unit
+	l.order = append(l.order, unit)
+
+	return unit, nil
+}
+
+func (l *Loader) loadFile(name, path string) (*Unit, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	node, err := parser.NewParser(lexer.NewTokeniser(f, l.lexOpts...)).Unit()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(node.Name, name) {
+		return nil, fmt.Errorf("expected unit %s, found unit %s at %s", name, node.Name, node.Pos)
+	}
+
+	if err := l.LoadUses(append(append([]*parser.UnitRef{}, node.InterfaceUses...), node.ImplUses...)); err != nil {
+		return nil, err
+	}
+
+	analyser := l.Analyser()
+	if err := analyser.Analyse(node); err != nil {
+		return nil, err
+	}
+
+	for _, sym := range analyser.Symbols() {
+		sym.File = path
+	}
+
+	return &Unit{
+		Name:    node.Name,
+		Path:    path,
+		Node:    node,
+		Exports: analyser.Exports(),
+	}, nil
+}
+
+// find returns the path of the first file in the search path named after
+// the unit, trying the lower case name first
+func (l *Loader) find(name string) (string, error) {
+	for _, dir := range l.searchPath {
+		for _, file := range []string{strings.ToLower(name) + ".pas", name + ".pas"} {
+			path := filepath.Join(dir, file)
+			info, err := os.Stat(path)
+			if err == nil && !info.IsDir() {
+				return path, nil
+			}
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return "", err
+			}
+		}
+	}
+
+	return "", fmt.Errorf("unit %s not found in search path %q", name, l.searchPath)
+}
@@ -0,0 +1,197 @@
+package loader_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/interpreter"
+	"github.com/kieron-dev/lsbasi/loader"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Loader", func() {
+	var (
+		searchPath []string
+		source     string
+		program    *loader.Program
+		err        error
+	)
+
+	BeforeEach(func() {
+		searchPath = []string{"testdata/missing", "testdata/units"}
+	})
+
+	JustBeforeEach(func() {
+		program, err = loader.NewLoader(searchPath...).Load(strings.NewReader(source))
+	})
+
+	Context("a program using units", func() {
+		BeforeEach(func() {
+			source = "USES Tax, Rates; BEGIN price := 100 * (100 + vat) DIV 100 - reduced END."
+		})
+
+		It("loads units in dependency order and imports their constants", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			names := []string{}
+			for _, unit := range program.Units {
+				names = append(names, unit.Name)
+			}
+			Expect(names).To(Equal([]string{"Rates", "Tax"}))
+			Expect(program.Units[0].Path).To(Equal("testdata/units/rates.pas"))
+
+			interp := interpreter.NewInterpreter(program, interpreter.WithUnits(program.UnitNodes()...))
+			Expect(interp.Interpret()).To(Succeed())
+			Expect(interp.GlobalScope()).To(Equal(map[string]interface{}{"price": 115}))
+		})
+	})
+
+	Context("a program using the types, variables and procedures of a unit", func() {
+		BeforeEach(func() {
+			source = `
+USES Counter;
+VAR l: Level;
+BEGIN
+  Reset;
+  Add(2);
+  Add(3);
+  total := count;
+  s := Scaled(4);
+  l := LevelOf(total);
+  count := 1
+END.`
+		})
+
+		It("runs the procedures of the unit against its variables", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			interp := interpreter.NewInterpreter(program, interpreter.WithUnits(program.UnitNodes()...))
+			Expect(interp.Interpret()).To(Succeed())
+
+			scope := interp.GlobalScope()
+			Expect(scope).To(HaveKeyWithValue("total", 5))
+			Expect(scope).To(HaveKeyWithValue("s", 20))
+			Expect(fmt.Sprint(scope["l"])).To(Equal("High"))
+			Expect(scope).NotTo(HaveKey("count"))
+		})
+	})
+
+	Context("calling a procedure from the implementation section", func() {
+		BeforeEach(func() {
+			source = "USES Counter; BEGIN Tick END."
+		})
+
+		It("is not visible", func() {
+			Expect(err).To(MatchError(`unknown procedure "Tick" at 1:21`))
+		})
+	})
+
+	Context("calling a procedure of a unit with the wrong arguments", func() {
+		BeforeEach(func() {
+			source = "USES Counter; BEGIN Add(1, 2) END."
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError("Add expects 1 argument(s), got 2 at 1:21"))
+		})
+	})
+
+	Context("a unit with a heading which is not implemented", func() {
+		BeforeEach(func() {
+			searchPath = []string{"testdata/unimplemented"}
+			source = "USES Half; BEGIN Done END."
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring(`function "Missing" is not implemented at 4:10`)))
+		})
+	})
+
+	Context("a unit implementing a heading with a different signature", func() {
+		BeforeEach(func() {
+			searchPath = []string{"testdata/mismatched"}
+			source = "USES Clash; BEGIN END."
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring(
+				"function Twice(n: REAL): REAL does not match its heading (n: INTEGER): INTEGER on line 3")))
+		})
+	})
+
+	Context("using a constant from the implementation section", func() {
+		BeforeEach(func() {
+			source = "USES Tax; BEGIN x := surcharge END."
+		})
+
+		It("is not visible", func() {
+			Expect(err).To(MatchError(ContainSubstring(`"surcharge"`)))
+		})
+	})
+
+	Context("using a constant from a unit which is not named in USES", func() {
+		BeforeEach(func() {
+			source = "USES Tax; BEGIN x := standard END."
+		})
+
+		It("is not visible", func() {
+			Expect(err).To(MatchError(ContainSubstring(`"standard"`)))
+		})
+	})
+
+	Context("a program declaring a constant with an imported name", func() {
+		BeforeEach(func() {
+			source = "USES Rates; CONST reduced = 1; BEGIN x := reduced END."
+		})
+
+		It("hides the imported constant", func() {
+			Expect(err).NotTo(HaveOccurred())
+			interp := interpreter.NewInterpreter(program, interpreter.WithUnits(program.UnitNodes()...))
+			Expect(interp.Interpret()).To(Succeed())
+			Expect(interp.GlobalScope()).To(Equal(map[string]interface{}{"x": 1}))
+		})
+	})
+
+	Context("assigning to an imported constant", func() {
+		BeforeEach(func() {
+			source = "USES Rates; BEGIN reduced := 1 END."
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(`cannot assign to constant "reduced" at 1:19`))
+		})
+	})
+
+	Context("a missing unit", func() {
+		BeforeEach(func() {
+			source = "USES Nope; BEGIN END."
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring("unit Nope not found in search path")))
+		})
+	})
+
+	Context("an import cycle", func() {
+		BeforeEach(func() {
+			searchPath = []string{"testdata/cycle"}
+			source = "USES A; BEGIN END."
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring("import cycle: A -> B -> A at 3:6")))
+		})
+	})
+
+	Context("a file with the wrong unit name", func() {
+		BeforeEach(func() {
+			searchPath = []string{"testdata/misnamed"}
+			source = "USES Wrong; BEGIN END."
+		})
+
+		It("fails", func() {
+			Expect(err).To(MatchError(ContainSubstring("expected unit Wrong, found unit Right")))
+		})
+	})
+})
@@ -0,0 +1,5 @@
+UNIT A;
+INTERFACE
+IMPLEMENTATION
+USES B;
+END.
@@ -0,0 +1,5 @@
+UNIT B;
+INTERFACE
+USES A;
+IMPLEMENTATION
+END.
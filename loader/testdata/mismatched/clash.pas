@@ -0,0 +1,9 @@
+UNIT Clash;
+INTERFACE
+FUNCTION Twice(n: INTEGER): INTEGER;
+IMPLEMENTATION
+FUNCTION Twice(n: REAL): REAL;
+BEGIN
+  Twice := 2 * n
+END;
+END.
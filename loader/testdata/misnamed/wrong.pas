@@ -0,0 +1,4 @@
+UNIT Right;
+INTERFACE
+IMPLEMENTATION
+END.
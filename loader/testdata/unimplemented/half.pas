@@ -0,0 +1,9 @@
+UNIT Half;
+INTERFACE
+PROCEDURE Done;
+FUNCTION Missing(n: INTEGER): INTEGER;
+IMPLEMENTATION
+PROCEDURE Done;
+BEGIN
+END;
+END.
@@ -0,0 +1,56 @@
+UNIT Counter;
+
+INTERFACE
+
+USES Rates;
+
+TYPE
+  Level = (Low, High);
+
+VAR
+  count: INTEGER;
+
+PROCEDURE Reset;
+PROCEDURE Add(n: INTEGER);
+FUNCTION Scaled(n: INTEGER): INTEGER;
+FUNCTION LevelOf(n: INTEGER): Level;
+
+IMPLEMENTATION
+
+VAR
+  calls: INTEGER;
+
+PROCEDURE Tick;
+BEGIN
+  calls := calls + 1
+END;
+
+PROCEDURE Reset;
+BEGIN
+  count := 0;
+  calls := 0
+END;
+
+PROCEDURE Add(n: INTEGER);
+BEGIN
+  count := count + n;
+  Tick
+END;
+
+FUNCTION Scaled(n: INTEGER): INTEGER;
+BEGIN
+  Tick;
+  Scaled := n * reduced
+END;
+
+FUNCTION LevelOf(n: INTEGER): Level;
+BEGIN
+  Tick;
+  CASE n OF
+    0..4: LevelOf := Low
+  ELSE
+    LevelOf := High
+  END
+END;
+
+END.
@@ -0,0 +1,7 @@
+UNIT Rates;
+INTERFACE
+CONST
+  standard = 18;
+  reduced = 5;
+IMPLEMENTATION
+END.
@@ -0,0 +1,15 @@
+UNIT Tax;
+
+INTERFACE
+
+USES Rates;
+
+CONST
+  vat = standard + 2;
+
+IMPLEMENTATION
+
+CONST
+  surcharge = 2;
+
+END.
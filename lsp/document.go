@@ -0,0 +1,177 @@
+package lsp
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/loader"
+	"github.com/kieron-dev/lsbasi/parser"
+	"github.com/kieron-dev/lsbasi/semantic"
+)
+
+// document is the analysed state of an open source file. Errors in the
+// units it uses are diagnosed in the unit files, by URI, as well as at the
+// USES clause naming them.
+type document struct {
+	uri             string
+	diagnostics     []diagnostic
+	unitDiagnostics map[string][]diagnostic
+	symbols         []*semantic.Symbol
+	references      []semantic.Reference
+}
+
+func analyse(uri, text string) *document {
+	doc := &document{
+		uri:             uri,
+		diagnostics:     []diagnostic{},
+		unitDiagnostics: map[string][]diagnostic{},
+	}
+
+	pars := parser.NewParser(lexer.NewTokeniser(strings.NewReader(text)))
+	program, err := pars.Program()
+	if err != nil {
+		doc.addDiagnostic(err)
+		return doc
+	}
+
+	analyser := semantic.NewAnalyser()
+	if block, ok := program.(*parser.BlockNode); ok && len(block.Uses) > 0 {
+		units := loader.NewLoader(uriDir(uri))
+		if err := units.LoadUses(block.Uses); err != nil {
+			doc.addDiagnostic(err)
+		}
+		analyser = units.Analyser()
+	}
+
+	if err := analyser.Analyse(program); err != nil && len(analyser.Errors()) == 0 {
+		doc.addDiagnostic(err)
+	}
+	for _, err := range analyser.Errors() {
+		doc.addDiagnostic(err)
+	}
+
+	doc.symbols = analyser.Symbols()
+	doc.references = analyser.References()
+
+	return doc
+}
+
+func (d *document) addDiagnostic(err error) {
+	var unitErr *loader.Error
+	if !errors.As(err, &unitErr) {
+		d.diagnostics = append(d.diagnostics, diagnose(err))
+		return
+	}
+
+	d.diagnostics = append(d.diagnostics, diagnose(&semantic.Error{Pos: unitErr.Pos, Msg: unitErr.Error()}))
+
+	// the error is in the unit loaded last, which may be used by another
+	for {
+		var inner *loader.Error
+		if !errors.As(unitErr.Err, &inner) {
+			break
+		}
+		unitErr = inner
+	}
+	file := fileURI(unitErr.Path)
+	d.unitDiagnostics[file] = append(d.unitDiagnostics[file], diagnose(unitErr.Err))
+}
+
+func diagnose(err error) diagnostic {
+	var (
+		pos    lexer.Position
+		msg    = err.Error()
+		lexErr *lexer.Error
+		synErr *parser.SyntaxError
+		semErr *semantic.Error
+	)
+
+	switch {
+	case errors.As(err, &lexErr):
+		pos, msg = lexErr.Pos, lexErr.Msg
+	case errors.As(err, &synErr):
+		pos, msg = synErr.Pos, synErr.Msg
+	case errors.As(err, &semErr):
+		pos, msg = semErr.Pos, semErr.Msg
+	}
+
+	start := toPosition(pos)
+	end := start
+	end.Character++
+
+	return diagnostic{
+		Range:    rng{Start: start, End: end},
+		Severity: severityError,
+		Source:   "lsbasi",
+		Message:  msg,
+	}
+}
+
+// referenceAt finds the symbol reference covering a position
+func (d *document) referenceAt(p position) (semantic.Reference, bool) {
+	for _, ref := range d.references {
+		r := nameRange(ref.Pos, ref.Symbol.Name)
+		if r.Start.Line == p.Line && r.Start.Character <= p.Character && p.Character <= r.End.Character {
+			return ref, true
+		}
+	}
+
+	return semantic.Reference{}, false
+}
+
+func (d *document) location(pos lexer.Position, name string) location {
+	return location{URI: d.uri, Range: nameRange(pos, name)}
+}
+
+// declaration is where a symbol is defined, in the document or in the file
+// of the unit it is imported from
+func (d *document) declaration(sym *semantic.Symbol) location {
+	if sym.File == "" {
+		return d.location(sym.Pos, sym.Name)
+	}
+
+	return location{URI: fileURI(sym.File), Range: nameRange(sym.Pos, sym.Name)}
+}
+
+// uriDir is the directory containing a file URI, where its units are found
+func uriDir(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "."
+	}
+
+	return filepath.Dir(u.Path)
+}
+
+// fileURI is the URI of a file loaded from a path
+func fileURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// toPosition converts a 1-based lexer position to a 0-based protocol position
+func toPosition(pos lexer.Position) position {
+	p := position{Line: pos.Line - 1, Character: pos.Column - 1}
+	if p.Line < 0 {
+		p.Line = 0
+	}
+	if p.Character < 0 {
+		p.Character = 0
+	}
+
+	return p
+}
+
+func nameRange(pos lexer.Position, name string) rng {
+	start := toPosition(pos)
+	end := start
+	end.Character += len(name)
+
+	return rng{Start: start, End: end}
+}
@@ -0,0 +1,331 @@
+// Package lsp implements a Language Server Protocol server over a stream
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/kieron-dev/lsbasi/interpreter"
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/semantic"
+)
+
+type Server struct {
+	conn      *conn
+	documents map[string]*document
+	shutdown  bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		conn:      newConn(in, out),
+		documents: map[string]*document{},
+	}
+}
+
+// Serve handles messages until the client sends exit or closes the stream
+func (s *Server) Serve() error {
+	for {
+		msg, err := s.conn.read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+
+			return nil
+		}
+
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			continue
+		}
+
+		if err := s.conn.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return s.initialize()
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, s.update(params.TextDocument.URI, text)
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return nil, nil
+
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(params)
+
+	case "textDocument/references":
+		var params referenceParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.references(params)
+
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params)
+
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.documentSymbols(params)
+
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(params)
+	}
+
+	return nil, &responseError{Code: methodNotFound, Message: fmt.Sprintf("method not found: %s", msg.Method)}
+}
+
+func (s *Server) initialize() (interface{}, error) {
+	result := initializeResult{}
+	result.ServerInfo.Name = "lsbasi"
+	result.Capabilities.TextDocumentSync = textDocumentSyncFull
+	result.Capabilities.DefinitionProvider = true
+	result.Capabilities.ReferencesProvider = true
+	result.Capabilities.HoverProvider = true
+	result.Capabilities.DocumentSymbolProvider = true
+	result.Capabilities.CompletionProvider.TriggerCharacters = []string{}
+
+	return result, nil
+}
+
+// update re-analyses a document and publishes its diagnostics, and those
+// of the units it uses, clearing them from units which no longer have any
+func (s *Server) update(uri, text string) error {
+	doc := analyse(uri, text)
+	units := map[string][]diagnostic{}
+	for file, diags := range doc.unitDiagnostics {
+		units[file] = diags
+	}
+	if old, ok := s.documents[uri]; ok {
+		for unit := range old.unitDiagnostics {
+			if _, ok := units[unit]; !ok {
+				units[unit] = []diagnostic{}
+			}
+		}
+	}
+	s.documents[uri] = doc
+
+	if err := s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: doc.diagnostics,
+	}); err != nil {
+		return err
+	}
+
+	files := make([]string, 0, len(units))
+	for file := range units {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if err := s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         file,
+			Diagnostics: units[file],
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) lookup(params textDocumentPositionParams) (*document, *semantic.Reference, error) {
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown document %s", params.TextDocument.URI)
+	}
+
+	ref, ok := doc.referenceAt(params.Position)
+	if !ok {
+		return doc, nil, nil
+	}
+
+	return doc, &ref, nil
+}
+
+func (s *Server) definition(params textDocumentPositionParams) (interface{}, error) {
+	doc, ref, err := s.lookup(params)
+	if err != nil || ref == nil {
+		return nil, err
+	}
+
+	return doc.declaration(ref.Symbol), nil
+}
+
+func (s *Server) references(params referenceParams) (interface{}, error) {
+	doc, ref, err := s.lookup(params.textDocumentPositionParams)
+	if err != nil || ref == nil {
+		return nil, err
+	}
+
+	// the declaration of a symbol imported from a unit is in the unit file
+	locations := []location{}
+	if ref.Symbol.File != "" && params.Context.IncludeDeclaration {
+		locations = append(locations, doc.declaration(ref.Symbol))
+	}
+	for _, r := range doc.references {
+		if r.Symbol != ref.Symbol {
+			continue
+		}
+		if r.Symbol.File == "" && r.Pos == r.Symbol.Pos && !params.Context.IncludeDeclaration {
+			continue
+		}
+		locations = append(locations, doc.location(r.Pos, r.Symbol.Name))
+	}
+
+	return locations, nil
+}
+
+func (s *Server) hover(params textDocumentPositionParams) (interface{}, error) {
+	doc, ref, err := s.lookup(params)
+	if err != nil || ref == nil {
+		return nil, err
+	}
+
+	sym := ref.Symbol
+	decl := fmt.Sprintf("(%s) %s: %s", sym.Kind, sym.Name, sym.Type)
+	if sym.Value != nil {
+		decl += fmt.Sprintf(" = %v", sym.Value)
+	}
+
+	return hover{
+		Contents: markupContent{
+			Kind:  "markdown",
+			Value: fmt.Sprintf("```pascal\n%s\n```", decl),
+		},
+		Range: doc.location(ref.Pos, sym.Name).Range,
+	}, nil
+}
+
+func (s *Server) documentSymbols(params documentSymbolParams) (interface{}, error) {
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil, fmt.Errorf("unknown document %s", params.TextDocument.URI)
+	}
+
+	symbols := []documentSymbol{}
+	for _, sym := range doc.symbols {
+		r := nameRange(sym.Pos, sym.Name)
+		symbols = append(symbols, documentSymbol{
+			Name:           sym.Name,
+			Detail:         sym.Type,
+			Kind:           symbolKind(sym.Kind),
+			Range:          r,
+			SelectionRange: r,
+		})
+	}
+
+	return symbols, nil
+}
+
+func (s *Server) completion(params textDocumentPositionParams) (interface{}, error) {
+	items := []completionItem{}
+	for _, word := range lexer.ReservedWords() {
+		items = append(items, completionItem{
+			Label: word,
+			Kind:  completionKindKeyword,
+		})
+	}
+
+	for _, name := range interpreter.Builtins() {
+		items = append(items, completionItem{
+			Label: name,
+			Kind:  completionKindFunction,
+		})
+	}
+
+	if doc, ok := s.documents[params.TextDocument.URI]; ok {
+		for _, sym := range doc.symbols {
+			items = append(items, completionItem{
+				Label:  sym.Name,
+				Kind:   completionKind(sym.Kind),
+				Detail: sym.Type,
+			})
+		}
+	}
+
+	return items, nil
+}
+
+func symbolKind(kind semantic.SymbolKind) int {
+	switch kind {
+	case semantic.Constant:
+		return symbolKindConstant
+	case semantic.TypeName:
+		return symbolKindClass
+	case semantic.Procedure, semantic.Function:
+		return symbolKindFunction
+	}
+
+	return symbolKindVariable
+}
+
+func completionKind(kind semantic.SymbolKind) int {
+	switch kind {
+	case semantic.Constant:
+		return completionKindConstant
+	case semantic.TypeName:
+		return completionKindClass
+	case semantic.Procedure, semantic.Function:
+		return completionKindFunction
+	}
+
+	return completionKindVariable
+}
@@ -0,0 +1,327 @@
+package lsp_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+
+	"github.com/kieron-dev/lsbasi/lsp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const uri = "file:///prog.pas"
+
+var _ = Describe("Server", func() {
+	var (
+		input    *bytes.Buffer
+		messages []map[string]interface{}
+		nextID   int
+	)
+
+	send := func(method string, params interface{}) {
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+		body, err := json.Marshal(msg)
+		Expect(err).NotTo(HaveOccurred())
+		fmt.Fprintf(input, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+
+	request := func(method string, params interface{}) int {
+		nextID++
+		msg := map[string]interface{}{"jsonrpc": "2.0", "id": nextID, "method": method, "params": params}
+		body, err := json.Marshal(msg)
+		Expect(err).NotTo(HaveOccurred())
+		fmt.Fprintf(input, "Content-Length: %d\r\n\r\n%s", len(body), body)
+
+		return nextID
+	}
+
+	response := func(id int) interface{} {
+		for _, msg := range messages {
+			if msgID, ok := msg["id"]; ok && msgID == float64(id) {
+				Expect(msg).NotTo(HaveKey("error"))
+				return msg["result"]
+			}
+		}
+		Fail(fmt.Sprintf("no response with id %d", id))
+
+		return nil
+	}
+
+	notifications := func(method string) []interface{} {
+		params := []interface{}{}
+		for _, msg := range messages {
+			if msg["method"] == method {
+				params = append(params, msg["params"])
+			}
+		}
+
+		return params
+	}
+
+	at := func(line, character int) map[string]interface{} {
+		return map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     map[string]interface{}{"line": line, "character": character},
+		}
+	}
+
+	serve := func() {
+		output := new(bytes.Buffer)
+		Expect(lsp.NewServer(input, output).Serve()).To(Succeed())
+
+		reader := textproto.NewReader(bufio.NewReader(output))
+		messages = nil
+		for {
+			header, err := reader.ReadMIMEHeader()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+
+			length, err := strconv.Atoi(header.Get("Content-Length"))
+			Expect(err).NotTo(HaveOccurred())
+			body := make([]byte, length)
+			_, err = io.ReadFull(reader.R, body)
+			Expect(err).NotTo(HaveOccurred())
+
+			msg := map[string]interface{}{}
+			Expect(json.Unmarshal(body, &msg)).To(Succeed())
+			messages = append(messages, msg)
+		}
+	}
+
+	open := func(text string) {
+		send("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "languageId": "pascal", "version": 1, "text": text},
+		})
+	}
+
+	BeforeEach(func() {
+		input = new(bytes.Buffer)
+		nextID = 0
+	})
+
+	It("advertises its capabilities", func() {
+		id := request("initialize", map[string]interface{}{})
+		serve()
+
+		caps := response(id).(map[string]interface{})["capabilities"]
+		Expect(caps).To(HaveKeyWithValue("definitionProvider", true))
+		Expect(caps).To(HaveKeyWithValue("hoverProvider", true))
+	})
+
+	It("publishes syntax errors as diagnostics", func() {
+		open("BEGIN\n  a := 1\n  b := 2\nEND.")
+		serve()
+
+		published := notifications("textDocument/publishDiagnostics")
+		Expect(published).To(HaveLen(1))
+		diags := published[0].(map[string]interface{})["diagnostics"].([]interface{})
+		Expect(diags).To(HaveLen(1))
+		diag := diags[0].(map[string]interface{})
+		Expect(diag["message"]).To(Equal("expected END, got ID"))
+		Expect(diag["range"].(map[string]interface{})["start"]).To(Equal(map[string]interface{}{
+			"line": float64(2), "character": float64(2),
+		}))
+	})
+
+	It("publishes semantic errors and clears them on change", func() {
+		open("BEGIN a := b END.")
+		send("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+			"contentChanges": []interface{}{map[string]interface{}{"text": "BEGIN a := 1 END."}},
+		})
+		serve()
+
+		published := notifications("textDocument/publishDiagnostics")
+		Expect(published).To(HaveLen(2))
+		Expect(published[0].(map[string]interface{})["diagnostics"]).To(HaveLen(1))
+		Expect(published[1].(map[string]interface{})["diagnostics"]).To(BeEmpty())
+	})
+
+	Context("navigation", func() {
+		var definition, references, hover, symbols, completion int
+
+		BeforeEach(func() {
+			open("BEGIN\n  total := 1;\n  x := total * 2;\n  total := x\nEND.")
+			definition = request("textDocument/definition", at(2, 8))
+			refParams := at(2, 8)
+			refParams["context"] = map[string]interface{}{"includeDeclaration": true}
+			references = request("textDocument/references", refParams)
+			hover = request("textDocument/hover", at(2, 9))
+			symbols = request("textDocument/documentSymbol", map[string]interface{}{
+				"textDocument": map[string]interface{}{"uri": uri},
+			})
+			completion = request("textDocument/completion", at(1, 0))
+			serve()
+		})
+
+		It("goes to the definition", func() {
+			Expect(response(definition)).To(Equal(map[string]interface{}{
+				"uri": uri,
+				"range": map[string]interface{}{
+					"start": map[string]interface{}{"line": float64(1), "character": float64(2)},
+					"end":   map[string]interface{}{"line": float64(1), "character": float64(7)},
+				},
+			}))
+		})
+
+		It("finds references", func() {
+			Expect(response(references)).To(HaveLen(3))
+		})
+
+		It("hovers with the type", func() {
+			contents := response(hover).(map[string]interface{})["contents"].(map[string]interface{})
+			Expect(contents["value"]).To(ContainSubstring("(variable) total: INTEGER"))
+		})
+
+		It("lists document symbols", func() {
+			names := []interface{}{}
+			for _, sym := range response(symbols).([]interface{}) {
+				names = append(names, sym.(map[string]interface{})["name"])
+			}
+			Expect(names).To(Equal([]interface{}{"total", "x"}))
+		})
+
+		It("completes keywords, built-ins and symbols", func() {
+			labels := []interface{}{}
+			for _, item := range response(completion).([]interface{}) {
+				labels = append(labels, item.(map[string]interface{})["label"])
+			}
+			Expect(labels).To(ContainElements("BEGIN", "END", "DIV", "Abs", "total"))
+		})
+	})
+
+	It("shows the value of constants on hover", func() {
+		open("CONST rate = 3 * 5;\nBEGIN\n  tax := rate\nEND.")
+		id := request("textDocument/hover", at(2, 10))
+		serve()
+
+		contents := response(id).(map[string]interface{})["contents"].(map[string]interface{})
+		Expect(contents["value"]).To(ContainSubstring("(constant) rate: INTEGER = 15"))
+	})
+
+	It("goes to the name of a procedure", func() {
+		open("PROCEDURE Reset;\nBEGIN\nEND;\nBEGIN\n  Reset\nEND.")
+		id := request("textDocument/definition", at(4, 3))
+		serve()
+
+		Expect(response(id)).To(Equal(map[string]interface{}{
+			"uri": uri,
+			"range": map[string]interface{}{
+				"start": map[string]interface{}{"line": float64(0), "character": float64(10)},
+				"end":   map[string]interface{}{"line": float64(0), "character": float64(15)},
+			},
+		}))
+	})
+
+	Context("a program using units", func() {
+		var program, shapes, broken string
+
+		BeforeEach(func() {
+			dir, err := filepath.Abs("testdata")
+			Expect(err).NotTo(HaveOccurred())
+			program = "file://" + filepath.ToSlash(filepath.Join(dir, "prog.pas"))
+			shapes = "file://" + filepath.ToSlash(filepath.Join(dir, "shapes.pas"))
+			broken = "file://" + filepath.ToSlash(filepath.Join(dir, "broken.pas"))
+		})
+
+		openProgram := func(text string) {
+			send("textDocument/didOpen", map[string]interface{}{
+				"textDocument": map[string]interface{}{"uri": program, "languageId": "pascal", "version": 1, "text": text},
+			})
+		}
+
+		atProgram := func(line, character int) map[string]interface{} {
+			return map[string]interface{}{
+				"textDocument": map[string]interface{}{"uri": program},
+				"position":     map[string]interface{}{"line": line, "character": character},
+			}
+		}
+
+		It("goes to and finds the declarations of symbols in their unit files", func() {
+			openProgram("USES Shapes;\nBEGIN\n  x := sides\nEND.")
+			definition := request("textDocument/definition", atProgram(2, 8))
+			refParams := atProgram(2, 8)
+			refParams["context"] = map[string]interface{}{"includeDeclaration": true}
+			references := request("textDocument/references", refParams)
+			serve()
+
+			declaration := map[string]interface{}{
+				"uri": shapes,
+				"range": map[string]interface{}{
+					"start": map[string]interface{}{"line": float64(5), "character": float64(2)},
+					"end":   map[string]interface{}{"line": float64(5), "character": float64(7)},
+				},
+			}
+			Expect(response(definition)).To(Equal(declaration))
+			Expect(response(references)).To(Equal([]interface{}{
+				declaration,
+				map[string]interface{}{
+					"uri": program,
+					"range": map[string]interface{}{
+						"start": map[string]interface{}{"line": float64(2), "character": float64(7)},
+						"end":   map[string]interface{}{"line": float64(2), "character": float64(12)},
+					},
+				},
+			}))
+		})
+
+		It("publishes errors in a unit in its file and at the USES clause", func() {
+			openProgram("USES Broken;\nBEGIN\nEND.")
+			serve()
+
+			published := notifications("textDocument/publishDiagnostics")
+			Expect(published).To(HaveLen(2))
+
+			doc := published[0].(map[string]interface{})
+			Expect(doc["uri"]).To(Equal(program))
+			diags := doc["diagnostics"].([]interface{})
+			Expect(diags).NotTo(BeEmpty())
+			Expect(diags[0].(map[string]interface{})["range"].(map[string]interface{})["start"]).To(Equal(map[string]interface{}{
+				"line": float64(0), "character": float64(5),
+			}))
+
+			unit := published[1].(map[string]interface{})
+			Expect(unit["uri"]).To(Equal(broken))
+			diags = unit["diagnostics"].([]interface{})
+			Expect(diags).To(HaveLen(1))
+			diag := diags[0].(map[string]interface{})
+			Expect(diag["message"]).To(Equal(`"missing" is not a constant`))
+			Expect(diag["range"].(map[string]interface{})["start"]).To(Equal(map[string]interface{}{
+				"line": float64(5), "character": float64(2),
+			}))
+		})
+
+		It("clears the errors of a unit once the program no longer uses it", func() {
+			openProgram("USES Broken;\nBEGIN\nEND.")
+			send("textDocument/didChange", map[string]interface{}{
+				"textDocument":   map[string]interface{}{"uri": program, "version": 2},
+				"contentChanges": []interface{}{map[string]interface{}{"text": "USES Shapes;\nBEGIN\nEND."}},
+			})
+			serve()
+
+			published := notifications("textDocument/publishDiagnostics")
+			Expect(published).To(HaveLen(4))
+			cleared := published[3].(map[string]interface{})
+			Expect(cleared["uri"]).To(Equal(broken))
+			Expect(cleared["diagnostics"]).To(BeEmpty())
+		})
+	})
+
+	It("stops on exit after shutdown", func() {
+		id := request("shutdown", nil)
+		send("exit", nil)
+		request("initialize", map[string]interface{}{})
+		serve()
+
+		Expect(messages).To(HaveLen(1))
+		Expect(response(id)).To(BeNil())
+	})
+})
@@ -0,0 +1,10 @@
+UNIT Broken;
+
+INTERFACE
+
+CONST
+  size = missing;
+
+IMPLEMENTATION
+
+END.
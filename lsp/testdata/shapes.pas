@@ -0,0 +1,10 @@
+UNIT Shapes;
+
+INTERFACE
+
+CONST
+  sides = 4;
+
+IMPLEMENTATION
+
+END.
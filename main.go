@@ -1,22 +1,326 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"math"
+	"math/big"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/kieron-dev/lsbasi/codegen/amd64"
+	"github.com/kieron-dev/lsbasi/codegen/wasm"
+	"github.com/kieron-dev/lsbasi/debugger"
 	"github.com/kieron-dev/lsbasi/interpreter"
+	"github.com/kieron-dev/lsbasi/ir"
 	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/lint"
+	"github.com/kieron-dev/lsbasi/loader"
+	"github.com/kieron-dev/lsbasi/lsp"
+	"github.com/kieron-dev/lsbasi/optimize"
 	"github.com/kieron-dev/lsbasi/parser"
+	"github.com/kieron-dev/lsbasi/typecheck"
 )
 
 func main() {
-	pars := parser.NewParser(lexer.NewTokeniser(os.Stdin))
-	interp := interpreter.NewInterpreter(pars)
-	err := interp.Interpret()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "debug":
+			debug(os.Args[2:])
+			return
+		case "lint":
+			lintFiles(os.Args[2:])
+			return
+		case "wasm":
+			compileWasm(os.Args[2:])
+			return
+		case "amd64":
+			compileAmd64(os.Args[2:])
+			return
+		case "lsp":
+			if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+				fmt.Fprintf(os.Stderr, "lsp: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	run(os.Args[1:])
+}
+
+func run(args []string) {
+	flags := flag.NewFlagSet("lsbasi", flag.ExitOnError)
+	tracePath := flags.String("trace", "", "write a JSON lines trace of each statement to `file`")
+	profile := flags.Bool("profile", false, "report execution counts and times per line on stderr")
+	optimise := flags.Bool("optimize", false, "simplify the program before running it")
+	typeCheck := flags.Bool("typecheck", false, "check the types of the program before running it")
+	searchPath := flags.String("path", ".", "list of directories to search for units")
+	intMode := flags.String("int", "64", "INTEGER `width`: 16, 32, 64 or big")
+	maxDepth := flags.Int("depth", interpreter.DefaultMaxDepth, "maximum `number` of nested procedure calls")
+	_ = flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flags.Arg(0))
+		os.Exit(2)
+	}
+
+	mode, err := interpreter.ParseIntegerMode(*intMode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *maxDepth < 1 || *maxDepth > interpreter.MaxDepthLimit {
+		fmt.Fprintf(os.Stderr, "depth must be between 1 and %d\n", interpreter.MaxDepthLimit)
+		os.Exit(2)
+	}
+
+	opts := []interpreter.Option{
+		interpreter.WithIntegerMode(mode),
+		interpreter.WithMaxDepth(*maxDepth),
+	}
+	if *tracePath != "" {
+		f, err := os.Create(*tracePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "creating trace file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		opts = append(opts, interpreter.WithTracer(f))
+	}
+
+	profiler := interpreter.NewProfiler()
+	if *profile {
+		opts = append(opts, interpreter.WithProfiler(profiler))
+	}
+
+	units := loader.NewLoader(filepath.SplitList(*searchPath)...)
+	units.SetIntegerRange(mode.Bounds())
+	prog, err := units.Load(os.Stdin)
+	if err != nil {
+		fmt.Printf("invalid expression: %v\n", err)
+		os.Exit(1)
+	}
+	opts = append(opts, interpreter.WithUnits(prog.UnitNodes()...))
+
+	var pars interpreter.Programmer = prog
+	optimiseOpts := []optimize.Option{optimize.WithIntegerRange(mode.Bounds())}
+	if *typeCheck {
+		checker := typecheck.NewChecker(pars, typecheck.WithAnalyser(prog.Analyser()))
+		optimiseOpts = append(optimiseOpts, optimize.WithTypes(checker.TypeOf))
+		pars = checker
+	}
+	if *optimise {
+		pars = optimize.NewOptimiser(pars, optimiseOpts...)
+	}
+
+	interp := interpreter.NewInterpreter(pars, opts...)
+	err = interp.Interpret()
+
+	if *profile {
+		profiler.Report(os.Stderr)
+	}
+
 	if err != nil {
 		fmt.Printf("invalid expression: %v\n", err)
+		reportStack(err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("result: %#v\n", interp.GlobalScope())
+	fmt.Printf("result: %v\n", interp.GlobalScope())
+	reportLeaks(interp.Leaks())
+}
+
+// reportLeaks lists the variables created by New and never disposed
+func reportLeaks(leaks []interpreter.Allocation) {
+	if len(leaks) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%d allocation(s) never disposed:\n", len(leaks))
+	for _, leak := range leaks {
+		fmt.Fprintf(os.Stderr, "  %s allocated at %s\n", leak.Type, leak.Pos)
+	}
+}
+
+// reportStack prints the calls in progress when a runtime error happened,
+// with the arguments they were made with
+func reportStack(err error) {
+	var stackErr *interpreter.StackError
+	if !errors.As(err, &stackErr) {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "\ncalls in progress:\n%s", stackErr.StackTrace())
+}
+
+func debug(args []string) {
+	flags := flag.NewFlagSet("lsbasi debug", flag.ExitOnError)
+	searchPath := flags.String("path", ".", "list of directories to search for units")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: lsbasi debug [-path DIRS] FILE")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "opening source: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	dbg := debugger.NewDebugger(os.Stdin, os.Stdout)
+	dbg.SetSearchPath(filepath.SplitList(*searchPath)...)
+	interp, err := dbg.Run(f)
+	if err != nil {
+		fmt.Printf("program stopped: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("result: %v\n", interp.GlobalScope())
+}
+
+// lintFiles prints the warnings for each file, exiting with status 1 if
+// there are any
+func lintFiles(paths []string) {
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: lsbasi lint FILE...")
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range paths {
+		warnings, err := lintFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+			continue
+		}
+
+		for _, w := range warnings {
+			fmt.Printf("%s:%s\n", path, w)
+		}
+		failed = failed || len(warnings) > 0
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func lintFile(path string) ([]lint.Warning, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return lint.Lint(f)
+}
+
+// compileWasm compiles a program to a WebAssembly module, written next to
+// the source unless an output file is given
+func compileWasm(args []string) {
+	flags := flag.NewFlagSet("lsbasi wasm", flag.ExitOnError)
+	wat := flags.Bool("wat", false, "write the text format rather than the binary format")
+	output := flags.String("o", "", "write the module to `file`")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: lsbasi wasm [-wat] [-o FILE] FILE")
+		os.Exit(2)
+	}
+
+	path := flags.Arg(0)
+	prog, err := lowerFile(path, lexer.WithIntegerRange(big.NewInt(math.MinInt32), big.NewInt(math.MaxInt32)))
+	var module *wasm.Module
+	if err == nil {
+		module, err = wasm.Compile(prog)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	if *output == "" {
+		ext := ".wasm"
+		if *wat {
+			ext = ".wat"
+		}
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ext
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "creating module: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	if *wat {
+		err = module.WAT(f)
+	} else {
+		err = module.Encode(f)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "writing module: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// compileAmd64 compiles a program to assembly for the GNU assembler,
+// written next to the source unless an output file is given
+func compileAmd64(args []string) {
+	flags := flag.NewFlagSet("lsbasi amd64", flag.ExitOnError)
+	output := flags.String("o", "", "write the assembly to `file`")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: lsbasi amd64 [-o FILE] FILE")
+		os.Exit(2)
+	}
+
+	path := flags.Arg(0)
+	prog, err := lowerFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".s"
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "creating assembly: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	if err := amd64.Compile(prog, f); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		os.Exit(1)
+	}
+}
+
+// lowerFile parses a program and lowers it to IR
+func lowerFile(path string, opts ...lexer.Option) (*ir.Program, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	node, err := parser.NewParser(lexer.NewTokeniser(f, opts...)).Program()
+	if err != nil {
+		return nil, err
+	}
+
+	return ir.Lower(node)
 }
@@ -0,0 +1,626 @@
+// Package optimize rewrites ASTs into simpler ASTs with the same results
+package optimize
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+	"github.com/kieron-dev/lsbasi/typecheck"
+)
+
+// A Rule rewrites a single node whose children have already been rewritten.
+// It must return the same node if it does not apply.
+type Rule func(node parser.ASTNode, info *Info) parser.ASTNode
+
+// Info is what the rules know about the program being rewritten
+type Info struct {
+	typeOf   func(parser.ASTNode) typecheck.Type
+	types    map[parser.ASTNode]typecheck.Type
+	min, max *big.Int
+}
+
+// InRange is true if n is a value of INTEGER
+func (info *Info) InRange(n *big.Int) bool {
+	return (info.min == nil || n.Cmp(info.min) >= 0) && (info.max == nil || n.Cmp(info.max) <= 0)
+}
+
+// Integer is true for an expression known to give an INTEGER: a number,
+// an expression typed INTEGER, or arithmetic on INTEGERs
+func (info *Info) Integer(node parser.ASTNode) bool {
+	switch n := node.(type) {
+	case *parser.NumNode:
+		return true
+	case *parser.UnaryNode:
+		return info.Integer(n.Child)
+	case *parser.BinOpNode:
+		switch n.Token.Type {
+		case lexer.Plus, lexer.Minus, lexer.Mult, lexer.Div, lexer.Mod:
+			return info.Integer(n.Left) && info.Integer(n.Right)
+		}
+	}
+
+	t := info.types[node]
+	if s, ok := t.(*typecheck.Subrange); ok {
+		t = s.Base
+	}
+
+	return t == typecheck.Integer
+}
+
+// inherit gives a rewritten node the type of the node it replaces
+func (info *Info) inherit(rewritten, original parser.ASTNode) {
+	if info.typeOf == nil {
+		return
+	}
+
+	if t := info.typeOf(original); t != nil {
+		info.types[rewritten] = t
+	}
+}
+
+type Option func(*Optimiser)
+
+// WithRules applies rules instead of all the rules in this package
+func WithRules(rules ...Rule) Option {
+	return func(o *Optimiser) {
+		o.rules = rules
+	}
+}
+
+// WithTypes gives the types of the expressions of a program which passed
+// type checking, such as those found by a typecheck.Checker. Identities
+// which would hide the errors of other types are only simplified for
+// operands known to be INTEGER.
+func WithTypes(typeOf func(parser.ASTNode) typecheck.Type) Option {
+	return func(o *Optimiser) {
+		o.typeOf = typeOf
+	}
+}
+
+// WithIntegerRange sets the range of INTEGER values, outside which constants
+// are not folded so that the overflow still happens at run time. A nil bound
+// leaves that end of the range open. The range is that of a 64-bit INTEGER
+// by default, as in the interpreter.
+func WithIntegerRange(min, max *big.Int) Option {
+	return func(o *Optimiser) {
+		o.min, o.max = min, max
+	}
+}
+
+// Programmer produces the AST to be optimised
+type Programmer interface {
+	Program() (parser.ASTNode, error)
+}
+
+// Optimiser is a Programmer which optimises the program of another
+type Optimiser struct {
+	pars     Programmer
+	rules    []Rule
+	typeOf   func(parser.ASTNode) typecheck.Type
+	min, max *big.Int
+}
+
+func NewOptimiser(pars Programmer, opts ...Option) *Optimiser {
+	o := &Optimiser{
+		pars:  pars,
+		rules: []Rule{FoldConstants, SimplifyIdentities, RemoveNoOps},
+		min:   big.NewInt(math.MinInt64),
+		max:   big.NewInt(math.MaxInt64),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+func (o *Optimiser) Program() (parser.ASTNode, error) {
+	program, err := o.pars.Program()
+	if err != nil {
+		return nil, err
+	}
+
+	return o.apply(program), nil
+}
+
+func (o *Optimiser) apply(node parser.ASTNode) parser.ASTNode {
+	r := &rewriter{
+		rules: o.rules,
+		info: &Info{
+			typeOf: o.typeOf,
+			types:  map[parser.ASTNode]typecheck.Type{},
+			min:    o.min,
+			max:    o.max,
+		},
+	}
+
+	return r.child(node)
+}
+
+// Optimize applies all the rules in this package, or those given by
+// WithRules
+func Optimize(node parser.ASTNode, opts ...Option) parser.ASTNode {
+	return NewOptimiser(nil, opts...).apply(node)
+}
+
+// Apply rewrites the tree bottom up, applying each rule in turn to every node.
+// The original tree is not modified.
+func Apply(node parser.ASTNode, rules ...Rule) parser.ASTNode {
+	return Optimize(node, WithRules(rules...))
+}
+
+type rewriter struct {
+	rules []Rule
+	info  *Info
+}
+
+// rewrite applies the rules until none of them changes the node
+func (r *rewriter) rewrite(node parser.ASTNode) (interface{}, error) {
+	for changed := true; changed; {
+		changed = false
+		for _, rule := range r.rules {
+			next := rule(node, r.info)
+			if next != node {
+				changed = true
+			}
+			node = next
+		}
+	}
+
+	return node, nil
+}
+
+func (r *rewriter) child(node parser.ASTNode) parser.ASTNode {
+	val, _ := node.Accept(r)
+	rewritten := val.(parser.ASTNode)
+	r.info.inherit(rewritten, node)
+
+	return rewritten
+}
+
+func (r *rewriter) VisitBinOp(node *parser.BinOpNode) (interface{}, error) {
+	return r.rewrite(&parser.BinOpNode{
+		Left:  r.child(node.Left),
+		Right: r.child(node.Right),
+		Token: node.Token,
+	})
+}
+
+func (r *rewriter) VisitNum(node *parser.NumNode) (interface{}, error) {
+	n := *node
+
+	return r.rewrite(&n)
+}
+
+func (r *rewriter) VisitReal(node *parser.RealNode) (interface{}, error) {
+	n := *node
+
+	return r.rewrite(&n)
+}
+
+func (r *rewriter) VisitUnary(node *parser.UnaryNode) (interface{}, error) {
+	return r.rewrite(&parser.UnaryNode{
+		Token: node.Token,
+		Child: r.child(node.Child),
+	})
+}
+
+func (r *rewriter) VisitToReal(node *parser.ToRealNode) (interface{}, error) {
+	return r.rewrite(&parser.ToRealNode{
+		Child: r.child(node.Child),
+		Pos:   node.Pos,
+	})
+}
+
+func (r *rewriter) VisitCall(node *parser.CallNode) (interface{}, error) {
+	args := []parser.ASTNode{}
+	for _, arg := range node.Args {
+		args = append(args, r.child(arg))
+	}
+
+	return r.rewrite(&parser.CallNode{
+		Name: node.Name,
+		Args: args,
+		Pos:  node.Pos,
+	})
+}
+
+func (r *rewriter) VisitCompound(node *parser.CompoundNode) (interface{}, error) {
+	children := []parser.ASTNode{}
+	for _, child := range node.Children {
+		children = append(children, r.child(child))
+	}
+
+	return r.rewrite(&parser.CompoundNode{
+		Children: children,
+		Pos:      node.Pos,
+	})
+}
+
+func (r *rewriter) VisitAssign(node *parser.AssignNode) (interface{}, error) {
+	return r.rewrite(&parser.AssignNode{
+		Left:  r.target(node.Left),
+		Right: r.child(node.Right),
+		Pos:   node.Pos,
+	})
+}
+
+// target copies the target of an assignment. Only the indexes of arrays
+// are rewritten.
+func (r *rewriter) target(node parser.ASTNode) parser.ASTNode {
+	switch n := node.(type) {
+	case *parser.VarNode:
+		v := *n
+		return &v
+	case *parser.DerefNode:
+		return &parser.DerefNode{Pointer: r.target(n.Pointer), Pos: n.Pos}
+	case *parser.FieldNode:
+		return &parser.FieldNode{Record: r.target(n.Record), Name: n.Name, Pos: n.Pos}
+	case *parser.IndexNode:
+		return &parser.IndexNode{Array: r.target(n.Array), Index: r.child(n.Index), Pos: n.Pos}
+	}
+
+	return r.child(node)
+}
+
+func (r *rewriter) VisitNil(node *parser.NilNode) (interface{}, error) {
+	n := *node
+
+	return r.rewrite(&n)
+}
+
+func (r *rewriter) VisitDeref(node *parser.DerefNode) (interface{}, error) {
+	return r.rewrite(&parser.DerefNode{
+		Pointer: r.child(node.Pointer),
+		Pos:     node.Pos,
+	})
+}
+
+func (r *rewriter) VisitField(node *parser.FieldNode) (interface{}, error) {
+	return r.rewrite(&parser.FieldNode{
+		Record: r.child(node.Record),
+		Name:   node.Name,
+		Pos:    node.Pos,
+	})
+}
+
+func (r *rewriter) VisitProcCall(node *parser.ProcCallNode) (interface{}, error) {
+	args := []parser.ASTNode{}
+	for _, arg := range node.Args {
+		args = append(args, r.target(arg))
+	}
+
+	return r.rewrite(&parser.ProcCallNode{
+		Name: node.Name,
+		Args: args,
+		Pos:  node.Pos,
+	})
+}
+
+func (r *rewriter) VisitVar(node *parser.VarNode) (interface{}, error) {
+	n := *node
+
+	return r.rewrite(&n)
+}
+
+func (r *rewriter) VisitCase(node *parser.CaseNode) (interface{}, error) {
+	branches := []*parser.CaseBranch{}
+	for _, branch := range node.Branches {
+		labels := []*parser.CaseLabel{}
+		for _, label := range branch.Labels {
+			l := &parser.CaseLabel{Low: r.child(label.Low), Pos: label.Pos}
+			if label.High != nil {
+				l.High = r.child(label.High)
+			}
+			labels = append(labels, l)
+		}
+
+		branches = append(branches, &parser.CaseBranch{
+			Labels: labels,
+			Body:   r.child(branch.Body),
+		})
+	}
+
+	n := &parser.CaseNode{
+		Expr:     r.child(node.Expr),
+		Branches: branches,
+		Pos:      node.Pos,
+	}
+
+	if node.Else != nil {
+		// no rule replaces a compound statement with another kind of node
+		n.Else = r.child(node.Else).(*parser.CompoundNode)
+	}
+
+	return r.rewrite(n)
+}
+
+func (r *rewriter) VisitBlock(node *parser.BlockNode) (interface{}, error) {
+	return r.rewrite(&parser.BlockNode{
+		Uses:     node.Uses,
+		Consts:   r.consts(node.Consts),
+		Types:    node.Types,
+		Vars:     node.Vars,
+		Procs:    r.procs(node.Procs),
+		Compound: r.child(node.Compound).(*parser.CompoundNode),
+	})
+}
+
+func (r *rewriter) procs(decls []*parser.ProcDeclNode) []*parser.ProcDeclNode {
+	var procs []*parser.ProcDeclNode
+	for _, decl := range decls {
+		procs = append(procs, r.child(decl).(*parser.ProcDeclNode))
+	}
+
+	return procs
+}
+
+func (r *rewriter) VisitProcDecl(node *parser.ProcDeclNode) (interface{}, error) {
+	n := *node
+	if node.Block != nil {
+		n.Block = r.child(node.Block).(*parser.BlockNode)
+	}
+
+	return r.rewrite(&n)
+}
+
+func (r *rewriter) VisitIndex(node *parser.IndexNode) (interface{}, error) {
+	return r.rewrite(&parser.IndexNode{
+		Array: r.child(node.Array),
+		Index: r.child(node.Index),
+		Pos:   node.Pos,
+	})
+}
+
+func (r *rewriter) VisitUnit(node *parser.UnitNode) (interface{}, error) {
+	n := *node
+	n.Interface = r.section(node.Interface)
+	n.Implementation = r.section(node.Implementation)
+
+	return r.rewrite(&n)
+}
+
+func (r *rewriter) section(node *parser.UnitSection) *parser.UnitSection {
+	return &parser.UnitSection{
+		Consts: r.consts(node.Consts),
+		Types:  node.Types,
+		Vars:   node.Vars,
+		Procs:  r.procs(node.Procs),
+	}
+}
+
+func (r *rewriter) consts(decls []*parser.ConstDeclNode) []*parser.ConstDeclNode {
+	var consts []*parser.ConstDeclNode
+	for _, decl := range decls {
+		consts = append(consts, r.child(decl).(*parser.ConstDeclNode))
+	}
+
+	return consts
+}
+
+func (r *rewriter) VisitConstDecl(node *parser.ConstDeclNode) (interface{}, error) {
+	return r.rewrite(&parser.ConstDeclNode{
+		Name:  node.Name,
+		Value: r.child(node.Value),
+		Pos:   node.Pos,
+	})
+}
+
+func (r *rewriter) VisitTypeDecl(node *parser.TypeDeclNode) (interface{}, error) {
+	n := *node
+
+	return r.rewrite(&n)
+}
+
+func (r *rewriter) VisitVarDecl(node *parser.VarDeclNode) (interface{}, error) {
+	n := *node
+
+	return r.rewrite(&n)
+}
+
+func (r *rewriter) VisitFor(node *parser.ForNode) (interface{}, error) {
+	v := *node.Var
+
+	return r.rewrite(&parser.ForNode{
+		Var:   &v,
+		Start: r.child(node.Start),
+		End:   r.child(node.End),
+		Down:  node.Down,
+		Body:  r.child(node.Body),
+		Pos:   node.Pos,
+	})
+}
+
+func (r *rewriter) VisitSet(node *parser.SetNode) (interface{}, error) {
+	n := &parser.SetNode{Pos: node.Pos}
+	for _, elem := range node.Elements {
+		e := &parser.SetElement{Low: r.child(elem.Low), Pos: elem.Pos}
+		if elem.High != nil {
+			e.High = r.child(elem.High)
+		}
+		n.Elements = append(n.Elements, e)
+	}
+
+	return r.rewrite(n)
+}
+
+func (r *rewriter) VisitNoOp(node *parser.NoOpNode) (interface{}, error) {
+	return r.rewrite(&parser.NoOpNode{})
+}
+
+// FoldConstants evaluates operations on number literals. Division by zero,
+// and results outside the range of INTEGER, are left in place so that the
+// error still happens at run time.
+func FoldConstants(node parser.ASTNode, info *Info) parser.ASTNode {
+	switch n := node.(type) {
+	case *parser.ToRealNode:
+		if child, ok := n.Child.(*parser.NumNode); ok && child.Big == nil {
+			return &parser.RealNode{Value: float64(child.Value)}
+		}
+
+	case *parser.UnaryNode:
+		child, ok := n.Child.(*parser.NumNode)
+		if !ok {
+			return node
+		}
+
+		if n.Token.Type == lexer.Minus {
+			return foldedNum(new(big.Int).Neg(child.Int()), node, info)
+		}
+
+		return child
+
+	case *parser.BinOpNode:
+		left, ok := n.Left.(*parser.NumNode)
+		if !ok {
+			return node
+		}
+		right, ok := n.Right.(*parser.NumNode)
+		if !ok {
+			return node
+		}
+
+		l, r := new(big.Int).Set(left.Int()), right.Int()
+
+		switch n.Token.Type {
+		case lexer.Plus:
+			return foldedNum(l.Add(l, r), node, info)
+		case lexer.Minus:
+			return foldedNum(l.Sub(l, r), node, info)
+		case lexer.Mult:
+			return foldedNum(l.Mul(l, r), node, info)
+		case lexer.Div:
+			if r.Sign() != 0 {
+				return foldedNum(l.Quo(l, r), node, info)
+			}
+		case lexer.Mod:
+			if r.Sign() != 0 {
+				return foldedNum(l.Rem(l, r), node, info)
+			}
+		}
+	}
+
+	return node
+}
+
+// foldedNum replaces node with the value n, unless n overflows, which is
+// left for the interpreter to report. The literal is at the start of the
+// expression it replaces, and has no text, as it was never written.
+func foldedNum(n *big.Int, node parser.ASTNode, info *Info) parser.ASTNode {
+	if !info.InRange(n) {
+		return node
+	}
+
+	token := lexer.Token{Type: lexer.Number, Pos: start(node)}
+	if !n.IsInt64() || int64(int(n.Int64())) != n.Int64() {
+		token.Value = n
+		return &parser.NumNode{Token: token, Big: n}
+	}
+
+	token.Value = int(n.Int64())
+	return &parser.NumNode{Token: token, Value: int(n.Int64())}
+}
+
+// start is the position of the first token of a folded expression
+func start(node parser.ASTNode) lexer.Position {
+	switch n := node.(type) {
+	case *parser.UnaryNode:
+		return n.Token.Pos
+	case *parser.BinOpNode:
+		return start(n.Left)
+	case *parser.NumNode:
+		return n.Token.Pos
+	}
+
+	return lexer.Position{}
+}
+
+// SimplifyIdentities removes operations which leave an INTEGER operand
+// unchanged, such as x*1, x+0, +x and - -x. The operations are kept for
+// other operands, for which they could fail or change the value. - - x is
+// kept for a literal x whose negation was too large to fold, so that the
+// overflow still happens at run time.
+func SimplifyIdentities(node parser.ASTNode, info *Info) parser.ASTNode {
+	switch n := node.(type) {
+	case *parser.UnaryNode:
+		if n.Token.Type == lexer.Plus && info.Integer(n.Child) {
+			return n.Child
+		}
+		if child, ok := n.Child.(*parser.UnaryNode); ok && n.Token.Type == lexer.Minus &&
+			child.Token.Type == lexer.Minus && info.Integer(child.Child) {
+			if _, literal := child.Child.(*parser.NumNode); !literal {
+				return child.Child
+			}
+		}
+
+	case *parser.BinOpNode:
+		switch n.Token.Type {
+		case lexer.Plus:
+			if isNum(n.Left, 0) && info.Integer(n.Right) {
+				return n.Right
+			}
+			if isNum(n.Right, 0) && info.Integer(n.Left) {
+				return n.Left
+			}
+
+		case lexer.Minus:
+			if isNum(n.Right, 0) && info.Integer(n.Left) {
+				return n.Left
+			}
+			if isNum(n.Left, 0) && info.Integer(n.Right) {
+				return &parser.UnaryNode{Token: n.Token, Child: n.Right}
+			}
+
+		case lexer.Mult:
+			if isNum(n.Left, 1) && info.Integer(n.Right) {
+				return n.Right
+			}
+			if isNum(n.Right, 1) && info.Integer(n.Left) {
+				return n.Left
+			}
+
+		case lexer.Div:
+			if isNum(n.Right, 1) && info.Integer(n.Left) {
+				return n.Left
+			}
+		}
+	}
+
+	return node
+}
+
+// RemoveNoOps drops empty statements from compound statements
+func RemoveNoOps(node parser.ASTNode, info *Info) parser.ASTNode {
+	n, ok := node.(*parser.CompoundNode)
+	if !ok {
+		return node
+	}
+
+	children := []parser.ASTNode{}
+	for _, child := range n.Children {
+		if _, ok := child.(*parser.NoOpNode); ok {
+			continue
+		}
+		children = append(children, child)
+	}
+
+	if len(children) == len(n.Children) {
+		return node
+	}
+
+	return &parser.CompoundNode{
+		Children: children,
+		Pos:      n.Pos,
+	}
+}
+
+// isNum reports whether node is the literal value; a literal too large for
+// Value is held in Big, with a Value of 0
+func isNum(node parser.ASTNode, value int) bool {
+	n, ok := node.(*parser.NumNode)
+
+	return ok && n.Big == nil && n.Value == value
+}
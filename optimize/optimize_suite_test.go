@@ -0,0 +1,13 @@
+package optimize_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOptimize(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Optimize Suite")
+}
@@ -0,0 +1,197 @@
+package optimize_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/interpreter"
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/optimize"
+	"github.com/kieron-dev/lsbasi/parser"
+	"github.com/kieron-dev/lsbasi/typecheck"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// parseExpr parses literals of any size, as big INTEGERs allow
+func parseExpr(expr string) parser.ASTNode {
+	pars := parser.NewParser(lexer.NewTokeniser(strings.NewReader(expr), lexer.WithIntegerRange(nil, nil)))
+	_, err := pars.NextToken()
+	Expect(err).NotTo(HaveOccurred())
+	node, err := pars.Expr()
+	Expect(err).NotTo(HaveOccurred())
+
+	return node
+}
+
+// render writes an expression as an s-expression
+func render(node parser.ASTNode) string {
+	switch n := node.(type) {
+	case *parser.NumNode:
+		return n.Int().String()
+	case *parser.VarNode:
+		return n.Value
+	case *parser.UnaryNode:
+		return fmt.Sprintf("(%s %s)", n.Token.Type, render(n.Child))
+	case *parser.BinOpNode:
+		return fmt.Sprintf("(%s %s %s)", n.Token.Type, render(n.Left), render(n.Right))
+	}
+
+	return fmt.Sprintf("%T", node)
+}
+
+// integers gives x and y the type INTEGER, and r the type REAL
+func integers(node parser.ASTNode) typecheck.Type {
+	if v, ok := node.(*parser.VarNode); ok {
+		switch v.Value {
+		case "x", "y":
+			return typecheck.Integer
+		case "r":
+			return typecheck.Real
+		}
+	}
+
+	return nil
+}
+
+var _ = DescribeTable("optimising expressions", func(expr, expected string) {
+	Expect(render(optimize.Optimize(parseExpr(expr), optimize.WithTypes(integers)))).To(Equal(expected))
+},
+	Entry("folds addition", "3 + 4", "7"),
+	Entry("folds nested arithmetic", "2 * (3 + 4) - 10 DIV 3", "11"),
+	Entry("folds unary minus", "-(2 + 3)", "-5"),
+	Entry("folds inside expressions with variables", "a + 2 * 3", "(Plus a 6)"),
+	Entry("keeps division by zero", "1 DIV 0", "(Int Divide 1 0)"),
+	Entry("keeps division by folded zero", "1 DIV (2 - 2)", "(Int Divide 1 0)"),
+	Entry("x * 1", "x * 1", "x"),
+	Entry("1 * x", "1 * x", "x"),
+	Entry("x + 0", "x + 0", "x"),
+	Entry("0 + x", "0 + x", "x"),
+	Entry("x - 0", "x - 0", "x"),
+	Entry("0 - x", "0 - x", "(Minus x)"),
+	Entry("x DIV 1", "x DIV 1", "x"),
+	Entry("+ x", "+x", "x"),
+	Entry("identities of INTEGER expressions", "(x + y) * 1", "(Plus x y)"),
+	Entry("identities after folding", "x * (3 - 2) + (4 - 4)", "x"),
+	Entry("x * 0 keeps x", "x * 0", "(Multiply x 0)"),
+	Entry("simplifies - - x", "- - x", "x"),
+	Entry("simplifies 0 - -x", "0 - -x", "x"),
+	Entry("keeps DIV of a REAL", "r DIV 1", "(Int Divide r 1)"),
+	Entry("keeps identities of a REAL", "r * 1 + 0", "(Plus (Multiply r 1) 0)"),
+	Entry("keeps identities of an untyped variable", "a * 1", "(Multiply a 1)"),
+	Entry("keeps a literal too large for Value", "x + 99999999999999999999", "(Plus x 99999999999999999999)"),
+	Entry("folds MOD", "-7 MOD 2", "-1"),
+	Entry("keeps overflow", "9223372036854775807 + 1", "(Plus 9223372036854775807 1)"),
+	Entry("keeps literals too large for INTEGER", "-(9223372036854775807 + 2)", "(Minus (Plus 9223372036854775807 2))"),
+	Entry("folds the most negative INTEGER", "-9223372036854775808", "-9223372036854775808"),
+)
+
+var _ = Describe("Optimize", func() {
+	It("removes empty statements from compound statements", func() {
+		program := &parser.CompoundNode{
+			Children: []parser.ASTNode{
+				&parser.NoOpNode{},
+				&parser.CompoundNode{
+					Children: []parser.ASTNode{&parser.NoOpNode{}},
+				},
+				&parser.AssignNode{
+					Left:  &parser.VarNode{Value: "a"},
+					Right: &parser.NumNode{Value: 1},
+				},
+				&parser.NoOpNode{},
+			},
+		}
+
+		Expect(optimize.Optimize(program)).To(Equal(&parser.CompoundNode{
+			Children: []parser.ASTNode{
+				&parser.CompoundNode{Children: []parser.ASTNode{}},
+				&parser.AssignNode{
+					Left:  &parser.VarNode{Value: "a"},
+					Right: &parser.NumNode{Value: 1},
+				},
+			},
+		}))
+	})
+
+	It("folds the conversion of a number literal to REAL", func() {
+		conv := &parser.ToRealNode{Child: &parser.NumNode{Value: 2}}
+		Expect(optimize.Optimize(conv)).To(Equal(&parser.RealNode{Value: 2.0}))
+	})
+
+	It("places a folded literal at the start of the expression it replaces", func() {
+		folded := optimize.Optimize(parseExpr("x + (2 * 3 - -1)"))
+		num := folded.(*parser.BinOpNode).Right.(*parser.NumNode)
+		Expect(num.Value).To(Equal(7))
+		Expect(num.Token.Pos).To(Equal(lexer.Position{Line: 1, Column: 6}))
+	})
+
+	It("does not modify the original tree", func() {
+		original := parseExpr("1 + 2")
+		optimize.Optimize(original)
+		Expect(original).To(Equal(parseExpr("1 + 2")))
+	})
+
+	DescribeTable("gives the same results as the unoptimised program", func(expr string) {
+		program := fmt.Sprintf("BEGIN a := 7; res := %s; END.", expr)
+
+		plain := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(program))))
+		plainErr := plain.Interpret()
+
+		optimiser := optimize.NewOptimiser(parser.NewParser(lexer.NewTokeniser(strings.NewReader(program))))
+		optimised := interpreter.NewInterpreter(optimiser)
+		optimisedErr := optimised.Interpret()
+
+		Expect(fmt.Sprint(optimisedErr)).To(Equal(fmt.Sprint(plainErr)))
+		Expect(optimised.GlobalScope()).To(Equal(plain.GlobalScope()))
+	},
+		Entry("arithmetic", "7 + 3 * (10 DIV (12 DIV (3 + 1) - 1))"),
+		Entry("identities", "- - a * 1 + 0 - (0 - a)"),
+		Entry("negative division", "-7 DIV 2 + a DIV -2"),
+		Entry("unknown variable", "b * 0"),
+		Entry("division by zero", "a DIV (a - 7) * 1"),
+		Entry("constant division by zero", "a DIV (7 - 7)"),
+		Entry("DIV of a REAL", "2.5 DIV 1"),
+		Entry("negation of the most negative INTEGER", "- - (-9223372036854775807 - 1)"),
+	)
+
+	DescribeTable("folds within the range of INTEGER of the interpreter", func(mode interpreter.IntegerMode, expr, expected string) {
+		program := fmt.Sprintf("BEGIN res := %s END.", expr)
+		optimiser := func() *optimize.Optimiser {
+			pars := parser.NewParser(lexer.NewTokeniser(strings.NewReader(program)))
+			return optimize.NewOptimiser(pars, optimize.WithIntegerRange(mode.Bounds()))
+		}
+
+		node, err := optimiser().Program()
+		Expect(err).NotTo(HaveOccurred())
+		assign := node.(*parser.BlockNode).Compound.Children[0].(*parser.AssignNode)
+		Expect(render(assign.Right)).To(Equal(expected))
+
+		plain := interpreter.NewInterpreter(parser.NewParser(lexer.NewTokeniser(strings.NewReader(program))), interpreter.WithIntegerMode(mode))
+		optimised := interpreter.NewInterpreter(optimiser(), interpreter.WithIntegerMode(mode))
+		Expect(fmt.Sprint(optimised.Interpret())).To(Equal(fmt.Sprint(plain.Interpret())))
+		Expect(optimised.GlobalScope()).To(Equal(plain.GlobalScope()))
+	},
+		Entry("16-bit overflow", interpreter.Int16, "200 * 200 DIV 1000", "(Int Divide (Multiply 200 200) 1000)"),
+		Entry("16-bit most negative", interpreter.Int16, "-32768", "-32768"),
+		Entry("32-bit in range", interpreter.Int32, "65536 * 16384", "1073741824"),
+		Entry("big", interpreter.BigInt, "9223372036854775807 * 4", "36893488147419103228"),
+	)
+
+	It("simplifies identities of INTEGER variables in a type checked program", func() {
+		program := "VAR x, res: INTEGER; BEGIN x := 7; res := - - x * 1 + 0 - (0 - x) END."
+		optimiser := func() *optimize.Optimiser {
+			checker := typecheck.NewChecker(parser.NewParser(lexer.NewTokeniser(strings.NewReader(program))))
+			return optimize.NewOptimiser(checker, optimize.WithTypes(checker.TypeOf))
+		}
+
+		node, err := optimiser().Program()
+		Expect(err).NotTo(HaveOccurred())
+		assign := node.(*parser.BlockNode).Compound.Children[1].(*parser.AssignNode)
+		Expect(render(assign.Right)).To(Equal("(Minus x (Minus x))"))
+
+		interp := interpreter.NewInterpreter(optimiser())
+		Expect(interp.Interpret()).To(Succeed())
+		Expect(interp.GlobalScope()).To(HaveKeyWithValue("res", 14))
+	})
+})
@@ -1,15 +1,36 @@
 package parser
 
-import "github.com/kieron-dev/lsbasi/lexer"
+import (
+	"math/big"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+)
 
 type Visitor interface {
 	VisitBinOp(*BinOpNode) (interface{}, error)
 	VisitNum(*NumNode) (interface{}, error)
+	VisitReal(*RealNode) (interface{}, error)
 	VisitUnary(*UnaryNode) (interface{}, error)
 	VisitCompound(*CompoundNode) (interface{}, error)
 	VisitAssign(*AssignNode) (interface{}, error)
 	VisitVar(*VarNode) (interface{}, error)
 	VisitNoOp(*NoOpNode) (interface{}, error)
+	VisitCase(*CaseNode) (interface{}, error)
+	VisitBlock(*BlockNode) (interface{}, error)
+	VisitConstDecl(*ConstDeclNode) (interface{}, error)
+	VisitUnit(*UnitNode) (interface{}, error)
+	VisitCall(*CallNode) (interface{}, error)
+	VisitTypeDecl(*TypeDeclNode) (interface{}, error)
+	VisitVarDecl(*VarDeclNode) (interface{}, error)
+	VisitFor(*ForNode) (interface{}, error)
+	VisitSet(*SetNode) (interface{}, error)
+	VisitNil(*NilNode) (interface{}, error)
+	VisitDeref(*DerefNode) (interface{}, error)
+	VisitField(*FieldNode) (interface{}, error)
+	VisitProcCall(*ProcCallNode) (interface{}, error)
+	VisitProcDecl(*ProcDeclNode) (interface{}, error)
+	VisitIndex(*IndexNode) (interface{}, error)
+	VisitToReal(*ToRealNode) (interface{}, error)
 }
 
 type ASTNode interface {
@@ -26,15 +47,36 @@ func (n *BinOpNode) Accept(v Visitor) (interface{}, error) {
 	return v.VisitBinOp(n)
 }
 
+// NumNode is an integer literal. A literal too large for an int has its
+// value in Big, and a Value of 0.
 type NumNode struct {
 	Token lexer.Token
 	Value int
+	Big   *big.Int
+}
+
+// Int returns the value of the literal, however large
+func (n *NumNode) Int() *big.Int {
+	if n.Big != nil {
+		return n.Big
+	}
+
+	return big.NewInt(int64(n.Value))
 }
 
 func (n *NumNode) Accept(v Visitor) (interface{}, error) {
 	return v.VisitNum(n)
 }
 
+type RealNode struct {
+	Token lexer.Token
+	Value float64
+}
+
+func (n *RealNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitReal(n)
+}
+
 type UnaryNode struct {
 	Token lexer.Token
 	Child ASTNode
@@ -46,15 +88,19 @@ func (n *UnaryNode) Accept(v Visitor) (interface{}, error) {
 
 type CompoundNode struct {
 	Children []ASTNode
+	Pos      lexer.Position
 }
 
 func (n *CompoundNode) Accept(v Visitor) (interface{}, error) {
 	return v.VisitCompound(n)
 }
 
+// AssignNode stores Right in Left, which is a designator: a *VarNode,
+// *DerefNode or *FieldNode
 type AssignNode struct {
-	Left  *VarNode
+	Left  ASTNode
 	Right ASTNode
+	Pos   lexer.Position
 }
 
 func (n *AssignNode) Accept(v Visitor) (interface{}, error) {
@@ -63,6 +109,7 @@ func (n *AssignNode) Accept(v Visitor) (interface{}, error) {
 
 type VarNode struct {
 	Value string
+	Pos   lexer.Position
 }
 
 func (n *VarNode) Accept(v Visitor) (interface{}, error) {
@@ -74,3 +121,334 @@ type NoOpNode struct{}
 func (n *NoOpNode) Accept(v Visitor) (interface{}, error) {
 	return v.VisitNoOp(n)
 }
+
+// CaseLabel matches a single constant, or the inclusive range Low..High.
+// High is nil for a single constant.
+type CaseLabel struct {
+	Low  ASTNode
+	High ASTNode
+	Pos  lexer.Position
+}
+
+type CaseBranch struct {
+	Labels []*CaseLabel
+	Body   ASTNode
+}
+
+// CaseNode runs the body of the branch with a label matching Expr, or Else
+// if none match. Else is nil when there is no ELSE part.
+type CaseNode struct {
+	Expr     ASTNode
+	Branches []*CaseBranch
+	Else     *CompoundNode
+	Pos      lexer.Position
+}
+
+func (n *CaseNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitCase(n)
+}
+
+// BlockNode is a program's declarations followed by its statements. Uses
+// lists the units named in the program's USES clause.
+type BlockNode struct {
+	Uses     []*UnitRef
+	Consts   []*ConstDeclNode
+	Types    []*TypeDeclNode
+	Vars     []*VarDeclNode
+	Procs    []*ProcDeclNode
+	Compound *CompoundNode
+}
+
+func (n *BlockNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitBlock(n)
+}
+
+type ConstDeclNode struct {
+	Name  string
+	Value ASTNode
+	Pos   lexer.Position
+}
+
+func (n *ConstDeclNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitConstDecl(n)
+}
+
+// UnitRef names a unit in a USES clause
+type UnitRef struct {
+	Name string
+	Pos  lexer.Position
+}
+
+// UnitNode is a separately loaded unit. Only the declarations in its
+// INTERFACE section are visible to programs and units which use it.
+type UnitNode struct {
+	Name           string
+	Pos            lexer.Position
+	InterfaceUses  []*UnitRef
+	Interface      *UnitSection
+	ImplUses       []*UnitRef
+	Implementation *UnitSection
+}
+
+// UnitSection holds the declarations of the INTERFACE or IMPLEMENTATION
+// section of a unit. The procedures of an INTERFACE section are headings,
+// with no Block, which the IMPLEMENTATION section declares in full.
+type UnitSection struct {
+	Consts []*ConstDeclNode
+	Types  []*TypeDeclNode
+	Vars   []*VarDeclNode
+	Procs  []*ProcDeclNode
+}
+
+func (n *UnitNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitUnit(n)
+}
+
+// CallNode is a call of a function, such as the built-in Abs(x)
+type CallNode struct {
+	Name string
+	Args []ASTNode
+	Pos  lexer.Position
+}
+
+func (n *CallNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitCall(n)
+}
+
+// TypeSpec is the definition of a type: a *NamedType, *EnumType or
+// *SubrangeType
+type TypeSpec interface {
+	typeSpec()
+}
+
+// NamedType refers to a type by name, such as INTEGER
+type NamedType struct {
+	Name string
+	Pos  lexer.Position
+}
+
+// EnumConst is one of the values of an enumerated type
+type EnumConst struct {
+	Name string
+	Pos  lexer.Position
+}
+
+// EnumType is an enumerated type such as (Red, Green, Blue)
+type EnumType struct {
+	Values []*EnumConst
+	Pos    lexer.Position
+}
+
+// SubrangeType is an inclusive range of an ordinal type, such as 0..9
+type SubrangeType struct {
+	Low  ASTNode
+	High ASTNode
+	Pos  lexer.Position
+}
+
+// SetType is a SET OF an ordinal type
+type SetType struct {
+	Elem TypeSpec
+	Pos  lexer.Position
+}
+
+// PointerType is a pointer to the named type, which may be declared later
+// in the same TYPE section
+type PointerType struct {
+	Name string
+	Pos  lexer.Position
+}
+
+// RecordType is a record with the fields declared in Fields
+type RecordType struct {
+	Fields []*VarDeclNode
+	Pos    lexer.Position
+}
+
+// ArrayType is an array indexed by an ordinal type. An array with several
+// indexes is an array of arrays.
+type ArrayType struct {
+	Index TypeSpec
+	Elem  TypeSpec
+	Pos   lexer.Position
+}
+
+func (*NamedType) typeSpec()    {}
+func (*EnumType) typeSpec()     {}
+func (*SubrangeType) typeSpec() {}
+func (*SetType) typeSpec()      {}
+func (*PointerType) typeSpec()  {}
+func (*RecordType) typeSpec()   {}
+func (*ArrayType) typeSpec()    {}
+
+type TypeDeclNode struct {
+	Name string
+	Type TypeSpec
+	Pos  lexer.Position
+}
+
+func (n *TypeDeclNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitTypeDecl(n)
+}
+
+// VarDeclNode declares one or more variables of the same type
+type VarDeclNode struct {
+	Names []*VarNode
+	Type  TypeSpec
+	Pos   lexer.Position
+}
+
+func (n *VarDeclNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitVarDecl(n)
+}
+
+// ForNode runs Body once for each value of Var from Start up to End, or
+// down to End when Down is set
+type ForNode struct {
+	Var   *VarNode
+	Start ASTNode
+	End   ASTNode
+	Down  bool
+	Body  ASTNode
+	Pos   lexer.Position
+}
+
+func (n *ForNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitFor(n)
+}
+
+// SetElement is a single member of a set literal, or the inclusive range
+// Low..High. High is nil for a single member.
+type SetElement struct {
+	Low  ASTNode
+	High ASTNode
+	Pos  lexer.Position
+}
+
+// SetNode is a set literal such as [1, 3..5]
+type SetNode struct {
+	Elements []*SetElement
+	Pos      lexer.Position
+}
+
+func (n *SetNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitSet(n)
+}
+
+type NilNode struct {
+	Pos lexer.Position
+}
+
+func (n *NilNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitNil(n)
+}
+
+// DerefNode is the variable Pointer points to, as in p^
+type DerefNode struct {
+	Pointer ASTNode
+	Pos     lexer.Position
+}
+
+func (n *DerefNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitDeref(n)
+}
+
+// FieldNode is a field of a record, as in r.name
+type FieldNode struct {
+	Record ASTNode
+	Name   string
+	Pos    lexer.Position
+}
+
+func (n *FieldNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitField(n)
+}
+
+// ProcCallNode is a procedure call statement, such as New(p)
+type ProcCallNode struct {
+	Name string
+	Args []ASTNode
+	Pos  lexer.Position
+}
+
+func (n *ProcCallNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitProcCall(n)
+}
+
+// IndexNode is an element of an array, as in a[i]
+type IndexNode struct {
+	Array ASTNode
+	Index ASTNode
+	Pos   lexer.Position
+}
+
+func (n *IndexNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitIndex(n)
+}
+
+// ToRealNode converts the INTEGER value of Child to a REAL. The type
+// checker inserts it where an INTEGER is used as a REAL.
+type ToRealNode struct {
+	Child ASTNode
+	Pos   lexer.Position
+}
+
+func (n *ToRealNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitToReal(n)
+}
+
+// ParamNode is a group of parameters of the same type. ByRef parameters are
+// declared with VAR and share the variable passed by the caller.
+type ParamNode struct {
+	Names []*VarNode
+	Type  TypeSpec
+	ByRef bool
+	Pos   lexer.Position
+}
+
+// ProcDeclNode declares a procedure, or a function if it has a Result type
+type ProcDeclNode struct {
+	Name   string
+	Params []*ParamNode
+	Result TypeSpec
+	Block  *BlockNode
+	Pos    lexer.Position
+}
+
+func (n *ProcDeclNode) Accept(v Visitor) (interface{}, error) {
+	return v.VisitProcDecl(n)
+}
+
+// Designator renders a designator such as p^.next
+func Designator(node ASTNode) string {
+	switch n := node.(type) {
+	case *VarNode:
+		return n.Value
+	case *DerefNode:
+		return Designator(n.Pointer) + "^"
+	case *FieldNode:
+		return Designator(n.Record) + "." + n.Name
+	case *IndexNode:
+		return Designator(n.Array) + "[" + Designator(n.Index) + "]"
+	case *NumNode:
+		return n.Int().String()
+	}
+
+	return "..."
+}
+
+// DesignatorVar returns the variable a designator starts from
+func DesignatorVar(node ASTNode) *VarNode {
+	switch n := node.(type) {
+	case *VarNode:
+		return n
+	case *DerefNode:
+		return DesignatorVar(n.Pointer)
+	case *FieldNode:
+		return DesignatorVar(n.Record)
+	case *IndexNode:
+		return DesignatorVar(n.Array)
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package parser
 
 import (
 	"fmt"
+	"math/big"
 
 	"github.com/kieron-dev/lsbasi/lexer"
 )
@@ -15,9 +16,26 @@ type Tokeniser interface {
 	NextToken() (lexer.Token, error)
 }
 
+// SyntaxError is a parse error at the position of the offending token
+type SyntaxError struct {
+	Pos lexer.Position
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at %s", e.Msg, e.Pos)
+}
+
+// maxNesting bounds how deeply statements and expressions are nested, so
+// that the parser and the passes which walk the tree it builds cannot
+// exhaust the Go stack. Each operator of a chain such as 1 + 1 + 1 nests
+// the terms before it one level deeper.
+const maxNesting = 10000
+
 type Parser struct {
 	tokeniser    Tokeniser
 	currentToken lexer.Token
+	nesting      int
 }
 
 func NewParser(tokeniser Tokeniser) *Parser {
@@ -33,35 +51,771 @@ func (p *Parser) NextToken() (lexer.Token, error) {
 	return token, err
 }
 
+func (p *Parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{
+		Pos: p.currentToken.Pos,
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
+
+// nest counts a level of nesting starting at the current token, failing if
+// there are too many. Each level is ended by a call of unnest.
+func (p *Parser) nest() error {
+	if p.nesting == maxNesting {
+		return p.errorf("nested more than %d levels deep", maxNesting)
+	}
+	p.nesting++
+
+	return nil
+}
+
+func (p *Parser) unnest(levels int) {
+	p.nesting -= levels
+}
+
+func (p *Parser) CurrentToken() lexer.Token {
+	return p.currentToken
+}
+
 func (p *Parser) Program() (ASTNode, error) {
-	// program : compound-statement DOT
+	// program : uses_clause? block DOT
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	uses, err := p.UsesClause()
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := p.Block()
+	if err != nil {
+		return nil, err
+	}
+	val.Uses = uses
+
+	if p.currentToken.Type != lexer.Dot {
+		return nil, p.errorf("expected a DOT, got %s", p.currentToken.Type)
+	}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
+func (p *Parser) Unit() (*UnitNode, error) {
+	// unit : UNIT ID SEMI
+	//        INTERFACE uses_clause? unit_section
+	//        IMPLEMENTATION uses_clause? unit_section
+	//        END DOT
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	if err := p.eat(lexer.Unit); err != nil {
+		return nil, err
+	}
+
+	if p.currentToken.Type != lexer.ID {
+		return nil, p.errorf("expected an ID, got %s", p.currentToken.Type)
+	}
+
+	node := &UnitNode{
+		Name: p.currentToken.Value.(string),
+		Pos:  p.currentToken.Pos,
+	}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	if err := p.eat(lexer.Semi); err != nil {
+		return nil, err
+	}
+
+	if err := p.eat(lexer.Interface); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if node.InterfaceUses, err = p.UsesClause(); err != nil {
+		return nil, err
+	}
+
+	if node.Interface, err = p.UnitSection(true); err != nil {
+		return nil, err
+	}
+
+	if err := p.eat(lexer.Implementation); err != nil {
+		return nil, err
+	}
+
+	if node.ImplUses, err = p.UsesClause(); err != nil {
+		return nil, err
+	}
+
+	if node.Implementation, err = p.UnitSection(false); err != nil {
+		return nil, err
+	}
+
+	if err := p.eat(lexer.End); err != nil {
+		return nil, err
+	}
+
+	if p.currentToken.Type != lexer.Dot {
+		return nil, p.errorf("expected a DOT, got %s", p.currentToken.Type)
+	}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// UnitSection parses the declarations of a section of a unit. Procedures
+// are only headings if headings is true, as in an INTERFACE section.
+func (p *Parser) UnitSection(headings bool) (*UnitSection, error) {
+	// unit_section : (declarations | type_declarations | var_declarations |
+	//                 proc_heading | proc_declaration)*
+
+	node := &UnitSection{}
+
+	for {
+		switch p.currentToken.Type {
+		case lexer.Const:
+			consts, err := p.Declarations()
+			if err != nil {
+				return nil, err
+			}
+			node.Consts = append(node.Consts, consts...)
+			continue
+
+		case lexer.Type:
+			types, err := p.TypeDeclarations()
+			if err != nil {
+				return nil, err
+			}
+			node.Types = append(node.Types, types...)
+			continue
+
+		case lexer.Var:
+			vars, err := p.VarDeclarations()
+			if err != nil {
+				return nil, err
+			}
+			node.Vars = append(node.Vars, vars...)
+			continue
+
+		case lexer.Procedure, lexer.Function:
+			parse := p.ProcDeclaration
+			if headings {
+				parse = p.ProcHeading
+			}
+
+			proc, err := parse()
+			if err != nil {
+				return nil, err
+			}
+			node.Procs = append(node.Procs, proc)
+			continue
+		}
+
+		return node, nil
+	}
+}
+
+func (p *Parser) UsesClause() ([]*UnitRef, error) {
+	// uses_clause : USES ID (COMMA ID)* SEMI
+
+	if p.currentToken.Type != lexer.Uses {
+		return nil, nil
+	}
+
+	units := []*UnitRef{}
+
+	for first := true; first || p.currentToken.Type == lexer.Comma; first = false {
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+
+		if p.currentToken.Type != lexer.ID {
+			return nil, p.errorf("expected a unit name, got %s", p.currentToken.Type)
+		}
+
+		units = append(units, &UnitRef{
+			Name: p.currentToken.Value.(string),
+			Pos:  p.currentToken.Pos,
+		})
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.eat(lexer.Semi); err != nil {
+		return nil, err
+	}
+
+	return units, nil
+}
+
+// eat moves past the current token, which must be of type tokenType
+func (p *Parser) eat(tokenType lexer.TokenType) error {
+	if p.currentToken.Type != tokenType {
+		return p.errorf("expected %s, got %s", tokenType, p.currentToken.Type)
+	}
+
+	_, err := p.NextToken()
+
+	return err
+}
+
+func (p *Parser) Block() (*BlockNode, error) {
+	// block : (declarations | type_declarations | var_declarations |
+	//          proc_declaration)*
+	//         compound_statement
+
+	node := &BlockNode{}
+
+	for {
+		switch p.currentToken.Type {
+		case lexer.Const:
+			consts, err := p.Declarations()
+			if err != nil {
+				return nil, err
+			}
+			node.Consts = append(node.Consts, consts...)
+			continue
+
+		case lexer.Type:
+			types, err := p.TypeDeclarations()
+			if err != nil {
+				return nil, err
+			}
+			node.Types = append(node.Types, types...)
+			continue
+
+		case lexer.Var:
+			vars, err := p.VarDeclarations()
+			if err != nil {
+				return nil, err
+			}
+			node.Vars = append(node.Vars, vars...)
+			continue
+
+		case lexer.Procedure, lexer.Function:
+			proc, err := p.ProcDeclaration()
+			if err != nil {
+				return nil, err
+			}
+			node.Procs = append(node.Procs, proc)
+			continue
+		}
+
+		break
+	}
+
+	compound, err := p.CompoundStatement()
+	if err != nil {
+		return nil, err
+	}
+	node.Compound = compound.(*CompoundNode)
+
+	return node, nil
+}
+
+func (p *Parser) Declarations() ([]*ConstDeclNode, error) {
+	// declarations : (CONST (const_decl SEMI)+)?
+
+	if p.currentToken.Type != lexer.Const {
+		return nil, nil
+	}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	consts := []*ConstDeclNode{}
+
+	for first := true; first || p.currentToken.Type == lexer.ID; first = false {
+		decl, err := p.ConstDecl()
+		if err != nil {
+			return nil, err
+		}
+		consts = append(consts, decl)
+
+		if p.currentToken.Type != lexer.Semi {
+			return nil, p.errorf("expected ;, got %s", p.currentToken.Type)
+		}
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	return consts, nil
+}
+
+func (p *Parser) ConstDecl() (*ConstDeclNode, error) {
+	// const_decl : ID EQUAL expr
+
+	if p.currentToken.Type != lexer.ID {
+		return nil, p.errorf("expected an ID, got %s", p.currentToken.Type)
+	}
+
+	node := &ConstDeclNode{
+		Name: p.currentToken.Value.(string),
+		Pos:  p.currentToken.Pos,
+	}
 
 	if _, err := p.NextToken(); err != nil {
 		return nil, err
 	}
 
-	val, err := p.CompoundStatement()
+	if p.currentToken.Type != lexer.Equal {
+		return nil, p.errorf("expected =, got %s", p.currentToken.Type)
+	}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	val, err := p.Expr()
 	if err != nil {
 		return nil, err
 	}
+	node.Value = val
+
+	return node, nil
+}
+
+func (p *Parser) TypeDeclarations() ([]*TypeDeclNode, error) {
+	// type_declarations : TYPE (ID EQUAL type_spec SEMI)+
+
+	if err := p.eat(lexer.Type); err != nil {
+		return nil, err
+	}
+
+	types := []*TypeDeclNode{}
+
+	for first := true; first || p.currentToken.Type == lexer.ID; first = false {
+		if p.currentToken.Type != lexer.ID {
+			return nil, p.errorf("expected an ID, got %s", p.currentToken.Type)
+		}
+
+		decl := &TypeDeclNode{
+			Name: p.currentToken.Value.(string),
+			Pos:  p.currentToken.Pos,
+		}
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+
+		if err := p.eat(lexer.Equal); err != nil {
+			return nil, err
+		}
+
+		spec, err := p.TypeSpec()
+		if err != nil {
+			return nil, err
+		}
+		decl.Type = spec
+		types = append(types, decl)
+
+		if err := p.eat(lexer.Semi); err != nil {
+			return nil, err
+		}
+	}
+
+	return types, nil
+}
+
+func (p *Parser) VarDeclarations() ([]*VarDeclNode, error) {
+	// var_declarations : VAR (var_decl SEMI)+
+
+	if err := p.eat(lexer.Var); err != nil {
+		return nil, err
+	}
+
+	vars := []*VarDeclNode{}
+
+	for first := true; first || p.currentToken.Type == lexer.ID; first = false {
+		decl, err := p.VarDecl()
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, decl)
+
+		if err := p.eat(lexer.Semi); err != nil {
+			return nil, err
+		}
+	}
+
+	return vars, nil
+}
+
+func (p *Parser) VarDecl() (*VarDeclNode, error) {
+	// var_decl : ID (COMMA ID)* COLON type_spec
+
+	decl := &VarDeclNode{Pos: p.currentToken.Pos}
+
+	for {
+		v, err := p.Variable()
+		if err != nil {
+			return nil, err
+		}
+		decl.Names = append(decl.Names, v)
+
+		if p.currentToken.Type != lexer.Comma {
+			break
+		}
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.eat(lexer.Colon); err != nil {
+		return nil, err
+	}
+
+	spec, err := p.TypeSpec()
+	if err != nil {
+		return nil, err
+	}
+	decl.Type = spec
+
+	return decl, nil
+}
+
+func (p *Parser) ProcDeclaration() (*ProcDeclNode, error) {
+	// proc_declaration : proc_heading block SEMI
+
+	node, err := p.ProcHeading()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := p.Block()
+	if err != nil {
+		return nil, err
+	}
+	node.Block = block
+
+	if err := p.eat(lexer.Semi); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// ProcHeading parses the heading of a procedure or function, which has no
+// Block
+func (p *Parser) ProcHeading() (*ProcDeclNode, error) {
+	// proc_heading : (PROCEDURE ID params? | FUNCTION ID params? COLON type_spec)
+	//                SEMI
+
+	isFunction := p.currentToken.Type == lexer.Function
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	if p.currentToken.Type != lexer.ID {
+		return nil, p.errorf("expected a name, got %s", p.currentToken.Type)
+	}
+	node := &ProcDeclNode{Name: p.currentToken.Value.(string), Pos: p.currentToken.Pos}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	if p.currentToken.Type == lexer.LParen {
+		params, err := p.Params()
+		if err != nil {
+			return nil, err
+		}
+		node.Params = params
+	}
+
+	if isFunction {
+		if err := p.eat(lexer.Colon); err != nil {
+			return nil, err
+		}
+
+		result, err := p.TypeSpec()
+		if err != nil {
+			return nil, err
+		}
+		node.Result = result
+	}
+
+	if err := p.eat(lexer.Semi); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func (p *Parser) Params() ([]*ParamNode, error) {
+	// params : LPAREN param_group (SEMI param_group)* RPAREN
+	// param_group : VAR? ID (COMMA ID)* COLON type_spec
+
+	params := []*ParamNode{}
+
+	if err := p.eat(lexer.LParen); err != nil {
+		return nil, err
+	}
+
+	for {
+		param := &ParamNode{Pos: p.currentToken.Pos}
+
+		if p.currentToken.Type == lexer.Var {
+			param.ByRef = true
+			if _, err := p.NextToken(); err != nil {
+				return nil, err
+			}
+		}
+
+		decl, err := p.VarDecl()
+		if err != nil {
+			return nil, err
+		}
+		param.Names = decl.Names
+		param.Type = decl.Type
+		params = append(params, param)
+
+		if p.currentToken.Type != lexer.Semi {
+			break
+		}
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.eat(lexer.RParen); err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+func (p *Parser) TypeSpec() (TypeSpec, error) {
+	// type_spec : ID
+	//           | LPAREN ID (COMMA ID)* RPAREN
+	//           | expr RANGE expr
+	//           | SET OF type_spec
+	//           | CARET ID
+	//           | record_type
+	//           | array_type
+
+	pos := p.currentToken.Pos
+
+	if p.currentToken.Type == lexer.Array {
+		return p.ArrayType()
+	}
+
+	if p.currentToken.Type == lexer.Caret {
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+
+		if p.currentToken.Type != lexer.ID {
+			return nil, p.errorf("expected a type name, got %s", p.currentToken.Type)
+		}
+		name := p.currentToken.Value.(string)
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+
+		return &PointerType{Name: name, Pos: pos}, nil
+	}
+
+	if p.currentToken.Type == lexer.Record {
+		return p.RecordType()
+	}
+
+	if p.currentToken.Type == lexer.LParen {
+		return p.EnumType()
+	}
+
+	if p.currentToken.Type == lexer.Set {
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+
+		if err := p.eat(lexer.Of); err != nil {
+			return nil, err
+		}
+
+		elem, err := p.TypeSpec()
+		if err != nil {
+			return nil, err
+		}
+
+		return &SetType{Elem: elem, Pos: pos}, nil
+	}
+
+	low, err := p.Expr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.currentToken.Type != lexer.Range {
+		if v, ok := low.(*VarNode); ok {
+			return &NamedType{Name: v.Value, Pos: v.Pos}, nil
+		}
+
+		return nil, p.errorf("expected a type, got %s", p.currentToken.Type)
+	}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	high, err := p.Expr()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SubrangeType{Low: low, High: high, Pos: pos}, nil
+}
+
+func (p *Parser) ArrayType() (*ArrayType, error) {
+	// array_type : ARRAY LBRACKET type_spec (COMMA type_spec)* RBRACKET OF type_spec
+
+	pos := p.currentToken.Pos
+
+	if err := p.eat(lexer.Array); err != nil {
+		return nil, err
+	}
+
+	if err := p.eat(lexer.LBracket); err != nil {
+		return nil, err
+	}
+
+	indexes := []TypeSpec{}
+	for {
+		index, err := p.TypeSpec()
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+
+		if p.currentToken.Type != lexer.Comma {
+			break
+		}
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.eat(lexer.RBracket); err != nil {
+		return nil, err
+	}
+
+	if err := p.eat(lexer.Of); err != nil {
+		return nil, err
+	}
+
+	elem, err := p.TypeSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	for n := len(indexes) - 1; n >= 0; n-- {
+		elem = &ArrayType{Index: indexes[n], Elem: elem, Pos: pos}
+	}
+
+	return elem.(*ArrayType), nil
+}
+
+func (p *Parser) RecordType() (*RecordType, error) {
+	// record_type : RECORD (var_decl (SEMI var_decl)*)? SEMI? END
+
+	node := &RecordType{Pos: p.currentToken.Pos}
+
+	if err := p.eat(lexer.Record); err != nil {
+		return nil, err
+	}
+
+	for p.currentToken.Type == lexer.ID {
+		field, err := p.VarDecl()
+		if err != nil {
+			return nil, err
+		}
+		node.Fields = append(node.Fields, field)
+
+		if p.currentToken.Type != lexer.Semi {
+			break
+		}
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.eat(lexer.End); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func (p *Parser) EnumType() (*EnumType, error) {
+	// enum_type : LPAREN ID (COMMA ID)* RPAREN
+
+	node := &EnumType{Pos: p.currentToken.Pos}
+
+	if err := p.eat(lexer.LParen); err != nil {
+		return nil, err
+	}
+
+	for {
+		if p.currentToken.Type != lexer.ID {
+			return nil, p.errorf("expected an ID, got %s", p.currentToken.Type)
+		}
+
+		node.Values = append(node.Values, &EnumConst{
+			Name: p.currentToken.Value.(string),
+			Pos:  p.currentToken.Pos,
+		})
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+
+		if p.currentToken.Type != lexer.Comma {
+			break
+		}
 
-	if p.currentToken.Type != lexer.Dot {
-		return nil, fmt.Errorf("expected a DOT, got %s", p.currentToken.Type)
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
 	}
 
-	if _, err := p.NextToken(); err != nil {
+	if err := p.eat(lexer.RParen); err != nil {
 		return nil, err
 	}
 
-	return val, nil
+	return node, nil
 }
 
 func (p *Parser) CompoundStatement() (ASTNode, error) {
 	// compound-statement: BEGIN statement-list END
 
 	if p.currentToken.Type != lexer.Begin {
-		return nil, fmt.Errorf("expected BEGIN, got %s", p.currentToken.Type)
+		return nil, p.errorf("expected BEGIN, got %s", p.currentToken.Type)
 	}
+	pos := p.currentToken.Pos
 
 	if _, err := p.NextToken(); err != nil {
 		return nil, err
@@ -71,9 +825,10 @@ func (p *Parser) CompoundStatement() (ASTNode, error) {
 	if err != nil {
 		return nil, err
 	}
+	val.Pos = pos
 
 	if p.currentToken.Type != lexer.End {
-		return nil, fmt.Errorf("expected END, got %s", p.currentToken.Type)
+		return nil, p.errorf("expected END, got %s", p.currentToken.Type)
 	}
 
 	if _, err := p.NextToken(); err != nil {
@@ -83,7 +838,7 @@ func (p *Parser) CompoundStatement() (ASTNode, error) {
 	return val, nil
 }
 
-func (p *Parser) StatementList() (ASTNode, error) {
+func (p *Parser) StatementList() (*CompoundNode, error) {
 	// statement-list: statement
 	//               | statement SEMI statement_list
 
@@ -115,12 +870,27 @@ func (p *Parser) StatementList() (ASTNode, error) {
 func (p *Parser) Statement() (ASTNode, error) {
 	// statement : compound_statement
 	//           | assignment_statement
+	//           | case_statement
+	//           | for_statement
 	//           | empty
 
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer p.unnest(1)
+
 	if p.currentToken.Type == lexer.Begin {
 		return p.CompoundStatement()
 	}
 
+	if p.currentToken.Type == lexer.Case {
+		return p.CaseStatement()
+	}
+
+	if p.currentToken.Type == lexer.For {
+		return p.ForStatement()
+	}
+
 	if p.currentToken.Type == lexer.ID {
 		return p.AssignmentStatement()
 	}
@@ -128,16 +898,225 @@ func (p *Parser) Statement() (ASTNode, error) {
 	return p.Empty()
 }
 
+func (p *Parser) ForStatement() (ASTNode, error) {
+	// for_statement : FOR variable ASSIGN expr (TO | DOWNTO) expr DO statement
+
+	node := &ForNode{Pos: p.currentToken.Pos}
+
+	if err := p.eat(lexer.For); err != nil {
+		return nil, err
+	}
+
+	v, err := p.Variable()
+	if err != nil {
+		return nil, err
+	}
+	node.Var = v
+
+	if err := p.eat(lexer.Assign); err != nil {
+		return nil, err
+	}
+
+	if node.Start, err = p.Expr(); err != nil {
+		return nil, err
+	}
+
+	switch p.currentToken.Type {
+	case lexer.To:
+	case lexer.Downto:
+		node.Down = true
+	default:
+		return nil, p.errorf("expected TO or DOWNTO, got %s", p.currentToken.Type)
+	}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	if node.End, err = p.Expr(); err != nil {
+		return nil, err
+	}
+
+	if err := p.eat(lexer.Do); err != nil {
+		return nil, err
+	}
+
+	if node.Body, err = p.Statement(); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func (p *Parser) CaseStatement() (ASTNode, error) {
+	// case_statement : CASE expr OF case_element (SEMI case_element)* SEMI?
+	//                  (ELSE statement_list)? END
+
+	node := &CaseNode{Pos: p.currentToken.Pos}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	expr, err := p.Expr()
+	if err != nil {
+		return nil, err
+	}
+	node.Expr = expr
+
+	if p.currentToken.Type != lexer.Of {
+		return nil, p.errorf("expected OF, got %s", p.currentToken.Type)
+	}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	for {
+		branch, err := p.CaseElement()
+		if err != nil {
+			return nil, err
+		}
+		node.Branches = append(node.Branches, branch)
+
+		if p.currentToken.Type != lexer.Semi {
+			break
+		}
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+
+		if p.currentToken.Type == lexer.Else || p.currentToken.Type == lexer.End {
+			break
+		}
+	}
+
+	if p.currentToken.Type == lexer.Else {
+		pos := p.currentToken.Pos
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+
+		elseBody, err := p.StatementList()
+		if err != nil {
+			return nil, err
+		}
+		elseBody.Pos = pos
+		node.Else = elseBody
+	}
+
+	if p.currentToken.Type != lexer.End {
+		return nil, p.errorf("expected END, got %s", p.currentToken.Type)
+	}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func (p *Parser) CaseElement() (*CaseBranch, error) {
+	// case_element : case_label (COMMA case_label)* COLON statement
+
+	branch := &CaseBranch{}
+
+	for {
+		label, err := p.CaseLabel()
+		if err != nil {
+			return nil, err
+		}
+		branch.Labels = append(branch.Labels, label)
+
+		if p.currentToken.Type != lexer.Comma {
+			break
+		}
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.currentToken.Type != lexer.Colon {
+		return nil, p.errorf("expected :, got %s", p.currentToken.Type)
+	}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	body, err := p.Statement()
+	if err != nil {
+		return nil, err
+	}
+	branch.Body = body
+
+	return branch, nil
+}
+
+func (p *Parser) CaseLabel() (*CaseLabel, error) {
+	// case_label : expr (RANGE expr)?
+	//
+	// labels must be constant expressions, which the semantic analyser checks
+
+	label := &CaseLabel{Pos: p.currentToken.Pos}
+
+	low, err := p.Expr()
+	if err != nil {
+		return nil, err
+	}
+	label.Low = low
+
+	if p.currentToken.Type != lexer.Range {
+		return label, nil
+	}
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	high, err := p.Expr()
+	if err != nil {
+		return nil, err
+	}
+	label.High = high
+
+	return label, nil
+}
+
 func (p *Parser) AssignmentStatement() (ASTNode, error) {
-	// assignment_statement : variable ASSIGN expr
+	// assignment_statement : designator ASSIGN expr
+	//                      | ID args?
+	//
+	// an ID followed by arguments or by the end of the statement is
+	// a procedure call
+
+	v, err := p.Variable()
+	if err != nil {
+		return nil, err
+	}
 
-	left, err := p.Variable()
+	switch p.currentToken.Type {
+	case lexer.LParen, lexer.Semi, lexer.End, lexer.Else:
+		call := &ProcCallNode{Name: v.Value, Pos: v.Pos}
+		if p.currentToken.Type == lexer.LParen {
+			if call.Args, err = p.Args(); err != nil {
+				return nil, err
+			}
+		}
+
+		return call, nil
+	}
+
+	left, err := p.Designator(v)
 	if err != nil {
 		return nil, err
 	}
 
 	if p.currentToken.Type != lexer.Assign {
-		return nil, fmt.Errorf("expected :=, got %s", p.currentToken.Type)
+		return nil, p.errorf("expected :=, got %s", p.currentToken.Type)
 	}
 
 	if _, err := p.NextToken(); err != nil {
@@ -152,18 +1131,82 @@ func (p *Parser) AssignmentStatement() (ASTNode, error) {
 	return &AssignNode{
 		Left:  left,
 		Right: right,
+		Pos:   v.Pos,
 	}, nil
 }
 
+func (p *Parser) Designator(v *VarNode) (ASTNode, error) {
+	// designator : variable (CARET | DOT ID | LBRACKET expr (COMMA expr)* RBRACKET)*
+
+	var node ASTNode = v
+
+	for {
+		pos := p.currentToken.Pos
+
+		switch p.currentToken.Type {
+		case lexer.Caret:
+			if _, err := p.NextToken(); err != nil {
+				return nil, err
+			}
+			node = &DerefNode{Pointer: node, Pos: pos}
+			continue
+
+		case lexer.Dot:
+			if _, err := p.NextToken(); err != nil {
+				return nil, err
+			}
+
+			if p.currentToken.Type != lexer.ID {
+				return nil, p.errorf("expected a field name, got %s", p.currentToken.Type)
+			}
+			node = &FieldNode{Record: node, Name: p.currentToken.Value.(string), Pos: p.currentToken.Pos}
+
+			if _, err := p.NextToken(); err != nil {
+				return nil, err
+			}
+			continue
+
+		case lexer.LBracket:
+			if _, err := p.NextToken(); err != nil {
+				return nil, err
+			}
+
+			for {
+				index, err := p.Expr()
+				if err != nil {
+					return nil, err
+				}
+				node = &IndexNode{Array: node, Index: index, Pos: pos}
+
+				if p.currentToken.Type != lexer.Comma {
+					break
+				}
+
+				if _, err := p.NextToken(); err != nil {
+					return nil, err
+				}
+			}
+
+			if err := p.eat(lexer.RBracket); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return node, nil
+	}
+}
+
 func (p *Parser) Variable() (*VarNode, error) {
 	// variable : ID
 
 	if p.currentToken.Type != lexer.ID {
-		return nil, fmt.Errorf("expected an ID, got %s", p.currentToken.Type)
+		return nil, p.errorf("expected an ID, got %s", p.currentToken.Type)
 	}
 
 	node := &VarNode{
 		Value: p.currentToken.Value.(string),
+		Pos:   p.currentToken.Pos,
 	}
 
 	if _, err := p.NextToken(); err != nil {
@@ -173,18 +1216,156 @@ func (p *Parser) Variable() (*VarNode, error) {
 	return node, nil
 }
 
+func (p *Parser) SetLiteral() (*SetNode, error) {
+	// set_literal : LBRACKET (set_element (COMMA set_element)*)? RBRACKET
+	// set_element : expr (RANGE expr)?
+
+	node := &SetNode{Pos: p.currentToken.Pos}
+
+	if err := p.eat(lexer.LBracket); err != nil {
+		return nil, err
+	}
+
+	for p.currentToken.Type != lexer.RBracket {
+		elem := &SetElement{Pos: p.currentToken.Pos}
+
+		low, err := p.Expr()
+		if err != nil {
+			return nil, err
+		}
+		elem.Low = low
+
+		if p.currentToken.Type == lexer.Range {
+			if _, err := p.NextToken(); err != nil {
+				return nil, err
+			}
+
+			if elem.High, err = p.Expr(); err != nil {
+				return nil, err
+			}
+		}
+		node.Elements = append(node.Elements, elem)
+
+		if p.currentToken.Type != lexer.Comma {
+			break
+		}
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.eat(lexer.RBracket); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// Call parses the arguments of a call to the function named by v
+func (p *Parser) Call(v *VarNode) (*CallNode, error) {
+	// call : ID LPAREN expr (COMMA expr)* RPAREN
+
+	args, err := p.Args()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CallNode{Name: v.Value, Args: args, Pos: v.Pos}, nil
+}
+
+func (p *Parser) Args() ([]ASTNode, error) {
+	// args : LPAREN expr (COMMA expr)* RPAREN
+
+	var args []ASTNode
+
+	if err := p.eat(lexer.LParen); err != nil {
+		return nil, err
+	}
+
+	for {
+		arg, err := p.Expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.currentToken.Type != lexer.Comma {
+			break
+		}
+
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.eat(lexer.RParen); err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
+
 func (p *Parser) Empty() (ASTNode, error) {
 	return &NoOpNode{}, nil
 }
 
+var relationalOps = map[lexer.TokenType]bool{
+	lexer.Equal:        true,
+	lexer.NotEqual:     true,
+	lexer.Less:         true,
+	lexer.LessEqual:    true,
+	lexer.Greater:      true,
+	lexer.GreaterEqual: true,
+	lexer.In:           true,
+}
+
 func (p *Parser) Expr() (ASTNode, error) {
+	// expr : simple_expr (relational_op simple_expr)?
+
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer p.unnest(1)
+
+	val, err := p.SimpleExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !relationalOps[p.currentToken.Type] {
+		return val, nil
+	}
+	op := p.currentToken
+
+	if _, err := p.NextToken(); err != nil {
+		return nil, err
+	}
+
+	right, err := p.SimpleExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	return &BinOpNode{Left: val, Right: right, Token: op}, nil
+}
+
+func (p *Parser) SimpleExpr() (ASTNode, error) {
+	// simple_expr : term ((PLUS | MINUS) term)*
+
 	val, err := p.Term()
 	if err != nil {
 		return nil, err
 	}
 
+	ops := 0
+	defer func() { p.unnest(ops) }()
 	for p.currentToken.Type == lexer.Plus || p.currentToken.Type == lexer.Minus {
 		op := p.currentToken
+		if err := p.nest(); err != nil {
+			return nil, err
+		}
+		ops++
 
 		if _, err := p.NextToken(); err != nil {
 			return nil, err
@@ -207,8 +1388,14 @@ func (p *Parser) Term() (ASTNode, error) {
 		return nil, err
 	}
 
-	for p.currentToken.Type == lexer.Mult || p.currentToken.Type == lexer.Div {
+	ops := 0
+	defer func() { p.unnest(ops) }()
+	for p.currentToken.Type == lexer.Mult || p.currentToken.Type == lexer.Div || p.currentToken.Type == lexer.Mod {
 		op := p.currentToken
+		if err := p.nest(); err != nil {
+			return nil, err
+		}
+		ops++
 
 		if _, err := p.NextToken(); err != nil {
 			return nil, err
@@ -229,6 +1416,11 @@ func (p *Parser) Factor() (ASTNode, error) {
 	token := p.currentToken
 
 	if token.Type == lexer.Plus || token.Type == lexer.Minus {
+		if err := p.nest(); err != nil {
+			return nil, err
+		}
+		defer p.unnest(1)
+
 		if _, err := p.NextToken(); err != nil {
 			return nil, err
 		}
@@ -252,7 +1444,7 @@ func (p *Parser) Factor() (ASTNode, error) {
 		}
 
 		if p.currentToken.Type != lexer.RParen {
-			return nil, fmt.Errorf("expected ), got %s", p.currentToken.Type)
+			return nil, p.errorf("expected ), got %s", p.currentToken.Type)
 		}
 
 		if _, err := p.NextToken(); err != nil {
@@ -262,17 +1454,46 @@ func (p *Parser) Factor() (ASTNode, error) {
 		return val, nil
 	}
 
+	if token.Type == lexer.LBracket {
+		return p.SetLiteral()
+	}
+
 	if token.Type == lexer.ID {
-		return p.Variable()
+		v, err := p.Variable()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.currentToken.Type == lexer.LParen {
+			return p.Call(v)
+		}
+
+		return p.Designator(v)
+	}
+
+	if token.Type == lexer.Nil {
+		if _, err := p.NextToken(); err != nil {
+			return nil, err
+		}
+
+		return &NilNode{Pos: token.Pos}, nil
 	}
 
-	if token.Type != lexer.Number {
-		return nil, fmt.Errorf("expected a left parenthesis, ID or a number, got %s", token.Type)
+	if token.Type != lexer.Number && token.Type != lexer.Real {
+		return nil, p.errorf("expected a left parenthesis, ID or a number, got %s", token.Type)
 	}
 
 	if _, err := p.NextToken(); err != nil {
 		return nil, err
 	}
 
-	return &NumNode{Value: token.Value.(int)}, nil
+	if token.Type == lexer.Real {
+		return &RealNode{Token: token, Value: token.Value.(float64)}, nil
+	}
+
+	if n, ok := token.Value.(*big.Int); ok {
+		return &NumNode{Token: token, Big: n}, nil
+	}
+
+	return &NumNode{Token: token, Value: token.Value.(int)}, nil
 }
@@ -15,6 +15,7 @@ type parserFn int
 const (
 	expr parserFn = iota + 1
 	program
+	unit
 )
 
 var _ = DescribeTable("tokens to AST",
@@ -42,6 +43,8 @@ var _ = DescribeTable("tokens to AST",
 			val, err = pars.Expr()
 		case program:
 			val, err = pars.Program()
+		case unit:
+			val, err = pars.Unit()
 		}
 
 		if expectedErr != nil {
@@ -63,8 +66,8 @@ var _ = DescribeTable("tokens to AST",
 			{Type: lexer.Number, Value: 8},
 		},
 		&parser.BinOpNode{
-			Left:  &parser.NumNode{Value: 3},
-			Right: &parser.NumNode{Value: 8},
+			Left:  &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 3}, Value: 3},
+			Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 8}, Value: 8},
 			Token: lexer.Token{Type: lexer.Plus},
 		},
 		nil,
@@ -81,11 +84,11 @@ var _ = DescribeTable("tokens to AST",
 		},
 		&parser.BinOpNode{
 			Left: &parser.BinOpNode{
-				Left:  &parser.NumNode{Value: 3},
-				Right: &parser.NumNode{Value: 8},
+				Left:  &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 3}, Value: 3},
+				Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 8}, Value: 8},
 				Token: lexer.Token{Type: lexer.Plus},
 			},
-			Right: &parser.NumNode{Value: 6},
+			Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 6}, Value: 6},
 			Token: lexer.Token{Type: lexer.Plus},
 		},
 		nil,
@@ -99,8 +102,8 @@ var _ = DescribeTable("tokens to AST",
 			{Type: lexer.Number, Value: 8},
 		},
 		&parser.BinOpNode{
-			Left:  &parser.NumNode{Value: 3},
-			Right: &parser.NumNode{Value: 8},
+			Left:  &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 3}, Value: 3},
+			Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 8}, Value: 8},
 			Token: lexer.Token{Type: lexer.Minus},
 		},
 		nil,
@@ -114,13 +117,106 @@ var _ = DescribeTable("tokens to AST",
 			{Type: lexer.Number, Value: 8},
 		},
 		&parser.BinOpNode{
-			Left:  &parser.NumNode{Value: 3},
-			Right: &parser.NumNode{Value: 8},
+			Left:  &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 3}, Value: 3},
+			Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 8}, Value: 8},
 			Token: lexer.Token{Type: lexer.Mult},
 		},
 		nil,
 	),
 
+	Entry("1.5*8",
+		expr,
+		[]lexer.Token{
+			{Type: lexer.Real, Value: 1.5},
+			{Type: lexer.Mult},
+			{Type: lexer.Number, Value: 8},
+		},
+		&parser.BinOpNode{
+			Left:  &parser.RealNode{Token: lexer.Token{Type: lexer.Real, Value: 1.5}, Value: 1.5},
+			Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 8}, Value: 8},
+			Token: lexer.Token{Type: lexer.Mult},
+		},
+		nil,
+	),
+
+	Entry("Max(-x, 2)",
+		expr,
+		[]lexer.Token{
+			{Type: lexer.ID, Value: "Max"},
+			{Type: lexer.LParen},
+			{Type: lexer.Minus},
+			{Type: lexer.ID, Value: "x"},
+			{Type: lexer.Comma},
+			{Type: lexer.Number, Value: 2},
+			{Type: lexer.RParen},
+		},
+		&parser.CallNode{
+			Name: "Max",
+			Args: []parser.ASTNode{
+				&parser.UnaryNode{Token: lexer.Token{Type: lexer.Minus}, Child: &parser.VarNode{Value: "x"}},
+				&parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 2}, Value: 2},
+			},
+		},
+		nil,
+	),
+
+	Entry("x IN [1, 3..5]",
+		expr,
+		[]lexer.Token{
+			{Type: lexer.ID, Value: "x"},
+			{Type: lexer.In},
+			{Type: lexer.LBracket},
+			{Type: lexer.Number, Value: 1},
+			{Type: lexer.Comma},
+			{Type: lexer.Number, Value: 3},
+			{Type: lexer.Range},
+			{Type: lexer.Number, Value: 5},
+			{Type: lexer.RBracket},
+		},
+		&parser.BinOpNode{
+			Left: &parser.VarNode{Value: "x"},
+			Right: &parser.SetNode{
+				Elements: []*parser.SetElement{
+					{Low: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 1}, Value: 1}},
+					{Low: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 3}, Value: 3}, High: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 5}, Value: 5}},
+				},
+			},
+			Token: lexer.Token{Type: lexer.In},
+		},
+		nil,
+	),
+
+	Entry("1+2 <= 3",
+		expr,
+		[]lexer.Token{
+			{Type: lexer.Number, Value: 1},
+			{Type: lexer.Plus},
+			{Type: lexer.Number, Value: 2},
+			{Type: lexer.LessEqual},
+			{Type: lexer.Number, Value: 3},
+		},
+		&parser.BinOpNode{
+			Left: &parser.BinOpNode{
+				Left:  &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 1}, Value: 1},
+				Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 2}, Value: 2},
+				Token: lexer.Token{Type: lexer.Plus},
+			},
+			Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 3}, Value: 3},
+			Token: lexer.Token{Type: lexer.LessEqual},
+		},
+		nil,
+	),
+
+	Entry("[]",
+		expr,
+		[]lexer.Token{
+			{Type: lexer.LBracket},
+			{Type: lexer.RBracket},
+		},
+		&parser.SetNode{},
+		nil,
+	),
+
 	Entry("3/8",
 		expr,
 		[]lexer.Token{
@@ -129,8 +225,8 @@ var _ = DescribeTable("tokens to AST",
 			{Type: lexer.Number, Value: 8},
 		},
 		&parser.BinOpNode{
-			Left:  &parser.NumNode{Value: 3},
-			Right: &parser.NumNode{Value: 8},
+			Left:  &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 3}, Value: 3},
+			Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 8}, Value: 8},
 			Token: lexer.Token{Type: lexer.Div},
 		},
 		nil,
@@ -148,10 +244,10 @@ var _ = DescribeTable("tokens to AST",
 			{Type: lexer.RParen},
 		},
 		&parser.BinOpNode{
-			Left: &parser.NumNode{Value: 25},
+			Left: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 25}, Value: 25},
 			Right: &parser.BinOpNode{
-				Left:  &parser.NumNode{Value: 5},
-				Right: &parser.NumNode{Value: 6},
+				Left:  &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 5}, Value: 5},
+				Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 6}, Value: 6},
 				Token: lexer.Token{Type: lexer.Plus},
 			},
 			Token: lexer.Token{Type: lexer.Minus},
@@ -166,7 +262,7 @@ var _ = DescribeTable("tokens to AST",
 			{Type: lexer.Number, Value: 5},
 		},
 		&parser.UnaryNode{
-			Child: &parser.NumNode{Value: 5},
+			Child: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 5}, Value: 5},
 			Token: lexer.Token{Type: lexer.Minus},
 		},
 		nil,
@@ -179,7 +275,7 @@ var _ = DescribeTable("tokens to AST",
 			{Type: lexer.Number, Value: 5},
 		},
 		&parser.UnaryNode{
-			Child: &parser.NumNode{Value: 5},
+			Child: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 5}, Value: 5},
 			Token: lexer.Token{Type: lexer.Plus},
 		},
 		nil,
@@ -208,17 +304,568 @@ END.
 			{Type: lexer.Dot},
 			{Type: lexer.EOF},
 		},
-		&parser.CompoundNode{
-			Children: []parser.ASTNode{
-				&parser.AssignNode{
-					Left:  &parser.VarNode{Value: "bob"},
-					Right: &parser.NumNode{Value: 2},
+		&parser.BlockNode{
+			Compound: &parser.CompoundNode{
+				Children: []parser.ASTNode{
+					&parser.AssignNode{
+						Left:  &parser.VarNode{Value: "bob"},
+						Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 2}, Value: 2},
+					},
+					&parser.AssignNode{
+						Left:  &parser.VarNode{Value: "res"},
+						Right: &parser.VarNode{Value: "bob"},
+					},
+					&parser.NoOpNode{},
+				},
+			},
+		},
+		nil,
+	),
+
+	Entry(`
+BEGIN
+	CASE a OF
+		1, -2: b := 1;
+		3..5: ;
+	ELSE
+		b := 2
+	END
+END.
+`,
+		program,
+		[]lexer.Token{
+			{Type: lexer.Begin},
+			{Type: lexer.Case},
+			{Type: lexer.ID, Value: "a"},
+			{Type: lexer.Of},
+			{Type: lexer.Number, Value: 1},
+			{Type: lexer.Comma},
+			{Type: lexer.Minus},
+			{Type: lexer.Number, Value: 2},
+			{Type: lexer.Colon},
+			{Type: lexer.ID, Value: "b"},
+			{Type: lexer.Assign},
+			{Type: lexer.Number, Value: 1},
+			{Type: lexer.Semi},
+			{Type: lexer.Number, Value: 3},
+			{Type: lexer.Range},
+			{Type: lexer.Number, Value: 5},
+			{Type: lexer.Colon},
+			{Type: lexer.Semi},
+			{Type: lexer.Else},
+			{Type: lexer.ID, Value: "b"},
+			{Type: lexer.Assign},
+			{Type: lexer.Number, Value: 2},
+			{Type: lexer.End},
+			{Type: lexer.End},
+			{Type: lexer.Dot},
+			{Type: lexer.EOF},
+		},
+		&parser.BlockNode{
+			Compound: &parser.CompoundNode{
+				Children: []parser.ASTNode{
+					&parser.CaseNode{
+						Expr: &parser.VarNode{Value: "a"},
+						Branches: []*parser.CaseBranch{
+							{
+								Labels: []*parser.CaseLabel{
+									{Low: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 1}, Value: 1}},
+									{Low: &parser.UnaryNode{
+										Token: lexer.Token{Type: lexer.Minus},
+										Child: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 2}, Value: 2},
+									}},
+								},
+								Body: &parser.AssignNode{
+									Left:  &parser.VarNode{Value: "b"},
+									Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 1}, Value: 1},
+								},
+							},
+							{
+								Labels: []*parser.CaseLabel{
+									{Low: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 3}, Value: 3}, High: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 5}, Value: 5}},
+								},
+								Body: &parser.NoOpNode{},
+							},
+						},
+						Else: &parser.CompoundNode{
+							Children: []parser.ASTNode{
+								&parser.AssignNode{
+									Left:  &parser.VarNode{Value: "b"},
+									Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 2}, Value: 2},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		nil,
+	),
+
+	Entry(`
+CONST
+	rate = 15;
+	double = rate * 2;
+BEGIN
+	CASE a OF rate: ; END
+END.
+`,
+		program,
+		[]lexer.Token{
+			{Type: lexer.Const},
+			{Type: lexer.ID, Value: "rate"},
+			{Type: lexer.Equal},
+			{Type: lexer.Number, Value: 15},
+			{Type: lexer.Semi},
+			{Type: lexer.ID, Value: "double"},
+			{Type: lexer.Equal},
+			{Type: lexer.ID, Value: "rate"},
+			{Type: lexer.Mult},
+			{Type: lexer.Number, Value: 2},
+			{Type: lexer.Semi},
+			{Type: lexer.Begin},
+			{Type: lexer.Case},
+			{Type: lexer.ID, Value: "a"},
+			{Type: lexer.Of},
+			{Type: lexer.ID, Value: "rate"},
+			{Type: lexer.Colon},
+			{Type: lexer.Semi},
+			{Type: lexer.End},
+			{Type: lexer.End},
+			{Type: lexer.Dot},
+			{Type: lexer.EOF},
+		},
+		&parser.BlockNode{
+			Consts: []*parser.ConstDeclNode{
+				{Name: "rate", Value: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 15}, Value: 15}},
+				{
+					Name: "double",
+					Value: &parser.BinOpNode{
+						Left:  &parser.VarNode{Value: "rate"},
+						Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 2}, Value: 2},
+						Token: lexer.Token{Type: lexer.Mult},
+					},
 				},
-				&parser.AssignNode{
-					Left:  &parser.VarNode{Value: "res"},
-					Right: &parser.VarNode{Value: "bob"},
+			},
+			Compound: &parser.CompoundNode{
+				Children: []parser.ASTNode{
+					&parser.CaseNode{
+						Expr: &parser.VarNode{Value: "a"},
+						Branches: []*parser.CaseBranch{
+							{
+								Labels: []*parser.CaseLabel{
+									{Low: &parser.VarNode{Value: "rate"}},
+								},
+								Body: &parser.NoOpNode{},
+							},
+						},
+					},
+				},
+			},
+		},
+		nil,
+	),
+
+	Entry(`
+USES a, b;
+BEGIN
+END.
+`,
+		program,
+		[]lexer.Token{
+			{Type: lexer.Uses},
+			{Type: lexer.ID, Value: "a"},
+			{Type: lexer.Comma},
+			{Type: lexer.ID, Value: "b"},
+			{Type: lexer.Semi},
+			{Type: lexer.Begin},
+			{Type: lexer.End},
+			{Type: lexer.Dot},
+			{Type: lexer.EOF},
+		},
+		&parser.BlockNode{
+			Uses: []*parser.UnitRef{{Name: "a"}, {Name: "b"}},
+			Compound: &parser.CompoundNode{
+				Children: []parser.ASTNode{&parser.NoOpNode{}},
+			},
+		},
+		nil,
+	),
+
+	Entry(`
+TYPE c = (r, g); d = 0..9;
+VAR x, y: d;
+BEGIN FOR x := 1 DOWNTO 0 DO y := x END.
+`,
+		program,
+		[]lexer.Token{
+			{Type: lexer.Type},
+			{Type: lexer.ID, Value: "c"},
+			{Type: lexer.Equal},
+			{Type: lexer.LParen},
+			{Type: lexer.ID, Value: "r"},
+			{Type: lexer.Comma},
+			{Type: lexer.ID, Value: "g"},
+			{Type: lexer.RParen},
+			{Type: lexer.Semi},
+			{Type: lexer.ID, Value: "d"},
+			{Type: lexer.Equal},
+			{Type: lexer.Number, Value: 0},
+			{Type: lexer.Range},
+			{Type: lexer.Number, Value: 9},
+			{Type: lexer.Semi},
+			{Type: lexer.Var},
+			{Type: lexer.ID, Value: "x"},
+			{Type: lexer.Comma},
+			{Type: lexer.ID, Value: "y"},
+			{Type: lexer.Colon},
+			{Type: lexer.ID, Value: "d"},
+			{Type: lexer.Semi},
+			{Type: lexer.Begin},
+			{Type: lexer.For},
+			{Type: lexer.ID, Value: "x"},
+			{Type: lexer.Assign},
+			{Type: lexer.Number, Value: 1},
+			{Type: lexer.Downto},
+			{Type: lexer.Number, Value: 0},
+			{Type: lexer.Do},
+			{Type: lexer.ID, Value: "y"},
+			{Type: lexer.Assign},
+			{Type: lexer.ID, Value: "x"},
+			{Type: lexer.End},
+			{Type: lexer.Dot},
+			{Type: lexer.EOF},
+		},
+		&parser.BlockNode{
+			Types: []*parser.TypeDeclNode{
+				{Name: "c", Type: &parser.EnumType{Values: []*parser.EnumConst{{Name: "r"}, {Name: "g"}}}},
+				{Name: "d", Type: &parser.SubrangeType{Low: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 0}, Value: 0}, High: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 9}, Value: 9}}},
+			},
+			Vars: []*parser.VarDeclNode{
+				{Names: []*parser.VarNode{{Value: "x"}, {Value: "y"}}, Type: &parser.NamedType{Name: "d"}},
+			},
+			Compound: &parser.CompoundNode{
+				Children: []parser.ASTNode{
+					&parser.ForNode{
+						Var:   &parser.VarNode{Value: "x"},
+						Start: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 1}, Value: 1},
+						End:   &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 0}, Value: 0},
+						Down:  true,
+						Body: &parser.AssignNode{
+							Left:  &parser.VarNode{Value: "y"},
+							Right: &parser.VarNode{Value: "x"},
+						},
+					},
+				},
+			},
+		},
+		nil,
+	),
+
+	Entry(`
+TYPE l = ^n; n = RECORD v: INTEGER; next: l END;
+VAR p: l;
+BEGIN New(p); p^.next := NIL; x := p^.v END.
+`,
+		program,
+		[]lexer.Token{
+			{Type: lexer.Type},
+			{Type: lexer.ID, Value: "l"},
+			{Type: lexer.Equal},
+			{Type: lexer.Caret},
+			{Type: lexer.ID, Value: "n"},
+			{Type: lexer.Semi},
+			{Type: lexer.ID, Value: "n"},
+			{Type: lexer.Equal},
+			{Type: lexer.Record},
+			{Type: lexer.ID, Value: "v"},
+			{Type: lexer.Colon},
+			{Type: lexer.ID, Value: "INTEGER"},
+			{Type: lexer.Semi},
+			{Type: lexer.ID, Value: "next"},
+			{Type: lexer.Colon},
+			{Type: lexer.ID, Value: "l"},
+			{Type: lexer.End},
+			{Type: lexer.Semi},
+			{Type: lexer.Var},
+			{Type: lexer.ID, Value: "p"},
+			{Type: lexer.Colon},
+			{Type: lexer.ID, Value: "l"},
+			{Type: lexer.Semi},
+			{Type: lexer.Begin},
+			{Type: lexer.ID, Value: "New"},
+			{Type: lexer.LParen},
+			{Type: lexer.ID, Value: "p"},
+			{Type: lexer.RParen},
+			{Type: lexer.Semi},
+			{Type: lexer.ID, Value: "p"},
+			{Type: lexer.Caret},
+			{Type: lexer.Dot},
+			{Type: lexer.ID, Value: "next"},
+			{Type: lexer.Assign},
+			{Type: lexer.Nil},
+			{Type: lexer.Semi},
+			{Type: lexer.ID, Value: "x"},
+			{Type: lexer.Assign},
+			{Type: lexer.ID, Value: "p"},
+			{Type: lexer.Caret},
+			{Type: lexer.Dot},
+			{Type: lexer.ID, Value: "v"},
+			{Type: lexer.End},
+			{Type: lexer.Dot},
+			{Type: lexer.EOF},
+		},
+		&parser.BlockNode{
+			Types: []*parser.TypeDeclNode{
+				{Name: "l", Type: &parser.PointerType{Name: "n"}},
+				{Name: "n", Type: &parser.RecordType{Fields: []*parser.VarDeclNode{
+					{Names: []*parser.VarNode{{Value: "v"}}, Type: &parser.NamedType{Name: "INTEGER"}},
+					{Names: []*parser.VarNode{{Value: "next"}}, Type: &parser.NamedType{Name: "l"}},
+				}}},
+			},
+			Vars: []*parser.VarDeclNode{
+				{Names: []*parser.VarNode{{Value: "p"}}, Type: &parser.NamedType{Name: "l"}},
+			},
+			Compound: &parser.CompoundNode{
+				Children: []parser.ASTNode{
+					&parser.ProcCallNode{Name: "New", Args: []parser.ASTNode{&parser.VarNode{Value: "p"}}},
+					&parser.AssignNode{
+						Left: &parser.FieldNode{
+							Record: &parser.DerefNode{Pointer: &parser.VarNode{Value: "p"}},
+							Name:   "next",
+						},
+						Right: &parser.NilNode{},
+					},
+					&parser.AssignNode{
+						Left: &parser.VarNode{Value: "x"},
+						Right: &parser.FieldNode{
+							Record: &parser.DerefNode{Pointer: &parser.VarNode{Value: "p"}},
+							Name:   "v",
+						},
+					},
+				},
+			},
+		},
+		nil,
+	),
+
+	Entry(`
+PROCEDURE p(VAR a: ARRAY [1..2] OF INTEGER; n: INTEGER); BEGIN a[n] := n END;
+FUNCTION f: INTEGER; BEGIN f := 1 END;
+BEGIN p(x, f) END.
+`,
+		program,
+		[]lexer.Token{
+			{Type: lexer.Procedure},
+			{Type: lexer.ID, Value: "p"},
+			{Type: lexer.LParen},
+			{Type: lexer.Var},
+			{Type: lexer.ID, Value: "a"},
+			{Type: lexer.Colon},
+			{Type: lexer.Array},
+			{Type: lexer.LBracket},
+			{Type: lexer.Number, Value: 1},
+			{Type: lexer.Range},
+			{Type: lexer.Number, Value: 2},
+			{Type: lexer.RBracket},
+			{Type: lexer.Of},
+			{Type: lexer.ID, Value: "INTEGER"},
+			{Type: lexer.Semi},
+			{Type: lexer.ID, Value: "n"},
+			{Type: lexer.Colon},
+			{Type: lexer.ID, Value: "INTEGER"},
+			{Type: lexer.RParen},
+			{Type: lexer.Semi},
+			{Type: lexer.Begin},
+			{Type: lexer.ID, Value: "a"},
+			{Type: lexer.LBracket},
+			{Type: lexer.ID, Value: "n"},
+			{Type: lexer.RBracket},
+			{Type: lexer.Assign},
+			{Type: lexer.ID, Value: "n"},
+			{Type: lexer.End},
+			{Type: lexer.Semi},
+			{Type: lexer.Function},
+			{Type: lexer.ID, Value: "f"},
+			{Type: lexer.Colon},
+			{Type: lexer.ID, Value: "INTEGER"},
+			{Type: lexer.Semi},
+			{Type: lexer.Begin},
+			{Type: lexer.ID, Value: "f"},
+			{Type: lexer.Assign},
+			{Type: lexer.Number, Value: 1},
+			{Type: lexer.End},
+			{Type: lexer.Semi},
+			{Type: lexer.Begin},
+			{Type: lexer.ID, Value: "p"},
+			{Type: lexer.LParen},
+			{Type: lexer.ID, Value: "x"},
+			{Type: lexer.Comma},
+			{Type: lexer.ID, Value: "f"},
+			{Type: lexer.RParen},
+			{Type: lexer.End},
+			{Type: lexer.Dot},
+			{Type: lexer.EOF},
+		},
+		&parser.BlockNode{
+			Procs: []*parser.ProcDeclNode{
+				{
+					Name: "p",
+					Params: []*parser.ParamNode{
+						{
+							Names: []*parser.VarNode{{Value: "a"}},
+							Type: &parser.ArrayType{
+								Index: &parser.SubrangeType{Low: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 1}, Value: 1}, High: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 2}, Value: 2}},
+								Elem:  &parser.NamedType{Name: "INTEGER"},
+							},
+							ByRef: true,
+						},
+						{Names: []*parser.VarNode{{Value: "n"}}, Type: &parser.NamedType{Name: "INTEGER"}},
+					},
+					Block: &parser.BlockNode{
+						Compound: &parser.CompoundNode{
+							Children: []parser.ASTNode{
+								&parser.AssignNode{
+									Left:  &parser.IndexNode{Array: &parser.VarNode{Value: "a"}, Index: &parser.VarNode{Value: "n"}},
+									Right: &parser.VarNode{Value: "n"},
+								},
+							},
+						},
+					},
+				},
+				{
+					Name:   "f",
+					Result: &parser.NamedType{Name: "INTEGER"},
+					Block: &parser.BlockNode{
+						Compound: &parser.CompoundNode{
+							Children: []parser.ASTNode{
+								&parser.AssignNode{
+									Left:  &parser.VarNode{Value: "f"},
+									Right: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 1}, Value: 1},
+								},
+							},
+						},
+					},
+				},
+			},
+			Compound: &parser.CompoundNode{
+				Children: []parser.ASTNode{
+					&parser.ProcCallNode{
+						Name: "p",
+						Args: []parser.ASTNode{&parser.VarNode{Value: "x"}, &parser.VarNode{Value: "f"}},
+					},
+				},
+			},
+		},
+		nil,
+	),
+
+	Entry(`
+UNIT u;
+INTERFACE
+USES a;
+CONST x = 1;
+IMPLEMENTATION
+CONST y = 2;
+END.
+`,
+		unit,
+		[]lexer.Token{
+			{Type: lexer.Unit},
+			{Type: lexer.ID, Value: "u"},
+			{Type: lexer.Semi},
+			{Type: lexer.Interface},
+			{Type: lexer.Uses},
+			{Type: lexer.ID, Value: "a"},
+			{Type: lexer.Semi},
+			{Type: lexer.Const},
+			{Type: lexer.ID, Value: "x"},
+			{Type: lexer.Equal},
+			{Type: lexer.Number, Value: 1},
+			{Type: lexer.Semi},
+			{Type: lexer.Implementation},
+			{Type: lexer.Const},
+			{Type: lexer.ID, Value: "y"},
+			{Type: lexer.Equal},
+			{Type: lexer.Number, Value: 2},
+			{Type: lexer.Semi},
+			{Type: lexer.End},
+			{Type: lexer.Dot},
+			{Type: lexer.EOF},
+		},
+		&parser.UnitNode{
+			Name:          "u",
+			InterfaceUses: []*parser.UnitRef{{Name: "a"}},
+			Interface: &parser.UnitSection{
+				Consts: []*parser.ConstDeclNode{
+					{Name: "x", Value: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 1}, Value: 1}},
+				},
+			},
+			Implementation: &parser.UnitSection{
+				Consts: []*parser.ConstDeclNode{
+					{Name: "y", Value: &parser.NumNode{Token: lexer.Token{Type: lexer.Number, Value: 2}, Value: 2}},
+				},
+			},
+		},
+		nil,
+	),
+
+	Entry(`
+UNIT u;
+INTERFACE
+VAR n: INTEGER;
+PROCEDURE p;
+IMPLEMENTATION
+PROCEDURE p; BEGIN END;
+END.
+`,
+		unit,
+		[]lexer.Token{
+			{Type: lexer.Unit},
+			{Type: lexer.ID, Value: "u"},
+			{Type: lexer.Semi},
+			{Type: lexer.Interface},
+			{Type: lexer.Var},
+			{Type: lexer.ID, Value: "n"},
+			{Type: lexer.Colon},
+			{Type: lexer.ID, Value: "INTEGER"},
+			{Type: lexer.Semi},
+			{Type: lexer.Procedure},
+			{Type: lexer.ID, Value: "p"},
+			{Type: lexer.Semi},
+			{Type: lexer.Implementation},
+			{Type: lexer.Procedure},
+			{Type: lexer.ID, Value: "p"},
+			{Type: lexer.Semi},
+			{Type: lexer.Begin},
+			{Type: lexer.End},
+			{Type: lexer.Semi},
+			{Type: lexer.End},
+			{Type: lexer.Dot},
+			{Type: lexer.EOF},
+		},
+		&parser.UnitNode{
+			Name: "u",
+			Interface: &parser.UnitSection{
+				Vars: []*parser.VarDeclNode{
+					{
+						Names: []*parser.VarNode{{Value: "n"}},
+						Type:  &parser.NamedType{Name: "INTEGER"},
+					},
+				},
+				Procs: []*parser.ProcDeclNode{{Name: "p"}},
+			},
+			Implementation: &parser.UnitSection{
+				Procs: []*parser.ProcDeclNode{
+					{
+						Name: "p",
+						Block: &parser.BlockNode{
+							Compound: &parser.CompoundNode{
+								Children: []parser.ASTNode{&parser.NoOpNode{}},
+							},
+						},
+					},
 				},
-				&parser.NoOpNode{},
 			},
 		},
 		nil,
@@ -235,4 +882,15 @@ END.
 		nil,
 		errors.New("expected a left parenthesis or a number"),
 	),
+
+	Entry("Abs(",
+		expr,
+		[]lexer.Token{
+			{Type: lexer.ID, Value: "Abs"},
+			{Type: lexer.LParen},
+			{Type: lexer.Number, Value: 2},
+		},
+		nil,
+		errors.New("expected right paren, got EOF at 0:0"),
+	),
 )
@@ -0,0 +1,760 @@
+// Package semantic checks the meaning of a parsed program and records its symbols
+package semantic
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// Error is a semantic error at a position in the source
+type Error struct {
+	Pos lexer.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at %s", e.Msg, e.Pos)
+}
+
+type SymbolKind int
+
+const (
+	Variable SymbolKind = iota
+	Constant
+	TypeName
+	Procedure
+	Function
+)
+
+func (k SymbolKind) String() string {
+	return []string{
+		"variable",
+		"constant",
+		"type",
+		"procedure",
+		"function",
+	}[k]
+}
+
+// Symbol is a named entity, defined at Pos in File, the path of the unit
+// declaring it, or in the source being analysed if File is empty. Value
+// holds the value of a constant. Level is the depth of procedure nesting
+// of the block which declares the symbol: 0 for the main program, 1 for
+// its procedures, and so on.
+type Symbol struct {
+	Name  string
+	Kind  SymbolKind
+	Type  string
+	Pos   lexer.Position
+	File  string
+	Value interface{}
+	Level int
+
+	// decl declares a procedure or function, for checking calls
+	decl *parser.ProcDeclNode
+}
+
+// Reference is a use of a symbol in the source, including its definition
+type Reference struct {
+	Symbol *Symbol
+	Pos    lexer.Position
+}
+
+// Programmer produces the AST to be checked
+type Programmer interface {
+	Program() (parser.ASTNode, error)
+}
+
+// Checker is a Programmer which rejects programs with semantic errors
+type Checker struct {
+	pars Programmer
+}
+
+func NewChecker(pars Programmer) *Checker {
+	return &Checker{
+		pars: pars,
+	}
+}
+
+func (c *Checker) Program() (parser.ASTNode, error) {
+	program, err := c.pars.Program()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := NewAnalyser().Analyse(program); err != nil {
+		return nil, err
+	}
+
+	return program, nil
+}
+
+// scope holds the symbols declared in a block. Names not declared in
+// a scope are looked up in its parent.
+type scope struct {
+	symbols map[string]*Symbol
+	parent  *scope
+	level   int
+}
+
+type Analyser struct {
+	scope      *scope
+	imported   map[string]*Symbol
+	units      map[string][]*Symbol
+	order      []*Symbol
+	exports    []*Symbol
+	references []Reference
+	errs       []error
+	pointers   []*parser.PointerType
+	headings   map[*Symbol]bool
+	function   *Symbol
+	values     map[parser.ASTNode]interface{}
+}
+
+func NewAnalyser() *Analyser {
+	return &Analyser{
+		scope:    &scope{symbols: map[string]*Symbol{}},
+		imported: map[string]*Symbol{},
+		units:    map[string][]*Symbol{},
+		headings: map[*Symbol]bool{},
+		values:   map[parser.ASTNode]interface{}{},
+	}
+}
+
+// Import makes a loaded unit available to USES clauses, with the symbols
+// it exports
+func (a *Analyser) Import(unit string, symbols []*Symbol) {
+	a.units[strings.ToLower(unit)] = symbols
+}
+
+// Exports returns the symbols declared in the INTERFACE section of a unit
+func (a *Analyser) Exports() []*Symbol {
+	return a.exports
+}
+
+func (a *Analyser) lookup(name string) (*Symbol, bool) {
+	name = strings.ToLower(name)
+	for s := a.scope; s != nil; s = s.parent {
+		if sym, ok := s.symbols[name]; ok {
+			return sym, true
+		}
+	}
+
+	if sym, ok := a.imported[name]; ok {
+		return sym, true
+	}
+
+	sym, ok := predeclared[name]
+
+	return sym, ok
+}
+
+// predeclared are the constants in scope in every program and unit, which
+// may be redeclared. Like the values of an enumeration, their values are
+// their ordinals.
+var predeclared = map[string]*Symbol{
+	"false": {Name: "FALSE", Kind: Constant, Type: "BOOLEAN", Value: 0},
+	"true":  {Name: "TRUE", Kind: Constant, Type: "BOOLEAN", Value: 1},
+}
+
+// Analyse walks the program, returning the first semantic error found
+func (a *Analyser) Analyse(program parser.ASTNode) error {
+	if _, err := program.Accept(a); err != nil {
+		return err
+	}
+
+	if len(a.errs) > 0 {
+		return a.errs[0]
+	}
+
+	return nil
+}
+
+// Errors returns all the semantic errors found
+func (a *Analyser) Errors() []error {
+	return a.errs
+}
+
+// Symbols returns the symbols in the order they were defined
+func (a *Analyser) Symbols() []*Symbol {
+	return a.order
+}
+
+// References returns every use of a symbol in source order of visiting
+func (a *Analyser) References() []Reference {
+	return a.references
+}
+
+// ValueOf gives the value of an expression which had to be constant, such
+// as a subrange bound or a case label, if it could be evaluated
+func (a *Analyser) ValueOf(node parser.ASTNode) (interface{}, bool) {
+	val, ok := a.values[node]
+
+	return val, ok
+}
+
+func (a *Analyser) errorf(pos lexer.Position, format string, args ...interface{}) {
+	a.errs = append(a.errs, &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (a *Analyser) VisitNum(node *parser.NumNode) (interface{}, error) {
+	return nil, nil
+}
+
+func (a *Analyser) VisitReal(node *parser.RealNode) (interface{}, error) {
+	return nil, nil
+}
+
+func (a *Analyser) VisitBinOp(node *parser.BinOpNode) (interface{}, error) {
+	if _, err := node.Left.Accept(a); err != nil {
+		return nil, err
+	}
+
+	return node.Right.Accept(a)
+}
+
+func (a *Analyser) VisitUnary(node *parser.UnaryNode) (interface{}, error) {
+	return node.Child.Accept(a)
+}
+
+func (a *Analyser) VisitToReal(node *parser.ToRealNode) (interface{}, error) {
+	return node.Child.Accept(a)
+}
+
+func (a *Analyser) VisitCompound(node *parser.CompoundNode) (interface{}, error) {
+	for _, child := range node.Children {
+		if _, err := child.Accept(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (a *Analyser) VisitAssign(node *parser.AssignNode) (interface{}, error) {
+	if _, err := node.Right.Accept(a); err != nil {
+		return nil, err
+	}
+
+	return nil, a.target(node.Left)
+}
+
+// target checks the target of an assignment. Assigning to a field of
+// a record variable assigns the variable, but a pointer must already be
+// assigned to assign through it.
+func (a *Analyser) target(node parser.ASTNode) error {
+	switch n := node.(type) {
+	case *parser.VarNode:
+		// variables are implicitly declared by their first assignment
+		sym, ok := a.lookup(n.Value)
+		if !ok {
+			sym = a.define(n.Value, Variable, n.Pos)
+		}
+		a.assign(sym, n)
+
+		return nil
+
+	case *parser.FieldNode:
+		return a.target(n.Record)
+
+	case *parser.IndexNode:
+		if _, err := n.Index.Accept(a); err != nil {
+			return err
+		}
+
+		return a.target(n.Array)
+	}
+
+	_, err := node.Accept(a)
+
+	return err
+}
+
+func (a *Analyser) assign(sym *Symbol, v *parser.VarNode) {
+	switch sym.Kind {
+	case Constant:
+		a.errorf(v.Pos, "cannot assign to constant %q", v.Value)
+	case TypeName:
+		a.errorf(v.Pos, "cannot assign to type %q", v.Value)
+	case Procedure:
+		a.errorf(v.Pos, "cannot assign to procedure %q", v.Value)
+	case Function:
+		// a function returns the value assigned to its name in its body
+		if sym != a.function {
+			a.errorf(v.Pos, "cannot assign to function %q", v.Value)
+		}
+	}
+
+	a.references = append(a.references, Reference{Symbol: sym, Pos: v.Pos})
+}
+
+func (a *Analyser) define(name string, kind SymbolKind, pos lexer.Position) *Symbol {
+	sym := &Symbol{
+		Name:  name,
+		Kind:  kind,
+		Type:  "INTEGER",
+		Pos:   pos,
+		Level: a.scope.level,
+	}
+	a.scope.symbols[strings.ToLower(name)] = sym
+	a.order = append(a.order, sym)
+
+	return sym
+}
+
+func (a *Analyser) VisitVar(node *parser.VarNode) (interface{}, error) {
+	sym, ok := a.lookup(node.Value)
+	if !ok {
+		a.errorf(node.Pos, "unknown identifier %q", node.Value)
+		return nil, nil
+	}
+
+	switch sym.Kind {
+	case TypeName:
+		a.errorf(node.Pos, "type %q is not a value", node.Value)
+	case Procedure:
+		a.errorf(node.Pos, "procedure %q does not return a value", node.Value)
+	case Function:
+		if sym != a.function {
+			a.checkArgs(sym, nil, node.Pos)
+		}
+	}
+
+	a.references = append(a.references, Reference{Symbol: sym, Pos: node.Pos})
+
+	return nil, nil
+}
+
+func (a *Analyser) VisitBlock(node *parser.BlockNode) (interface{}, error) {
+	a.checkUses(node.Uses)
+
+	for _, decl := range node.Consts {
+		if _, err := decl.Accept(a); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, decl := range node.Types {
+		if _, err := decl.Accept(a); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, decl := range node.Vars {
+		if _, err := decl.Accept(a); err != nil {
+			return nil, err
+		}
+	}
+	a.checkPointers()
+
+	for _, decl := range node.Procs {
+		if _, err := decl.Accept(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return node.Compound.Accept(a)
+}
+
+func (a *Analyser) VisitUnit(node *parser.UnitNode) (interface{}, error) {
+	a.checkUses(node.InterfaceUses)
+
+	if err := a.section(node.Interface); err != nil {
+		return nil, err
+	}
+	for _, sym := range a.order {
+		// leaving out the parameters of procedure headings
+		if sym.Level == 0 {
+			a.exports = append(a.exports, sym)
+		}
+	}
+
+	a.checkUses(node.ImplUses)
+
+	if err := a.section(node.Implementation); err != nil {
+		return nil, err
+	}
+
+	for _, sym := range a.exports {
+		if a.headings[sym] {
+			a.errorf(sym.Pos, "%s %q is not implemented", sym.Kind, sym.Name)
+		}
+	}
+
+	return nil, nil
+}
+
+// section checks the declarations of a section of a unit
+func (a *Analyser) section(node *parser.UnitSection) error {
+	for _, decl := range node.Consts {
+		if _, err := decl.Accept(a); err != nil {
+			return err
+		}
+	}
+
+	for _, decl := range node.Types {
+		if _, err := decl.Accept(a); err != nil {
+			return err
+		}
+	}
+
+	for _, decl := range node.Vars {
+		if _, err := decl.Accept(a); err != nil {
+			return err
+		}
+	}
+	a.checkPointers()
+
+	for _, decl := range node.Procs {
+		if _, err := decl.Accept(a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkUses makes the symbols of each used unit visible. Symbols from later
+// units hide those of the same name from earlier ones, and declarations in
+// the program or unit being analysed hide them all.
+func (a *Analyser) checkUses(uses []*parser.UnitRef) {
+	for _, ref := range uses {
+		symbols, ok := a.units[strings.ToLower(ref.Name)]
+		if !ok {
+			a.errorf(ref.Pos, "unit %q has not been loaded", ref.Name)
+			continue
+		}
+
+		for _, sym := range symbols {
+			a.imported[strings.ToLower(sym.Name)] = sym
+		}
+	}
+}
+
+func (a *Analyser) VisitConstDecl(node *parser.ConstDeclNode) (interface{}, error) {
+	if prev, ok := a.scope.symbols[strings.ToLower(node.Name)]; ok {
+		a.errorf(node.Pos, "%q is already declared on line %d", node.Name, prev.Pos.Line)
+		return nil, nil
+	}
+
+	// the value is checked before the name is in scope, so that a constant
+	// cannot be defined in terms of itself
+	val, ok := a.constant(node.Value, node.Pos)
+
+	sym := a.define(node.Name, Constant, node.Pos)
+	a.references = append(a.references, Reference{Symbol: sym, Pos: node.Pos})
+	if ok {
+		sym.Value = val
+		sym.Type = typeName(val)
+	}
+
+	return nil, nil
+}
+
+// VisitCall checks the arguments of a call. Built-in functions are
+// resolved when the program runs.
+func (a *Analyser) VisitCall(node *parser.CallNode) (interface{}, error) {
+	if sym, ok := a.callable(node.Name, node.Pos); ok {
+		if sym.Kind == Procedure {
+			a.errorf(node.Pos, "procedure %q does not return a value", node.Name)
+		}
+
+		return nil, a.checkArgs(sym, node.Args, node.Pos)
+	}
+
+	for _, arg := range node.Args {
+		if _, err := arg.Accept(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// VisitProcCall checks the arguments of a procedure call. New assigns the
+// variable it is given.
+func (a *Analyser) VisitProcCall(node *parser.ProcCallNode) (interface{}, error) {
+	if sym, ok := a.callable(node.Name, node.Pos); ok {
+		return nil, a.checkArgs(sym, node.Args, node.Pos)
+	}
+
+	switch strings.ToLower(node.Name) {
+	case "new":
+		if len(node.Args) == 1 {
+			return nil, a.target(node.Args[0])
+		}
+	case "dispose", "write", "writeln":
+	default:
+		a.errorf(node.Pos, "unknown procedure %q", node.Name)
+	}
+
+	for _, arg := range node.Args {
+		if _, err := arg.Accept(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (a *Analyser) VisitNil(node *parser.NilNode) (interface{}, error) {
+	return nil, nil
+}
+
+func (a *Analyser) VisitDeref(node *parser.DerefNode) (interface{}, error) {
+	return node.Pointer.Accept(a)
+}
+
+// VisitField checks the record. Field names are checked when the program
+// runs.
+func (a *Analyser) VisitField(node *parser.FieldNode) (interface{}, error) {
+	return node.Record.Accept(a)
+}
+
+func (a *Analyser) VisitNoOp(node *parser.NoOpNode) (interface{}, error) {
+	return nil, nil
+}
+
+type labelRange struct {
+	low  int
+	high int
+	pos  lexer.Position
+}
+
+func (a *Analyser) VisitCase(node *parser.CaseNode) (interface{}, error) {
+	if _, err := node.Expr.Accept(a); err != nil {
+		return nil, err
+	}
+
+	seen := []labelRange{}
+
+	for _, branch := range node.Branches {
+		for _, label := range branch.Labels {
+			r, ok := a.labelRange(label)
+			if !ok {
+				continue
+			}
+
+			for _, prev := range seen {
+				if r.low <= prev.high && prev.low <= r.high {
+					a.errorf(label.Pos, "case label %s overlaps label %s on line %d", r, prev, prev.pos.Line)
+					break
+				}
+			}
+
+			seen = append(seen, r)
+		}
+
+		if _, err := branch.Body.Accept(a); err != nil {
+			return nil, err
+		}
+	}
+
+	if node.Else != nil {
+		return node.Else.Accept(a)
+	}
+
+	return nil, nil
+}
+
+func (r labelRange) String() string {
+	if r.low == r.high {
+		return fmt.Sprint(r.low)
+	}
+
+	return fmt.Sprintf("%d..%d", r.low, r.high)
+}
+
+func (a *Analyser) labelRange(label *parser.CaseLabel) (labelRange, bool) {
+	low, ok := a.ordinal(label.Low, label.Pos)
+	if !ok {
+		return labelRange{}, false
+	}
+
+	high := low
+	if label.High != nil {
+		high, ok = a.ordinal(label.High, label.Pos)
+		if !ok {
+			return labelRange{}, false
+		}
+	}
+
+	if low > high {
+		a.errorf(label.Pos, "case label range %d..%d is empty", low, high)
+		return labelRange{}, false
+	}
+
+	return labelRange{low: low, high: high, pos: label.Pos}, true
+}
+
+// constant evaluates an expression which must be known before the program
+// runs, reporting an error at pos if it is not. The value is an int for an
+// INTEGER or a float64 for a REAL.
+func (a *Analyser) constant(node parser.ASTNode, pos lexer.Position) (interface{}, bool) {
+	val, err := a.evaluate(node)
+	if err != nil {
+		a.errorf(pos, "%v", err)
+		return nil, false
+	}
+	a.values[node] = val
+
+	return val, true
+}
+
+// ordinal evaluates a constant expression which must be an INTEGER
+func (a *Analyser) ordinal(node parser.ASTNode, pos lexer.Position) (int, bool) {
+	val, ok := a.constant(node, pos)
+	if !ok {
+		return 0, false
+	}
+
+	n, ok := val.(int)
+	if !ok {
+		a.errorf(pos, "%v is not an ordinal value", val)
+		return 0, false
+	}
+
+	return n, true
+}
+
+func (a *Analyser) evaluate(node parser.ASTNode) (interface{}, error) {
+	switch n := node.(type) {
+	case *parser.NumNode:
+		return literal(n.Int())
+
+	case *parser.RealNode:
+		return n.Value, nil
+
+	case *parser.VarNode:
+		sym, ok := a.lookup(n.Value)
+		if !ok || sym.Kind != Constant {
+			return nil, fmt.Errorf("%q is not a constant", n.Value)
+		}
+		a.references = append(a.references, Reference{Symbol: sym, Pos: n.Pos})
+
+		if sym.Value == nil {
+			return nil, fmt.Errorf("constant %q has no value", n.Value)
+		}
+
+		return sym.Value, nil
+
+	case *parser.UnaryNode:
+		if num, ok := n.Child.(*parser.NumNode); ok && n.Token.Type == lexer.Minus {
+			return literal(new(big.Int).Neg(num.Int()))
+		}
+
+		val, err := a.evaluate(n.Child)
+		if err != nil {
+			return nil, err
+		}
+
+		if n.Token.Type != lexer.Minus {
+			return val, nil
+		}
+
+		if f, ok := val.(float64); ok {
+			return -f, nil
+		}
+
+		return -val.(int), nil
+
+	case *parser.BinOpNode:
+		left, err := a.evaluate(n.Left)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := a.evaluate(n.Right)
+		if err != nil {
+			return nil, err
+		}
+
+		return fold(n.Token.Type, left, right)
+	}
+
+	return nil, fmt.Errorf("expected a constant expression")
+}
+
+// literal gives the value of an integer literal, which must fit in an int
+// to be used as a constant
+func literal(n *big.Int) (interface{}, error) {
+	if !n.IsInt64() || int64(int(n.Int64())) != n.Int64() {
+		return nil, fmt.Errorf("integer literal %s is too large for a constant", n)
+	}
+
+	return int(n.Int64()), nil
+}
+
+// fold applies a binary operator to constant values, giving a REAL if
+// either of them is one
+func fold(op lexer.TokenType, left, right interface{}) (interface{}, error) {
+	l, lok := left.(int)
+	r, rok := right.(int)
+
+	if lok && rok {
+		switch op {
+		case lexer.Plus:
+			return l + r, nil
+		case lexer.Minus:
+			return l - r, nil
+		case lexer.Mult:
+			return l * r, nil
+		case lexer.Div:
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero in constant expression")
+			}
+			return l / r, nil
+		case lexer.Mod:
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero in constant expression")
+			}
+			return l % r, nil
+		}
+
+		return nil, fmt.Errorf("expected a constant expression")
+	}
+
+	lf, rf := toReal(left), toReal(right)
+
+	switch op {
+	case lexer.Plus:
+		return lf + rf, nil
+	case lexer.Minus:
+		return lf - rf, nil
+	case lexer.Mult:
+		return lf * rf, nil
+	case lexer.Div, lexer.Mod:
+		return nil, fmt.Errorf("%s needs INTEGER operands", keyword(op))
+	}
+
+	return nil, fmt.Errorf("expected a constant expression")
+}
+
+func toReal(val interface{}) float64 {
+	if n, ok := val.(int); ok {
+		return float64(n)
+	}
+
+	return val.(float64)
+}
+
+// typeName is the Pascal type of a constant value
+func typeName(val interface{}) string {
+	if _, ok := val.(float64); ok {
+		return "REAL"
+	}
+
+	return "INTEGER"
+}
+
+func keyword(op lexer.TokenType) string {
+	if op == lexer.Mod {
+		return "MOD"
+	}
+
+	return "DIV"
+}
@@ -0,0 +1,431 @@
+package semantic_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+	"github.com/kieron-dev/lsbasi/semantic"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Analyser", func() {
+	var (
+		source   string
+		analyser *semantic.Analyser
+		err      error
+	)
+
+	JustBeforeEach(func() {
+		program, parseErr := parser.NewParser(lexer.NewTokeniser(strings.NewReader(source))).Program()
+		Expect(parseErr).NotTo(HaveOccurred())
+
+		analyser = semantic.NewAnalyser()
+		err = analyser.Analyse(program)
+	})
+
+	Context("a valid program", func() {
+		BeforeEach(func() {
+			source = "BEGIN\n  a := 1;\n  b := a + a;\n  a := b\nEND."
+		})
+
+		It("records symbols and their references", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			symbols := analyser.Symbols()
+			Expect(symbols).To(HaveLen(2))
+			Expect(*symbols[0]).To(Equal(semantic.Symbol{
+				Name: "a",
+				Kind: semantic.Variable,
+				Type: "INTEGER",
+				Pos:  lexer.Position{Line: 2, Column: 3},
+			}))
+
+			positions := []lexer.Position{}
+			for _, ref := range analyser.References() {
+				if ref.Symbol == symbols[0] {
+					positions = append(positions, ref.Pos)
+				}
+			}
+			Expect(positions).To(Equal([]lexer.Position{
+				{Line: 2, Column: 3},
+				{Line: 3, Column: 8},
+				{Line: 3, Column: 12},
+				{Line: 4, Column: 3},
+			}))
+		})
+	})
+
+	Context("undeclared identifiers", func() {
+		BeforeEach(func() {
+			source = "BEGIN\n  a := b;\n  c := d\nEND."
+		})
+
+		It("reports every use", func() {
+			Expect(err).To(MatchError(`unknown identifier "b" at 2:8`))
+			Expect(analyser.Errors()).To(HaveLen(2))
+		})
+	})
+
+	Context("the predeclared constants", func() {
+		BeforeEach(func() {
+			source = "CONST yes = TRUE;\nBEGIN\n  a := FALSE;\n  true := 1\nEND."
+		})
+
+		It("are BOOLEAN constants", func() {
+			Expect(analyser.Errors()).To(Equal([]error{
+				&semantic.Error{Pos: lexer.Position{Line: 4, Column: 3}, Msg: `cannot assign to constant "true"`},
+			}))
+		})
+	})
+
+	Context("case labels", func() {
+		BeforeEach(func() {
+			source = `BEGIN
+  CASE 1 OF
+    1, 2: ;
+    3..5: ;
+    -1..0: ;
+  END
+END.`
+		})
+
+		It("accepts distinct labels", func() {
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("duplicates and overlaps", func() {
+			BeforeEach(func() {
+				source = `BEGIN
+  CASE 1 OF
+    1, 2: ;
+    3..5: ;
+    2: ;
+    4..9: ;
+    0..1: ;
+    7..6: ;
+  END
+END.`
+			})
+
+			It("reports each bad label", func() {
+				Expect(analyser.Errors()).To(Equal([]error{
+					&semantic.Error{Pos: lexer.Position{Line: 5, Column: 5}, Msg: "case label 2 overlaps label 2 on line 3"},
+					&semantic.Error{Pos: lexer.Position{Line: 6, Column: 5}, Msg: "case label 4..9 overlaps label 3..5 on line 4"},
+					&semantic.Error{Pos: lexer.Position{Line: 7, Column: 5}, Msg: "case label 0..1 overlaps label 1 on line 3"},
+					&semantic.Error{Pos: lexer.Position{Line: 8, Column: 5}, Msg: "case label range 7..6 is empty"},
+				}))
+			})
+		})
+	})
+
+	Context("constants", func() {
+		BeforeEach(func() {
+			source = `CONST
+  a = 2;
+  b = -a * (3 + a) DIV 2;
+BEGIN
+  CASE 1 OF b: ; a..a + 1: ; END;
+  c := b
+END.`
+		})
+
+		It("evaluates them", func() {
+			Expect(err).NotTo(HaveOccurred())
+			symbols := analyser.Symbols()
+			Expect(symbols[0].Kind).To(Equal(semantic.Constant))
+			Expect(symbols[0].Value).To(Equal(2))
+			Expect(symbols[1].Value).To(Equal(-5))
+		})
+
+		It("records the values of the expressions which must be constant", func() {
+			program, parseErr := parser.NewParser(lexer.NewTokeniser(strings.NewReader(source))).Program()
+			Expect(parseErr).NotTo(HaveOccurred())
+			analyser := semantic.NewAnalyser()
+			Expect(analyser.Analyse(program)).To(Succeed())
+
+			block := program.(*parser.BlockNode)
+			label := block.Compound.Children[0].(*parser.CaseNode).Branches[1].Labels[0]
+			val, ok := analyser.ValueOf(label.High)
+			Expect(ok).To(BeTrue())
+			Expect(val).To(Equal(3))
+
+			_, ok = analyser.ValueOf(block.Compound.Children[1].(*parser.AssignNode).Right)
+			Expect(ok).To(BeFalse())
+		})
+
+		Context("with REAL values", func() {
+			BeforeEach(func() {
+				source = `CONST
+  half = 0.5;
+  big = $10 * half;
+  n = 3;
+BEGIN
+  CASE n OF half: ; END
+END.`
+			})
+
+			It("gives the constants a REAL type", func() {
+				symbols := analyser.Symbols()
+				Expect(symbols[0].Type).To(Equal("REAL"))
+				Expect(symbols[1].Value).To(Equal(8.0))
+				Expect(symbols[1].Type).To(Equal("REAL"))
+				Expect(symbols[2].Type).To(Equal("INTEGER"))
+			})
+
+			It("rejects them as case labels", func() {
+				Expect(analyser.Errors()).To(Equal([]error{
+					&semantic.Error{Pos: lexer.Position{Line: 6, Column: 13}, Msg: "0.5 is not an ordinal value"},
+				}))
+			})
+		})
+
+		Context("misused", func() {
+			BeforeEach(func() {
+				source = `CONST
+  a = 2;
+  b = x + 1;
+  c = c;
+  a = 3;
+  d = 1 DIV (a - 2);
+BEGIN
+  x := 1;
+  a := 4;
+  CASE 1 OF x: ; END
+END.`
+			})
+
+			It("reports each error", func() {
+				Expect(analyser.Errors()).To(Equal([]error{
+					&semantic.Error{Pos: lexer.Position{Line: 3, Column: 3}, Msg: `"x" is not a constant`},
+					&semantic.Error{Pos: lexer.Position{Line: 4, Column: 3}, Msg: `"c" is not a constant`},
+					&semantic.Error{Pos: lexer.Position{Line: 5, Column: 3}, Msg: `"a" is already declared on line 2`},
+					&semantic.Error{Pos: lexer.Position{Line: 6, Column: 3}, Msg: "division by zero in constant expression"},
+					&semantic.Error{Pos: lexer.Position{Line: 9, Column: 3}, Msg: `cannot assign to constant "a"`},
+					&semantic.Error{Pos: lexer.Position{Line: 10, Column: 13}, Msg: `"x" is not a constant`},
+				}))
+			})
+		})
+	})
+
+	Context("types and variable declarations", func() {
+		BeforeEach(func() {
+			source = `TYPE
+  Color = (Red, Green);
+  Small = 1..3;
+VAR
+  c: Color;
+  s: Small;
+  n: integer;
+BEGIN
+  c := Green;
+  FOR i := 1 TO 3 DO s := i
+END.`
+		})
+
+		It("records types, enum values and declared variables", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			describe := []string{}
+			for _, sym := range analyser.Symbols() {
+				describe = append(describe, fmt.Sprintf("%s %s: %s = %v", sym.Kind, sym.Name, sym.Type, sym.Value))
+			}
+			Expect(describe).To(Equal([]string{
+				"constant Red: Color = 0",
+				"constant Green: Color = 1",
+				"type Color: Color = <nil>",
+				"type Small: 1..3 = <nil>",
+				"variable c: Color = <nil>",
+				"variable s: Small = <nil>",
+				"variable n: INTEGER = <nil>",
+				"variable i: INTEGER = <nil>",
+			}))
+		})
+
+		Context("misused", func() {
+			BeforeEach(func() {
+				source = `TYPE
+  Color = (Red, Green);
+  Empty = 3..1;
+  Red = INTEGER;
+  Numbers = SET OF INTEGER;
+VAR
+  x: Colour;
+  n: INTEGER;
+  r: REAL;
+BEGIN
+  Color := 1;
+  m := n + Color;
+  n := 1;
+  r := n
+END.`
+			})
+
+			It("reports each error", func() {
+				Expect(analyser.Errors()).To(Equal([]error{
+					&semantic.Error{Pos: lexer.Position{Line: 3, Column: 11}, Msg: "subrange 3..1 is empty"},
+					&semantic.Error{Pos: lexer.Position{Line: 4, Column: 3}, Msg: `"Red" is already declared on line 2`},
+					&semantic.Error{Pos: lexer.Position{Line: 5, Column: 13}, Msg: "cannot make a set of INTEGER"},
+					&semantic.Error{Pos: lexer.Position{Line: 7, Column: 6}, Msg: `unknown type "Colour"`},
+					&semantic.Error{Pos: lexer.Position{Line: 11, Column: 3}, Msg: `cannot assign to type "Color"`},
+					&semantic.Error{Pos: lexer.Position{Line: 12, Column: 12}, Msg: `type "Color" is not a value`},
+				}))
+			})
+		})
+	})
+
+	Context("pointers and records", func() {
+		BeforeEach(func() {
+			source = `TYPE
+  List = ^Node;
+  Node = RECORD
+    value: INTEGER;
+    next: List
+  END;
+VAR
+  head: List;
+  last: Node;
+BEGIN
+  New(head);
+  head^.next := NIL;
+  last.value := 1;
+  n := last.value
+END.`
+		})
+
+		It("allows pointers to later types and assignment through New and fields", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			describe := []string{}
+			for _, sym := range analyser.Symbols() {
+				describe = append(describe, fmt.Sprintf("%s %s: %s", sym.Kind, sym.Name, sym.Type))
+			}
+			Expect(describe).To(Equal([]string{
+				"type List: ^Node",
+				"type Node: Node",
+				"variable head: List",
+				"variable last: Node",
+				"variable n: INTEGER",
+			}))
+		})
+
+		Context("misused", func() {
+			BeforeEach(func() {
+				source = `TYPE
+  Link = ^Missing;
+  Pair = RECORD a, A: INTEGER END;
+VAR
+  p: Link;
+BEGIN
+  p^.next := NIL
+END.`
+			})
+
+			It("reports each error", func() {
+				Expect(analyser.Errors()).To(Equal([]error{
+					&semantic.Error{Pos: lexer.Position{Line: 3, Column: 20}, Msg: `duplicate field "A"`},
+					&semantic.Error{Pos: lexer.Position{Line: 2, Column: 10}, Msg: `unknown type "Missing"`},
+				}))
+			})
+		})
+	})
+
+	Context("procedures and functions", func() {
+		BeforeEach(func() {
+			source = `VAR
+  a, b: INTEGER;
+
+PROCEDURE Swap(VAR x, y: INTEGER);
+VAR t: INTEGER;
+BEGIN
+  t := x; x := y; y := t
+END;
+
+FUNCTION Double(n: INTEGER): INTEGER;
+BEGIN
+  Double := n * 2
+END;
+
+BEGIN
+  a := 1;
+  Swap(a, b);
+  b := Double(b)
+END.`
+		})
+
+		It("declares signatures and parameters, and lets VAR arguments be assigned", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			describe := []string{}
+			for _, sym := range analyser.Symbols() {
+				describe = append(describe, fmt.Sprintf("%s %s: %s", sym.Kind, sym.Name, sym.Type))
+			}
+			Expect(describe).To(Equal([]string{
+				"variable a: INTEGER",
+				"variable b: INTEGER",
+				"procedure Swap: (VAR x, y: INTEGER)",
+				"variable x: INTEGER",
+				"variable y: INTEGER",
+				"variable t: INTEGER",
+				"function Double: (n: INTEGER): INTEGER",
+				"variable n: INTEGER",
+			}))
+		})
+
+		Context("nested", func() {
+			BeforeEach(func() {
+				source = `VAR total: INTEGER;
+PROCEDURE Outer(n: INTEGER);
+  VAR sum: INTEGER;
+  PROCEDURE Add(k: INTEGER);
+  BEGIN
+    sum := sum + k + n
+  END;
+BEGIN
+  sum := 0;
+  Add(1);
+  total := sum
+END;
+BEGIN
+  Outer(2)
+END.`
+			})
+
+			It("gives each symbol the nesting level of its declaration", func() {
+				Expect(err).NotTo(HaveOccurred())
+
+				levels := []string{}
+				for _, sym := range analyser.Symbols() {
+					levels = append(levels, fmt.Sprintf("%s %d", sym.Name, sym.Level))
+				}
+				Expect(levels).To(Equal([]string{"total 0", "Outer 0", "n 1", "sum 1", "Add 1", "k 2"}))
+			})
+		})
+
+		Context("misused", func() {
+			BeforeEach(func() {
+				source = `PROCEDURE P(VAR x: INTEGER); BEGIN x := 1 END;
+FUNCTION F: INTEGER; BEGIN F := 1 END;
+BEGIN
+  P(1);
+  P(a, 2);
+  Q(a);
+  F := 2;
+  c := P
+END.`
+			})
+
+			It("reports each error", func() {
+				Expect(analyser.Errors()).To(Equal([]error{
+					&semantic.Error{Pos: lexer.Position{Line: 4, Column: 3}, Msg: "argument 1 of P must be a variable"},
+					&semantic.Error{Pos: lexer.Position{Line: 5, Column: 3}, Msg: "P expects 1 argument(s), got 2"},
+					&semantic.Error{Pos: lexer.Position{Line: 6, Column: 3}, Msg: `unknown procedure "Q"`},
+					&semantic.Error{Pos: lexer.Position{Line: 7, Column: 3}, Msg: `cannot assign to function "F"`},
+					&semantic.Error{Pos: lexer.Position{Line: 8, Column: 8}, Msg: `procedure "P" does not return a value`},
+				}))
+			})
+		})
+	})
+})
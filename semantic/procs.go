@@ -0,0 +1,144 @@
+package semantic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+// VisitProcDecl declares a procedure or function and checks its body in
+// a new scope holding its parameters. The type of the symbol is the
+// signature of the procedure. A heading, with no body, declares a
+// procedure of the INTERFACE section of a unit, which must be implemented
+// with the same signature.
+func (a *Analyser) VisitProcDecl(node *parser.ProcDeclNode) (interface{}, error) {
+	kind := Procedure
+	if node.Result != nil {
+		kind = Function
+	}
+
+	sym, heading := a.scope.symbols[strings.ToLower(node.Name)]
+	heading = heading && a.headings[sym] && node.Block != nil
+	declared := true
+	if heading {
+		delete(a.headings, sym)
+		a.references = append(a.references, Reference{Symbol: sym, Pos: node.Pos})
+	} else {
+		sym, declared = a.declare(node.Name, kind, node.Pos)
+	}
+
+	outer := a.scope
+	a.scope = &scope{symbols: map[string]*Symbol{}, parent: outer, level: outer.level + 1}
+	defer func() { a.scope = outer }()
+
+	params := []string{}
+	for _, group := range node.Params {
+		typeName, ok := a.typeSpec(group.Type, "")
+
+		names := []string{}
+		for _, v := range group.Names {
+			names = append(names, v.Value)
+			if psym, declared := a.declare(v.Value, Variable, v.Pos); declared && ok {
+				psym.Type = typeName
+			}
+		}
+
+		param := fmt.Sprintf("%s: %s", strings.Join(names, ", "), typeName)
+		if group.ByRef {
+			param = "VAR " + param
+		}
+		params = append(params, param)
+	}
+
+	signature := "(" + strings.Join(params, "; ") + ")"
+	if node.Result != nil {
+		result, _ := a.typeSpec(node.Result, "")
+		signature += ": " + result
+	}
+
+	if heading && (sym.Kind != kind || sym.Type != signature) {
+		a.errorf(node.Pos, "%s %s%s does not match its heading %s on line %d",
+			kind, node.Name, signature, sym.Type, sym.Pos.Line)
+	}
+
+	enclosing := a.function
+	if declared {
+		sym.Type = signature
+		sym.decl = node
+		if kind == Function {
+			a.function = sym
+		}
+	}
+	defer func() { a.function = enclosing }()
+
+	if node.Block == nil {
+		if declared {
+			a.headings[sym] = true
+		}
+		return nil, nil
+	}
+
+	return node.Block.Accept(a)
+}
+
+// callable looks up a declared procedure or function, recording the
+// reference
+func (a *Analyser) callable(name string, pos lexer.Position) (*Symbol, bool) {
+	sym, ok := a.lookup(name)
+	if !ok || (sym.Kind != Procedure && sym.Kind != Function) {
+		return nil, false
+	}
+	a.references = append(a.references, Reference{Symbol: sym, Pos: pos})
+
+	return sym, true
+}
+
+// checkArgs checks the number of arguments to a call, and that each VAR
+// parameter is given a variable, which the call may assign
+func (a *Analyser) checkArgs(sym *Symbol, args []parser.ASTNode, pos lexer.Position) error {
+	decl := sym.decl
+	if decl == nil {
+		return nil
+	}
+
+	byRef := []bool{}
+	for _, group := range decl.Params {
+		for range group.Names {
+			byRef = append(byRef, group.ByRef)
+		}
+	}
+
+	if len(args) != len(byRef) {
+		a.errorf(pos, "%s expects %d argument(s), got %d", sym.Name, len(byRef), len(args))
+	}
+
+	for n, arg := range args {
+		if n >= len(byRef) || !byRef[n] {
+			if _, err := arg.Accept(a); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if parser.DesignatorVar(arg) == nil {
+			a.errorf(pos, "argument %d of %s must be a variable", n+1, sym.Name)
+			continue
+		}
+
+		if err := a.target(arg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Analyser) VisitIndex(node *parser.IndexNode) (interface{}, error) {
+	if _, err := node.Array.Accept(a); err != nil {
+		return nil, err
+	}
+
+	return node.Index.Accept(a)
+}
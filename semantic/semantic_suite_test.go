@@ -0,0 +1,13 @@
+package semantic_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSemantic(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Semantic Suite")
+}
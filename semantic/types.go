@@ -0,0 +1,232 @@
+package semantic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+)
+
+var basicTypes = []string{"INTEGER", "REAL", "BOOLEAN"}
+
+func isBasic(name string) bool {
+	for _, b := range basicTypes {
+		if strings.EqualFold(b, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// declare defines a new symbol, reporting an error if the name is taken
+func (a *Analyser) declare(name string, kind SymbolKind, pos lexer.Position) (*Symbol, bool) {
+	if prev, ok := a.scope.symbols[strings.ToLower(name)]; ok {
+		a.errorf(pos, "%q is already declared on line %d", name, prev.Pos.Line)
+		return nil, false
+	}
+
+	sym := a.define(name, kind, pos)
+	a.references = append(a.references, Reference{Symbol: sym, Pos: pos})
+
+	return sym, true
+}
+
+func (a *Analyser) VisitTypeDecl(node *parser.TypeDeclNode) (interface{}, error) {
+	typeName, ok := a.typeSpec(node.Type, node.Name)
+	if !ok {
+		return nil, nil
+	}
+
+	if sym, ok := a.declare(node.Name, TypeName, node.Pos); ok {
+		sym.Type = typeName
+	}
+
+	return nil, nil
+}
+
+func (a *Analyser) VisitVarDecl(node *parser.VarDeclNode) (interface{}, error) {
+	typeName, ok := a.typeSpec(node.Type, "")
+	if !ok {
+		return nil, nil
+	}
+
+	for _, v := range node.Names {
+		if sym, ok := a.declare(v.Value, Variable, v.Pos); ok {
+			sym.Type = typeName
+		}
+	}
+
+	return nil, nil
+}
+
+// typeSpec checks the definition of a type, declaring the values of an
+// enumeration, and returns a description of the type. An enumeration takes
+// the name of the type it defines, if it has one.
+func (a *Analyser) typeSpec(spec parser.TypeSpec, name string) (string, bool) {
+	switch t := spec.(type) {
+	case *parser.NamedType:
+		if isBasic(t.Name) {
+			return strings.ToUpper(t.Name), true
+		}
+
+		sym, ok := a.lookup(t.Name)
+		if !ok || sym.Kind != TypeName {
+			a.errorf(t.Pos, "unknown type %q", t.Name)
+			return "", false
+		}
+		a.references = append(a.references, Reference{Symbol: sym, Pos: t.Pos})
+
+		return sym.Name, true
+
+	case *parser.EnumType:
+		values := []string{}
+		for _, v := range t.Values {
+			values = append(values, v.Name)
+		}
+		if name == "" {
+			name = fmt.Sprintf("(%s)", strings.Join(values, ", "))
+		}
+
+		for n, v := range t.Values {
+			if sym, ok := a.declare(v.Name, Constant, v.Pos); ok {
+				sym.Type = name
+				sym.Value = n
+			}
+		}
+
+		return name, true
+
+	case *parser.SubrangeType:
+		low, ok := a.ordinal(t.Low, t.Pos)
+		if !ok {
+			return "", false
+		}
+
+		high, ok := a.ordinal(t.High, t.Pos)
+		if !ok {
+			return "", false
+		}
+
+		if low > high {
+			a.errorf(t.Pos, "subrange %d..%d is empty", low, high)
+			return "", false
+		}
+
+		return fmt.Sprintf("%d..%d", low, high), true
+
+	case *parser.SetType:
+		elem, ok := a.typeSpec(t.Elem, "")
+		if !ok {
+			return "", false
+		}
+
+		if strings.EqualFold(elem, "INTEGER") || strings.EqualFold(elem, "REAL") {
+			a.errorf(t.Pos, "cannot make a set of %s", elem)
+			return "", false
+		}
+
+		return "SET OF " + elem, true
+
+	case *parser.PointerType:
+		// the type pointed to may be declared later, so it is checked at
+		// the end of the declarations
+		a.pointers = append(a.pointers, t)
+
+		return "^" + t.Name, true
+
+	case *parser.RecordType:
+		fields := map[string]bool{}
+		for _, decl := range t.Fields {
+			if _, ok := a.typeSpec(decl.Type, ""); !ok {
+				return "", false
+			}
+
+			for _, f := range decl.Names {
+				if fields[strings.ToLower(f.Value)] {
+					a.errorf(f.Pos, "duplicate field %q", f.Value)
+					return "", false
+				}
+				fields[strings.ToLower(f.Value)] = true
+			}
+		}
+
+		if name == "" {
+			name = "RECORD"
+		}
+
+		return name, true
+
+	case *parser.ArrayType:
+		index, ok := a.typeSpec(t.Index, "")
+		if !ok {
+			return "", false
+		}
+
+		if strings.EqualFold(index, "INTEGER") || strings.EqualFold(index, "REAL") {
+			a.errorf(t.Pos, "cannot index an array by %s", index)
+			return "", false
+		}
+
+		elem, ok := a.typeSpec(t.Elem, "")
+		if !ok {
+			return "", false
+		}
+
+		return fmt.Sprintf("ARRAY [%s] OF %s", index, elem), true
+	}
+
+	return "", false
+}
+
+// checkPointers reports pointers to types which were never declared
+func (a *Analyser) checkPointers() {
+	for _, t := range a.pointers {
+		if isBasic(t.Name) {
+			continue
+		}
+
+		sym, ok := a.lookup(t.Name)
+		if !ok || sym.Kind != TypeName {
+			a.errorf(t.Pos, "unknown type %q", t.Name)
+			continue
+		}
+		a.references = append(a.references, Reference{Symbol: sym, Pos: t.Pos})
+	}
+	a.pointers = nil
+}
+
+func (a *Analyser) VisitFor(node *parser.ForNode) (interface{}, error) {
+	if _, err := node.Start.Accept(a); err != nil {
+		return nil, err
+	}
+
+	if _, err := node.End.Accept(a); err != nil {
+		return nil, err
+	}
+
+	sym, ok := a.lookup(node.Var.Value)
+	if !ok {
+		sym = a.define(node.Var.Value, Variable, node.Var.Pos)
+	}
+	a.assign(sym, node.Var)
+
+	return node.Body.Accept(a)
+}
+
+func (a *Analyser) VisitSet(node *parser.SetNode) (interface{}, error) {
+	for _, elem := range node.Elements {
+		if _, err := elem.Low.Accept(a); err != nil {
+			return nil, err
+		}
+
+		if elem.High != nil {
+			if _, err := elem.High.Accept(a); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,722 @@
+// Package typecheck works out the type of every expression in a program,
+// making conversions from INTEGER to REAL explicit
+package typecheck
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+	"github.com/kieron-dev/lsbasi/semantic"
+)
+
+// Error is a type error at a position in the source
+type Error struct {
+	Pos lexer.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at %s", e.Msg, e.Pos)
+}
+
+// Programmer produces the AST to be checked
+type Programmer interface {
+	Program() (parser.ASTNode, error)
+}
+
+type Option func(*TypeChecker)
+
+// WithAnalyser sets the semantic analyser which resolves the names of the
+// program, such as one to which the units it uses have been imported. The
+// analyser should not have analysed anything else.
+func WithAnalyser(analyser *semantic.Analyser) Option {
+	return func(c *TypeChecker) {
+		c.analyser = analyser
+	}
+}
+
+// Checker is a Programmer which rejects programs with type errors, and
+// produces the others with their conversions made explicit
+type Checker struct {
+	pars    Programmer
+	opts    []Option
+	checker *TypeChecker
+}
+
+func NewChecker(pars Programmer, opts ...Option) *Checker {
+	return &Checker{
+		pars: pars,
+		opts: opts,
+	}
+}
+
+func (c *Checker) Program() (parser.ASTNode, error) {
+	program, err := c.pars.Program()
+	if err != nil {
+		return nil, err
+	}
+
+	c.checker = NewTypeChecker(c.opts...)
+	if err := c.checker.Check(program); err != nil {
+		return nil, err
+	}
+
+	return program, nil
+}
+
+// TypeOf gives the type of an expression in the last program produced
+func (c *Checker) TypeOf(node parser.ASTNode) Type {
+	if c.checker == nil {
+		return nil
+	}
+
+	return c.checker.TypeOf(node)
+}
+
+// TypeChecker records the type of each expression of a program. Names are
+// resolved by semantic analysis, which the program must pass first. Names
+// declared elsewhere, such as in units, are given no type unless they are
+// constants of a basic type.
+//
+// Variables declared implicitly by their first assignment take the type
+// of the value assigned.
+type TypeChecker struct {
+	analyser *semantic.Analyser
+	symbols  map[lexer.Position]*semantic.Symbol
+	declared map[*semantic.Symbol]Type
+	function *semantic.Symbol
+	types    map[parser.ASTNode]Type
+	pointers []pointer
+	errs     []error
+}
+
+// pointer is a pointer type whose type is named at pos
+type pointer struct {
+	typ *Pointer
+	pos lexer.Position
+}
+
+func NewTypeChecker(opts ...Option) *TypeChecker {
+	c := &TypeChecker{
+		analyser: semantic.NewAnalyser(),
+		symbols:  map[lexer.Position]*semantic.Symbol{},
+		declared: map[*semantic.Symbol]Type{},
+		types:    map[parser.ASTNode]Type{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Check analyses the program, then checks its types, inserting
+// a *parser.ToRealNode wherever an INTEGER is used as a REAL. It returns the
+// first error found.
+func (c *TypeChecker) Check(program parser.ASTNode) error {
+	if err := c.analyser.Analyse(program); err != nil {
+		return err
+	}
+
+	for _, ref := range c.analyser.References() {
+		c.symbols[ref.Pos] = ref.Symbol
+	}
+
+	if _, err := program.Accept(c); err != nil {
+		return err
+	}
+
+	if len(c.errs) > 0 {
+		return c.errs[0]
+	}
+
+	return nil
+}
+
+// Errors returns all the type errors found
+func (c *TypeChecker) Errors() []error {
+	return c.errs
+}
+
+// TypeOf gives the type of an expression, or nil if it has none
+func (c *TypeChecker) TypeOf(node parser.ASTNode) Type {
+	return c.types[node]
+}
+
+func (c *TypeChecker) errorf(pos lexer.Position, format string, args ...interface{}) {
+	c.errs = append(c.errs, &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// symbol gives the symbol the analyser resolved the name at pos to
+func (c *TypeChecker) symbol(pos lexer.Position) (*semantic.Symbol, bool) {
+	sym, ok := c.symbols[pos]
+
+	return sym, ok
+}
+
+// declare gives the symbol declared at pos its type
+func (c *TypeChecker) declare(pos lexer.Position, t Type) {
+	if sym, ok := c.symbol(pos); ok {
+		c.declared[sym] = t
+	}
+}
+
+// typeOf gives the type of a constant or variable
+func (c *TypeChecker) typeOf(sym *semantic.Symbol) Type {
+	if t, ok := c.declared[sym]; ok {
+		return t
+	}
+
+	if sym.Kind != semantic.Constant {
+		return nil
+	}
+
+	for _, b := range []Basic{Integer, Real, Boolean} {
+		if strings.EqualFold(sym.Type, string(b)) {
+			return b
+		}
+	}
+
+	return nil
+}
+
+// proc finds the procedure or function named at pos, including a function
+// named from within its own body
+func (c *TypeChecker) proc(pos lexer.Position) (*Proc, bool) {
+	sym, ok := c.symbol(pos)
+	if !ok {
+		return nil, false
+	}
+
+	p, ok := c.declared[sym].(*Proc)
+
+	return p, ok
+}
+
+// expr checks the expression at *node, recording and returning its type,
+// which is nil if it is unknown
+func (c *TypeChecker) expr(node *parser.ASTNode) Type {
+	val, _ := (*node).Accept(c)
+
+	t, _ := val.(Type)
+	if t != nil {
+		c.types[*node] = t
+	}
+
+	return t
+}
+
+// toReal wraps the INTEGER expression at *node in a conversion to REAL
+func (c *TypeChecker) toReal(node *parser.ASTNode) {
+	conv := &parser.ToRealNode{Child: *node, Pos: pos(*node)}
+	c.types[conv] = Real
+	*node = conv
+}
+
+// assign checks that the value at *node, of type from, can be stored in
+// a variable of type to, converting it to REAL if need be
+func (c *TypeChecker) assign(to Type, node *parser.ASTNode, from Type) bool {
+	if to == nil || from == nil {
+		return true
+	}
+
+	if base(to) == Real && base(from) == Integer {
+		c.toReal(node)
+		return true
+	}
+
+	return assignable(to, from)
+}
+
+func assignable(to, from Type) bool {
+	switch t := to.(type) {
+	case *Set:
+		f, ok := from.(*Set)
+		return ok && (f.Elem == nil || compatible(t.Elem, f.Elem))
+
+	case *Pointer:
+		if _, ok := from.(nilType); ok {
+			return true
+		}
+	}
+
+	if isOrdinal(to) || isNumber(to) {
+		return compatible(to, from)
+	}
+
+	return identical(to, from)
+}
+
+var operators = map[lexer.TokenType]string{
+	lexer.Plus:         "+",
+	lexer.Minus:        "-",
+	lexer.Mult:         "*",
+	lexer.Div:          "DIV",
+	lexer.Mod:          "MOD",
+	lexer.Equal:        "=",
+	lexer.NotEqual:     "<>",
+	lexer.Less:         "<",
+	lexer.LessEqual:    "<=",
+	lexer.Greater:      ">",
+	lexer.GreaterEqual: ">=",
+	lexer.In:           "IN",
+}
+
+func pos(node parser.ASTNode) lexer.Position {
+	switch n := node.(type) {
+	case *parser.NumNode:
+		return n.Token.Pos
+	case *parser.RealNode:
+		return n.Token.Pos
+	case *parser.UnaryNode:
+		return n.Token.Pos
+	case *parser.BinOpNode:
+		return pos(n.Left)
+	case *parser.VarNode:
+		return n.Pos
+	case *parser.CallNode:
+		return n.Pos
+	case *parser.SetNode:
+		return n.Pos
+	case *parser.NilNode:
+		return n.Pos
+	case *parser.DerefNode:
+		return pos(n.Pointer)
+	case *parser.FieldNode:
+		return pos(n.Record)
+	case *parser.IndexNode:
+		return pos(n.Array)
+	case *parser.ToRealNode:
+		return n.Pos
+	}
+
+	return lexer.Position{}
+}
+
+func (c *TypeChecker) VisitNum(node *parser.NumNode) (interface{}, error) {
+	return Integer, nil
+}
+
+func (c *TypeChecker) VisitReal(node *parser.RealNode) (interface{}, error) {
+	return Real, nil
+}
+
+func (c *TypeChecker) VisitToReal(node *parser.ToRealNode) (interface{}, error) {
+	c.expr(&node.Child)
+
+	return Real, nil
+}
+
+func (c *TypeChecker) VisitUnary(node *parser.UnaryNode) (interface{}, error) {
+	t := c.expr(&node.Child)
+	if t == nil {
+		return nil, nil
+	}
+
+	if !isNumber(t) {
+		c.errorf(node.Token.Pos, "unary %s needs an INTEGER or REAL operand, got %s", operators[node.Token.Type], t)
+		return nil, nil
+	}
+
+	return base(t), nil
+}
+
+func (c *TypeChecker) VisitBinOp(node *parser.BinOpNode) (interface{}, error) {
+	left := c.expr(&node.Left)
+	right := c.expr(&node.Right)
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	op := node.Token.Type
+	at := node.Token.Pos
+
+	switch op {
+	case lexer.Div, lexer.Mod:
+		if base(left) != Integer || base(right) != Integer {
+			c.errorf(at, "%s needs INTEGER operands, got %s and %s", operators[op], left, right)
+			return nil, nil
+		}
+		return Integer, nil
+
+	case lexer.Plus, lexer.Minus, lexer.Mult:
+		if l, ok := left.(*Set); ok {
+			if r, ok := right.(*Set); ok && setsMatch(l, r) {
+				if l.Elem == nil {
+					return r, nil
+				}
+				return l, nil
+			}
+		}
+
+		if isNumber(left) && isNumber(right) {
+			return c.numbers(node, left, right), nil
+		}
+
+		c.errorf(at, "%s is not defined on %s and %s", operators[op], left, right)
+		return nil, nil
+
+	case lexer.In:
+		s, ok := right.(*Set)
+		if !ok {
+			c.errorf(at, "IN needs a set on the right, got %s", right)
+			return nil, nil
+		}
+		if !isOrdinal(left) || (s.Elem != nil && !compatible(left, s.Elem)) {
+			c.errorf(at, "IN needs a value of the element type of %s, got %s", s, left)
+			return nil, nil
+		}
+		return Boolean, nil
+	}
+
+	if c.comparable(node, left, right) {
+		return Boolean, nil
+	}
+
+	return nil, nil
+}
+
+// numbers gives the type of arithmetic on two numbers: INTEGER if both
+// are, and otherwise REAL, converting the INTEGER operand
+func (c *TypeChecker) numbers(node *parser.BinOpNode, left, right Type) Type {
+	if base(left) == Integer && base(right) == Integer {
+		return Integer
+	}
+
+	if base(left) == Integer {
+		c.toReal(&node.Left)
+	}
+	if base(right) == Integer {
+		c.toReal(&node.Right)
+	}
+
+	return Real
+}
+
+func setsMatch(l, r *Set) bool {
+	return l.Elem == nil || r.Elem == nil || compatible(l.Elem, r.Elem)
+}
+
+// comparable checks the operands of a relational operator
+func (c *TypeChecker) comparable(node *parser.BinOpNode, left, right Type) bool {
+	op := node.Token.Type
+	at := node.Token.Pos
+
+	if isNumber(left) && isNumber(right) {
+		c.numbers(node, left, right)
+		return true
+	}
+
+	if isOrdinal(left) && compatible(left, right) {
+		return true
+	}
+
+	l, lok := left.(*Set)
+	r, rok := right.(*Set)
+	if lok && rok && setsMatch(l, r) {
+		if op == lexer.Less || op == lexer.Greater {
+			c.errorf(at, "%s is not defined on sets", operators[op])
+			return false
+		}
+		return true
+	}
+
+	if pointerLike(left) && pointerLike(right) && (isNil(left) || isNil(right) || identical(left, right)) {
+		if op != lexer.Equal && op != lexer.NotEqual {
+			c.errorf(at, "%s is not defined on pointers", operators[op])
+			return false
+		}
+		return true
+	}
+
+	c.errorf(at, "cannot compare %s with %s", left, right)
+
+	return false
+}
+
+func pointerLike(t Type) bool {
+	_, ok := t.(*Pointer)
+
+	return ok || isNil(t)
+}
+
+func isNil(t Type) bool {
+	_, ok := t.(nilType)
+
+	return ok
+}
+
+func (c *TypeChecker) VisitVar(node *parser.VarNode) (interface{}, error) {
+	sym, ok := c.symbol(node.Pos)
+	if !ok {
+		return nil, nil
+	}
+
+	switch sym.Kind {
+	case semantic.Constant, semantic.Variable:
+		return c.typeOf(sym), nil
+	case semantic.Procedure, semantic.Function:
+		p, ok := c.declared[sym].(*Proc)
+		if !ok {
+			return nil, nil
+		}
+		// a function without parameters is called by naming it, except
+		// within its own body, where the name is its result
+		if sym != c.function {
+			c.call(p, nil, node.Pos)
+		}
+		return p.Result, nil
+	}
+
+	return nil, nil
+}
+
+func (c *TypeChecker) VisitCall(node *parser.CallNode) (interface{}, error) {
+	if p, ok := c.proc(node.Pos); ok {
+		c.call(p, node.Args, node.Pos)
+		return p.Result, nil
+	}
+
+	return c.builtin(node), nil
+}
+
+// call checks the arguments of a call. The number of arguments is checked
+// by semantic analysis.
+func (c *TypeChecker) call(p *Proc, args []parser.ASTNode, at lexer.Position) {
+	for n := range args {
+		t := c.expr(&args[n])
+		if n >= len(p.Params) || t == nil {
+			continue
+		}
+
+		prm := p.Params[n]
+		if prm.ByRef {
+			if prm.Type != nil && !identical(prm.Type, t) {
+				c.errorf(at, "argument %d of %s must be a variable of type %s, got %s", n+1, p.Name, prm.Type, t)
+			}
+			continue
+		}
+
+		if !c.assign(prm.Type, &args[n], t) {
+			c.errorf(at, "argument %d of %s must be of type %s, got %s", n+1, p.Name, prm.Type, t)
+		}
+	}
+}
+
+func (c *TypeChecker) VisitProcCall(node *parser.ProcCallNode) (interface{}, error) {
+	if p, ok := c.proc(node.Pos); ok {
+		c.call(p, node.Args, node.Pos)
+		return nil, nil
+	}
+
+	for n := range node.Args {
+		t := c.expr(&node.Args[n])
+
+		switch strings.ToLower(node.Name) {
+		case "new", "dispose":
+			if _, ok := t.(*Pointer); t != nil && !ok {
+				c.errorf(node.Pos, "%s needs a pointer, got %s", node.Name, t)
+			}
+		case "write", "writeln":
+			if t != nil && !isOrdinal(t) && base(t) != Real {
+				c.errorf(node.Pos, "%s cannot write a value of type %s", node.Name, t)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *TypeChecker) VisitSet(node *parser.SetNode) (interface{}, error) {
+	var elem Type
+
+	for _, e := range node.Elements {
+		bounds := []*parser.ASTNode{&e.Low}
+		if e.High != nil {
+			bounds = append(bounds, &e.High)
+		}
+
+		for _, bound := range bounds {
+			t := c.expr(bound)
+			switch {
+			case t == nil:
+			case !isOrdinal(t):
+				c.errorf(e.Pos, "set elements must be ordinal values, got %s", t)
+			case elem == nil:
+				elem = base(t)
+			case !compatible(elem, t):
+				c.errorf(e.Pos, "set element of type %s does not match %s", t, elem)
+			}
+		}
+	}
+
+	return &Set{Elem: elem}, nil
+}
+
+func (c *TypeChecker) VisitNil(node *parser.NilNode) (interface{}, error) {
+	return nilType{}, nil
+}
+
+func (c *TypeChecker) VisitDeref(node *parser.DerefNode) (interface{}, error) {
+	t := c.expr(&node.Pointer)
+	if t == nil {
+		return nil, nil
+	}
+
+	p, ok := t.(*Pointer)
+	if !ok {
+		c.errorf(node.Pos, "cannot dereference %s", t)
+		return nil, nil
+	}
+
+	return p.Elem, nil
+}
+
+func (c *TypeChecker) VisitField(node *parser.FieldNode) (interface{}, error) {
+	t := c.expr(&node.Record)
+	if t == nil {
+		return nil, nil
+	}
+
+	r, ok := t.(*Record)
+	if !ok {
+		c.errorf(node.Pos, "cannot select field %q of %s, which is not a record", node.Name, t)
+		return nil, nil
+	}
+
+	f, ok := r.Field(node.Name)
+	if !ok {
+		c.errorf(node.Pos, "%s has no field %q", r, node.Name)
+		return nil, nil
+	}
+
+	return f.Type, nil
+}
+
+func (c *TypeChecker) VisitIndex(node *parser.IndexNode) (interface{}, error) {
+	t := c.expr(&node.Array)
+	index := c.expr(&node.Index)
+	if t == nil {
+		return nil, nil
+	}
+
+	a, ok := t.(*Array)
+	if !ok {
+		c.errorf(node.Pos, "cannot index %s, which is not an array", t)
+		return nil, nil
+	}
+
+	if index != nil && !compatible(a.Index, index) {
+		c.errorf(node.Pos, "index of %s must be of type %s, got %s", parser.Designator(node.Array), a.Index, index)
+	}
+
+	return a.Elem, nil
+}
+
+func (c *TypeChecker) VisitNoOp(node *parser.NoOpNode) (interface{}, error) {
+	return nil, nil
+}
+
+func (c *TypeChecker) VisitCompound(node *parser.CompoundNode) (interface{}, error) {
+	for _, child := range node.Children {
+		if _, err := child.Accept(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *TypeChecker) VisitAssign(node *parser.AssignNode) (interface{}, error) {
+	from := c.expr(&node.Right)
+	to := c.target(&node.Left, from)
+
+	if !c.assign(to, &node.Right, from) {
+		c.errorf(node.Pos, "cannot assign %s to %s of type %s", from, parser.Designator(node.Left), to)
+	}
+
+	return nil, nil
+}
+
+// target checks the target of an assignment of a value of type from. A
+// variable which is declared by the assignment is given that type.
+func (c *TypeChecker) target(node *parser.ASTNode, from Type) Type {
+	if v, ok := (*node).(*parser.VarNode); ok {
+		if sym, ok := c.symbol(v.Pos); ok && sym.Kind == semantic.Variable && sym.Pos == v.Pos {
+			c.implicit(v, sym, from)
+		}
+	}
+
+	return c.expr(node)
+}
+
+// implicit declares a variable by its first assignment
+func (c *TypeChecker) implicit(v *parser.VarNode, sym *semantic.Symbol, t Type) {
+	switch t := t.(type) {
+	case nilType:
+		c.errorf(v.Pos, "cannot infer the type of %s from NIL", v.Value)
+	case *Set:
+		if t.Elem == nil {
+			c.errorf(v.Pos, "cannot infer the type of %s from []", v.Value)
+		}
+	}
+
+	c.declared[sym] = base(t)
+}
+
+func (c *TypeChecker) VisitCase(node *parser.CaseNode) (interface{}, error) {
+	t := c.expr(&node.Expr)
+	if t != nil && !isOrdinal(t) {
+		c.errorf(node.Pos, "CASE needs an ordinal selector, got %s", t)
+		t = nil
+	}
+
+	for _, branch := range node.Branches {
+		for _, label := range branch.Labels {
+			bounds := []*parser.ASTNode{&label.Low}
+			if label.High != nil {
+				bounds = append(bounds, &label.High)
+			}
+
+			for _, bound := range bounds {
+				lt := c.expr(bound)
+				if t != nil && lt != nil && !compatible(t, lt) {
+					c.errorf(label.Pos, "case label of type %s does not match selector of type %s", lt, t)
+				}
+			}
+		}
+
+		if _, err := branch.Body.Accept(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if node.Else != nil {
+		return node.Else.Accept(c)
+	}
+
+	return nil, nil
+}
+
+func (c *TypeChecker) VisitFor(node *parser.ForNode) (interface{}, error) {
+	start := c.expr(&node.Start)
+	end := c.expr(&node.End)
+
+	var v parser.ASTNode = node.Var
+	t := c.target(&v, start)
+
+	if t != nil && !isOrdinal(t) {
+		c.errorf(node.Var.Pos, "FOR needs an ordinal control variable, got %s", t)
+	} else if t != nil {
+		for _, bound := range []Type{start, end} {
+			if bound != nil && !compatible(t, bound) {
+				c.errorf(node.Pos, "FOR bound of type %s does not match %s of type %s", bound, node.Var.Value, t)
+			}
+		}
+	}
+
+	return node.Body.Accept(c)
+}
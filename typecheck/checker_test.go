@@ -0,0 +1,237 @@
+package typecheck_test
+
+import (
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/interpreter"
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+	"github.com/kieron-dev/lsbasi/semantic"
+	"github.com/kieron-dev/lsbasi/typecheck"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TypeChecker", func() {
+	var (
+		source  string
+		program *parser.BlockNode
+		checker *typecheck.TypeChecker
+		err     error
+	)
+
+	JustBeforeEach(func() {
+		node, parseErr := parser.NewParser(lexer.NewTokeniser(strings.NewReader(source))).Program()
+		Expect(parseErr).NotTo(HaveOccurred())
+		program = node.(*parser.BlockNode)
+
+		checker = typecheck.NewTypeChecker()
+		err = checker.Check(program)
+	})
+
+	statement := func(n int) *parser.AssignNode {
+		return program.Compound.Children[n].(*parser.AssignNode)
+	}
+
+	Context("mixing INTEGER and REAL", func() {
+		BeforeEach(func() {
+			source = `VAR r: REAL;
+BEGIN
+  r := 1;
+  x := r * 2;
+  n := 7 DIV 2;
+  s := Sqrt(n)
+END.`
+		})
+
+		It("converts the INTEGER operands", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			conv, ok := statement(0).Right.(*parser.ToRealNode)
+			Expect(ok).To(BeTrue())
+			Expect(conv.Child).To(BeAssignableToTypeOf(&parser.NumNode{}))
+			Expect(conv.Child.(*parser.NumNode).Value).To(Equal(1))
+			Expect(checker.TypeOf(conv)).To(Equal(typecheck.Real))
+
+			mult := statement(1).Right.(*parser.BinOpNode)
+			Expect(checker.TypeOf(mult)).To(Equal(typecheck.Real))
+			Expect(checker.TypeOf(mult.Left)).To(Equal(typecheck.Real))
+			Expect(mult.Right).To(BeAssignableToTypeOf(&parser.ToRealNode{}))
+			Expect(checker.TypeOf(statement(1).Left)).To(Equal(typecheck.Real))
+
+			Expect(checker.TypeOf(statement(2).Right)).To(Equal(typecheck.Integer))
+			Expect(statement(2).Right.(*parser.BinOpNode).Left).To(BeAssignableToTypeOf(&parser.NumNode{}))
+
+			sqrt := statement(3).Right.(*parser.CallNode)
+			Expect(checker.TypeOf(sqrt)).To(Equal(typecheck.Real))
+			Expect(sqrt.Args[0]).To(BeAssignableToTypeOf(&parser.ToRealNode{}))
+		})
+	})
+
+	Context("declared types", func() {
+		BeforeEach(func() {
+			source = `CONST
+  Top = 5 * 2;
+TYPE
+  Colour = (Red, Green, Blue);
+  List = ^Node;
+  Node = RECORD value: REAL; next: List END;
+  Row = ARRAY [Colour] OF 1..Top;
+VAR
+  head: List;
+  cells: Row;
+  c: SET OF Colour;
+BEGIN
+  cells[Red] := 1;
+  New(head);
+  head^.value := cells[Green];
+  c := [Red..Green] + [];
+  found := Blue IN c
+END.`
+		})
+
+		It("annotates designators and set expressions", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			field := statement(2).Left.(*parser.FieldNode)
+			Expect(checker.TypeOf(field)).To(Equal(typecheck.Real))
+			Expect(checker.TypeOf(field.Record).String()).To(Equal("Node"))
+			Expect(checker.TypeOf(field.Record.(*parser.DerefNode).Pointer).String()).To(Equal("^Node"))
+
+			conv := statement(2).Right.(*parser.ToRealNode)
+			Expect(checker.TypeOf(conv.Child).String()).To(Equal("1..10"))
+			Expect(checker.TypeOf(conv.Child.(*parser.IndexNode).Array).String()).To(Equal("ARRAY [Colour] OF 1..10"))
+
+			Expect(checker.TypeOf(statement(3).Right).String()).To(Equal("SET OF Colour"))
+			Expect(checker.TypeOf(statement(4).Right)).To(Equal(typecheck.Boolean))
+		})
+	})
+
+	Context("procedures and functions", func() {
+		BeforeEach(func() {
+			source = `FUNCTION Half(x: REAL): REAL;
+BEGIN
+  Half := x * 0.5
+END;
+PROCEDURE Bump(VAR n: INTEGER);
+BEGIN
+  n := n + 1
+END;
+BEGIN
+  h := Half(3);
+  i := 1;
+  Bump(i)
+END.`
+		})
+
+		It("converts value arguments and types results", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			call := statement(0).Right.(*parser.CallNode)
+			Expect(checker.TypeOf(call)).To(Equal(typecheck.Real))
+			Expect(call.Args[0]).To(BeAssignableToTypeOf(&parser.ToRealNode{}))
+		})
+	})
+
+	DescribeTable("reporting type errors", func(src, msg string) {
+		source = src
+		node, parseErr := parser.NewParser(lexer.NewTokeniser(strings.NewReader(source))).Program()
+		Expect(parseErr).NotTo(HaveOccurred())
+
+		Expect(typecheck.NewTypeChecker().Check(node)).To(MatchError(msg))
+	},
+		Entry("DIV on reals", "BEGIN x := 1.5 DIV 2 END.",
+			"DIV needs INTEGER operands, got REAL and INTEGER at 1:16"),
+		Entry("MOD on reals", "VAR r: REAL; BEGIN r := 2; x := 3 MOD r END.",
+			"MOD needs INTEGER operands, got INTEGER and REAL at 1:35"),
+		Entry("adding a boolean to an integer", "BEGIN x := 1 + Odd(3) END.",
+			"+ is not defined on INTEGER and BOOLEAN at 1:14"),
+		Entry("negating a boolean", "BEGIN x := -Odd(3) END.",
+			"unary - needs an INTEGER or REAL operand, got BOOLEAN at 1:12"),
+		Entry("assigning a real to an integer", "VAR n: INTEGER; BEGIN n := 2.5 END.",
+			"cannot assign REAL to n of type INTEGER at 1:23"),
+		Entry("assigning TRUE to an integer", "VAR n: INTEGER; BEGIN n := TRUE END.",
+			"cannot assign BOOLEAN to n of type INTEGER at 1:23"),
+		Entry("changing the type of an implicit variable", "BEGIN x := 1; x := Odd(1) END.",
+			"cannot assign BOOLEAN to x of type INTEGER at 1:15"),
+		Entry("inferring from NIL", "BEGIN p := NIL END.",
+			"cannot infer the type of p from NIL at 1:7"),
+		Entry("comparing different enumerations", "TYPE A = (X, Y); B = (Z); BEGIN t := X = Z END.",
+			"cannot compare A with B at 1:40"),
+		Entry("ordering sets", "BEGIN t := [1] < [1, 2] END.",
+			"< is not defined on sets at 1:16"),
+		Entry("ordering pointers", "TYPE Ptr = ^INTEGER; VAR p: Ptr; BEGIN New(p); t := p < NIL END.",
+			"< is not defined on pointers at 1:55"),
+		Entry("IN without a set", "BEGIN t := 1 IN 2 END.",
+			"IN needs a set on the right, got INTEGER at 1:14"),
+		Entry("IN with the wrong element type", "TYPE C = (R, G); BEGIN t := 1 IN [R] END.",
+			"IN needs a value of the element type of SET OF C, got INTEGER at 1:31"),
+		Entry("mixed set elements", "TYPE C = (R, G); BEGIN s := [1, R] END.",
+			"set element of type C does not match INTEGER at 1:33"),
+		Entry("a real selector", "BEGIN CASE 1.5 OF 1: x := 1 END END.",
+			"CASE needs an ordinal selector, got REAL at 1:7"),
+		Entry("a mismatched case label", "TYPE Colour = (R, G); VAR c: Colour; BEGIN c := R; CASE c OF 1: x := 1 END END.",
+			"case label of type INTEGER does not match selector of type Colour at 1:62"),
+		Entry("a real FOR variable", "VAR r: REAL; BEGIN FOR r := 1 TO 2 DO x := r END.",
+			"FOR needs an ordinal control variable, got REAL at 1:24"),
+		Entry("a wrong array index", "TYPE C = (R, G); VAR a: ARRAY [C] OF INTEGER; BEGIN a[1] := 1 END.",
+			"index of a must be of type C, got INTEGER at 1:54"),
+		Entry("an unknown field", "TYPE Rec = RECORD a: INTEGER END; VAR r: Rec; BEGIN r.b := 1 END.",
+			`Rec has no field "b" at 1:55`),
+		Entry("dereferencing a non-pointer", "BEGIN x := 1; y := x^ END.",
+			"cannot dereference INTEGER at 1:21"),
+		Entry("a wrong VAR argument", "PROCEDURE P(VAR r: REAL); BEGIN r := 1 END; BEGIN n := 1; P(n) END.",
+			"argument 1 of P must be a variable of type REAL, got INTEGER at 1:59"),
+		Entry("a wrong value argument", "PROCEDURE P(n: INTEGER); BEGIN END; BEGIN P(1.5) END.",
+			"argument 1 of P must be of type INTEGER, got REAL at 1:43"),
+		Entry("a wrong built-in argument", "BEGIN x := Odd(1.5) END.",
+			"Odd expects an INTEGER argument, got REAL at 1:12"),
+		Entry("an unknown function", "BEGIN x := Max(1, 2) END.",
+			`unknown function "Max" at 1:12`),
+		Entry("writing a record", "TYPE Rec = RECORD a: INTEGER END; VAR r: Rec; BEGIN r.a := 1; WriteLn(1, r) END.",
+			"WriteLn cannot write a value of type Rec at 1:63"),
+	)
+})
+
+var _ = Describe("Checker", func() {
+	It("produces a program which the interpreter runs with its conversions", func() {
+		unit, err := parser.NewParser(lexer.NewTokeniser(strings.NewReader(`
+UNIT Rates;
+INTERFACE
+CONST Imported = 1.5;
+IMPLEMENTATION
+END.`))).Unit()
+		Expect(err).NotTo(HaveOccurred())
+
+		rates := semantic.NewAnalyser()
+		Expect(rates.Analyse(unit)).To(Succeed())
+		analyser := semantic.NewAnalyser()
+		analyser.Import("Rates", rates.Exports())
+
+		tokeniser := lexer.NewTokeniser(strings.NewReader(`
+USES Rates;
+CONST Scale = 2;
+VAR r: REAL;
+BEGIN
+  r := Scale;
+  x := r + Trunc(7) DIV Scale;
+  y := Imported * 2
+END.`))
+		checker := typecheck.NewChecker(parser.NewParser(tokeniser), typecheck.WithAnalyser(analyser))
+		interp := interpreter.NewInterpreter(checker, interpreter.WithUnits(unit))
+		Expect(interp.Interpret()).To(Succeed())
+
+		Expect(interp.GlobalScope()).To(Equal(map[string]interface{}{
+			"r": 2.0,
+			"x": 5.0,
+			"y": 3.0,
+		}))
+	})
+
+	It("rejects a program with a type error", func() {
+		tokeniser := lexer.NewTokeniser(strings.NewReader("BEGIN x := 1.5 DIV 2 END."))
+		_, err := typecheck.NewChecker(parser.NewParser(tokeniser)).Program()
+		Expect(err).To(MatchError("DIV needs INTEGER operands, got REAL and INTEGER at 1:16"))
+	})
+})
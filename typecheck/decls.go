@@ -0,0 +1,304 @@
+package typecheck
+
+import (
+	"strings"
+
+	"github.com/kieron-dev/lsbasi/lexer"
+	"github.com/kieron-dev/lsbasi/parser"
+	"github.com/kieron-dev/lsbasi/semantic"
+)
+
+func (c *TypeChecker) VisitBlock(node *parser.BlockNode) (interface{}, error) {
+	for _, decl := range node.Consts {
+		if _, err := decl.Accept(c); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, decl := range node.Types {
+		if _, err := decl.Accept(c); err != nil {
+			return nil, err
+		}
+	}
+	c.resolvePointers()
+
+	for _, decl := range node.Vars {
+		if _, err := decl.Accept(c); err != nil {
+			return nil, err
+		}
+	}
+	c.resolvePointers()
+
+	for _, decl := range node.Procs {
+		if _, err := decl.Accept(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return node.Compound.Accept(c)
+}
+
+func (c *TypeChecker) VisitUnit(node *parser.UnitNode) (interface{}, error) {
+	for _, section := range []*parser.UnitSection{node.Interface, node.Implementation} {
+		for _, decl := range section.Consts {
+			if _, err := decl.Accept(c); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, decl := range section.Types {
+			if _, err := decl.Accept(c); err != nil {
+				return nil, err
+			}
+		}
+		c.resolvePointers()
+
+		for _, decl := range section.Vars {
+			if _, err := decl.Accept(c); err != nil {
+				return nil, err
+			}
+		}
+		c.resolvePointers()
+
+		for _, decl := range section.Procs {
+			if _, err := decl.Accept(c); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *TypeChecker) VisitConstDecl(node *parser.ConstDeclNode) (interface{}, error) {
+	t := c.expr(&node.Value)
+	c.declare(node.Pos, base(t))
+
+	return nil, nil
+}
+
+func (c *TypeChecker) VisitTypeDecl(node *parser.TypeDeclNode) (interface{}, error) {
+	c.declare(node.Pos, c.typeSpec(node.Type, node.Name))
+
+	return nil, nil
+}
+
+func (c *TypeChecker) VisitVarDecl(node *parser.VarDeclNode) (interface{}, error) {
+	t := c.typeSpec(node.Type, "")
+	for _, v := range node.Names {
+		c.declare(v.Pos, t)
+	}
+
+	return nil, nil
+}
+
+// typeSpec resolves the definition of a type. An enumeration or record
+// takes the name of the type it defines, if it has one.
+func (c *TypeChecker) typeSpec(spec parser.TypeSpec, name string) Type {
+	switch t := spec.(type) {
+	case *parser.NamedType:
+		return c.named(t.Name, t.Pos)
+
+	case *parser.EnumType:
+		e := &Enum{Name: name}
+		for _, v := range t.Values {
+			e.Values = append(e.Values, v.Name)
+			c.declare(v.Pos, e)
+		}
+		return e
+
+	case *parser.SubrangeType:
+		low := c.expr(&t.Low)
+		high := c.expr(&t.High)
+		if low == nil || high == nil {
+			return nil
+		}
+		if !compatible(low, high) {
+			c.errorf(t.Pos, "subrange bounds of types %s and %s do not match", low, high)
+			return nil
+		}
+
+		lv, _ := c.analyser.ValueOf(t.Low)
+		hv, _ := c.analyser.ValueOf(t.High)
+		l, lok := lv.(int)
+		h, hok := hv.(int)
+		if !lok || !hok {
+			return nil
+		}
+
+		return &Subrange{Base: base(low), Low: l, High: h}
+
+	case *parser.SetType:
+		elem := c.typeSpec(t.Elem, "")
+		if elem == nil {
+			return nil
+		}
+		return &Set{Elem: elem}
+
+	case *parser.PointerType:
+		// the type pointed to may be declared later in the same section
+		p := &Pointer{Name: t.Name}
+		c.pointers = append(c.pointers, pointer{typ: p, pos: t.Pos})
+		return p
+
+	case *parser.RecordType:
+		r := &Record{Name: name}
+		for _, decl := range t.Fields {
+			ft := c.typeSpec(decl.Type, "")
+			for _, f := range decl.Names {
+				r.Fields = append(r.Fields, Field{Name: f.Value, Type: ft})
+			}
+		}
+		return r
+
+	case *parser.ArrayType:
+		index := c.typeSpec(t.Index, "")
+		elem := c.typeSpec(t.Elem, "")
+		if index == nil || elem == nil {
+			return nil
+		}
+
+		a := &Array{Index: index, Elem: elem}
+		switch idx := index.(type) {
+		case *Subrange:
+			a.Low, a.High = idx.Low, idx.High
+		case *Enum:
+			a.High = len(idx.Values) - 1
+		default:
+			if index != Boolean {
+				return nil
+			}
+			a.High = 1
+		}
+		return a
+	}
+
+	return nil
+}
+
+// named resolves the name of a basic or declared type, named at pos
+func (c *TypeChecker) named(name string, pos lexer.Position) Type {
+	for _, b := range []Basic{Integer, Real, Boolean} {
+		if strings.EqualFold(name, string(b)) {
+			return b
+		}
+	}
+
+	sym, ok := c.symbol(pos)
+	if !ok || sym.Kind != semantic.TypeName {
+		return nil
+	}
+
+	return c.declared[sym]
+}
+
+// resolvePointers looks up the types named by the pointers declared since
+// it was last called
+func (c *TypeChecker) resolvePointers() {
+	for _, p := range c.pointers {
+		p.typ.Elem = c.named(p.typ.Name, p.pos)
+	}
+	c.pointers = nil
+}
+
+// VisitProcDecl records the signature of a procedure or function and checks
+// its body. Within the body of a function, its name is its result.
+func (c *TypeChecker) VisitProcDecl(node *parser.ProcDeclNode) (interface{}, error) {
+	p := &Proc{Name: node.Name}
+	for _, group := range node.Params {
+		t := c.typeSpec(group.Type, "")
+		for _, v := range group.Names {
+			p.Params = append(p.Params, Param{Name: v.Value, Type: t, ByRef: group.ByRef})
+			c.declare(v.Pos, t)
+		}
+	}
+	if node.Result != nil {
+		p.Result = c.typeSpec(node.Result, "")
+	}
+	c.resolvePointers()
+
+	sym, ok := c.symbol(node.Pos)
+	if !ok {
+		return nil, nil
+	}
+	c.declared[sym] = p
+	if node.Block == nil {
+		return nil, nil
+	}
+
+	enclosing := c.function
+	if p.Result != nil {
+		c.function = sym
+	}
+	defer func() { c.function = enclosing }()
+
+	return node.Block.Accept(c)
+}
+
+// builtin gives the type of a call of a standard function
+func (c *TypeChecker) builtin(node *parser.CallNode) Type {
+	name := strings.ToLower(node.Name)
+	switch name {
+	case "abs", "sqr", "odd", "succ", "pred", "ord", "trunc", "round", "sqrt", "sin", "cos", "exp", "ln":
+	default:
+		for n := range node.Args {
+			c.expr(&node.Args[n])
+		}
+		c.errorf(node.Pos, "unknown function %q", node.Name)
+		return nil
+	}
+
+	if len(node.Args) != 1 {
+		for n := range node.Args {
+			c.expr(&node.Args[n])
+		}
+		c.errorf(node.Pos, "%s expects 1 argument(s), got %d", node.Name, len(node.Args))
+		return nil
+	}
+
+	t := c.expr(&node.Args[0])
+	if t == nil {
+		return nil
+	}
+
+	switch name {
+	case "abs", "sqr":
+		if !isNumber(t) {
+			c.errorf(node.Pos, "%s expects an INTEGER or REAL argument, got %s", node.Name, t)
+			return nil
+		}
+		return base(t)
+
+	case "odd":
+		if base(t) != Integer {
+			c.errorf(node.Pos, "%s expects an INTEGER argument, got %s", node.Name, t)
+			return nil
+		}
+		return Boolean
+
+	case "succ", "pred", "ord":
+		if !isOrdinal(t) {
+			c.errorf(node.Pos, "%s expects an ordinal argument, got %s", node.Name, t)
+			return nil
+		}
+		if name == "ord" {
+			return Integer
+		}
+		return base(t)
+	}
+
+	// the rest take a REAL
+	if !isNumber(t) {
+		c.errorf(node.Pos, "%s expects an INTEGER or REAL argument, got %s", node.Name, t)
+		return nil
+	}
+	if base(t) == Integer {
+		c.toReal(&node.Args[0])
+	}
+
+	if name == "trunc" || name == "round" {
+		return Integer
+	}
+
+	return Real
+}
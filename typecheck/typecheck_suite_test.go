@@ -0,0 +1,13 @@
+package typecheck_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestTypecheck(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Typecheck Suite")
+}
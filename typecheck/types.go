@@ -0,0 +1,225 @@
+package typecheck
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type is the type of an expression or of a declared name
+type Type interface {
+	String() string
+}
+
+// Basic is a predeclared type
+type Basic string
+
+const (
+	Integer Basic = "INTEGER"
+	Real    Basic = "REAL"
+	Boolean Basic = "BOOLEAN"
+)
+
+func (b Basic) String() string {
+	return string(b)
+}
+
+// Enum is an enumerated type. Name is empty for an anonymous type.
+type Enum struct {
+	Name   string
+	Values []string
+}
+
+func (e *Enum) String() string {
+	if e.Name != "" {
+		return e.Name
+	}
+
+	return "(" + strings.Join(e.Values, ", ") + ")"
+}
+
+// Subrange is the ordinals Low..High of its Base type
+type Subrange struct {
+	Base Type
+	Low  int
+	High int
+}
+
+func (s *Subrange) String() string {
+	if e, ok := s.Base.(*Enum); ok {
+		return e.Values[s.Low] + ".." + e.Values[s.High]
+	}
+
+	return fmt.Sprintf("%d..%d", s.Low, s.High)
+}
+
+// Set is a SET OF an ordinal type. Elem is nil for the empty set [], which
+// can be used as a set of any type.
+type Set struct {
+	Elem Type
+}
+
+func (s *Set) String() string {
+	if s.Elem == nil {
+		return "[]"
+	}
+
+	return "SET OF " + s.Elem.String()
+}
+
+// Pointer points to a variable of type Elem. Elem is nil until the type
+// named by Name has been declared.
+type Pointer struct {
+	Name string
+	Elem Type
+}
+
+func (p *Pointer) String() string {
+	return "^" + p.Name
+}
+
+// Field is a field of a record
+type Field struct {
+	Name string
+	Type Type
+}
+
+// Record is a RECORD type. Name is empty for an anonymous type.
+type Record struct {
+	Name   string
+	Fields []Field
+}
+
+func (r *Record) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	return "RECORD"
+}
+
+// Field looks up a field by name, ignoring case
+func (r *Record) Field(name string) (Field, bool) {
+	for _, f := range r.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+
+	return Field{}, false
+}
+
+// Array is an ARRAY indexed by the ordinals Low..High of Index
+type Array struct {
+	Index Type
+	Low   int
+	High  int
+	Elem  Type
+}
+
+func (a *Array) String() string {
+	return fmt.Sprintf("ARRAY [%s] OF %s", a.Index, a.Elem)
+}
+
+// Param is a formal parameter. ByRef parameters are declared with VAR.
+type Param struct {
+	Name  string
+	Type  Type
+	ByRef bool
+}
+
+// Proc is the signature of a procedure, or of a function if it has a
+// Result type
+type Proc struct {
+	Name   string
+	Params []Param
+	Result Type
+}
+
+func (p *Proc) String() string {
+	params := make([]string, len(p.Params))
+	for n, prm := range p.Params {
+		params[n] = fmt.Sprintf("%s: %s", prm.Name, prm.Type)
+		if prm.ByRef {
+			params[n] = "VAR " + params[n]
+		}
+	}
+
+	s := "(" + strings.Join(params, "; ") + ")"
+	if p.Result != nil {
+		s += ": " + p.Result.String()
+	}
+
+	return s
+}
+
+// nilType is the type of NIL, which can be used as any pointer
+type nilType struct{}
+
+func (nilType) String() string {
+	return "NIL"
+}
+
+// base is the type a subrange is taken from, or the type itself
+func base(t Type) Type {
+	if s, ok := t.(*Subrange); ok {
+		return s.Base
+	}
+
+	return t
+}
+
+func isOrdinal(t Type) bool {
+	b := base(t)
+	if _, ok := b.(*Enum); ok {
+		return true
+	}
+
+	return b == Integer || b == Boolean
+}
+
+func isNumber(t Type) bool {
+	b := base(t)
+
+	return b == Integer || b == Real
+}
+
+// identical is true for types which are the same: declared types are the
+// same only if they come from the same declaration
+func identical(a, b Type) bool {
+	if a == b {
+		return true
+	}
+
+	switch a := a.(type) {
+	case *Subrange:
+		b, ok := b.(*Subrange)
+		return ok && identical(a.Base, b.Base) && a.Low == b.Low && a.High == b.High
+
+	case *Set:
+		b, ok := b.(*Set)
+		return ok && a.Elem != nil && b.Elem != nil && identical(base(a.Elem), base(b.Elem))
+
+	case *Pointer:
+		b, ok := b.(*Pointer)
+		if !ok {
+			return false
+		}
+		if a.Elem == nil || b.Elem == nil {
+			return strings.EqualFold(a.Name, b.Name)
+		}
+		return identical(a.Elem, b.Elem)
+
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && a.Low == b.Low && a.High == b.High &&
+			identical(base(a.Index), base(b.Index)) && identical(a.Elem, b.Elem)
+	}
+
+	return false
+}
+
+// compatible is true for ordinal or number types taken from the same type,
+// such as INTEGER and 1..10
+func compatible(a, b Type) bool {
+	return identical(base(a), base(b))
+}